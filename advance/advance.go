@@ -0,0 +1,186 @@
+package advance
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cjduffett/synthea/config"
+	"github.com/cjduffett/synthea/entity"
+	"github.com/cjduffett/synthea/exporter"
+	"github.com/cjduffett/synthea/generator"
+)
+
+// Formats the changes to each patient can be written in.
+const (
+	// FormatFHIR writes each patient's changes as a FHIR transaction
+	// Bundle to fhir/<patient id>.json.
+	FormatFHIR = "fhir"
+	// FormatNDJSON writes the changed resources of every patient to
+	// ndjson/<resource type>.ndjson.
+	FormatNDJSON = "ndjson"
+)
+
+// Task advances a population saved as snapshots (see entity.Snapshot)
+// from the date each patient was last simulated to a new end date, and
+// exports only what changed: new encounters, observations and other
+// resources, resources that ended, and deaths. The changes apply on top
+// of the FHIR resources exported for the snapshots, see
+// exporter.NewFHIRDelta.
+//
+// The advanced snapshots are written to the snapshots subdirectory of
+// the output directory, from which the population can be advanced again.
+// The snapshots advanced from are left as they are, so an interrupted
+// task can simply be run again.
+type Task struct {
+	generator   *generator.Generator
+	end         time.Time
+	snapshotDir string
+	outputDir   string
+	format      string
+
+	advanced int
+	changed  int
+	died     int
+}
+
+// NewTask returns a task that advances the snapshots in snapshotDir with
+// the modules and end date configured by cfg, and writes the changes in
+// format to the configured output directory.
+func NewTask(cfg *config.Config, snapshotDir, format string) (*Task, error) {
+	if format != FormatFHIR && format != FormatNDJSON {
+		return nil, fmt.Errorf("Invalid format '%s': must be %s or %s", format, FormatFHIR, FormatNDJSON)
+	}
+	snapshots, err := filepath.Abs(snapshotDir)
+	if err == nil {
+		var output string
+		output, err = filepath.Abs(filepath.Join(cfg.OutputDir, "snapshots"))
+		if err == nil && snapshots == output {
+			err = fmt.Errorf("Invalid output directory: it would overwrite the snapshots in %s", snapshotDir)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	g, err := generator.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range g.Modules() {
+		fmt.Printf("Loaded module '%s'\n", name)
+	}
+	return &Task{
+		generator:   g,
+		end:         g.EndDate(),
+		snapshotDir: snapshotDir,
+		outputDir:   cfg.OutputDir,
+		format:      format,
+	}, nil
+}
+
+// Run advances every snapshot in the snapshot directory, in the order of
+// their file names.
+func (task *Task) Run() error {
+	paths, err := filepath.Glob(filepath.Join(task.snapshotDir, "*.json"))
+	if err == nil && len(paths) == 0 {
+		err = fmt.Errorf("No snapshots in %s", task.snapshotDir)
+	}
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Join(task.outputDir, "snapshots"), 0755)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Advancing %d patients to %s...\n", len(paths), task.end.Format(config.DateFormat))
+	var ndjson *exporter.FHIRNDJSONWriter
+	if task.format == FormatNDJSON {
+		ndjson = exporter.NewFHIRNDJSONWriter(filepath.Join(task.outputDir, FormatNDJSON))
+	} else {
+		err = os.MkdirAll(filepath.Join(task.outputDir, FormatFHIR), 0755)
+	}
+	for _, path := range paths {
+		if err != nil {
+			break
+		}
+		err = task.advance(path, ndjson)
+	}
+	if ndjson != nil {
+		if cerr := ndjson.Close(); err == nil {
+			err = cerr
+		}
+	}
+	fmt.Printf("Advanced %d patients, %d changed and %d died\n", task.advanced, task.changed, task.died)
+	return err
+}
+
+// advance advances the snapshot at path, writes its changes to ndjson
+// or to a bundle if ndjson is nil, and writes the advanced snapshot.
+func (task *Task) advance(path string, ndjson *exporter.FHIRNDJSONWriter) error {
+	s, err := entity.LoadSnapshot(path)
+	if err != nil {
+		return err
+	}
+	since := s.Until
+	alive := !s.Entity.Record.Expired()
+	err = task.generator.Continue(s)
+	if err != nil {
+		return fmt.Errorf("Failed to advance %s: %s", path, err.Error())
+	}
+
+	e := s.Entity
+	delta := exporter.NewFHIRDelta(&e.Patient, &e.Record, since)
+	if len(delta.Entry) > 0 {
+		if ndjson != nil {
+			err = ndjson.Write(delta)
+		} else {
+			err = task.writeBundle(e.Patient.ID(), delta)
+		}
+		if err != nil {
+			return err
+		}
+		task.changed++
+	}
+	if alive && e.Record.Expired() {
+		task.died++
+	}
+	task.advanced++
+	return task.writeSnapshot(e.Snapshot(s.Start, s.Until, s.Step))
+}
+
+// writeBundle writes a patient's changes to <patient id>.json in the fhir
+// subdirectory of the output directory.
+func (task *Task) writeBundle(id string, bundle *exporter.FHIRBundle) error {
+	path := filepath.Join(task.outputDir, FormatFHIR, id+".json")
+	return writeFile(path, func(f *os.File) error {
+		return exporter.WriteFHIRBundle(f, bundle)
+	})
+}
+
+// writeSnapshot writes an advanced snapshot to <patient id>.json in the
+// snapshots subdirectory of the output directory.
+func (task *Task) writeSnapshot(s *entity.Snapshot) error {
+	path := filepath.Join(task.outputDir, "snapshots", s.Entity.Patient.ID()+".json")
+	return writeFile(path, func(f *os.File) error {
+		return s.WriteJSON(f)
+	})
+}
+
+// writeFile creates the file at path and writes it with write.
+func writeFile(path string, write func(f *os.File) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = write(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to write %s: %s", path, err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+package advance
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cjduffett/synthea/config"
+	"github.com/cjduffett/synthea/entity"
+	"github.com/cjduffett/synthea/exporter"
+	"github.com/cjduffett/synthea/generator"
+	"github.com/stretchr/testify/suite"
+)
+
+type AdvanceTestSuite struct {
+	suite.Suite
+	snapshotDir string
+	// bundles are the full bundles of the patients in the snapshots.
+	bundles map[string]*exporter.FHIRBundle
+}
+
+func TestAdvanceTestSuite(t *testing.T) {
+	suite.Run(t, new(AdvanceTestSuite))
+}
+
+func (suite *AdvanceTestSuite) SetupTest() {
+	// Everyone dies at 23 in the lifecycle module, so some of the cohort
+	// born since 2000 die by 2030.
+	g, err := generator.New(suite.config("2016-01-01", suite.T().TempDir()))
+	suite.Require().Nil(err)
+	suite.snapshotDir = suite.T().TempDir()
+	suite.bundles = make(map[string]*exporter.FHIRBundle)
+	for i := 0; i < 8; i++ {
+		p, err := g.Generate(context.Background(), i)
+		suite.Require().Nil(err)
+		id := p.Entity.Patient.ID()
+		suite.bundles[id] = exporter.NewFHIRBundle(&p.Entity.Patient, &p.Entity.Record)
+		f, err := os.Create(filepath.Join(suite.snapshotDir, id+".json"))
+		suite.Require().Nil(err)
+		suite.Require().Nil(g.Snapshot(p).WriteJSON(f))
+		suite.Require().Nil(f.Close())
+	}
+}
+
+func (suite *AdvanceTestSuite) config(endDate, outputDir string) *config.Config {
+	cfg := config.Default()
+	cfg.Population = 8
+	cfg.Seed = 42
+	cfg.StartDate = "2000-01-01"
+	cfg.EndDate = endDate
+	cfg.BirthCohort = true
+	cfg.ModuleDirs = []string{"../fixtures/modules/lifecycle", "../fixtures/modules/random"}
+	cfg.OutputDir = outputDir
+	return cfg
+}
+
+// advance advances the snapshots in snapshotDir to endDate, and returns
+// the output directory.
+func (suite *AdvanceTestSuite) advance(snapshotDir, endDate, format string) string {
+	outputDir := suite.T().TempDir()
+	task, err := NewTask(suite.config(endDate, outputDir), snapshotDir, format)
+	suite.Require().Nil(err)
+	suite.Require().Nil(task.Run())
+	return outputDir
+}
+
+// readBundle reads the bundle at path.
+func (suite *AdvanceTestSuite) readBundle(path string) *exporter.FHIRBundle {
+	data, err := ioutil.ReadFile(path)
+	suite.Require().Nil(err)
+	bundle := new(exporter.FHIRBundle)
+	suite.Require().Nil(json.Unmarshal(data, bundle))
+	return bundle
+}
+
+// resources returns the resources of a bundle by fullUrl, as JSON.
+func (suite *AdvanceTestSuite) resources(bundle *exporter.FHIRBundle) map[string]string {
+	resources := make(map[string]string)
+	for _, entry := range bundle.Entry {
+		data, err := json.Marshal(entry.Resource)
+		suite.Require().Nil(err)
+		resources[entry.FullURL] = string(data)
+	}
+	return resources
+}
+
+func (suite *AdvanceTestSuite) TestModuleErrorNamesTheSnapshot() {
+	cfg := suite.config("2030-01-01", suite.T().TempDir())
+	cfg.ModuleDirs = []string{"../fixtures/modules/invalid"}
+	task, err := NewTask(cfg, suite.snapshotDir, FormatFHIR)
+	suite.Require().Nil(err)
+	err = task.Run()
+	suite.Require().NotNil(err)
+	suite.Contains(err.Error(), suite.snapshotDir)
+}
+
+func (suite *AdvanceTestSuite) TestDeltasApplyOnTopOfThePreviousLoad() {
+	outputDir := suite.advance(suite.snapshotDir, "2030-01-01", FormatFHIR)
+
+	deaths := 0
+	for id, bundle := range suite.bundles {
+		s, err := entity.LoadSnapshot(filepath.Join(outputDir, "snapshots", id+".json"))
+		suite.Require().Nil(err)
+		suite.Equal("2030-01-01", s.Until.Format(config.DateFormat))
+		expected := suite.resources(exporter.NewFHIRBundle(&s.Entity.Patient, &s.Entity.Record))
+
+		// Loading the delta on top of the previous bundle gives the bundle
+		// of the advanced patient.
+		loaded := suite.resources(bundle)
+		path := filepath.Join(outputDir, FormatFHIR, id+".json")
+		if _, err := os.Stat(path); err == nil {
+			delta := suite.readBundle(path)
+			suite.NotEmpty(delta.Entry)
+			for url, resource := range suite.resources(delta) {
+				loaded[url] = resource
+			}
+			if delta.Entry[0].Resource["resourceType"] == "Patient" {
+				suite.Contains(delta.Entry[0].Resource, "deceasedDateTime")
+				deaths++
+			}
+		}
+		suite.Equal(expected, loaded)
+	}
+	suite.True(deaths > 0, "Some patients die after the snapshots")
+}
+
+func (suite *AdvanceTestSuite) TestAdvanceIsDeterministic() {
+	first := suite.advance(suite.snapshotDir, "2030-01-01", FormatFHIR)
+	second := suite.advance(suite.snapshotDir, "2030-01-01", FormatFHIR)
+	for _, dir := range []string{FormatFHIR, "snapshots"} {
+		files, err := ioutil.ReadDir(filepath.Join(first, dir))
+		suite.Require().Nil(err)
+		suite.NotEmpty(files)
+		for _, file := range files {
+			expected, err := ioutil.ReadFile(filepath.Join(first, dir, file.Name()))
+			suite.Require().Nil(err)
+			actual, err := ioutil.ReadFile(filepath.Join(second, dir, file.Name()))
+			suite.Require().Nil(err)
+			suite.Equal(string(expected), string(actual), file.Name())
+		}
+	}
+}
+
+func (suite *AdvanceTestSuite) TestAdvanceAgain() {
+	first := suite.advance(suite.snapshotDir, "2030-01-01", FormatFHIR)
+
+	// Advancing to the same date changes nothing.
+	again := suite.advance(filepath.Join(first, "snapshots"), "2030-01-01", FormatFHIR)
+	files, err := ioutil.ReadDir(filepath.Join(again, FormatFHIR))
+	suite.Require().Nil(err)
+	suite.Empty(files)
+	snapshots, err := ioutil.ReadDir(filepath.Join(again, "snapshots"))
+	suite.Require().Nil(err)
+	suite.Len(snapshots, len(suite.bundles))
+}
+
+func (suite *AdvanceTestSuite) TestNDJSON() {
+	fhir := suite.advance(suite.snapshotDir, "2030-01-01", FormatFHIR)
+	ndjson := suite.advance(suite.snapshotDir, "2030-01-01", FormatNDJSON)
+
+	expected := make(map[string]int)
+	files, err := ioutil.ReadDir(filepath.Join(fhir, FormatFHIR))
+	suite.Require().Nil(err)
+	for _, file := range files {
+		for _, entry := range suite.readBundle(filepath.Join(fhir, FormatFHIR, file.Name())).Entry {
+			expected[entry.Resource["resourceType"].(string)]++
+		}
+	}
+
+	counts := make(map[string]int)
+	for resourceType := range expected {
+		f, err := os.Open(filepath.Join(ndjson, FormatNDJSON, resourceType+".ndjson"))
+		suite.Require().Nil(err)
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(nil, 1<<20)
+		for scanner.Scan() {
+			var resource exporter.FHIRResource
+			suite.Require().Nil(json.Unmarshal(scanner.Bytes(), &resource))
+			suite.Equal(resourceType, resource["resourceType"])
+			counts[resourceType]++
+		}
+		suite.Nil(scanner.Err())
+		f.Close()
+	}
+	suite.Equal(expected, counts)
+}
+
+func (suite *AdvanceTestSuite) TestInvalidTask() {
+	_, err := NewTask(suite.config("2030-01-01", suite.T().TempDir()), suite.snapshotDir, "csv")
+	suite.NotNil(err)
+
+	// The advanced snapshots would overwrite the snapshots advanced from.
+	outputDir := suite.T().TempDir()
+	_, err = NewTask(suite.config("2030-01-01", outputDir), filepath.Join(outputDir, "snapshots"), FormatFHIR)
+	suite.NotNil(err)
+
+	task, err := NewTask(suite.config("2030-01-01", suite.T().TempDir()), suite.T().TempDir(), FormatFHIR)
+	suite.Require().Nil(err)
+	suite.NotNil(task.Run(), "There are no snapshots to advance")
+}
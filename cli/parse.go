@@ -1,11 +1,25 @@
 package cli
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/cjduffett/synthea/advance"
+	"github.com/cjduffett/synthea/config"
+	"github.com/cjduffett/synthea/entity"
+	"github.com/cjduffett/synthea/exporter"
+	"github.com/cjduffett/synthea/generator"
+	"github.com/cjduffett/synthea/gmf"
 	"github.com/cjduffett/synthea/sequential"
+	"github.com/cjduffett/synthea/server"
 )
 
 // ParseSubCommands parses the specified command and its arguments
@@ -15,20 +29,234 @@ func ParseSubCommands(cmd string, args []string) {
 
 	case "sequential":
 		// sequential [options]
-		// -n         Number of patients to generate (default 100)
-
-		// TODO: Additional config
 		// -config    Path to custom synthea.yml (default is at config/synthea.yml)
-		// -demo      Provide demographic data (see towns.json)
-		// -thread    Multithread the sequential generation
+		// -n         Number of patients to generate (default 100)
+		// -start     Simulation start date, YYYY-MM-DD (default 100 years before -end)
+		// -end       Simulation end date, YYYY-MM-DD (default today)
+		// -step      Simulation time step, in days (default 7)
+		// -seed      Random seed, 0 picks a seed from the clock (default 0)
+		// -m         Comma-separated list of module directories
+		// -e         Comma-separated list of exporters to run (default html)
+		// -o         Output directory; each exporter writes to a subdirectory (default output)
+		// -x         Exporter option as name.key=value, may be repeated
+		// -demo      Provide town demographic data (see Town in entity/towns.go)
+		// -pack      Built-in data pack name or path (see DataPack in entity/demographics.go)
+		// -names     Built-in name dictionary name or path (see Names in entity/names.go)
+		// -name-digits Append three random digits to every given and family name
+		// -cohort    Generate a birth cohort, born within the simulation window
+		// -households Generate households of related patients (see NewHousehold in entity/household.go)
+		// -thread    Number of patients to generate concurrently, 0 uses every CPU (default 1)
+		// -profile   Count module state entries and transitions, written to <output>/profile
+		// -keep      Path to a JSON GMF condition; only matching patients are kept (see Filter in gmf/filter.go)
+		// -snapshot  Save a snapshot of every patient to <output>/snapshots (see Snapshot in entity/snapshot.go)
+		// -checkpoint Number of patients between checkpoints, 0 disables them (default 1000)
+		// -resume    Continue an interrupted run from the checkpoint in the output directory
+		//
+		// Flags override SYNTHEA_* environment variables, which override
+		// the configuration file, which overrides the built-in defaults.
+		// SIGINT (Ctrl-C) flushes the patients exported so far and writes a
+		// checkpoint; a second SIGINT exits immediately.
 
 		sequentialCommand := flag.NewFlagSet("sequential", flag.ExitOnError)
-		numPatients := sequentialCommand.Int("n", 100, "The number of patients to generate ")
+		configPath := sequentialCommand.String("config", "", "Path to a custom synthea.yml (default "+config.DefaultPath+")")
+		sequentialCommand.Int("n", 100, "The number of patients to generate ")
+		sequentialCommand.String("start", "", "The simulation start date, YYYY-MM-DD ")
+		sequentialCommand.String("end", "", "The simulation end date, YYYY-MM-DD ")
+		sequentialCommand.Int("step", 7, "The simulation time step, in days ")
+		sequentialCommand.Int64("seed", 0, "The random seed, 0 picks a seed from the clock ")
+		sequentialCommand.String("m", "", "Comma-separated list of module directories ")
+		sequentialCommand.String("e", "html", "Comma-separated list of exporters: "+strings.Join(exporter.Names(), ", "))
+		sequentialCommand.String("o", "output", "The output directory ")
+		sequentialCommand.String("demo", "", "Path to a town demographics file ")
+		sequentialCommand.String("pack", entity.DefaultDataPack, "Data pack name or path, built-in packs: "+strings.Join(entity.BuiltinDataPacks(), ", "))
+		sequentialCommand.String("names", entity.DefaultNames, "Name dictionary name or path, built-in dictionaries: "+strings.Join(entity.BuiltinNames(), ", "))
+		sequentialCommand.Bool("name-digits", false, "Append three random digits to every given and family name ")
+		sequentialCommand.Bool("cohort", false, "Generate a birth cohort, born between -start and -end ")
+		sequentialCommand.Bool("households", false, "Generate households of related patients who live together ")
+		sequentialCommand.Int("thread", 1, "The number of patients to generate concurrently, 0 uses every CPU ")
+		sequentialCommand.Bool("profile", false, "Count module state entries and transitions, written to <output>/profile ")
+		sequentialCommand.String("keep", "", "Path to a JSON GMF condition, only patients who match it are kept ")
+		sequentialCommand.Bool("snapshot", false, "Save a snapshot of every patient to <output>/snapshots ")
+		sequentialCommand.Int("checkpoint", 1000, "The number of patients between checkpoints, 0 disables them ")
+		resume := sequentialCommand.Bool("resume", false, "Continue an interrupted run from the checkpoint in the output directory ")
+		options := exporterOptions{}
+		sequentialCommand.Var(options, "x", "An exporter option as name.key=value, for example omop.concepts=concepts.csv ")
 
 		// parse the args
 		sequentialCommand.Parse(args)
 		if sequentialCommand.Parsed() {
-			sequential.NewTask(*numPatients).Run()
+			cfg, err := loadConfig(*configPath, sequentialCommand, options)
+			if err != nil {
+				invalidArgs(cmd, err)
+			}
+			var checkpoint *sequential.Checkpoint
+			var set *exporter.Set
+			if *resume {
+				checkpoint, err = sequential.LoadCheckpoint(cfg.OutputDir)
+				if err == nil {
+					set, err = exporter.ResumeSet(cfg.Exporters, cfg.OutputDir, exporter.Options(cfg.ExporterOptions), checkpoint.Exporters)
+				}
+			} else {
+				set, err = exporter.NewSet(cfg.Exporters, cfg.OutputDir, exporter.Options(cfg.ExporterOptions))
+			}
+			if err != nil {
+				invalidArgs(cmd, err)
+			}
+			var task *sequential.Task
+			if *resume {
+				task, err = sequential.ResumeTask(cfg, set, checkpoint)
+			} else {
+				task, err = sequential.NewTask(cfg, set)
+			}
+			if err != nil {
+				set.Close()
+				invalidArgs(cmd, err)
+			}
+			err = task.RunContext(interruptContext())
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}
+
+	case "graphviz":
+		// graphviz [options]
+		// -m         Comma-separated list of module directories
+		// -profile   Path to a profile.json written by sequential -profile
+		// -o         Output directory (default output/graphviz)
+
+		graphvizCommand := flag.NewFlagSet("graphviz", flag.ExitOnError)
+		moduleDirs := graphvizCommand.String("m", "", "Comma-separated list of module directories ")
+		profilePath := graphvizCommand.String("profile", "", "Path to a profile.json to weight the graphs by ")
+		outputDir := graphvizCommand.String("o", filepath.Join("output", "graphviz"), "The output directory ")
+
+		graphvizCommand.Parse(args)
+		if graphvizCommand.Parsed() {
+			modules := new(gmf.GMF)
+			for _, dir := range strings.Split(*moduleDirs, ",") {
+				if dir = strings.TrimSpace(dir); dir == "" {
+					continue
+				}
+				if err := modules.Load(dir); err != nil {
+					invalidArgs(cmd, err)
+				}
+			}
+			var profile *gmf.Profile
+			if *profilePath != "" {
+				var err error
+				profile, err = gmf.LoadProfile(*profilePath)
+				if err != nil {
+					invalidArgs(cmd, err)
+				}
+			}
+			err := modules.WriteGraphviz(*outputDir, profile)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			fmt.Printf("Wrote %d module graphs to %s\n", len(modules.Modules()), *outputDir)
+		}
+
+	case "serve":
+		// serve [options]
+		// -addr      Address to listen on (default localhost:8080)
+		// -config    Path to custom synthea.yml (default is at config/synthea.yml)
+		// -start     Simulation start date, YYYY-MM-DD (default 100 years before -end)
+		// -end       Simulation end date, YYYY-MM-DD (default today)
+		// -step      Simulation time step, in days (default 7)
+		// -m         Comma-separated list of module directories
+		// -demo      Provide town demographic data (see Town in entity/towns.go)
+		// -pack      Built-in data pack name or path (see DataPack in entity/demographics.go)
+		// -names     Built-in name dictionary name or path (see Names in entity/names.go)
+		// -name-digits Append three random digits to every given and family name
+		// -cohort    Generate birth cohorts, born within the simulation window
+		// -households Generate households of related patients (see NewHousehold in entity/household.go)
+		// -thread    Number of patients each request generates concurrently, 0 uses every CPU (default 1)
+		//
+		// Each request picks its own seed, population and keep filter; see
+		// Server in server/server.go for the endpoints.
+
+		serveCommand := flag.NewFlagSet("serve", flag.ExitOnError)
+		addr := serveCommand.String("addr", "localhost:8080", "The address to listen on ")
+		configPath := serveCommand.String("config", "", "Path to a custom synthea.yml (default "+config.DefaultPath+")")
+		serveCommand.String("start", "", "The simulation start date, YYYY-MM-DD ")
+		serveCommand.String("end", "", "The simulation end date, YYYY-MM-DD ")
+		serveCommand.Int("step", 7, "The simulation time step, in days ")
+		serveCommand.String("m", "", "Comma-separated list of module directories ")
+		serveCommand.String("demo", "", "Path to a town demographics file ")
+		serveCommand.String("pack", entity.DefaultDataPack, "Data pack name or path, built-in packs: "+strings.Join(entity.BuiltinDataPacks(), ", "))
+		serveCommand.String("names", entity.DefaultNames, "Name dictionary name or path, built-in dictionaries: "+strings.Join(entity.BuiltinNames(), ", "))
+		serveCommand.Bool("name-digits", false, "Append three random digits to every given and family name ")
+		serveCommand.Bool("cohort", false, "Generate birth cohorts, born between -start and -end ")
+		serveCommand.Bool("households", false, "Generate households of related patients who live together ")
+		serveCommand.Int("thread", 1, "The number of patients each request generates concurrently, 0 uses every CPU ")
+
+		serveCommand.Parse(args)
+		if serveCommand.Parsed() {
+			cfg, err := loadConfig(*configPath, serveCommand, exporterOptions{})
+			if err != nil {
+				invalidArgs(cmd, err)
+			}
+			// The keep filter is given per request.
+			cfg.Keep = ""
+			g, err := generator.New(cfg)
+			if err != nil {
+				invalidArgs(cmd, err)
+			}
+			for _, name := range g.Modules() {
+				fmt.Printf("Loaded module '%s'\n", name)
+			}
+
+			fmt.Printf("Serving patients on http://%s\n", *addr)
+			srv := &http.Server{
+				Addr:              *addr,
+				Handler:           server.New(g),
+				ReadHeaderTimeout: 10 * time.Second,
+			}
+			err = srv.ListenAndServe()
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}
+
+	case "advance":
+		// advance [options]
+		// -config    Path to custom synthea.yml (default is at config/synthea.yml)
+		// -snapshots Directory of snapshots written by sequential -snapshot (default output/snapshots)
+		// -end       New simulation end date, YYYY-MM-DD (default today)
+		// -m         Comma-separated list of module directories
+		// -format    Format of the changes, fhir or ndjson (default fhir)
+		// -o         Output directory (default output/advanced)
+		//
+		// Only the changes after each snapshot are exported, see Task in
+		// advance/advance.go. The advanced snapshots are written to
+		// <output>/snapshots, to advance again from.
+
+		advanceCommand := flag.NewFlagSet("advance", flag.ExitOnError)
+		configPath := advanceCommand.String("config", "", "Path to a custom synthea.yml (default "+config.DefaultPath+")")
+		snapshotDir := advanceCommand.String("snapshots", filepath.Join("output", "snapshots"), "The directory of snapshots to advance ")
+		advanceCommand.String("end", "", "The new simulation end date, YYYY-MM-DD ")
+		advanceCommand.String("m", "", "Comma-separated list of module directories ")
+		format := advanceCommand.String("format", advance.FormatFHIR, "The format of the changes, "+advance.FormatFHIR+" or "+advance.FormatNDJSON+" ")
+		outputDir := advanceCommand.String("o", filepath.Join("output", "advanced"), "The output directory ")
+
+		advanceCommand.Parse(args)
+		if advanceCommand.Parsed() {
+			cfg, err := loadConfig(*configPath, advanceCommand, exporterOptions{})
+			if err != nil {
+				invalidArgs(cmd, err)
+			}
+			cfg.OutputDir = *outputDir
+			task, err := advance.NewTask(cfg, *snapshotDir, *format)
+			if err != nil {
+				invalidArgs(cmd, err)
+			}
+			err = task.Run()
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
 		}
 
 	default:
@@ -37,15 +265,103 @@ func ParseSubCommands(cmd string, args []string) {
 }
 
 // TODO: Additional sub commands
-// graphviz [module_name]
-//graphvizCommand := flag.NewFlagSet("graphviz", flag.ExitOnError)
-//
 // story [patient_id]
 //storyCommand := flag.NewFlagSet("story", flag.ExitOnError)
 //
 // new [module_name]
 //newModuleCommand := flag.NewFlagSet("new", flag.ExitOnError)
 
+// interruptContext returns a context that is canceled on the first
+// SIGINT. The process exits on the second.
+func interruptContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	interrupts := make(chan os.Signal, 2)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		<-interrupts
+		fmt.Println("Interrupted, finishing the patients exported so far (interrupt again to exit now)...")
+		cancel()
+		<-interrupts
+		os.Exit(130)
+	}()
+	return ctx
+}
+
+// flagConfigKeys maps command line flags to the configuration keys
+// they override.
+var flagConfigKeys = map[string]string{
+	"n":           "population",
+	"start":       "start_date",
+	"end":         "end_date",
+	"step":        "time_step",
+	"seed":        "seed",
+	"m":           "module_dirs",
+	"e":           "exporters",
+	"o":           "output_dir",
+	"demo":        "demographics",
+	"pack":        "data_pack",
+	"names":       "names",
+	"name-digits": "name_digits",
+	"cohort":      "birth_cohort",
+	"households":  "households",
+	"thread":      "threads",
+	"profile":     "profile",
+	"keep":        "keep",
+	"snapshot":    "snapshots",
+	"checkpoint":  "checkpoint_interval",
+}
+
+// loadConfig resolves the run configuration: the configuration file (or
+// defaults), then SYNTHEA_* environment variables, then any flags that
+// were explicitly set.
+func loadConfig(path string, flags *flag.FlagSet, options exporterOptions) (*config.Config, error) {
+	var cfg *config.Config
+	var err error
+	if path == "" {
+		cfg, err = config.LoadDefault()
+	} else {
+		cfg, err = config.Load(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = cfg.ApplyEnv(os.LookupEnv)
+	if err != nil {
+		return nil, err
+	}
+
+	flags.Visit(func(f *flag.Flag) {
+		if key, ok := flagConfigKeys[f.Name]; ok && err == nil {
+			err = cfg.Set(key, f.Value.String())
+		}
+	})
+	for key, value := range options {
+		cfg.ExporterOptions[key] = value
+	}
+	return cfg, err
+}
+
+// exporterOptions collects repeated name.key=value flags.
+type exporterOptions map[string]string
+
+func (o exporterOptions) String() string {
+	var pairs []string
+	for key, value := range o {
+		pairs = append(pairs, key+"="+value)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (o exporterOptions) Set(option string) error {
+	parts := strings.SplitN(option, "=", 2)
+	if len(parts) != 2 || !strings.Contains(parts[0], ".") {
+		return errors.New("exporter options must be of the form name.key=value")
+	}
+	o[parts[0]] = parts[1]
+	return nil
+}
+
 func invalidArgs(cmd string, err error) {
 	fmt.Printf("Invalid arguments for command %s.\n", cmd)
 	fmt.Println(err)
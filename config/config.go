@@ -0,0 +1,297 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// DefaultPath is where Synthea looks for a configuration file if none is
+// specified.
+const DefaultPath = "config/synthea.yml"
+
+// DateFormat is the format of all dates in the configuration.
+const DateFormat = "2006-01-02"
+
+// Config is the configuration for a Synthea run. Values are resolved in
+// the following order, each overriding the last:
+//
+//  1. The defaults returned by Default()
+//  2. The YAML configuration file (see synthea.yml)
+//  3. SYNTHEA_* environment variables (see ApplyEnv)
+//  4. Command line flags
+type Config struct {
+	// Population is the number of patients to generate.
+	Population int `yaml:"population"`
+	// StartDate and EndDate bound the simulation, formatted as DateFormat.
+	// An empty EndDate is today; an empty StartDate is 100 years before
+	// the EndDate.
+	StartDate string `yaml:"start_date"`
+	EndDate   string `yaml:"end_date"`
+	// TimeStep is the number of days between simulation time steps.
+	TimeStep int `yaml:"time_step"`
+	// Seed seeds the random number generator. 0 picks a seed from the clock.
+	Seed int64 `yaml:"seed"`
+	// ModuleDirs are directories to load GMF modules from.
+	ModuleDirs []string `yaml:"module_dirs"`
+	// Exporters are the names of the exporters to run.
+	Exporters []string `yaml:"exporters"`
+	// ExporterOptions are exporter-specific settings keyed by
+	// "<exporter>.<setting>", for example "omop.concepts".
+	ExporterOptions map[string]string `yaml:"exporter_options"`
+	// OutputDir is the directory exporters write to. Each exporter
+	// writes to its own subdirectory.
+	OutputDir string `yaml:"output_dir"`
+	// Demographics is the path to a town demographics file. If empty,
+	// the built-in demographics are used.
+	Demographics string `yaml:"demographics"`
+	// DataPack is the name of a built-in data pack, or the path to a data
+	// pack, holding the statewide demographic tables.
+	DataPack string `yaml:"data_pack"`
+	// Names is the name of a built-in name dictionary, or the path to a
+	// name dictionary, holding the given and family names of each
+	// ethnicity.
+	Names string `yaml:"names"`
+	// NameDigits appends three random digits to every generated given
+	// and family name, so that patients cannot be mistaken for real
+	// people.
+	NameDigits bool `yaml:"name_digits"`
+	// BirthCohort generates a birth cohort: every patient is born within
+	// the simulation window and simulated from birth. Otherwise patients
+	// are alive at the EndDate with ages drawn from the demographics.
+	BirthCohort bool `yaml:"birth_cohort"`
+	// Households generates a household for each patient index: an adult
+	// householder, their spouse if they have one, and their children
+	// under 18, who share an address and socioeconomic status. Otherwise
+	// every patient is generated on their own. It cannot be combined
+	// with BirthCohort.
+	Households bool `yaml:"households"`
+	// Threads is the number of patients to generate concurrently. 0 uses
+	// one thread per CPU.
+	Threads int `yaml:"threads"`
+	// Profile counts how often patients enter each state of each module
+	// and follow each transition, and writes the counts and a graph of
+	// each module to <output_dir>/profile.
+	Profile bool `yaml:"profile"`
+	// Keep is the path to a JSON file holding a GMF condition. If set,
+	// only patients alive at the EndDate who match the condition are
+	// kept, and patients are generated until Population are kept.
+	Keep string `yaml:"keep"`
+	// Snapshots saves a snapshot of every exported patient to
+	// <output_dir>/snapshots, from which their simulation can be
+	// continued. See entity.Snapshot.
+	Snapshots bool `yaml:"snapshots"`
+	// CheckpointInterval is the number of patients exported between
+	// checkpoints of a sequential run, which an interrupted run can be
+	// resumed from. 0 disables periodic checkpoints.
+	CheckpointInterval int `yaml:"checkpoint_interval"`
+}
+
+// Default returns the default configuration.
+func Default() *Config {
+	return &Config{
+		Population:         100,
+		TimeStep:           7,
+		ModuleDirs:         []string{},
+		Exporters:          []string{"html"},
+		ExporterOptions:    make(map[string]string),
+		OutputDir:          "output",
+		DataPack:           "massachusetts",
+		Names:              "us",
+		Threads:            1,
+		CheckpointInterval: 1000,
+	}
+}
+
+// Load returns the default configuration overridden by the YAML file at
+// path. Keys that are missing from the file keep their default values;
+// unknown keys are an error.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	err = yaml.UnmarshalStrict(data, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid configuration %s: %s", path, err.Error())
+	}
+	if cfg.ExporterOptions == nil {
+		cfg.ExporterOptions = make(map[string]string)
+	}
+	return cfg, nil
+}
+
+// LoadDefault loads the configuration at DefaultPath, falling back to
+// Default() if there is no file there.
+func LoadDefault() (*Config, error) {
+	if _, err := os.Stat(DefaultPath); os.IsNotExist(err) {
+		return Default(), nil
+	}
+	return Load(DefaultPath)
+}
+
+// ApplyEnv overrides the configuration with any of the following
+// environment variables that are set. Lists are comma-separated.
+//
+//	SYNTHEA_POPULATION     SYNTHEA_SEED         SYNTHEA_OUTPUT_DIR
+//	SYNTHEA_START_DATE     SYNTHEA_MODULE_DIRS  SYNTHEA_DEMOGRAPHICS
+//	SYNTHEA_END_DATE       SYNTHEA_EXPORTERS
+//	SYNTHEA_TIME_STEP      SYNTHEA_DATA_PACK    SYNTHEA_BIRTH_COHORT
+//	SYNTHEA_THREADS        SYNTHEA_PROFILE      SYNTHEA_KEEP
+//	SYNTHEA_CHECKPOINT_INTERVAL SYNTHEA_SNAPSHOTS SYNTHEA_HOUSEHOLDS
+//	SYNTHEA_NAMES          SYNTHEA_NAME_DIGITS
+func (c *Config) ApplyEnv(lookup func(key string) (string, bool)) error {
+	for _, key := range EnvKeys() {
+		if value, found := lookup(key); found {
+			err := c.Set(strings.ToLower(strings.TrimPrefix(key, "SYNTHEA_")), value)
+			if err != nil {
+				return fmt.Errorf("Invalid %s: %s", key, err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+// EnvKeys returns the environment variables read by ApplyEnv.
+func EnvKeys() []string {
+	return []string{
+		"SYNTHEA_POPULATION",
+		"SYNTHEA_START_DATE",
+		"SYNTHEA_END_DATE",
+		"SYNTHEA_TIME_STEP",
+		"SYNTHEA_SEED",
+		"SYNTHEA_MODULE_DIRS",
+		"SYNTHEA_EXPORTERS",
+		"SYNTHEA_OUTPUT_DIR",
+		"SYNTHEA_DEMOGRAPHICS",
+		"SYNTHEA_DATA_PACK",
+		"SYNTHEA_NAMES",
+		"SYNTHEA_NAME_DIGITS",
+		"SYNTHEA_BIRTH_COHORT",
+		"SYNTHEA_THREADS",
+		"SYNTHEA_PROFILE",
+		"SYNTHEA_KEEP",
+		"SYNTHEA_CHECKPOINT_INTERVAL",
+		"SYNTHEA_SNAPSHOTS",
+		"SYNTHEA_HOUSEHOLDS",
+	}
+}
+
+// Set sets a single configuration value by its YAML key, parsing value
+// from a string.
+func (c *Config) Set(key, value string) error {
+	var err error
+	switch key {
+	case "population":
+		c.Population, err = strconv.Atoi(value)
+	case "start_date":
+		c.StartDate = value
+	case "end_date":
+		c.EndDate = value
+	case "time_step":
+		c.TimeStep, err = strconv.Atoi(value)
+	case "seed":
+		c.Seed, err = strconv.ParseInt(value, 10, 64)
+	case "module_dirs":
+		c.ModuleDirs = splitList(value)
+	case "exporters":
+		c.Exporters = splitList(value)
+	case "output_dir":
+		c.OutputDir = value
+	case "demographics":
+		c.Demographics = value
+	case "data_pack":
+		c.DataPack = value
+	case "names":
+		c.Names = value
+	case "name_digits":
+		c.NameDigits, err = strconv.ParseBool(value)
+	case "birth_cohort":
+		c.BirthCohort, err = strconv.ParseBool(value)
+	case "households":
+		c.Households, err = strconv.ParseBool(value)
+	case "threads":
+		c.Threads, err = strconv.Atoi(value)
+	case "profile":
+		c.Profile, err = strconv.ParseBool(value)
+	case "keep":
+		c.Keep = value
+	case "snapshots":
+		c.Snapshots, err = strconv.ParseBool(value)
+	case "checkpoint_interval":
+		c.CheckpointInterval, err = strconv.Atoi(value)
+	default:
+		err = fmt.Errorf("Unknown configuration key '%s'", key)
+	}
+	return err
+}
+
+// Window returns the start and end dates of the simulation, resolving
+// the defaults for empty dates.
+func (c *Config) Window() (start, end time.Time, err error) {
+	end = time.Now()
+	if c.EndDate != "" {
+		end, err = time.Parse(DateFormat, c.EndDate)
+		if err != nil {
+			return start, end, fmt.Errorf("Invalid end_date '%s'", c.EndDate)
+		}
+	}
+	start = end.AddDate(-100, 0, 0)
+	if c.StartDate != "" {
+		start, err = time.Parse(DateFormat, c.StartDate)
+		if err != nil {
+			return start, end, fmt.Errorf("Invalid start_date '%s'", c.StartDate)
+		}
+	}
+	return start, end, nil
+}
+
+// Validate returns an error if the configuration cannot be run.
+func (c *Config) Validate() error {
+	if c.Population <= 0 {
+		return fmt.Errorf("population must be greater than 0, got %d", c.Population)
+	}
+	if c.TimeStep <= 0 {
+		return fmt.Errorf("time_step must be greater than 0, got %d", c.TimeStep)
+	}
+	if c.Threads < 0 {
+		return fmt.Errorf("threads must not be negative, got %d", c.Threads)
+	}
+	if c.CheckpointInterval < 0 {
+		return fmt.Errorf("checkpoint_interval must not be negative, got %d", c.CheckpointInterval)
+	}
+	if c.DataPack == "" {
+		return fmt.Errorf("data_pack must not be empty")
+	}
+	if c.Names == "" {
+		return fmt.Errorf("names must not be empty")
+	}
+	if c.Households && c.BirthCohort {
+		return fmt.Errorf("households cannot be combined with birth_cohort")
+	}
+	start, end, err := c.Window()
+	if err != nil {
+		return err
+	}
+	if !start.Before(end) {
+		return fmt.Errorf("start_date %s must be before end_date %s", start.Format(DateFormat), end.Format(DateFormat))
+	}
+	return nil
+}
+
+// splitList splits a comma-separated list, dropping empty elements.
+func splitList(list string) []string {
+	items := []string{}
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
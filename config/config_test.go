@@ -0,0 +1,118 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ConfigTestSuite struct {
+	suite.Suite
+}
+
+func TestConfigTestSuite(t *testing.T) {
+	suite.Run(t, new(ConfigTestSuite))
+}
+
+func (suite *ConfigTestSuite) TestDefaultIsValid() {
+	suite.Nil(Default().Validate())
+}
+
+func (suite *ConfigTestSuite) TestLoadShippedConfig() {
+	cfg, err := Load("synthea.yml")
+	suite.Nil(err)
+	suite.Nil(cfg.Validate())
+	suite.Equal(Default().Population, cfg.Population)
+	suite.Equal(Default().TimeStep, cfg.TimeStep)
+	suite.Equal([]string{"html"}, cfg.Exporters)
+}
+
+func (suite *ConfigTestSuite) TestLoadOverridesDefaults() {
+	cfg, err := Load("../fixtures/config/custom.yml")
+	suite.Nil(err)
+	suite.Equal(25, cfg.Population)
+	suite.Equal(1, cfg.TimeStep)
+	suite.Equal([]string{"../fixtures/gmf"}, cfg.ModuleDirs)
+	suite.Equal("concepts.csv", cfg.ExporterOptions["omop.concepts"])
+
+	// Keys missing from the file keep their default values
+	suite.Equal("output", cfg.OutputDir)
+
+	start, end, err := cfg.Window()
+	suite.Nil(err)
+	suite.Equal(time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC), start)
+	suite.Equal(time.Date(2010, time.January, 1, 0, 0, 0, 0, time.UTC), end)
+}
+
+func (suite *ConfigTestSuite) TestLoadUnknownKey() {
+	_, err := Load("../fixtures/config/unknown_key.yml")
+	suite.NotNil(err)
+}
+
+func (suite *ConfigTestSuite) TestApplyEnv() {
+	env := map[string]string{
+		"SYNTHEA_POPULATION":   "12",
+		"SYNTHEA_EXPORTERS":    "omop, hl7",
+		"SYNTHEA_SEED":         "42",
+		"SYNTHEA_BIRTH_COHORT": "true",
+		"SYNTHEA_THREADS":      "4",
+		"SYNTHEA_NAME_DIGITS":  "true",
+	}
+	lookup := func(key string) (string, bool) {
+		value, found := env[key]
+		return value, found
+	}
+
+	cfg := Default()
+	suite.Nil(cfg.ApplyEnv(lookup))
+	suite.Equal(12, cfg.Population)
+	suite.Equal([]string{"omop", "hl7"}, cfg.Exporters)
+	suite.Equal(int64(42), cfg.Seed)
+	suite.Equal(4, cfg.Threads)
+	suite.Equal(7, cfg.TimeStep)
+	suite.True(cfg.NameDigits)
+	suite.Equal("us", cfg.Names)
+
+	env["SYNTHEA_TIME_STEP"] = "weekly"
+	suite.NotNil(cfg.ApplyEnv(lookup))
+}
+
+func (suite *ConfigTestSuite) TestDefaultWindow() {
+	start, end, err := Default().Window()
+	suite.Nil(err)
+	suite.Equal(end.AddDate(-100, 0, 0), start)
+}
+
+func (suite *ConfigTestSuite) TestValidate() {
+	cfg := Default()
+	cfg.Population = 0
+	suite.NotNil(cfg.Validate())
+
+	cfg = Default()
+	cfg.TimeStep = -1
+	suite.NotNil(cfg.Validate())
+
+	cfg = Default()
+	cfg.Threads = -1
+	suite.NotNil(cfg.Validate())
+
+	cfg = Default()
+	cfg.StartDate = "2010-01-01"
+	cfg.EndDate = "2000-01-01"
+	suite.NotNil(cfg.Validate())
+
+	cfg = Default()
+	cfg.EndDate = "01/01/2000"
+	suite.NotNil(cfg.Validate())
+
+	cfg = Default()
+	cfg.Names = ""
+	suite.NotNil(cfg.Validate())
+
+	cfg = Default()
+	cfg.Households = true
+	suite.Nil(cfg.Validate())
+	cfg.BirthCohort = true
+	suite.NotNil(cfg.Validate())
+}
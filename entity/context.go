@@ -0,0 +1,48 @@
+package entity
+
+import "time"
+
+// ModuleContext is an entity's progress through a single GMF module:
+// the state it is currently in and the states it has already processed.
+type ModuleContext struct {
+	CurrentState string `json:"current_state"`
+	// Entered is when the entity entered the current state.
+	Entered time.Time `json:"entered"`
+	// DelayUntil is when the current state stops blocking, if it is a
+	// delay that has started or a wellness encounter that is waiting for
+	// its scheduled time. It is the zero time otherwise.
+	DelayUntil time.Time    `json:"delay_until"`
+	History    []StateVisit `json:"history,omitempty"`
+}
+
+// StateVisit is a state an entity has processed in a module.
+type StateVisit struct {
+	Name    string    `json:"name"`
+	Entered time.Time `json:"entered"`
+	Exited  time.Time `json:"exited"`
+}
+
+// Transition records that the entity exited the current state at the
+// given time and entered the named state.
+func (c *ModuleContext) Transition(name string, at time.Time) {
+	if c.CurrentState != "" {
+		c.History = append(c.History, StateVisit{
+			Name:    c.CurrentState,
+			Entered: c.Entered,
+			Exited:  at,
+		})
+	}
+	c.CurrentState = name
+	c.Entered = at
+	c.DelayUntil = time.Time{}
+}
+
+// Visited returns true if the named state is in the context's history.
+func (c *ModuleContext) Visited(name string) bool {
+	for _, visit := range c.History {
+		if visit.Name == name {
+			return true
+		}
+	}
+	return false
+}
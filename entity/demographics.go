@@ -1,357 +1,451 @@
 package entity
 
-import "github.com/cjduffett/synthea/utils"
-
-// Demographics is a lookup hash for demographics used to seed Synthea.
-var Demographics = map[string]interface{}{
-
-	"Race": []utils.Choice{
-		// https://en.wikipedia.org/wiki/Demographics_of_Massachusetts#Race.2C_ethnicity.2C_and_ancestry
-		utils.Choice{
-			Weight: 0.694,
-			Item:   "White",
-		},
-		utils.Choice{
-			Weight: 0.105,
-			Item:   "Hispanic",
-		},
-		utils.Choice{
-			Weight: 0.081,
-			Item:   "Black",
-		},
-		utils.Choice{
-			Weight: 0.06,
-			Item:   "Asian",
-		},
-		utils.Choice{
-			Weight: 0.05,
-			Item:   "Native",
-		},
-		utils.Choice{
-			Weight: 0.01,
-			Item:   "Other",
-		},
-	},
-
-	"Ethnicity": map[string][]utils.Choice{
-		"White": []utils.Choice{
-			// https://en.wikipedia.org/wiki/Demographics_of_Massachusetts#Race.2C_ethnicity.2C_and_ancestry
-			// Scaled out of 100% for each ethnicity.
-			utils.Choice{
-				Weight: 0.263,
-				Item:   "Irish",
-			},
-			utils.Choice{
-				Weight: 0.160,
-				Item:   "Italian",
-			},
-			utils.Choice{
-				Weight: 0.123,
-				Item:   "English",
-			},
-			utils.Choice{
-				Weight: 0.090,
-				Item:   "French",
-			},
-			utils.Choice{
-				Weight: 0.074,
-				Item:   "German",
-			},
-			utils.Choice{
-				Weight: 0.057,
-				Item:   "Polish",
-			},
-			utils.Choice{
-				Weight: 0.054,
-				Item:   "Portuguese",
-			},
-			utils.Choice{
-				Weight: 0.050,
-				Item:   "American",
-			},
-			utils.Choice{
-				Weight: 0.044,
-				Item:   "French Canadian",
-			},
-			utils.Choice{
-				Weight: 0.028,
-				Item:   "Scottish",
-			},
-			utils.Choice{
-				Weight: 0.022,
-				Item:   "Russian",
-			},
-			utils.Choice{
-				Weight: 0.021,
-				Item:   "Swedish",
-			},
-			utils.Choice{
-				Weight: 0.014,
-				Item:   "Greek",
-			},
-		},
-		"Hispanic": []utils.Choice{
-			utils.Choice{
-				Weight: 0.577,
-				Item:   "Puerto Rican",
-			},
-			utils.Choice{
-				Weight: 0.0141,
-				Item:   "Mexican",
-			},
-			utils.Choice{
-				Weight: 0.0141,
-				Item:   "Central American",
-			},
-			utils.Choice{
-				Weight: 0.0141,
-				Item:   "South American",
-			},
-		},
-		"Black": []utils.Choice{
-			utils.Choice{
-				Weight: 0.34,
-				Item:   "African",
-			},
-			utils.Choice{
-				Weight: 0.33,
-				Item:   "Dominican",
-			},
-			utils.Choice{
-				Weight: 0.33,
-				Item:   "West Indian",
-			},
-		},
-		"Asian": []utils.Choice{
-			utils.Choice{
-				Weight: 0.6,
-				Item:   "Chinese",
-			},
-			utils.Choice{
-				Weight: 0.4,
-				Item:   "Asian Indian",
-			},
-		},
-		"Native": []utils.Choice{
-			utils.Choice{
-				Weight: 1,
-				Item:   "American Indian",
-			},
-		},
-		"Other": []utils.Choice{
-			utils.Choice{
-				Weight: 1,
-				Item:   "Arab",
-			},
-		},
-	},
-
-	"BloodType": map[string][]utils.Choice{
-		// blood type data from http://www.redcrossblood.org/learn-about-blood/blood-types
-		// data for Native and Other from https://en.wikipedia.org/wiki/Blood_type_distribution_by_country
-		"White": []utils.Choice{
-			utils.Choice{
-				Weight: 0.37,
-				Item:   "o_positive",
-			},
-			utils.Choice{
-				Weight: 0.08,
-				Item:   "o_negative",
-			},
-			utils.Choice{
-				Weight: 0.33,
-				Item:   "a_positive",
-			},
-			utils.Choice{
-				Weight: 0.07,
-				Item:   "a_negative",
-			},
-			utils.Choice{
-				Weight: 0.09,
-				Item:   "b_positive",
-			},
-			utils.Choice{
-				Weight: 0.02,
-				Item:   "b_negative",
-			},
-			utils.Choice{
-				Weight: 0.03,
-				Item:   "ab_positive",
-			},
-			utils.Choice{
-				Weight: 0.01,
-				Item:   "ab_negative",
-			},
-		},
-		"Hispanic": []utils.Choice{
-			utils.Choice{
-				Weight: 0.52,
-				Item:   "o_positive",
-			},
-			utils.Choice{
-				Weight: 0.04,
-				Item:   "o_negative",
-			},
-			utils.Choice{
-				Weight: 0.29,
-				Item:   "a_positive",
-			},
-			utils.Choice{
-				Weight: 0.02,
-				Item:   "a_negative",
-			},
-			utils.Choice{
-				Weight: 0.09,
-				Item:   "b_positive",
-			},
-			utils.Choice{
-				Weight: 0.01,
-				Item:   "b_negative",
-			},
-			utils.Choice{
-				Weight: 0.02,
-				Item:   "ab_positive",
-			},
-			utils.Choice{
-				Weight: 0.01,
-				Item:   "ab_negative",
-			},
-		},
-		"Black": []utils.Choice{
-			utils.Choice{
-				Weight: 0.46,
-				Item:   "o_positive",
-			},
-			utils.Choice{
-				Weight: 0.04,
-				Item:   "o_negative",
-			},
-			utils.Choice{
-				Weight: 0.24,
-				Item:   "a_positive",
-			},
-			utils.Choice{
-				Weight: 0.02,
-				Item:   "a_negative",
-			},
-			utils.Choice{
-				Weight: 0.18,
-				Item:   "b_positive",
-			},
-			utils.Choice{
-				Weight: 0.01,
-				Item:   "b_negative",
-			},
-			utils.Choice{
-				Weight: 0.04,
-				Item:   "ab_positive",
-			},
-			utils.Choice{
-				Weight: 0.01,
-				Item:   "ab_negative",
-			},
-		},
-		"Asian": []utils.Choice{
-			utils.Choice{
-				Weight: 0.39,
-				Item:   "o_positive",
-			},
-			utils.Choice{
-				Weight: 0.01,
-				Item:   "o_negative",
-			},
-			utils.Choice{
-				Weight: 0.26,
-				Item:   "a_positive",
-			},
-			utils.Choice{
-				Weight: 0.01,
-				Item:   "a_negative",
-			},
-			utils.Choice{
-				Weight: 0.25,
-				Item:   "b_positive",
-			},
-			utils.Choice{
-				Weight: 0.01,
-				Item:   "b_negative",
-			},
-			utils.Choice{
-				Weight: 0.06,
-				Item:   "ab_positive",
-			},
-			utils.Choice{
-				Weight: 0.01,
-				Item:   "ab_negative",
-			},
-		},
-		"Native": []utils.Choice{
-			utils.Choice{
-				Weight: 0.374,
-				Item:   "o_positive",
-			},
-			utils.Choice{
-				Weight: 0.066,
-				Item:   "o_negative",
-			},
-			utils.Choice{
-				Weight: 0.357,
-				Item:   "a_positive",
-			},
-			utils.Choice{
-				Weight: 0.063,
-				Item:   "a_negative",
-			},
-			utils.Choice{
-				Weight: 0.085,
-				Item:   "b_positive",
-			},
-			utils.Choice{
-				Weight: 0.015,
-				Item:   "b_negative",
-			},
-			utils.Choice{
-				Weight: 0.034,
-				Item:   "ab_positive",
-			},
-			utils.Choice{
-				Weight: 0.006,
-				Item:   "ab_negative",
-			},
-		},
-		"Other": []utils.Choice{
-			utils.Choice{
-				Weight: 0.374,
-				Item:   "o_positive",
-			},
-			utils.Choice{
-				Weight: 0.066,
-				Item:   "o_negative",
-			},
-			utils.Choice{
-				Weight: 0.357,
-				Item:   "a_positive",
-			},
-			utils.Choice{
-				Weight: 0.063,
-				Item:   "a_negative",
-			},
-			utils.Choice{
-				Weight: 0.085,
-				Item:   "b_positive",
-			},
-			utils.Choice{
-				Weight: 0.015,
-				Item:   "b_negative",
-			},
-			utils.Choice{
-				Weight: 0.034,
-				Item:   "ab_positive",
-			},
-			utils.Choice{
-				Weight: 0.006,
-				Item:   "ab_negative",
-			},
-		},
-	},
+import (
+	"embed"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"math/rand"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cjduffett/synthea/utils"
+)
+
+// DataPackFormat is the data pack format version this version of Synthea reads.
+const DataPackFormat = 1
+
+// DefaultDataPack is the name of the built-in data pack used if no other
+// is loaded.
+const DefaultDataPack = "massachusetts"
+
+// weightTolerance is how far the weights of a distribution may sum from 1.
+const weightTolerance = 0.01
+
+//go:embed packs/*.json
+var builtinPacks embed.FS
+
+// Demographics is the default data pack, used by NewPatient and
+// NewBirthCohortPatient. It is the built-in Massachusetts pack. Use the
+// DataPack methods to generate patients from another pack.
+var Demographics = mustLoadBuiltinDataPack(DefaultDataPack)
+
+// DataPack holds the statewide demographic tables used to generate
+// patients. Data packs are versioned and are either a single JSON file:
+//
+//	{
+//	  "format": 1,
+//	  "name": "Massachusetts",
+//	  "version": "2016.1",
+//	  "sources": ["https://..."],
+//	  "state": "MA",
+//	  "race": {"White": 0.8, "Black": 0.2},
+//	  "ethnicity": {"White": {"Irish": 1}, "Black": {"African": 1}},
+//	  "blood_type": {"White": {"o_positive": 1}, "Black": {"o_positive": 1}},
+//	  "income": {"0..49999": 0.5, "50000..150000": 0.5},
+//	  "education": {"hs_degree": 0.5, "bs_degree": 0.5},
+//	  "age_sex": {"0..17": {"Male": 0.1, "Female": 0.1}, "18..99": {"Male": 0.4, "Female": 0.4}}
+//	}
+//
+// or a directory holding a pack.json with the format, name, version,
+// sources and state, and one CSV file (with a header row) per table:
+//
+//	race.csv        race,weight
+//	ethnicity.csv   race,ethnicity,weight
+//	blood_type.csv  race,blood_type,weight
+//	income.csv      range,weight
+//	education.csv   education,weight
+//	age_sex.csv     ages,gender,weight
+//
+// Every race must have an ethnicity and blood type distribution, and the
+// weights of each distribution must be positive and sum to 1. The age_sex
+// table is a census population pyramid: the share of the population in
+// each age bracket and sex, so its weights sum to 1 across all brackets.
+// The optional state is the abbreviation of the state patients live in,
+// which must be in the gazetteer (see gazetteer.csv); without it patients
+// live anywhere in the gazetteer.
+type DataPack struct {
+	Format    int                           `json:"format"`
+	Name      string                        `json:"name"`
+	Version   string                        `json:"version"`
+	Sources   []string                      `json:"sources"`
+	State     string                        `json:"state"`
+	Race      map[string]float64            `json:"race"`
+	Ethnicity map[string]map[string]float64 `json:"ethnicity"`
+	BloodType map[string]map[string]float64 `json:"blood_type"`
+	Income    map[string]float64            `json:"income"`
+	Education map[string]float64            `json:"education"`
+	AgeSex    map[string]map[string]float64 `json:"age_sex"`
+
+	// Parsed distributions, built when the pack is loaded.
+	race      []utils.Choice
+	ethnicity map[string][]utils.Choice
+	bloodType map[string][]utils.Choice
+	income    []utils.Choice
+	education []utils.Choice
+	ageSex    []utils.Choice
+
+	// The names patients are named from, see WithNames.
+	names      *Names
+	nameDigits bool
+}
+
+// ageSex is one cell of an age-by-sex table.
+type ageSex struct {
+	ages   bracket
+	gender string
+}
+
+// LoadDataPack loads and validates a data pack. name may be the path to a
+// JSON pack or a CSV pack directory, or the name of a built-in pack.
+func LoadDataPack(name string) (*DataPack, error) {
+	info, err := os.Stat(name)
+	if err != nil {
+		if _, berr := fs.Stat(builtinPacks, builtinPackPath(name)); berr == nil {
+			return loadBuiltinDataPack(name)
+		}
+		return nil, fmt.Errorf("Data pack '%s' not found (built-in packs: %s)", name, strings.Join(BuiltinDataPacks(), ", "))
+	}
+
+	var pack *DataPack
+	if info.IsDir() {
+		pack, err = readCSVDataPack(name)
+	} else {
+		pack, err = readJSONDataPack(name)
+	}
+	if err == nil {
+		err = pack.parse()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Invalid data pack %s: %s", name, err.Error())
+	}
+	return pack, nil
+}
+
+// BuiltinDataPacks returns the names of the data packs built into Synthea.
+func BuiltinDataPacks() []string {
+	var names []string
+	entries, _ := builtinPacks.ReadDir("packs")
+	for _, entry := range entries {
+		names = append(names, strings.TrimSuffix(entry.Name(), ".json"))
+	}
+	return names
+}
+
+func builtinPackPath(name string) string {
+	return path.Join("packs", name+".json")
+}
+
+func loadBuiltinDataPack(name string) (*DataPack, error) {
+	data, err := builtinPacks.ReadFile(builtinPackPath(name))
+	if err != nil {
+		return nil, err
+	}
+	pack := &DataPack{}
+	err = json.Unmarshal(data, pack)
+	if err == nil {
+		err = pack.parse()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Invalid built-in data pack '%s': %s", name, err.Error())
+	}
+	return pack, nil
+}
+
+func mustLoadBuiltinDataPack(name string) *DataPack {
+	pack, err := loadBuiltinDataPack(name)
+	if err != nil {
+		panic(err.Error())
+	}
+	return pack
+}
+
+func readJSONDataPack(path string) (*DataPack, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	pack := &DataPack{}
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(pack)
+	return pack, err
+}
+
+func readCSVDataPack(dir string) (*DataPack, error) {
+	pack, err := readJSONDataPack(filepath.Join(dir, "pack.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	if pack.Race, err = readWeightsCSV(filepath.Join(dir, "race.csv")); err != nil {
+		return nil, err
+	}
+	if pack.Ethnicity, err = readNestedWeightsCSV(filepath.Join(dir, "ethnicity.csv")); err != nil {
+		return nil, err
+	}
+	if pack.BloodType, err = readNestedWeightsCSV(filepath.Join(dir, "blood_type.csv")); err != nil {
+		return nil, err
+	}
+	if pack.Income, err = readWeightsCSV(filepath.Join(dir, "income.csv")); err != nil {
+		return nil, err
+	}
+	if pack.Education, err = readWeightsCSV(filepath.Join(dir, "education.csv")); err != nil {
+		return nil, err
+	}
+	if pack.AgeSex, err = readNestedWeightsCSV(filepath.Join(dir, "age_sex.csv")); err != nil {
+		return nil, err
+	}
+	return pack, nil
+}
+
+// readCSV reads all rows of a CSV file with the given number of columns,
+// skipping the header row.
+func readCSV(path string, columns int) ([][]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return parseCSV(file, filepath.Base(path), columns)
+}
+
+// parseCSV reads all rows of the CSV file name from r, like readCSV.
+func parseCSV(r io.Reader, name string, columns int) ([][]string, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = columns
+	reader.TrimLeadingSpace = true
+
+	var rows [][]string
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err.Error())
+		}
+		rows = append(rows, row)
+	}
+	if len(rows) < 2 {
+		return nil, fmt.Errorf("%s: no rows found", name)
+	}
+	return rows[1:], nil
+}
+
+func readWeightsCSV(path string) (map[string]float64, error) {
+	rows, err := readCSV(path, 2)
+	if err != nil {
+		return nil, err
+	}
+	weights := make(map[string]float64)
+	for _, row := range rows {
+		weight, err := strconv.ParseFloat(row[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: weight '%s' for '%s' is not a number", filepath.Base(path), row[1], row[0])
+		}
+		weights[row[0]] = weight
+	}
+	return weights, nil
+}
+
+func readNestedWeightsCSV(path string) (map[string]map[string]float64, error) {
+	rows, err := readCSV(path, 3)
+	if err != nil {
+		return nil, err
+	}
+	weights := make(map[string]map[string]float64)
+	for _, row := range rows {
+		weight, err := strconv.ParseFloat(row[2], 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: weight '%s' for '%s' is not a number", filepath.Base(path), row[2], row[1])
+		}
+		if weights[row[0]] == nil {
+			weights[row[0]] = make(map[string]float64)
+		}
+		weights[row[0]][row[1]] = weight
+	}
+	return weights, nil
+}
+
+// parse validates the data pack and builds its distributions.
+func (d *DataPack) parse() error {
+	var err error
+	if d.Format != DataPackFormat {
+		return fmt.Errorf("unsupported format %d, expected %d", d.Format, DataPackFormat)
+	}
+	if d.Name == "" || d.Version == "" {
+		return errors.New("missing 'name' or 'version'")
+	}
+	if d.State != "" && !defaultGazetteer.hasState(d.State) {
+		return fmt.Errorf("no places in the gazetteer for state '%s'", d.State)
+	}
+	if d.race, err = parseWeights("race", d.Race, nil); err != nil {
+		return err
+	}
+	if d.income, err = parseWeights("income", d.Income, parseBracket); err != nil {
+		return err
+	}
+	if d.education, err = parseWeights("education", d.Education, nil); err != nil {
+		return err
+	}
+	if d.ageSex, err = parseAgeSex("age_sex", d.AgeSex); err != nil {
+		return err
+	}
+
+	d.ethnicity = make(map[string][]utils.Choice)
+	d.bloodType = make(map[string][]utils.Choice)
+	for race := range d.Race {
+		if d.ethnicity[race], err = parseWeights("ethnicity."+race, d.Ethnicity[race], nil); err != nil {
+			return err
+		}
+		if d.bloodType[race], err = parseWeights("blood_type."+race, d.BloodType[race], nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckTowns returns an error if any of the towns has a race that is not
+// one of the data pack's races, which the pack has no ethnicity or blood
+// type distribution for.
+func (d *DataPack) CheckTowns(towns []*Town) error {
+	for _, town := range towns {
+		for race := range town.Race {
+			if _, ok := d.Race[race]; !ok {
+				var races []string
+				for r := range d.Race {
+					races = append(races, r)
+				}
+				sort.Strings(races)
+				return fmt.Errorf("Town '%s': race '%s' is not one of the races of data pack %s (%s)", town.Name, race, d.Name, strings.Join(races, ", "))
+			}
+		}
+	}
+	return nil
+}
+
+// parseWeights validates a map of weights and converts it into choices,
+// sorted by key so that choices are made in a consistent order. If
+// parseKey is not nil it converts each key into the choice's Item.
+func parseWeights(name string, weights map[string]float64, parseKey func(string) (interface{}, error)) ([]utils.Choice, error) {
+	if len(weights) == 0 {
+		return nil, fmt.Errorf("no '%s' distribution found", name)
+	}
+
+	var keys []string
+	total := 0.0
+	for key, weight := range weights {
+		if !(weight > 0) || math.IsInf(weight, 0) {
+			return nil, fmt.Errorf("'%s' weight for '%s' must be greater than 0", name, key)
+		}
+		keys = append(keys, key)
+		total += weight
+	}
+	if math.Abs(total-1) > weightTolerance {
+		return nil, fmt.Errorf("'%s' weights sum to %g, expected 1", name, total)
+	}
+	sort.Strings(keys)
+
+	choices := make([]utils.Choice, len(keys))
+	for i, key := range keys {
+		var item interface{} = key
+		if parseKey != nil {
+			var err error
+			if item, err = parseKey(key); err != nil {
+				return nil, fmt.Errorf("'%s': %s", name, err.Error())
+			}
+		}
+		choices[i] = utils.Choice{Weight: weights[key], Item: item}
+	}
+	return choices, nil
+}
+
+// parseAgeSex validates an age-by-sex table and converts it into choices
+// of ageSex. The weights of every cell together must sum to 1.
+func parseAgeSex(name string, table map[string]map[string]float64) ([]utils.Choice, error) {
+	weights := make(map[string]float64)
+	for ages, genders := range table {
+		for gender, weight := range genders {
+			if gender != "Male" && gender != "Female" {
+				return nil, fmt.Errorf("'%s' gender '%s' must be Male or Female", name, gender)
+			}
+			weights[ages+"/"+gender] = weight
+		}
+	}
+	return parseWeights(name, weights, func(key string) (interface{}, error) {
+		parts := strings.SplitN(key, "/", 2)
+		ages, err := parseBracket(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		return ageSex{ages: ages.(bracket), gender: parts[1]}, nil
+	})
+}
+
+// pickAgeSex returns a random age and gender from the data pack's
+// age-by-sex table.
+func (d *DataPack) pickAgeSex(rng *rand.Rand) (int, string) {
+	cell, _ := utils.WeightedChoice(rng, d.ageSex).Item.(ageSex)
+	return cell.ages.pick(rng), cell.gender
+}
+
+// pickSexAtBirth returns a random gender using the sex ratio of the
+// youngest age bracket in the data pack's age-by-sex table.
+func (d *DataPack) pickSexAtBirth(rng *rand.Rand) string {
+	youngest := -1
+	var choices []utils.Choice
+	for _, choice := range d.ageSex {
+		cell := choice.Item.(ageSex)
+		if youngest == -1 || cell.ages.low < youngest {
+			youngest = cell.ages.low
+			choices = nil
+		}
+		if cell.ages.low == youngest {
+			choices = append(choices, choice)
+		}
+	}
+	total := 0.0
+	for _, choice := range choices {
+		total += choice.Weight
+	}
+	for i := range choices {
+		choices[i].Weight /= total
+	}
+	cell, _ := utils.WeightedChoice(rng, choices).Item.(ageSex)
+	return cell.gender
+}
+
+// bracket is an inclusive range parsed from a "low..high" key.
+type bracket struct {
+	low  int
+	high int
+}
+
+func parseBracket(key string) (interface{}, error) {
+	parts := strings.Split(key, "..")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("'%s' is not a range of the form low..high", key)
+	}
+	low, lerr := strconv.Atoi(parts[0])
+	high, herr := strconv.Atoi(parts[1])
+	if lerr != nil || herr != nil || low < 0 || high < low {
+		return nil, fmt.Errorf("'%s' is not a range of the form low..high", key)
+	}
+	return bracket{low: low, high: high}, nil
+}
+
+// pick returns a uniformly random value within the bracket.
+func (b bracket) pick(rng *rand.Rand) int {
+	return b.low + rng.Intn(b.high-b.low+1)
 }
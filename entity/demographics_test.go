@@ -0,0 +1,101 @@
+package entity
+
+import (
+	"testing"
+
+	"github.com/cjduffett/synthea/utils"
+	"github.com/stretchr/testify/suite"
+)
+
+type DataPackTestSuite struct {
+	suite.Suite
+}
+
+func TestDataPackTestSuite(t *testing.T) {
+	suite.Run(t, new(DataPackTestSuite))
+}
+
+func (t *DataPackTestSuite) TestBuiltinDataPacks() {
+	t.Equal([]string{"massachusetts", "texas"}, BuiltinDataPacks())
+
+	for _, name := range BuiltinDataPacks() {
+		pack, err := LoadDataPack(name)
+		t.Require().Nil(err)
+		t.Equal(DataPackFormat, pack.Format)
+		t.NotEmpty(pack.Sources)
+	}
+	t.Equal("Massachusetts", Demographics.Name, "Massachusetts should be the default data pack")
+}
+
+func (t *DataPackTestSuite) TestLoadCSVDataPack() {
+	pack, err := LoadDataPack("../fixtures/demographics/csv_pack")
+	t.Require().Nil(err)
+	t.Equal("Testland", pack.Name)
+	t.Equal("1.0", pack.Version)
+	t.Equal(map[string]float64{"White": 0.75, "Black": 0.25}, pack.Race)
+	t.Equal(map[string]float64{"Irish": 0.6, "Italian": 0.4}, pack.Ethnicity["White"])
+	t.Equal(1.0, pack.BloodType["Black"]["o_positive"])
+	t.Equal(2, len(pack.income))
+	t.Equal(bracket{low: 50000, high: 150000}, pack.income[1].Item)
+	t.Equal(0.45, pack.AgeSex["18..99"]["Female"])
+	t.Equal(4, len(pack.ageSex))
+}
+
+func (t *DataPackTestSuite) TestLoadInvalidDataPacks() {
+	_, err := LoadDataPack("../fixtures/demographics/invalid_pack.json")
+	t.NotNil(err)
+	t.Contains(err.Error(), "'ethnicity.White' weights sum to 0.8")
+
+	_, err = LoadDataPack("../fixtures/demographics/missing_race_pack.json")
+	t.NotNil(err)
+	t.Contains(err.Error(), "no 'ethnicity.Black' distribution found")
+
+	_, err = LoadDataPack("../fixtures/demographics/future_pack.json")
+	t.NotNil(err)
+	t.Contains(err.Error(), "unsupported format 2")
+
+	_, err = LoadDataPack("atlantis")
+	t.NotNil(err)
+	t.Contains(err.Error(), "massachusetts, texas")
+}
+
+func (t *DataPackTestSuite) TestCheckTowns() {
+	towns, err := LoadTowns("../fixtures/demographics/towns.json")
+	t.Require().Nil(err)
+	t.Nil(Demographics.CheckTowns(towns))
+
+	pack, err := LoadDataPack("../fixtures/demographics/csv_pack")
+	t.Require().Nil(err)
+	err = pack.CheckTowns(towns)
+	t.NotNil(err)
+	t.Contains(err.Error(), "Town 'Boston'")
+	t.Contains(err.Error(), "is not one of the races of data pack")
+	t.Contains(err.Error(), "(Black, White)")
+}
+
+func (t *DataPackTestSuite) TestPickAgeSex() {
+	pack, err := LoadDataPack("../fixtures/demographics/csv_pack")
+	t.Require().Nil(err)
+	rng := utils.NewRand(1)
+
+	children := 0
+	for i := 0; i < 1000; i++ {
+		age, gender := pack.pickAgeSex(rng)
+		t.True(age >= 0 && age <= 99, "Age out of range")
+		t.True(contains([]string{"Male", "Female"}, gender), "Invalid gender")
+		if age < 18 {
+			children++
+		}
+	}
+	// 20% of the fixture population is under 18
+	t.InDelta(200, children, 60)
+
+	// Only the youngest bracket, which is evenly split, decides sex at birth
+	males := 0
+	for i := 0; i < 1000; i++ {
+		if pack.pickSexAtBirth(rng) == "Male" {
+			males++
+		}
+	}
+	t.InDelta(500, males, 60)
+}
@@ -1,6 +1,8 @@
 package entity
 
 import (
+	"math/rand"
+	"sort"
 	"time"
 
 	"github.com/cjduffett/synthea/records"
@@ -12,19 +14,132 @@ type Entity struct {
 	Patient       Patient
 	Record        records.Record
 	Attributes    map[string]interface{}
+	contexts      map[string]*ModuleContext
+	symptoms      map[string]map[string]int // symptom -> cause -> severity
+	rng           *rand.Rand
 	lastWellVisit time.Time
 }
 
-/*
-// NextWellnessEncounter returns the next wellness
-// encounter that should be processed given the current
-// simulation time.
+// NewEntity returns a new Entity to simulate for the given patient. All
+// random choices made while simulating the entity use rng, which is
+// usually the same generator the patient was created with.
+func NewEntity(patient *Patient, rng *rand.Rand) *Entity {
+	return &Entity{
+		Patient:    *patient,
+		Attributes: make(map[string]interface{}),
+		contexts:   make(map[string]*ModuleContext),
+		symptoms:   make(map[string]map[string]int),
+		rng:        rng,
+	}
+}
+
+// Rand returns the entity's random number generator.
+func (e *Entity) Rand() *rand.Rand {
+	if e.rng == nil {
+		e.rng = rand.New(rand.NewSource(0))
+	}
+	return e.rng
+}
+
+// Context returns the entity's progress through the named module,
+// starting a new context if the entity has not entered the module yet.
+func (e *Entity) Context(module string) *ModuleContext {
+	if e.contexts == nil {
+		e.contexts = make(map[string]*ModuleContext)
+	}
+	ctx, ok := e.contexts[module]
+	if !ok {
+		ctx = &ModuleContext{}
+		e.contexts[module] = ctx
+	}
+	return ctx
+}
+
+// Modules returns the names of the modules the entity has entered,
+// sorted.
+func (e *Entity) Modules() []string {
+	names := make([]string, 0, len(e.contexts))
+	for name := range e.contexts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// VisitedState returns true if the entity has processed the named state
+// in any module.
+func (e *Entity) VisitedState(name string) bool {
+	for _, ctx := range e.contexts {
+		if ctx.Visited(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Alive returns true if the entity is alive at the given time.
+func (e *Entity) Alive(time time.Time) bool {
+	return !e.Record.Expired() || e.Record.DeathTime().After(time)
+}
+
+// SetSymptom sets the severity of a symptom caused by cause.
+func (e *Entity) SetSymptom(cause, symptom string, severity int) {
+	if e.symptoms == nil {
+		e.symptoms = make(map[string]map[string]int)
+	}
+	if e.symptoms[symptom] == nil {
+		e.symptoms[symptom] = make(map[string]int)
+	}
+	e.symptoms[symptom][cause] = severity
+}
+
+// Symptom returns the severity of a symptom, the highest severity of
+// all of its causes. It is 0 if the entity doesn't have the symptom.
+func (e *Entity) Symptom(symptom string) int {
+	severity := 0
+	for _, s := range e.symptoms[symptom] {
+		if s > severity {
+			severity = s
+		}
+	}
+	return severity
+}
+
+// NextWellnessEncounter returns when the entity's next wellness
+// encounter after the given time is scheduled: an interval after their
+// last wellness encounter that depends on their age at it, or after
+// their birth if they have not had one. An overdue encounter is
+// scheduled at the given time.
 func (e *Entity) NextWellnessEncounter(time time.Time) time.Time {
-	age := e.Patient.getAgeAtTime(time)
+	last := e.lastWellVisit
+	if last.IsZero() {
+		last = e.Patient.BirthDate()
+	}
+	next := last.AddDate(0, wellnessEncounterSchedule(e.Patient.AgeAt(last)), 0)
+	if next.Before(time) {
+		return time
+	}
+	return next
+}
+
+// SetLastWellnessEncounter records that the entity had a wellness
+// encounter at the given time, from which the next one is scheduled.
+func (e *Entity) SetLastWellnessEncounter(time time.Time) {
+	e.lastWellVisit = time
 }
 
+// wellnessEncounterSchedule returns the number of months between
+// wellness encounters for a patient of the given age.
 func wellnessEncounterSchedule(age int) int {
-	// Based on the age, return a factor that becomes the duration
-	// between scheduled wellness encounters.
+	switch {
+	case age < 3:
+		return 6
+	case age < 20:
+		return 12
+	case age < 40:
+		return 36
+	case age < 50:
+		return 24
+	}
+	return 12
 }
-*/
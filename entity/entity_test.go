@@ -0,0 +1,89 @@
+package entity
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/cjduffett/synthea/records"
+	"github.com/cjduffett/synthea/utils"
+	"github.com/stretchr/testify/suite"
+)
+
+type EntityTestSuite struct {
+	suite.Suite
+	entity *Entity
+	now    time.Time
+}
+
+func TestEntityTestSuite(t *testing.T) {
+	suite.Run(t, new(EntityTestSuite))
+}
+
+func (e *EntityTestSuite) SetupTest() {
+	e.now = time.Date(2016, time.December, 1, 0, 0, 0, 0, time.UTC)
+	rng := utils.NewRand(1)
+	e.entity = NewEntity(NewPatient(rng, e.now.AddDate(-100, 0, 0), e.now, nil), rng)
+}
+
+func (e *EntityTestSuite) TestAlive() {
+	e.True(e.entity.Alive(e.now))
+	e.entity.Record.Death(e.now, nil)
+	e.True(e.entity.Alive(e.now.Add(-time.Second)), "Patient should be alive before they die")
+	e.False(e.entity.Alive(e.now))
+}
+
+func (e *EntityTestSuite) TestContext() {
+	ctx := e.entity.Context("Diabetes")
+	e.Equal("", ctx.CurrentState)
+	ctx.Transition("Initial", e.now)
+	ctx.Transition("Terminal", e.now.AddDate(0, 0, 1))
+	e.Equal(ctx, e.entity.Context("Diabetes"), "Context should be kept for the module")
+	e.Equal("Terminal", ctx.CurrentState)
+	e.Equal([]StateVisit{{Name: "Initial", Entered: e.now, Exited: e.now.AddDate(0, 0, 1)}}, ctx.History)
+	e.True(e.entity.VisitedState("Initial"))
+	e.False(e.entity.VisitedState("Terminal"), "The current state has not been processed yet")
+
+	e.entity.Context("Asthma")
+	e.Equal([]string{"Asthma", "Diabetes"}, e.entity.Modules())
+}
+
+func (e *EntityTestSuite) TestSymptom() {
+	e.Equal(0, e.entity.Symptom("Fatigue"))
+	e.entity.SetSymptom("Diabetes", "Fatigue", 20)
+	e.entity.SetSymptom("Anemia", "Fatigue", 40)
+	e.Equal(40, e.entity.Symptom("Fatigue"), "Symptom severity is the highest of its causes")
+}
+
+func (e *EntityTestSuite) TestSnapshot() {
+	diabetes := []records.Code{{System: "SNOMED-CT", Code: "44054006", Display: "Diabetes"}}
+	e.entity.Record.AddCondition(records.Condition{Codes: diabetes, Start: e.now})
+	e.entity.Record.AddEncounter(records.Encounter{Class: "ambulatory", Codes: diabetes, Start: e.now, Stop: e.now})
+	e.entity.Attributes["diabetes"] = diabetes
+	e.entity.Attributes["count"] = 2.0
+	e.entity.Attributes["smoker"] = true
+	e.entity.Attributes["nothing"] = nil
+	e.entity.Context("Diabetes").Transition("Initial", e.now)
+	e.entity.Context("Diabetes").Transition("Onset", e.now)
+	e.entity.SetSymptom("Diabetes", "Fatigue", 20)
+
+	birth := e.entity.Patient.BirthDate()
+	var buf bytes.Buffer
+	e.Require().Nil(e.entity.Snapshot(birth, e.now, 7*24*time.Hour).WriteJSON(&buf))
+	snapshot, err := ReadSnapshot(&buf)
+	e.Require().Nil(err)
+	restored := snapshot.Entity
+
+	e.Equal(birth, snapshot.Start)
+	e.Equal(e.now, snapshot.Until)
+	e.Equal(7*24*time.Hour, snapshot.Step)
+	e.Equal(e.entity.Patient, restored.Patient)
+	e.Equal(e.entity.Record, restored.Record)
+	e.Equal(e.entity.Attributes, restored.Attributes)
+	e.Equal(e.entity.Context("Diabetes"), restored.Context("Diabetes"))
+	e.Equal(20, restored.Symptom("Fatigue"))
+	e.Equal(e.entity.Rand().Int63(), restored.Rand().Int63(), "The entity and its copy draw the same random numbers")
+
+	_, err = ReadSnapshot(bytes.NewBufferString(`{"step": 1}`))
+	e.NotNil(err)
+}
@@ -0,0 +1,174 @@
+package entity
+
+import (
+	"bytes"
+	_ "embed" // for the built-in gazetteer
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"github.com/cjduffett/synthea/utils"
+)
+
+// earthRadius is the mean radius of the Earth, in km.
+const earthRadius = 6371.0
+
+//go:embed gazetteer.csv
+var gazetteerCSV []byte
+
+// defaultGazetteer is the built-in gazetteer, which addresses and places
+// of birth are drawn from.
+var defaultGazetteer = mustLoadGazetteer()
+
+// place is a city in the gazetteer, with its postal codes and the
+// latitude and longitude of its center.
+type place struct {
+	city        string
+	state       string
+	postalCodes []string
+	latitude    float64
+	longitude   float64
+	population  int
+}
+
+// gazetteer holds the places addresses are drawn from. The built-in
+// gazetteer is read from gazetteer.csv, which has a header row and one row
+// per city:
+//
+//	city,state,postal_codes,latitude,longitude,population
+//	Boston,MA,02108 02109 02110,42.3601,-71.0589,675647
+//
+// Postal codes are separated by spaces, and every postal code of a city
+// is in its state. The same city name may appear in several states.
+type gazetteer struct {
+	places []*place
+}
+
+func mustLoadGazetteer() *gazetteer {
+	g, err := parseGazetteer(gazetteerCSV)
+	if err != nil {
+		panic(fmt.Sprintf("Invalid built-in gazetteer: %s", err.Error()))
+	}
+	return g
+}
+
+// parseGazetteer parses and validates a gazetteer in CSV form.
+func parseGazetteer(data []byte) (*gazetteer, error) {
+	rows, err := parseCSV(bytes.NewReader(data), "gazetteer.csv", 6)
+	if err != nil {
+		return nil, err
+	}
+	g := &gazetteer{}
+	for _, row := range rows {
+		p := &place{city: row[0], state: row[1], postalCodes: strings.Fields(row[2])}
+		if p.city == "" || p.state == "" || len(p.postalCodes) == 0 {
+			return nil, fmt.Errorf("missing city, state or postal codes in %v", row)
+		}
+		p.latitude, err = strconv.ParseFloat(row[3], 64)
+		if err == nil {
+			p.longitude, err = strconv.ParseFloat(row[4], 64)
+		}
+		if err == nil {
+			p.population, err = strconv.Atoi(row[5])
+		}
+		if err != nil || p.population <= 0 {
+			return nil, fmt.Errorf("invalid location or population for %s, %s", p.city, p.state)
+		}
+		g.places = append(g.places, p)
+	}
+	return g, nil
+}
+
+// hasState returns true if the gazetteer has places in state.
+func (g *gazetteer) hasState(state string) bool {
+	for _, p := range g.places {
+		if p.state == state {
+			return true
+		}
+	}
+	return false
+}
+
+// pick picks a place that matches, weighted by population, or returns nil
+// if no place matches.
+func (g *gazetteer) pick(rng *rand.Rand, matches func(p *place) bool) *place {
+	var choices []utils.Choice
+	total := 0
+	for _, p := range g.places {
+		if matches(p) {
+			total += p.population
+			choices = append(choices, utils.Choice{Weight: float64(p.population), Item: p})
+		}
+	}
+	if len(choices) == 0 {
+		return nil
+	}
+	for i := range choices {
+		choices[i].Weight /= float64(total)
+	}
+	p, _ := utils.WeightedChoice(rng, choices).Item.(*place)
+	return p
+}
+
+// find returns the place of a town, preferring the city with postalCode if
+// several cities of the state share the town's name, or nil if the town is
+// not in the gazetteer.
+func (g *gazetteer) find(city, state, postalCode string) *place {
+	var found *place
+	for _, p := range g.places {
+		if p.city != city || p.state != state {
+			continue
+		}
+		for _, code := range p.postalCodes {
+			if code == postalCode {
+				return p
+			}
+		}
+		if found == nil {
+			found = p
+		}
+	}
+	return found
+}
+
+// nearest returns the place of at least minPopulation that is closest to
+// the given latitude and longitude, or nil if there is none.
+func (g *gazetteer) nearest(latitude, longitude float64, minPopulation int) *place {
+	var nearest *place
+	best := math.Inf(1)
+	for _, p := range g.places {
+		if p.population < minPopulation {
+			continue
+		}
+		if d := distance(latitude, longitude, p.latitude, p.longitude); d < best {
+			nearest, best = p, d
+		}
+	}
+	return nearest
+}
+
+// pickPostalCode picks one of the place's postal codes.
+func (p *place) pickPostalCode(rng *rand.Rand) string {
+	return p.postalCodes[rng.Intn(len(p.postalCodes))]
+}
+
+// locate picks a location in the place, around its center. Larger places
+// spread further: about 5 km for a city of a million.
+func (p *place) locate(rng *rand.Rand) (latitude, longitude float64) {
+	spread := math.Min(0.005*math.Sqrt(float64(p.population)/10000), 0.15)
+	latitude = p.latitude + (2*rng.Float64()-1)*spread
+	longitude = p.longitude + (2*rng.Float64()-1)*spread
+	return
+}
+
+// distance returns the great-circle distance between two locations, in km.
+func distance(lat1, lon1, lat2, lon2 float64) float64 {
+	toRadians := math.Pi / 180
+	dLat := (lat2 - lat1) * toRadians
+	dLon := (lon2 - lon1) * toRadians
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*toRadians)*math.Cos(lat2*toRadians)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(a))
+}
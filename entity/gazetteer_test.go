@@ -0,0 +1,86 @@
+package entity
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cjduffett/synthea/utils"
+	"github.com/stretchr/testify/suite"
+)
+
+type GazetteerTestSuite struct {
+	suite.Suite
+}
+
+func TestGazetteerTestSuite(t *testing.T) {
+	suite.Run(t, new(GazetteerTestSuite))
+}
+
+func (t *GazetteerTestSuite) TestBuiltinGazetteer() {
+	postalCodes := make(map[string]*place)
+	for _, p := range defaultGazetteer.places {
+		for _, code := range p.postalCodes {
+			t.Len(code, 5)
+			t.Nil(postalCodes[code], "Postal code %s is in one city", code)
+			postalCodes[code] = p
+		}
+		t.InDelta(40, p.latitude, 25, p.city)
+		t.InDelta(-100, p.longitude, 60, p.city)
+	}
+	// Patients may be born in any state.
+	for state := range driversLicenses {
+		t.True(defaultGazetteer.hasState(state), state)
+	}
+}
+
+func (t *GazetteerTestSuite) TestInvalidGazetteer() {
+	_, err := parseGazetteer([]byte("city,state,postal_codes,latitude,longitude,population\nBoston,MA,,42.36,-71.06,675647\n"))
+	t.NotNil(err)
+	_, err = parseGazetteer([]byte("city,state,postal_codes,latitude,longitude,population\nBoston,MA,02108,north,-71.06,675647\n"))
+	t.NotNil(err)
+}
+
+func (t *GazetteerTestSuite) TestDistance() {
+	boston := defaultGazetteer.find("Boston", "MA", "")
+	newYork := defaultGazetteer.find("New York", "NY", "")
+	t.InDelta(306, distance(boston.latitude, boston.longitude, newYork.latitude, newYork.longitude), 5)
+	t.Equal(0.0, distance(boston.latitude, boston.longitude, boston.latitude, boston.longitude))
+}
+
+func (t *GazetteerTestSuite) TestNearest() {
+	nantucket := defaultGazetteer.find("Nantucket", "MA", "02554")
+	t.Equal("Barnstable", defaultGazetteer.nearest(nantucket.latitude, nantucket.longitude, hospitalPopulation).city)
+	t.Equal("Nantucket", defaultGazetteer.nearest(nantucket.latitude, nantucket.longitude, 0).city)
+	t.Nil(defaultGazetteer.nearest(0, 0, 100000000))
+}
+
+func (t *GazetteerTestSuite) TestTownAddresses() {
+	towns, err := LoadTowns("../fixtures/demographics/towns.json")
+	t.Require().Nil(err)
+	end := time.Date(2016, time.December, 1, 0, 0, 0, 0, time.UTC)
+	for _, town := range towns {
+		p := NewPatient(utils.NewRand(1), end.AddDate(-100, 0, 0), end, town)
+		t.Equal(town.Name, p.Address().City())
+		t.True(p.Address().HasLocation(), "%s is in the gazetteer", town.Name)
+	}
+
+	// Towns outside the gazetteer have no location, and are served by a
+	// hospital in the town.
+	atlantis := *towns[0]
+	atlantis.Name = "Atlantis"
+	p := NewPatient(utils.NewRand(1), end.AddDate(-100, 0, 0), end, &atlantis)
+	t.False(p.Address().HasLocation())
+	t.Equal([]string{"Atlantis Community Hospital", "Atlantis Primary Care"}, organizations(p.Address()))
+}
+
+func (t *GazetteerTestSuite) TestPlaceOfBirth() {
+	address := Demographics.pickCurrentAddress(utils.NewRand(1), nil)
+	rng := utils.NewRand(1)
+	for i := 0; i < 200; i++ {
+		pob := pickPlaceOfBirth(rng, address, 40)
+		if pob.Country() != "United States" {
+			continue
+		}
+		t.NotNil(defaultGazetteer.find(pob.City(), pob.State(), ""), "%s, %s is in the gazetteer", pob.City(), pob.State())
+	}
+}
@@ -0,0 +1,343 @@
+package entity
+
+import (
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/cjduffett/synthea/utils"
+)
+
+// Relationships of a relative to a patient. See Relation.
+const (
+	RelationSpouse  = "spouse"
+	RelationMother  = "mother"
+	RelationFather  = "father"
+	RelationChild   = "child"
+	RelationSibling = "sibling"
+)
+
+const (
+	// adultAge is the age from which patients head households, and until
+	// which children live with their parents.
+	adultAge = 18
+	// spouseRate is the fraction of householders who live with a spouse.
+	// About half of US households are married couples:
+	// https://www.census.gov/prod/cen2010/briefs/c2010br-14.pdf
+	spouseRate = 0.5
+	// Mothers have children between these ages.
+	firstBirthAge = 18
+	lastBirthAge  = 45
+	// Twin and triplet (or higher order) births per birth, from the CDC:
+	// https://www.cdc.gov/nchs/data/nvsr/nvsr66/nvsr66_01.pdf
+	twinRate    = 0.0335
+	tripletRate = 0.001
+)
+
+// births is the distribution of the number of births a mother in a
+// household has while her children are under 18.
+var births = []utils.Choice{
+	{Weight: 0.40, Item: 0},
+	{Weight: 0.25, Item: 1},
+	{Weight: 0.22, Item: 2},
+	{Weight: 0.13, Item: 3},
+}
+
+// Relation is a relative who lives in the same household as a patient.
+// It holds the relative's details as they were when the household was
+// generated.
+type Relation struct {
+	relationship string
+	id           string
+	firstName    string
+	lastName     string
+	gender       string
+	birthDate    time.Time
+}
+
+// Relationship returns how the relative is related to the patient, one
+// of the Relation constants: RelationMother means the relative is the
+// patient's mother.
+func (r Relation) Relationship() string {
+	return r.relationship
+}
+
+// ID returns the relative's patient ID.
+func (r Relation) ID() string {
+	return r.id
+}
+
+// FirstName returns the relative's given name.
+func (r Relation) FirstName() string {
+	return r.firstName
+}
+
+// LastName returns the relative's family name.
+func (r Relation) LastName() string {
+	return r.lastName
+}
+
+// Gender returns the relative's gender, either "Male" or "Female".
+func (r Relation) Gender() string {
+	return r.gender
+}
+
+// BirthDate returns the relative's date of birth.
+func (r Relation) BirthDate() time.Time {
+	return r.birthDate
+}
+
+// NewHousehold creates the patients of a household as they would be at
+// endDate if none of them died: an adult householder, their spouse if
+// they have one, and any children under 18 born to the household's
+// mother, who may be twins or triplets. Spouses are of the opposite
+// gender and a similar age. The members share the householder's address,
+// income and education, and children share their mother's race and
+// ethnicity and their father's family name, or their mother's if they
+// have no father in the household. Spouses are married, see marry, so the
+// mother's family name may be the father's too. Each member is related to
+// every other member, see Patient.Relations. The householder comes first,
+// then their spouse, then the children from oldest to youngest. All
+// random choices are made with rng.
+//
+// The parents may die before some of their children are born once they
+// are simulated, so simulate the parents first and remove those children
+// with RemoveUnborn.
+func (d *DataPack) NewHousehold(rng *rand.Rand, startDate, endDate time.Time, town *Town) []*Patient {
+	householder := d.newAdult(rng, endDate, town)
+	members := []*Patient{householder}
+
+	var spouse *Patient
+	if rng.Float64() < spouseRate {
+		spouse = d.newSpouse(rng, householder, endDate, town)
+		members = append(members, spouse)
+		marry(rng, householder, spouse)
+		relate(householder, spouse, RelationSpouse)
+		relate(spouse, householder, RelationSpouse)
+	}
+
+	mother, father := householder, spouse
+	if householder.gender != "Female" {
+		mother, father = spouse, householder
+	}
+	if mother == nil {
+		return members
+	}
+	if father != nil && father.gender != "Male" {
+		father = nil
+	}
+
+	children := d.newChildren(rng, mother, father, endDate, town)
+	for i, child := range children {
+		relate(child, mother, RelationMother)
+		relate(mother, child, RelationChild)
+		if father != nil {
+			relate(child, father, RelationFather)
+			relate(father, child, RelationChild)
+		}
+		for j, sibling := range children {
+			if i != j {
+				relate(child, sibling, RelationSibling)
+			}
+		}
+	}
+	return append(members, children...)
+}
+
+// newAdult creates a patient of at least adultAge, with their age and
+// gender drawn from the demographics.
+func (d *DataPack) newAdult(rng *rand.Rand, endDate time.Time, town *Town) *Patient {
+	var age int
+	var gender string
+	// Draw until an adult is picked, giving up on demographics that
+	// have too few adults.
+	for i := 0; i < 100 && age < adultAge; i++ {
+		if town != nil {
+			age, gender = town.pickAge(rng), town.pickGender(rng)
+		} else {
+			age, gender = d.pickAgeSex(rng)
+		}
+	}
+	if age < adultAge {
+		age = adultAge
+	}
+	return d.newPatient(rng, pickBirthdate(rng, endDate, age), endDate, gender, town)
+}
+
+// newSpouse creates a spouse for the householder: an adult of the
+// opposite gender and a similar age, who shares their household.
+func (d *DataPack) newSpouse(rng *rand.Rand, householder *Patient, endDate time.Time, town *Town) *Patient {
+	gender := "Female"
+	if householder.gender == "Female" {
+		gender = "Male"
+	}
+	age := householder.AgeAt(endDate) + int(rng.NormFloat64()*3)
+	if age < adultAge {
+		age = adultAge
+	}
+	spouse := d.newPatient(rng, pickBirthdate(rng, endDate, age), endDate, gender, town)
+	shareHousehold(rng, spouse, householder, endDate)
+	return spouse
+}
+
+// newChildren creates the children under 18 that the mother had, in
+// order of birth, while she and the father were old enough. Births are
+// at least a year apart, and a birth may be of twins or triplets.
+func (d *DataPack) newChildren(rng *rand.Rand, mother, father *Patient, endDate time.Time, town *Town) []*Patient {
+	earliest := mother.birthDate.AddDate(firstBirthAge, 0, 0)
+	if father != nil && father.birthDate.AddDate(firstBirthAge, 0, 0).After(earliest) {
+		earliest = father.birthDate.AddDate(firstBirthAge, 0, 0)
+	}
+	// Patient.AgeAt counts birthdays by the day of the year, which may be
+	// a day out in leap years.
+	if minor := endDate.AddDate(-adultAge, 0, 2); minor.After(earliest) {
+		earliest = minor
+	}
+	latest := mother.birthDate.AddDate(lastBirthAge, 0, 0)
+	if endDate.Before(latest) {
+		latest = endDate
+	}
+	if latest.Sub(earliest) < 24*time.Hour {
+		return nil
+	}
+
+	n, _ := utils.WeightedChoice(rng, births).Item.(int)
+	var dates []time.Time
+	for i := 0; i < n; i++ {
+		dates = append(dates, pickDateBetween(rng, earliest, latest))
+	}
+	sort.Slice(dates, func(i, j int) bool { return dates[i].Before(dates[j]) })
+
+	lastName := mother.lastName
+	if father != nil {
+		lastName = father.lastName
+	}
+	var children []*Patient
+	var previous time.Time
+	for _, birthDate := range dates {
+		if !previous.IsZero() && birthDate.Before(previous.AddDate(1, 0, 0)) {
+			continue
+		}
+		previous = birthDate
+
+		size := 1
+		if r := rng.Float64(); r < tripletRate {
+			size = 3
+		} else if r < tripletRate+twinRate {
+			size = 2
+		}
+		for order := 1; order <= size; order++ {
+			child := d.newPatient(rng, birthDate, endDate, d.pickSexAtBirth(rng), town)
+			child.race = mother.race
+			child.ethnicity = mother.ethnicity
+			child.bloodType = d.pickBloodType(rng, mother.race)
+			child.lastName = lastName
+			if size > 1 {
+				child.multipleBirth = order
+			}
+			shareHousehold(rng, child, mother, endDate)
+			children = append(children, child)
+		}
+	}
+	return children
+}
+
+// shareHousehold moves the patient into member's household, sharing its
+// address and socioeconomic status, and picks their place of birth
+// again relative to the shared address. The patient's identifiers are
+// assigned again, as their medical records are now kept by the
+// organizations serving the shared address.
+func shareHousehold(rng *rand.Rand, p, member *Patient, endDate time.Time) {
+	p.address = member.address
+	p.income = member.income
+	p.education = member.education
+	p.placeOfBirth = pickPlaceOfBirth(rng, p.address, p.AgeAt(endDate))
+	assignIdentifiers(p, endDate)
+}
+
+// RemoveUnborn removes the children of a household created by
+// NewHousehold who would have been born after their mother died, and
+// removes the father of children born after he died. Only the parents
+// need to have been simulated. The members who remain are returned in the
+// same order, and their relations no longer refer to the children
+// removed.
+func RemoveUnborn(members []*Entity) []*Entity {
+	byID := make(map[string]*Entity, len(members))
+	for _, e := range members {
+		byID[e.Patient.id] = e
+	}
+	unborn := make(map[string]bool)
+	for _, child := range members {
+		var fathers []*Entity
+		for _, r := range child.Patient.relations {
+			parent := byID[r.id]
+			if parent == nil || parent.Alive(child.Patient.birthDate) {
+				continue
+			}
+			switch r.relationship {
+			case RelationMother:
+				unborn[child.Patient.id] = true
+			case RelationFather:
+				fathers = append(fathers, parent)
+			}
+		}
+		for _, father := range fathers {
+			child.Patient.unrelate(map[string]bool{father.Patient.id: true})
+			father.Patient.unrelate(map[string]bool{child.Patient.id: true})
+		}
+	}
+	if len(unborn) == 0 {
+		return members
+	}
+
+	var born []*Entity
+	for _, e := range members {
+		if !unborn[e.Patient.id] {
+			born = append(born, e)
+		}
+	}
+	RemoveRelations(born, func(id string) bool { return unborn[id] })
+	return born
+}
+
+// RemoveRelations removes the relations of a household's members to the
+// relatives whose IDs remove returns true for, for example to members of
+// the household who are not exported.
+func RemoveRelations(members []*Entity, remove func(id string) bool) {
+	for _, e := range members {
+		ids := make(map[string]bool)
+		for _, r := range e.Patient.relations {
+			if remove(r.id) {
+				ids[r.id] = true
+			}
+		}
+		e.Patient.unrelate(ids)
+	}
+}
+
+// unrelate removes the patient's relations to the relatives with the
+// given IDs.
+func (p *Patient) unrelate(ids map[string]bool) {
+	if len(ids) == 0 {
+		return
+	}
+	var relations []Relation
+	for _, r := range p.relations {
+		if !ids[r.id] {
+			relations = append(relations, r)
+		}
+	}
+	p.relations = relations
+}
+
+// relate adds relative to the patient's relations.
+func relate(p, relative *Patient, relationship string) {
+	p.relations = append(p.relations, Relation{
+		relationship: relationship,
+		id:           relative.id,
+		firstName:    relative.firstName,
+		lastName:     relative.lastName,
+		gender:       relative.gender,
+		birthDate:    relative.birthDate,
+	})
+}
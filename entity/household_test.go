@@ -0,0 +1,220 @@
+package entity
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/cjduffett/synthea/utils"
+	"github.com/stretchr/testify/suite"
+)
+
+type HouseholdTestSuite struct {
+	suite.Suite
+	startTime  time.Time
+	endTime    time.Time
+	households [][]*Patient
+}
+
+func TestHouseholdTestSuite(t *testing.T) {
+	suite.Run(t, new(HouseholdTestSuite))
+}
+
+func (h *HouseholdTestSuite) SetupSuite() {
+	h.endTime = time.Date(2016, time.December, 1, 0, 0, 0, 0, time.UTC)
+	h.startTime = h.endTime.AddDate(-100, 0, 0)
+	for seed := int64(0); seed < 2000; seed++ {
+		h.households = append(h.households, Demographics.NewHousehold(utils.NewRand(seed), h.startTime, h.endTime, nil))
+	}
+}
+
+// relation returns the patient's relation to relative, if any.
+func relation(p, relative *Patient) (Relation, bool) {
+	for _, r := range p.Relations() {
+		if r.ID() == relative.ID() {
+			return r, true
+		}
+	}
+	return Relation{}, false
+}
+
+func (h *HouseholdTestSuite) TestMembers() {
+	spouses := 0
+	for _, members := range h.households {
+		householder := members[0]
+		h.True(householder.AgeAt(h.endTime) >= adultAge, "Householders are adults")
+		h.Len(householder.Relations(), len(members)-1)
+
+		for i, member := range members {
+			h.Equal(householder.Address(), member.Address(), "Members share an address")
+			h.Equal(householder.Income(), member.Income())
+			h.Equal(householder.Education(), member.Education())
+			h.Len(member.Relations(), len(members)-1, "Members are related to every other member")
+			for j, other := range members {
+				if i == j {
+					continue
+				}
+				r, found := relation(member, other)
+				h.Require().True(found)
+				h.Equal(other.LastName(), r.LastName())
+				h.Equal(other.BirthDate(), r.BirthDate())
+				inverse, _ := relation(other, member)
+				switch r.Relationship() {
+				case RelationSpouse, RelationSibling:
+					h.Equal(r.Relationship(), inverse.Relationship())
+				case RelationMother, RelationFather:
+					h.Equal(RelationChild, inverse.Relationship())
+					h.True(member.AgeAt(h.endTime) < adultAge, "Children are under 18")
+					h.True(member.BirthDate().After(other.BirthDate().AddDate(firstBirthAge, 0, 0)))
+				}
+				if r.Relationship() == RelationSpouse {
+					h.NotEqual(member.Gender(), other.Gender())
+					spouses++
+				}
+			}
+		}
+	}
+	// Each couple is counted twice.
+	h.InDelta(spouseRate, float64(spouses)/2/float64(len(h.households)), 0.05)
+}
+
+func (h *HouseholdTestSuite) TestMultipleBirths() {
+	children, multiples := 0, 0
+	for _, members := range h.households {
+		for _, member := range members {
+			if _, ok := relation(member, members[0]); !ok || member.AgeAt(h.endTime) >= adultAge {
+				continue
+			}
+			children++
+			if member.MultipleBirth() == 0 {
+				continue
+			}
+			multiples++
+			// The siblings of a multiple birth are born at the same time.
+			born := 0
+			for _, sibling := range members {
+				if sibling.MultipleBirth() > 0 && sibling.BirthDate().Equal(member.BirthDate()) {
+					born++
+				}
+			}
+			h.True(born >= 2)
+		}
+	}
+	h.True(multiples > 0, "Some children are twins")
+	h.True(float64(multiples) < 0.2*float64(children))
+}
+
+func (h *HouseholdTestSuite) TestMarriedNames() {
+	couples, changed := 0, 0
+	for _, members := range h.households {
+		if len(members) < 2 {
+			continue
+		}
+		if r, _ := relation(members[0], members[1]); r.Relationship() != RelationSpouse {
+			continue
+		}
+		couples++
+		for _, spouse := range members[:2] {
+			if spouse.Gender() == "Female" {
+				h.Equal("Mrs.", spouse.Prefix())
+			}
+			if spouse.MaidenName() != "" {
+				h.NotEqual(spouse.MaidenName(), spouse.LastName())
+				changed++
+			}
+		}
+		if members[0].MaidenName() != "" || members[1].MaidenName() != "" {
+			h.Equal(members[0].LastName(), members[1].LastName())
+		}
+	}
+	h.InDelta(marriedNameRate+marriedNameRateMen, float64(changed)/float64(couples), 0.06)
+}
+
+func (h *HouseholdTestSuite) TestIsDeterministic() {
+	first := Demographics.NewHousehold(utils.NewRand(7), h.startTime, h.endTime, nil)
+	second := Demographics.NewHousehold(utils.NewRand(7), h.startTime, h.endTime, nil)
+	h.Equal(first, second)
+}
+
+func (h *HouseholdTestSuite) TestSnapshotRelations() {
+	for _, members := range h.households {
+		if len(members) < 3 {
+			continue
+		}
+		for _, member := range members {
+			data, err := json.Marshal(member)
+			h.Require().Nil(err)
+			restored := new(Patient)
+			h.Require().Nil(json.Unmarshal(data, restored))
+			h.Equal(member.Relations(), restored.Relations())
+			h.Equal(member.MultipleBirth(), restored.MultipleBirth())
+			h.Equal(member.Identifiers(), restored.Identifiers())
+		}
+		return
+	}
+	h.Fail("No household has children")
+}
+
+// family returns the entities of a household with a mother, a father and
+// children born on at least two dates.
+func (h *HouseholdTestSuite) family() []*Entity {
+	for _, members := range h.households {
+		if len(members) < 4 || members[0].Gender() == members[1].Gender() {
+			continue
+		}
+		children := members[2:]
+		if !children[0].BirthDate().Before(children[len(children)-1].BirthDate()) {
+			continue
+		}
+		var entities []*Entity
+		for _, member := range members {
+			entities = append(entities, NewEntity(member, nil))
+		}
+		return entities
+	}
+	h.FailNow("No household has parents and children born on different dates")
+	return nil
+}
+
+func (h *HouseholdTestSuite) TestRemoveUnbornAfterMothersDeath() {
+	members := h.family()
+	mother, father := members[0], members[1]
+	if mother.Patient.Gender() != "Female" {
+		mother, father = father, mother
+	}
+	last := members[len(members)-1].Patient.BirthDate()
+	mother.Record.Death(last.AddDate(0, 0, -1), nil)
+
+	born := RemoveUnborn(members)
+	h.True(len(born) < len(members))
+	h.Equal(members[:len(born)], born, "The youngest children are removed")
+	for _, e := range born {
+		for _, r := range e.Patient.Relations() {
+			h.True(r.BirthDate().Before(last), "%s is related to a child who was not born", e.Patient.ID())
+		}
+		if e != mother && e != father {
+			h.True(mother.Alive(e.Patient.BirthDate()))
+		}
+	}
+}
+
+func (h *HouseholdTestSuite) TestRemoveUnbornAfterFathersDeath() {
+	members := h.family()
+	mother, father := members[0], members[1]
+	if mother.Patient.Gender() != "Female" {
+		mother, father = father, mother
+	}
+	youngest := members[len(members)-1]
+	father.Record.Death(youngest.Patient.BirthDate().AddDate(0, 0, -1), nil)
+
+	h.Equal(members, RemoveUnborn(members), "Children are born after their father died")
+	_, ok := relation(&youngest.Patient, &father.Patient)
+	h.False(ok, "The youngest child has no father")
+	_, ok = relation(&father.Patient, &youngest.Patient)
+	h.False(ok)
+	r, ok := relation(&members[2].Patient, &father.Patient)
+	h.True(ok, "The oldest child was born before their father died")
+	h.Equal(RelationFather, r.Relationship())
+	_, ok = relation(&youngest.Patient, &mother.Patient)
+	h.True(ok)
+}
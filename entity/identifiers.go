@@ -0,0 +1,190 @@
+package entity
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/cjduffett/synthea/utils"
+)
+
+// Identifier types, as HL7 v2 table 0203 codes.
+const (
+	IdentifierMRN            = "MR"
+	IdentifierSSN            = "SS"
+	IdentifierDriversLicense = "DL"
+	IdentifierPassport       = "PPN"
+)
+
+const (
+	// SSNSystem and PassportSystem identify US social security and
+	// passport numbers.
+	SSNSystem      = "http://hl7.org/fhir/sid/us-ssn"
+	PassportSystem = "http://hl7.org/fhir/sid/passport-USA"
+	// mrnSystemPrefix is followed by the organization that assigned a
+	// medical record number.
+	mrnSystemPrefix = "urn:synthea:mrn:"
+	// driversLicenseSystemPrefix is the root of the OIDs of US state
+	// driver's licenses, which is followed by the state's FIPS code.
+	driversLicenseSystemPrefix = "urn:oid:2.16.840.1.113883.4.3."
+)
+
+const (
+	// drivingAge is the age from which patients may have a driver's
+	// license.
+	drivingAge = 16
+	// licenseRate is the fraction of patients of driving age with a
+	// driver's license:
+	// https://www.fhwa.dot.gov/policyinformation/statistics/2016/dl20.cfm
+	licenseRate = 0.85
+	// passportRate is the fraction of adults with a passport:
+	// https://travel.state.gov/content/travel/en/about-us/reports-and-statistics.html
+	passportRate = 0.42
+	// hospitalPopulation is the population from which a city has a
+	// hospital. See organizations.
+	hospitalPopulation = 40000
+)
+
+// driversLicenses holds the FIPS code of each state, which identifies its
+// driver's licenses, and the format of its license numbers, in which #
+// is a digit and @ a letter.
+var driversLicenses = map[string]struct {
+	fips   string
+	format string
+}{
+	"AL": {"01", "#######"}, "AK": {"02", "#######"}, "AZ": {"04", "@########"},
+	"AR": {"05", "#########"}, "CA": {"06", "@#######"}, "CO": {"08", "#########"},
+	"CT": {"09", "#########"}, "DE": {"10", "#######"}, "DC": {"11", "#######"},
+	"FL": {"12", "@############"}, "GA": {"13", "#########"}, "HI": {"15", "H########"},
+	"ID": {"16", "@@######@"}, "IL": {"17", "@###########"}, "IN": {"18", "##########"},
+	"IA": {"19", "###@@####"}, "KS": {"20", "K########"}, "KY": {"21", "@########"},
+	"LA": {"22", "#########"}, "ME": {"23", "#######"}, "MD": {"24", "@############"},
+	"MA": {"25", "S########"}, "MI": {"26", "@############"}, "MN": {"27", "@############"},
+	"MS": {"28", "#########"}, "MO": {"29", "@#########"}, "MT": {"30", "#############"},
+	"NE": {"31", "@########"}, "NV": {"32", "##########"}, "NH": {"33", "##@@@#####"},
+	"NJ": {"34", "@##############"}, "NM": {"35", "#########"}, "NY": {"36", "#########"},
+	"NC": {"37", "############"}, "ND": {"38", "@@@######"}, "OH": {"39", "@@######"},
+	"OK": {"40", "@#########"}, "OR": {"41", "#######"}, "PA": {"42", "########"},
+	"RI": {"44", "#######"}, "SC": {"45", "#########"}, "SD": {"46", "########"},
+	"TN": {"47", "#########"}, "TX": {"48", "########"}, "UT": {"49", "##########"},
+	"VT": {"50", "########"}, "VA": {"51", "@########"}, "WA": {"53", "WDL#########"},
+	"WV": {"54", "@######"}, "WI": {"55", "@#############"}, "WY": {"56", "#########"},
+}
+
+// Identifier is a number that identifies a patient, such as a medical
+// record or social security number.
+type Identifier struct {
+	typ      string
+	system   string
+	value    string
+	assigner string
+}
+
+// Type returns the kind of identifier, one of the Identifier constants.
+func (id Identifier) Type() string {
+	return id.typ
+}
+
+// System returns the URI of the namespace the identifier is unique in.
+func (id Identifier) System() string {
+	return id.system
+}
+
+// Value returns the identifier itself.
+func (id Identifier) Value() string {
+	return id.value
+}
+
+// Assigner returns the name of the organization or state that assigned
+// the identifier, or an empty string for social security numbers.
+func (id Identifier) Assigner() string {
+	return id.assigner
+}
+
+// assignIdentifiers assigns the patient's identifiers, as of endDate: a
+// medical record number from each organization serving their address, a
+// social security number, and for some of those old enough a driver's
+// license from their state and a passport. Identifiers are drawn from
+// the patient's ID rather than the random numbers used to generate the
+// patient, so assigning them again, for example after the patient moves
+// to another state, gives the same numbers.
+func assignIdentifiers(p *Patient, endDate time.Time) {
+	h := fnv.New64a()
+	h.Write([]byte(p.id))
+	rng := utils.NewRand(int64(h.Sum64()))
+
+	p.identifiers = nil
+	for _, organization := range organizations(p.address) {
+		p.identifiers = append(p.identifiers, Identifier{
+			typ:      IdentifierMRN,
+			system:   mrnSystemPrefix + strings.ToLower(strings.Replace(organization, " ", "-", -1)),
+			value:    pickPattern(rng, "########"),
+			assigner: organization,
+		})
+	}
+	p.identifiers = append(p.identifiers, Identifier{
+		typ:    IdentifierSSN,
+		system: SSNSystem,
+		value:  pickSSN(rng),
+	})
+
+	age := p.AgeAt(endDate)
+	license, ok := driversLicenses[p.address.state]
+	if ok && age >= drivingAge && rng.Float64() < licenseRate {
+		p.identifiers = append(p.identifiers, Identifier{
+			typ:      IdentifierDriversLicense,
+			system:   driversLicenseSystemPrefix + license.fips,
+			value:    pickPattern(rng, license.format),
+			assigner: p.address.state,
+		})
+	}
+	if age >= adultAge && rng.Float64() < passportRate {
+		p.identifiers = append(p.identifiers, Identifier{
+			typ:      IdentifierPassport,
+			system:   PassportSystem,
+			value:    pickPattern(rng, "#########"),
+			assigner: "United States",
+		})
+	}
+}
+
+// organizations returns the names of the organizations that keep the
+// medical records of patients living at address: the nearest hospital,
+// and a primary care practice in their city. Cities of the gazetteer with
+// at least hospitalPopulation people have a hospital; addresses without a
+// location are served by a hospital in their own city.
+func organizations(address Address) []string {
+	hospital := address.city
+	if address.HasLocation() {
+		if p := defaultGazetteer.nearest(address.latitude, address.longitude, hospitalPopulation); p != nil {
+			hospital = p.city
+		}
+	}
+	return []string{hospital + " Community Hospital", address.city + " Primary Care"}
+}
+
+// pickSSN returns a social security number that the Social Security
+// Administration never issues, so that it cannot belong to anyone:
+// numbers with area numbers from 900 are not issued. Group numbers from
+// 50 are avoided too, as they are used for taxpayer identification
+// numbers. See https://www.ssa.gov/employer/randomization.html
+func pickSSN(rng *rand.Rand) string {
+	return fmt.Sprintf("%03d-%02d-%04d", 900+rng.Intn(100), 1+rng.Intn(49), 1+rng.Intn(9999))
+}
+
+// pickPattern returns a random string in the format of pattern, in which
+// # is replaced by a digit and @ by an upper case letter.
+func pickPattern(rng *rand.Rand, pattern string) string {
+	b := []byte(pattern)
+	for i, c := range b {
+		switch c {
+		case '#':
+			b[i] = byte('0' + rng.Intn(10))
+		case '@':
+			b[i] = byte('A' + rng.Intn(26))
+		}
+	}
+	return string(b)
+}
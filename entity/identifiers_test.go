@@ -0,0 +1,93 @@
+package entity
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cjduffett/synthea/utils"
+	"github.com/stretchr/testify/suite"
+)
+
+type IdentifiersTestSuite struct {
+	suite.Suite
+	endTime  time.Time
+	patients []*Patient
+}
+
+func TestIdentifiersTestSuite(t *testing.T) {
+	suite.Run(t, new(IdentifiersTestSuite))
+}
+
+func (suite *IdentifiersTestSuite) SetupSuite() {
+	suite.endTime = time.Date(2016, time.December, 1, 0, 0, 0, 0, time.UTC)
+	for seed := int64(0); seed < 1000; seed++ {
+		suite.patients = append(suite.patients, NewPatient(utils.NewRand(seed), suite.endTime.AddDate(-100, 0, 0), suite.endTime, nil))
+	}
+}
+
+// identifiers returns the patient's identifiers of the given type.
+func identifiers(p *Patient, typ string) []Identifier {
+	var ids []Identifier
+	for _, id := range p.Identifiers() {
+		if id.Type() == typ {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func (suite *IdentifiersTestSuite) TestIdentifiers() {
+	ssns := make(map[string]bool)
+	licenses, passports, adults := 0, 0, 0
+	for _, p := range suite.patients {
+		mrns := identifiers(p, IdentifierMRN)
+		suite.Len(mrns, len(organizations(p.Address())))
+		for i, mrn := range mrns {
+			suite.Regexp(`^\d{8}$`, mrn.Value())
+			suite.Equal(organizations(p.Address())[i], mrn.Assigner())
+		}
+
+		ssn := identifiers(p, IdentifierSSN)
+		suite.Require().Len(ssn, 1)
+		suite.Regexp(`^9\d\d-(0[1-9]|[1-4]\d)-\d{4}$`, ssn[0].Value(), "SSNs are never issued")
+		suite.NotEqual("0000", ssn[0].Value()[7:])
+		ssns[ssn[0].Value()] = true
+
+		age := p.AgeAt(suite.endTime)
+		for _, license := range identifiers(p, IdentifierDriversLicense) {
+			suite.True(age >= drivingAge)
+			state := driversLicenses[p.Address().State()]
+			suite.Equal(driversLicenseSystemPrefix+state.fips, license.System())
+			pattern := strings.NewReplacer("#", `\d`, "@", "[A-Z]").Replace(state.format)
+			suite.Regexp("^"+pattern+"$", license.Value())
+			licenses++
+		}
+		for range identifiers(p, IdentifierPassport) {
+			suite.True(age >= adultAge)
+			passports++
+		}
+		if age >= adultAge {
+			adults++
+		}
+	}
+	suite.InDelta(len(suite.patients), len(ssns), 2, "SSNs are unique")
+	suite.InDelta(licenseRate, float64(licenses)/float64(adults), 0.1)
+	suite.InDelta(passportRate, float64(passports)/float64(adults), 0.05)
+}
+
+func (suite *IdentifiersTestSuite) TestAssignedAgain() {
+	p := suite.patients[0]
+	before := p.Identifiers()
+	assignIdentifiers(p, suite.endTime)
+	suite.Equal(before, p.Identifiers(), "Identifiers are derived from the patient's ID")
+}
+
+func (suite *IdentifiersTestSuite) TestPickPattern() {
+	value := pickPattern(utils.NewRand(1), "WDL@#")
+	suite.True(regexp.MustCompile(`^WDL[A-Z]\d$`).MatchString(value), value)
+	_, err := strconv.Atoi(pickPattern(utils.NewRand(1), "#########"))
+	suite.Nil(err)
+}
@@ -0,0 +1,290 @@
+package entity
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/cjduffett/synthea/utils"
+)
+
+// NamesFormat is the name dictionary format version this version of
+// Synthea reads.
+const NamesFormat = 1
+
+// DefaultNames is the name of the built-in name dictionary used if no
+// other is loaded.
+const DefaultNames = "us"
+
+// defaultEthnicity holds the names of patients whose ethnicity has no
+// names of its own.
+const defaultEthnicity = "default"
+
+const (
+	// marriedNameRate is the fraction of married couples in which the
+	// wife takes her husband's family name, and marriedNameRateMen the
+	// fraction in which the husband takes his wife's:
+	// https://www.pewresearch.org/short-reads/2023/09/07/about-eight-in-ten-women-in-opposite-sex-marriages-say-they-took-their-husbands-last-name/
+	marriedNameRate    = 0.79
+	marriedNameRateMen = 0.05
+)
+
+// suffixes is the distribution of the suffixes of men's names.
+var suffixes = []utils.Choice{
+	{Weight: 0.955, Item: ""},
+	{Weight: 0.03, Item: "Jr."},
+	{Weight: 0.01, Item: "II"},
+	{Weight: 0.005, Item: "III"},
+}
+
+//go:embed names/*.json
+var builtinNames embed.FS
+
+// defaultNames is the built-in name dictionary, used by data packs that
+// have not been given another. See DataPack.WithNames.
+var defaultNames = mustLoadBuiltinNames(DefaultNames)
+
+// Names is a dictionary of the given names, by gender, and family names
+// of each ethnicity. Name dictionaries are versioned JSON files:
+//
+//	{
+//	  "format": 1,
+//	  "name": "United States",
+//	  "version": "2017.1",
+//	  "sources": ["https://..."],
+//	  "ethnicities": {
+//	    "default": {"given": {"Male": ["James"], "Female": ["Mary"]}, "family": ["Smith"]},
+//	    "Irish": {"given": {"Male": ["Patrick"], "Female": ["Siobhan"]}, "family": ["Murphy"]}
+//	  }
+//	}
+//
+// Names are picked uniformly from the lists of the patient's ethnicity,
+// as named by the data pack or towns. Patients of an ethnicity that is not
+// in the dictionary are named from the "default" lists, which every
+// dictionary must have. Every list must have at least one name.
+type Names struct {
+	Format      int                 `json:"format"`
+	Name        string              `json:"name"`
+	Version     string              `json:"version"`
+	Sources     []string            `json:"sources"`
+	Ethnicities map[string]NameList `json:"ethnicities"`
+}
+
+// NameList holds the names of one ethnicity in a name dictionary.
+type NameList struct {
+	Given  map[string][]string `json:"given"`
+	Family []string            `json:"family"`
+}
+
+// LoadNames loads and validates a name dictionary. name may be the path
+// to a JSON dictionary or the name of a built-in dictionary.
+func LoadNames(name string) (*Names, error) {
+	if _, err := os.Stat(name); err != nil {
+		if _, berr := fs.Stat(builtinNames, builtinNamesPath(name)); berr == nil {
+			return loadBuiltinNames(name)
+		}
+		return nil, fmt.Errorf("Name dictionary '%s' not found (built-in dictionaries: %s)", name, strings.Join(BuiltinNames(), ", "))
+	}
+
+	names := &Names{}
+	file, err := os.Open(name)
+	if err == nil {
+		decoder := json.NewDecoder(file)
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(names)
+		file.Close()
+	}
+	if err == nil {
+		err = names.validate()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Invalid name dictionary %s: %s", name, err.Error())
+	}
+	return names, nil
+}
+
+// BuiltinNames returns the names of the name dictionaries built into
+// Synthea.
+func BuiltinNames() []string {
+	var names []string
+	entries, _ := builtinNames.ReadDir("names")
+	for _, entry := range entries {
+		names = append(names, strings.TrimSuffix(entry.Name(), ".json"))
+	}
+	return names
+}
+
+func builtinNamesPath(name string) string {
+	return path.Join("names", name+".json")
+}
+
+func loadBuiltinNames(name string) (*Names, error) {
+	data, err := builtinNames.ReadFile(builtinNamesPath(name))
+	if err != nil {
+		return nil, err
+	}
+	names := &Names{}
+	err = json.Unmarshal(data, names)
+	if err == nil {
+		err = names.validate()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Invalid built-in name dictionary '%s': %s", name, err.Error())
+	}
+	return names, nil
+}
+
+func mustLoadBuiltinNames(name string) *Names {
+	names, err := loadBuiltinNames(name)
+	if err != nil {
+		panic(err.Error())
+	}
+	return names
+}
+
+// validate returns an error if the dictionary cannot name every patient.
+func (n *Names) validate() error {
+	if n.Format != NamesFormat {
+		return fmt.Errorf("unsupported format %d, expected %d", n.Format, NamesFormat)
+	}
+	if n.Name == "" || n.Version == "" {
+		return errors.New("missing 'name' or 'version'")
+	}
+	if _, ok := n.Ethnicities[defaultEthnicity]; !ok {
+		return fmt.Errorf("no '%s' names found", defaultEthnicity)
+	}
+
+	var ethnicities []string
+	for ethnicity := range n.Ethnicities {
+		ethnicities = append(ethnicities, ethnicity)
+	}
+	sort.Strings(ethnicities)
+	for _, ethnicity := range ethnicities {
+		list := n.Ethnicities[ethnicity]
+		lists := map[string][]string{
+			"family":       list.Family,
+			"given.Male":   list.Given["Male"],
+			"given.Female": list.Given["Female"],
+		}
+		for _, key := range []string{"given.Male", "given.Female", "family"} {
+			if len(lists[key]) == 0 {
+				return fmt.Errorf("no '%s' names found for '%s'", key, ethnicity)
+			}
+			for _, name := range lists[key] {
+				if strings.TrimSpace(name) == "" {
+					return fmt.Errorf("empty '%s' name for '%s'", key, ethnicity)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// list returns the names of ethnicity, or the default names if the
+// dictionary has none for it.
+func (n *Names) list(ethnicity string) NameList {
+	if list, ok := n.Ethnicities[ethnicity]; ok {
+		return list
+	}
+	return n.Ethnicities[defaultEthnicity]
+}
+
+// WithNames returns a copy of the data pack that names patients from
+// names instead of the built-in name dictionary. If digits is true, three
+// random digits are appended to every given and family name, so that
+// generated patients can never be mistaken for real people. The
+// demographic tables are shared with d.
+func (d *DataPack) WithNames(names *Names, digits bool) *DataPack {
+	c := *d
+	c.names = names
+	c.nameDigits = digits
+	return &c
+}
+
+// nameDictionary returns the name dictionary the data pack names patients
+// from.
+func (d *DataPack) nameDictionary() *Names {
+	if d.names != nil {
+		return d.names
+	}
+	return defaultNames
+}
+
+// pickFirstName picks a given name for a patient of the given gender and
+// ethnicity.
+func (d *DataPack) pickFirstName(rng *rand.Rand, gender, ethnicity string) string {
+	given := d.nameDictionary().list(ethnicity).Given[gender]
+	if len(given) == 0 {
+		// Patients are either male or female, but fall back to the
+		// default names rather than fail on any other gender.
+		given = d.nameDictionary().list(defaultEthnicity).Given["Female"]
+	}
+	return d.withDigits(rng, given[rng.Intn(len(given))])
+}
+
+// pickLastName picks a family name for a patient of the given ethnicity.
+func (d *DataPack) pickLastName(rng *rand.Rand, ethnicity string) string {
+	family := d.nameDictionary().list(ethnicity).Family
+	return d.withDigits(rng, family[rng.Intn(len(family))])
+}
+
+// withDigits appends random digits to name if the data pack is
+// configured to append them.
+func (d *DataPack) withDigits(rng *rand.Rand, name string) string {
+	if !d.nameDigits {
+		return name
+	}
+	return fmt.Sprintf("%s%03d", name, rng.Intn(1000))
+}
+
+// pickAffixes picks the prefix and suffix of the name of a patient who
+// will be the given age at the end of the simulation. Adults are "Mr." or
+// "Ms.", and some men have a generational suffix. See marry for the
+// prefixes of married women.
+func pickAffixes(rng *rand.Rand, gender string, age int) (prefix, suffix string) {
+	if gender == "Male" {
+		suffix, _ = utils.WeightedChoice(rng, suffixes).Item.(string)
+	}
+	if age < adultAge {
+		return "", suffix
+	}
+	if gender == "Male" {
+		return "Mr.", suffix
+	}
+	return "Ms.", suffix
+}
+
+// marry gives a married couple the names of married people: the wife is
+// "Mrs.", and sometimes one of the spouses, usually the wife, takes the
+// other's family name and keeps their own as their maiden name. A couple
+// who already share a family name keep it.
+func marry(rng *rand.Rand, p, spouse *Patient) {
+	wife, husband := p, spouse
+	if p.gender == "Male" {
+		wife, husband = spouse, p
+	}
+	if wife.gender != "Female" || husband.gender != "Male" {
+		return
+	}
+	wife.prefix = "Mrs."
+	// Draw first so that the random numbers used after marrying do not
+	// depend on the names.
+	r := rng.Float64()
+	if wife.lastName == husband.lastName {
+		return
+	}
+	if r < marriedNameRate {
+		wife.maidenName = wife.lastName
+		wife.lastName = husband.lastName
+	} else if r < marriedNameRate+marriedNameRateMen {
+		husband.maidenName = husband.lastName
+		husband.lastName = wife.lastName
+	}
+}
@@ -0,0 +1,89 @@
+package entity
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cjduffett/synthea/utils"
+	"github.com/stretchr/testify/suite"
+)
+
+type NamesTestSuite struct {
+	suite.Suite
+	endTime time.Time
+}
+
+func TestNamesTestSuite(t *testing.T) {
+	suite.Run(t, new(NamesTestSuite))
+}
+
+func (t *NamesTestSuite) SetupSuite() {
+	t.endTime = time.Date(2016, time.December, 1, 0, 0, 0, 0, time.UTC)
+}
+
+func (t *NamesTestSuite) TestBuiltinNames() {
+	t.Equal([]string{"us"}, BuiltinNames())
+	names, err := LoadNames(DefaultNames)
+	t.Require().Nil(err)
+	t.Equal(NamesFormat, names.Format)
+	t.NotEmpty(names.Sources)
+
+	// Every ethnicity of the built-in data packs has names of its own.
+	for _, pack := range BuiltinDataPacks() {
+		d, err := LoadDataPack(pack)
+		t.Require().Nil(err)
+		for _, ethnicities := range d.Ethnicity {
+			for ethnicity := range ethnicities {
+				t.Contains(names.Ethnicities, ethnicity, pack)
+			}
+		}
+	}
+}
+
+func (t *NamesTestSuite) TestLoadInvalidNames() {
+	_, err := LoadNames("../fixtures/names/missing_default_names.json")
+	t.NotNil(err)
+	t.Contains(err.Error(), "no 'default' names found")
+
+	_, err = LoadNames("../fixtures/names/empty_list_names.json")
+	t.NotNil(err)
+	t.Contains(err.Error(), "no 'given.Female' names found for 'Irish'")
+
+	_, err = LoadNames("atlantis")
+	t.NotNil(err)
+	t.Contains(err.Error(), "us")
+}
+
+func (t *NamesTestSuite) TestNamesByEthnicity() {
+	names, err := LoadNames("../fixtures/names/names.json")
+	t.Require().Nil(err)
+	pack := Demographics.WithNames(names, false)
+
+	irish := 0
+	for seed := int64(0); seed < 200; seed++ {
+		p := pack.NewPatient(utils.NewRand(seed), t.endTime.AddDate(-100, 0, 0), t.endTime, nil)
+		switch {
+		case p.Ethnicity() == "Irish":
+			t.Equal("Murphy", p.LastName())
+			t.Contains([]string{"Patrick", "Siobhan"}, p.FirstName())
+			irish++
+		default:
+			t.Equal("Doe", p.LastName())
+			t.Contains([]string{"Adam", "Eve"}, p.FirstName())
+		}
+		if p.AgeAt(t.endTime) < adultAge {
+			t.Empty(p.Prefix(), "Children have no prefix")
+		} else {
+			t.Contains([]string{"Mr.", "Ms."}, p.Prefix())
+		}
+	}
+	t.True(irish > 0)
+	t.Nil(Demographics.names, "WithNames copies the data pack")
+}
+
+func (t *NamesTestSuite) TestNameDigits() {
+	pack := Demographics.WithNames(defaultNames, true)
+	p := pack.NewPatient(utils.NewRand(1), t.endTime.AddDate(-100, 0, 0), t.endTime, nil)
+	t.Regexp(`^\D+\d{3}$`, p.FirstName())
+	t.Regexp(`^\D+\d{3}$`, p.LastName())
+}
@@ -3,6 +3,7 @@ package entity
 import (
 	"fmt"
 	"math/rand"
+	"sync"
 	"time"
 
 	"github.com/cjduffett/synthea/utils"
@@ -11,11 +12,14 @@ import (
 
 // Patient is a patient simulated by Synthea.
 // TODO: fingerprint
-// TODO: multiple births
 type Patient struct {
+	id           string
 	gender       string
 	firstName    string
 	lastName     string
+	prefix       string // such as "Mr." or "Mrs."
+	suffix       string // such as "Jr."
+	maidenName   string // the family name before marriage, if it changed
 	birthDate    time.Time
 	race         string
 	ethnicity    string
@@ -24,33 +28,96 @@ type Patient struct {
 	weight       float64 // in kg
 	address      Address
 	placeOfBirth PlaceOfBirth
+	income       int    // annual household income, in dollars
+	education    string // highest level of education attained
+	// multipleBirth is the patient's birth order if they were born a
+	// twin or triplet, and 0 otherwise.
+	multipleBirth int
+	relations     []Relation   // the patient's relatives in their household
+	identifiers   []Identifier // medical record numbers, SSN and so on
 }
 
-// NewPatient creates a new Patient object
-func NewPatient(startDate, endDate time.Time) *Patient {
-	// pick a random age for the patient, between 0 and 100
-	targetAge := rand.Intn(100)
-
-	// TODO: Config to append ### to the patient's names
-
-	gender := fake.Gender()
-	first, last := pickFullName(gender)
-	race := pickRace()
-	address := pickCurrentAddress()
-
-	return &Patient{
-		gender:       gender,
-		firstName:    first,
-		lastName:     last,
-		birthDate:    pickBirthdate(endDate, targetAge),
-		race:         race,
-		ethnicity:    pickEthnicity(race),
-		bloodType:    pickBloodType(race),
-		height:       51.0, // Average height at birth
-		weight:       3.5,  // Average weight at birth
-		address:      address,
-		placeOfBirth: pickPlaceOfBirth(address, targetAge),
+// NewPatient creates a new Patient object alive at endDate, using the
+// default Demographics. See DataPack.NewPatient.
+func NewPatient(rng *rand.Rand, startDate, endDate time.Time, town *Town) *Patient {
+	return Demographics.NewPatient(rng, startDate, endDate, town)
+}
+
+// NewBirthCohortPatient creates a new Patient object born between
+// startDate and endDate, using the default Demographics. See
+// DataPack.NewBirthCohortPatient.
+func NewBirthCohortPatient(rng *rand.Rand, startDate, endDate time.Time, town *Town) *Patient {
+	return Demographics.NewBirthCohortPatient(rng, startDate, endDate, town)
+}
+
+// NewPatient creates a new Patient object alive at endDate. If town is
+// not nil the patient lives in that town and is generated from its
+// demographics, otherwise the statewide demographics of the data pack
+// are used. Age and gender are sampled from the age-by-sex table of the
+// demographics. All random choices are made with rng, so the same seed
+// always creates the same patient.
+func (d *DataPack) NewPatient(rng *rand.Rand, startDate, endDate time.Time, town *Town) *Patient {
+	var targetAge int
+	var gender string
+
+	if town != nil {
+		targetAge = town.pickAge(rng)
+		gender = town.pickGender(rng)
+	} else {
+		targetAge, gender = d.pickAgeSex(rng)
+	}
+	return d.newPatient(rng, pickBirthdate(rng, endDate, targetAge), endDate, gender, town)
+}
+
+// NewBirthCohortPatient creates a new Patient object born between
+// startDate and endDate, to be simulated from birth. Gender is sampled
+// from the sex ratio at birth of the demographics. All random choices are
+// made with rng.
+func (d *DataPack) NewBirthCohortPatient(rng *rand.Rand, startDate, endDate time.Time, town *Town) *Patient {
+	var gender string
+
+	if town != nil {
+		gender = town.pickGender(rng)
+	} else {
+		gender = d.pickSexAtBirth(rng)
 	}
+	return d.newPatient(rng, pickDateBetween(rng, startDate, endDate), endDate, gender, town)
+}
+
+func (d *DataPack) newPatient(rng *rand.Rand, birthDate, endDate time.Time, gender string, town *Town) *Patient {
+	var race, ethnicity string
+
+	if town != nil {
+		race = town.pickRace(rng)
+		ethnicity = town.pickEthnicity(rng, d, race)
+	} else {
+		race = d.pickRace(rng)
+		ethnicity = d.pickEthnicity(rng, race)
+	}
+
+	first, last := d.pickFirstName(rng, gender, ethnicity), d.pickLastName(rng, ethnicity)
+	address := d.pickCurrentAddress(rng, town)
+	income, education := d.pickSocioeconomics(rng, town)
+
+	patient := &Patient{
+		id:        pickID(rng),
+		gender:    gender,
+		firstName: first,
+		lastName:  last,
+		birthDate: birthDate,
+		race:      race,
+		ethnicity: ethnicity,
+		bloodType: d.pickBloodType(rng, race),
+		height:    51.0, // Average height at birth
+		weight:    3.5,  // Average weight at birth
+		address:   address,
+		income:    income,
+		education: education,
+	}
+	patient.placeOfBirth = pickPlaceOfBirth(rng, address, patient.AgeAt(endDate))
+	patient.prefix, patient.suffix = pickAffixes(rng, gender, patient.AgeAt(endDate))
+	assignIdentifiers(patient, endDate)
+	return patient
 }
 
 // Address is a patient's full street address
@@ -59,6 +126,9 @@ type Address struct {
 	city       string
 	state      string
 	postalCode string
+	// The location of the address, or 0, 0 if it is not known.
+	latitude  float64
+	longitude float64
 }
 
 // PlaceOfBirth is a patient's place of birth
@@ -68,7 +138,176 @@ type PlaceOfBirth struct {
 	country string
 }
 
-func (p *Patient) getAgeAtTime(time time.Time) int {
+// ID returns the patient's unique identifier.
+func (p *Patient) ID() string {
+	return p.id
+}
+
+// Gender returns the patient's gender, either "Male" or "Female".
+func (p *Patient) Gender() string {
+	return p.gender
+}
+
+// FirstName returns the patient's given name.
+func (p *Patient) FirstName() string {
+	return p.firstName
+}
+
+// LastName returns the patient's family name.
+func (p *Patient) LastName() string {
+	return p.lastName
+}
+
+// Prefix returns the prefix of the patient's name, such as "Mr.", or an
+// empty string for children.
+func (p *Patient) Prefix() string {
+	return p.prefix
+}
+
+// Suffix returns the suffix of the patient's name, such as "Jr.", if they
+// have one.
+func (p *Patient) Suffix() string {
+	return p.suffix
+}
+
+// MaidenName returns the patient's family name before they took their
+// spouse's, or an empty string if their family name has not changed.
+func (p *Patient) MaidenName() string {
+	return p.maidenName
+}
+
+// BirthDate returns the patient's date of birth.
+func (p *Patient) BirthDate() time.Time {
+	return p.birthDate
+}
+
+// Race returns the patient's race.
+func (p *Patient) Race() string {
+	return p.race
+}
+
+// Ethnicity returns the patient's ethnicity.
+func (p *Patient) Ethnicity() string {
+	return p.ethnicity
+}
+
+// BloodType returns the patient's blood type, for example "o_positive".
+func (p *Patient) BloodType() string {
+	return p.bloodType
+}
+
+// Height returns the patient's height in cm.
+func (p *Patient) Height() float64 {
+	return p.height
+}
+
+// Weight returns the patient's weight in kg.
+func (p *Patient) Weight() float64 {
+	return p.weight
+}
+
+// Address returns the patient's current address.
+func (p *Patient) Address() Address {
+	return p.address
+}
+
+// PlaceOfBirth returns the patient's place of birth.
+func (p *Patient) PlaceOfBirth() PlaceOfBirth {
+	return p.placeOfBirth
+}
+
+// Income returns the patient's annual household income, in dollars.
+func (p *Patient) Income() int {
+	return p.income
+}
+
+// Education returns the highest level of education the patient has
+// attained, for example "hs_degree".
+func (p *Patient) Education() string {
+	return p.education
+}
+
+// Line returns the street address lines, omitting any that are empty.
+func (a Address) Line() []string {
+	var lines []string
+	for _, line := range a.line {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// City returns the address' city.
+func (a Address) City() string {
+	return a.city
+}
+
+// State returns the address' two character state abbreviation.
+func (a Address) State() string {
+	return a.state
+}
+
+// PostalCode returns the address' 5 digit postal code.
+func (a Address) PostalCode() string {
+	return a.postalCode
+}
+
+// HasLocation returns true if the latitude and longitude of the address
+// are known. Addresses in towns that are not in the gazetteer have no
+// location.
+func (a Address) HasLocation() bool {
+	return a.latitude != 0 || a.longitude != 0
+}
+
+// Latitude returns the latitude of the address, in degrees.
+func (a Address) Latitude() float64 {
+	return a.latitude
+}
+
+// Longitude returns the longitude of the address, in degrees.
+func (a Address) Longitude() float64 {
+	return a.longitude
+}
+
+// City returns the city of birth.
+func (pob PlaceOfBirth) City() string {
+	return pob.city
+}
+
+// State returns the state of birth. It is empty if the patient
+// was born outside of the United States.
+func (pob PlaceOfBirth) State() string {
+	return pob.state
+}
+
+// Country returns the country of birth.
+func (pob PlaceOfBirth) Country() string {
+	return pob.country
+}
+
+// MultipleBirth returns the patient's birth order, counting from 1, if
+// they were one of twins or triplets, or 0 if they were born alone.
+func (p *Patient) MultipleBirth() int {
+	return p.multipleBirth
+}
+
+// Relations returns the patient's relatives in their household. See
+// DataPack.NewHousehold.
+func (p *Patient) Relations() []Relation {
+	return p.relations
+}
+
+// Identifiers returns the patient's medical record numbers, one for each
+// organization that keeps their records, followed by their social
+// security number and, if they have them, their driver's license and
+// passport numbers.
+func (p *Patient) Identifiers() []Identifier {
+	return p.identifiers
+}
+
+// AgeAt returns the patient's age in whole years at the given time.
+func (p *Patient) AgeAt(time time.Time) int {
 	if time.Before(p.birthDate) {
 		panic("Patient has not been born yet")
 	}
@@ -80,68 +319,103 @@ func (p *Patient) getAgeAtTime(time time.Time) int {
 	return years
 }
 
-func pickFullName(gender string) (first, last string) {
-	switch gender {
-	case "Male":
-		first = fake.MaleFirstName()
-		last = fake.MaleLastName()
-	case "Female":
-		first = fake.FemaleFirstName()
-		last = fake.FemaleLastName()
-	}
-	return
+// pickID returns a random version 4 UUID.
+func pickID(rng *rand.Rand) string {
+	b := make([]byte, 16)
+	rng.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// fakeMutex serializes use of the fake package, whose random source is
+// global. withFake seeds it from rng first, so that fake data is as
+// deterministic as the rest of the patient, even when patients are
+// generated concurrently.
+var fakeMutex sync.Mutex
+
+func withFake(rng *rand.Rand, f func()) {
+	fakeMutex.Lock()
+	defer fakeMutex.Unlock()
+	fake.Seed(rng.Int63())
+	f()
 }
 
-func pickBirthdate(endDate time.Time, targetAge int) time.Time {
+func pickBirthdate(rng *rand.Rand, endDate time.Time, targetAge int) time.Time {
 	earliest := endDate.AddDate(-(targetAge + 1), 0, 1)
 	latest := endDate.AddDate(-targetAge, 0, 0)
-	totalSeconds := int(latest.Sub(earliest).Seconds())
-	randomDuration := time.Duration(rand.Intn(totalSeconds))
+	return pickDateBetween(rng, earliest, latest)
+}
+
+// pickDateBetween returns a random time in [earliest, latest).
+func pickDateBetween(rng *rand.Rand, earliest, latest time.Time) time.Time {
+	totalSeconds := int64(latest.Sub(earliest).Seconds())
+	randomDuration := time.Duration(rng.Int63n(totalSeconds)) * time.Second
 	return earliest.Add(randomDuration)
 }
 
-func pickRace() string {
-	races, ok := (Demographics["Race"]).([]utils.Choice)
-	if !ok {
-		panic("No race demographics provided")
-	}
-	race, _ := (utils.WeightedChoice(races).Item).(string)
+func (d *DataPack) pickRace(rng *rand.Rand) string {
+	race, _ := (utils.WeightedChoice(rng, d.race).Item).(string)
 	return race
 }
 
-func pickEthnicity(race string) string {
-	ethMap, ok := (Demographics["Ethnicity"]).(map[string][]utils.Choice)
-	if !ok {
-		panic("No ethnicity demographics provided")
-	}
-	eth, _ := (utils.WeightedChoice(ethMap[race]).Item).(string)
+func (d *DataPack) pickEthnicity(rng *rand.Rand, race string) string {
+	eth, _ := (utils.WeightedChoice(rng, d.ethnicity[race]).Item).(string)
 	return eth
 }
 
-func pickBloodType(race string) string {
-	bloodMap, ok := (Demographics["BloodType"]).(map[string][]utils.Choice)
-	if !ok {
-		panic("No blood type demographics provided")
-	}
-	typ, _ := (utils.WeightedChoice(bloodMap[race]).Item).(string)
+func (d *DataPack) pickBloodType(rng *rand.Rand, race string) string {
+	typ, _ := (utils.WeightedChoice(rng, d.bloodType[race]).Item).(string)
 	return typ
 }
 
-func pickCurrentAddress() Address {
+// pickCurrentAddress picks an address from the gazetteer, so that its
+// city, state, postal code and location agree. If town is not nil the
+// address is in the town, and has no location if the town is not in the
+// gazetteer. Otherwise the address is in a city of the data pack's state,
+// picked by population.
+func (d *DataPack) pickCurrentAddress(rng *rand.Rand, town *Town) Address {
 	secondaryAddress := ""
-	if rand.Float64() < 0.5 {
-		secondaryAddress = fmt.Sprintf("APT %s", string(rand.Intn(1000)))
+	if rng.Float64() < 0.5 {
+		secondaryAddress = fmt.Sprintf("APT %d", 1+rng.Intn(999))
 	}
+	var street string
+	withFake(rng, func() {
+		street = fake.StreetAddress()
+	})
 
-	return Address{
-		line:       []string{fake.StreetAddress(), secondaryAddress},
-		city:       fake.City(),
-		state:      fake.StateAbbrev(),
-		postalCode: fake.Zip()[:5], // only use the first 5 digits
+	var p *place
+	address := Address{line: []string{street, secondaryAddress}}
+	if town != nil {
+		address.city = town.Name
+		address.state = town.State
+		address.postalCode = town.pickPostalCode(rng)
+		p = defaultGazetteer.find(town.Name, town.State, address.postalCode)
+	} else {
+		p = defaultGazetteer.pick(rng, func(p *place) bool {
+			return d.State == "" || p.state == d.State
+		})
+		address.city = p.city
+		address.state = p.state
+		address.postalCode = p.pickPostalCode(rng)
 	}
+	if p != nil {
+		address.latitude, address.longitude = p.locate(rng)
+	}
+	return address
+}
+
+func (d *DataPack) pickSocioeconomics(rng *rand.Rand, town *Town) (income int, education string) {
+	if town != nil {
+		return town.pickIncome(rng), town.pickEducation(rng)
+	}
+
+	incomeBracket, _ := utils.WeightedChoice(rng, d.income).Item.(bracket)
+	education, _ = utils.WeightedChoice(rng, d.education).Item.(string)
+	return incomeBracket.pick(rng), education
 }
 
-func pickPlaceOfBirth(address Address, age int) PlaceOfBirth {
+func pickPlaceOfBirth(rng *rand.Rand, address Address, age int) PlaceOfBirth {
 	// Based on CDC census data:
 	// https://www.census.gov/prod/2011pubs/acsbr10-07.pdf
 
@@ -163,7 +437,7 @@ func pickPlaceOfBirth(address Address, age int) PlaceOfBirth {
 			Item:   "state",
 		},
 	}
-	change, _ := utils.WeightedChoice(changes).Item.(string)
+	change, _ := utils.WeightedChoice(rng, changes).Item.(string)
 
 	pob := PlaceOfBirth{
 		city:    address.city,
@@ -174,16 +448,24 @@ func pickPlaceOfBirth(address Address, age int) PlaceOfBirth {
 	switch change {
 	case "country":
 		// Born in a foreign country.
-		pob.city = fake.City()
-		pob.state = ""
-		pob.country = fake.Country()
+		withFake(rng, func() {
+			pob.city = fake.City()
+			pob.state = ""
+			pob.country = fake.Country()
+		})
 	case "state":
 		// Born in the U.S. but now lives in a different state.
-		pob.city = fake.City()
-		pob.state = fake.State()
+		if p := defaultGazetteer.pick(rng, func(p *place) bool { return p.state != address.state }); p != nil {
+			pob.city, pob.state = p.city, p.state
+		}
 	case "city":
-		// Born in the same state but now lives in a different city.
-		pob.city = fake.City()
+		// Born in the same state but now lives in a different city. Towns
+		// in states that are not in the gazetteer keep their own city.
+		if p := defaultGazetteer.pick(rng, func(p *place) bool {
+			return p.state == address.state && p.city != address.city
+		}); p != nil {
+			pob.city = p.city
+		}
 	}
 	return pob
 }
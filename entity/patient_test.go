@@ -1,9 +1,11 @@
 package entity
 
 import (
+	"math/rand"
 	"testing"
 	"time"
 
+	"github.com/cjduffett/synthea/utils"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -11,6 +13,7 @@ type PatientTestSuite struct {
 	suite.Suite
 	startTime time.Time
 	endTime   time.Time
+	rng       *rand.Rand
 }
 
 func TestPatientTestSuite(t *testing.T) {
@@ -23,6 +26,10 @@ func (p *PatientTestSuite) SetupSuite() {
 	p.startTime = p.endTime.AddDate(-100, 0, 0)
 }
 
+func (p *PatientTestSuite) SetupTest() {
+	p.rng = utils.NewRand(1)
+}
+
 // Tests that the chosen birthdate is in range
 func (p *PatientTestSuite) TestPickBirthdateRange() {
 	targetAge := 45
@@ -30,56 +37,95 @@ func (p *PatientTestSuite) TestPickBirthdateRange() {
 	expectedEarliest := time.Date(1970, time.December, 2, 12, 5, 0, 0, time.UTC)
 	expectedLatest := time.Date(1971, time.December, 1, 12, 5, 0, 0, time.UTC)
 
-	birthdate := pickBirthdate(now, targetAge)
+	birthdate := pickBirthdate(p.rng, now, targetAge)
 	p.True(birthdate.After(expectedEarliest), "Birthdate is more than one year before target age")
 	p.True(birthdate.Before(expectedLatest), "Birthdate is too recent to meet target age")
 }
 
+func (p *PatientTestSuite) TestNewPatientAgeDistribution() {
+	// Under a flat distribution about 5% of patients would be 95 or older
+	oldest := 0
+	for i := 0; i < 1000; i++ {
+		patient := NewPatient(p.rng, p.startTime, p.endTime, nil)
+		if patient.AgeAt(p.endTime) >= 95 {
+			oldest++
+		}
+	}
+	p.True(oldest < 20, "Ages should follow the census age-by-sex table")
+}
+
+func (p *PatientTestSuite) TestNewBirthCohortPatient() {
+	startTime := p.endTime.AddDate(-10, 0, 0)
+	for i := 0; i < 100; i++ {
+		patient := NewBirthCohortPatient(p.rng, startTime, p.endTime, nil)
+		p.False(patient.birthDate.Before(startTime), "Patient born before the simulation started")
+		p.True(patient.birthDate.Before(p.endTime), "Patient born after the simulation ended")
+	}
+}
+
+func (p *PatientTestSuite) TestNewPatientIsDeterministic() {
+	first := NewPatient(utils.NewRand(42), p.startTime, p.endTime, nil)
+	second := NewPatient(utils.NewRand(42), p.startTime, p.endTime, nil)
+	p.Equal(first, second, "The same seed should create the same patient")
+
+	third := NewPatient(utils.NewRand(43), p.startTime, p.endTime, nil)
+	p.NotEqual(first.id, third.id)
+}
+
 func (p *PatientTestSuite) TestPatientGetAge() {
-	patient := NewPatient(p.startTime, p.endTime)
+	patient := NewPatient(p.rng, p.startTime, p.endTime, nil)
 	patient.birthDate = time.Date(1994, time.January, 6, 12, 58, 00, 0, time.UTC)
 
 	// Pick a time in the simulation between those two dates
 	simTime := time.Date(2016, time.December, 9, 12, 00, 00, 0, time.UTC)
 
-	age := patient.getAgeAtTime(simTime)
+	age := patient.AgeAt(simTime)
 	p.Equal(22, age, "Patient born in 1994 expected age in 2016 is 22")
 }
 
 func (p *PatientTestSuite) TestPatientPickGender() {
 	genders := []string{"Male", "Female"}
-	patient := NewPatient(p.startTime, p.endTime)
+	patient := NewPatient(p.rng, p.startTime, p.endTime, nil)
 	p.True(contains(genders, patient.gender), "Invalid gender")
 }
 
 func (p *PatientTestSuite) TestPatientPickRace() {
 	races := []string{"White", "Hispanic", "Black", "Asian", "Native", "Other"}
-	race := pickRace()
+	race := Demographics.pickRace(p.rng)
 	p.True(contains(races, race), "Invalid race")
 }
 
 func (p *PatientTestSuite) TestPatientPickEthnicityGivenRace() {
 	eths := []string{"Puerto Rican", "Mexican", "Central American", "South American"}
 	race := "Hispanic"
-	eth := pickEthnicity(race)
+	eth := Demographics.pickEthnicity(p.rng, race)
 	p.True(contains(eths, eth), "Invalid ethnicity")
 }
 
 func (p *PatientTestSuite) TestPatientPickBloodTypeGivenRace() {
 	typs := []string{"o_positive", "o_negative", "a_positive", "a_negative", "b_positive", "b_negative", "ab_positive", "ab_negative"}
 	race := "White"
-	typ := pickBloodType(race)
+	typ := Demographics.pickBloodType(p.rng, race)
 	p.True(contains(typs, typ), "Invalid blood type")
 }
 
 func (p *PatientTestSuite) TestPatientPickCurrentAddress() {
-	addr := pickCurrentAddress()
-	p.NotEmpty(addr.line[0], "Invalid address line[0]")
-	p.NotEmpty(addr.state, "Invalid state")
-	p.Equal(2, len(addr.state), "State name is not a standard 2 character abbreviation")
-	p.NotEmpty(addr.city, "Invalid city")
-	p.NotEmpty(addr.postalCode, "Invalid postal code")
-	p.Equal(5, len(addr.postalCode), "Invalid postal code")
+	for i := 0; i < 100; i++ {
+		addr := Demographics.pickCurrentAddress(p.rng, nil)
+		p.NotEmpty(addr.line[0], "Invalid address line[0]")
+		p.Equal("MA", addr.state, "Addresses are in the data pack's state")
+		p.Equal(5, len(addr.postalCode), "Invalid postal code")
+		if len(addr.Line()) > 1 {
+			p.Regexp(`^APT [1-9]\d*$`, addr.Line()[1])
+		}
+
+		// The city, postal code and location agree.
+		place := defaultGazetteer.find(addr.city, addr.state, addr.postalCode)
+		p.Require().NotNil(place, "Invalid city")
+		p.Contains(place.postalCodes, addr.postalCode)
+		p.True(addr.HasLocation())
+		p.True(distance(place.latitude, place.longitude, addr.Latitude(), addr.Longitude()) < 25)
+	}
 }
 
 func contains(choices []string, want string) bool {
@@ -0,0 +1,347 @@
+package entity
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/cjduffett/synthea/records"
+	"github.com/cjduffett/synthea/utils"
+)
+
+// Snapshot is an entity saved in the middle of its simulation, from
+// which the simulation can be continued; see gmf.GMF.Continue. The
+// entity was simulated on time steps of Step from Start, up to and
+// including Until.
+type Snapshot struct {
+	Entity *Entity
+	Start  time.Time
+	Until  time.Time
+	Step   time.Duration
+	// seed seeds the entity's random number generator after the
+	// snapshot.
+	seed int64
+}
+
+// snapshotState is the JSON form of a Snapshot.
+type snapshotState struct {
+	Start  time.Time     `json:"start"`
+	Until  time.Time     `json:"until"`
+	Step   time.Duration `json:"step"`
+	Seed   int64         `json:"seed"`
+	Entity *Entity       `json:"entity"`
+}
+
+// Snapshot returns a snapshot of the entity, which has been simulated on
+// time steps of step from start until until. The state of a random
+// number generator cannot be saved, so Snapshot reseeds the entity's
+// generator from itself and saves the new seed: the entity and a copy
+// restored from the snapshot continue identically. The snapshot refers
+// to the entity, so write it before simulating the entity any further.
+func (e *Entity) Snapshot(start, until time.Time, step time.Duration) *Snapshot {
+	seed := e.Rand().Int63()
+	e.rng = utils.NewRand(seed)
+	return &Snapshot{Entity: e, Start: start, Until: until, Step: step, seed: seed}
+}
+
+// WriteJSON writes the snapshot as JSON.
+func (s *Snapshot) WriteJSON(w io.Writer) error {
+	return json.NewEncoder(w).Encode(snapshotState{
+		Start:  s.Start,
+		Until:  s.Until,
+		Step:   s.Step,
+		Seed:   s.seed,
+		Entity: s.Entity,
+	})
+}
+
+// ReadSnapshot reads a snapshot written by Snapshot.WriteJSON.
+func ReadSnapshot(r io.Reader) (*Snapshot, error) {
+	var state snapshotState
+	err := json.NewDecoder(r).Decode(&state)
+	if err == nil && state.Entity == nil {
+		err = fmt.Errorf("missing entity")
+	}
+	if err == nil && state.Step <= 0 {
+		err = fmt.Errorf("step must be greater than 0")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Invalid snapshot: %s", err.Error())
+	}
+	state.Entity.rng = utils.NewRand(state.Seed)
+	return &Snapshot{
+		Entity: state.Entity,
+		Start:  state.Start,
+		Until:  state.Until,
+		Step:   state.Step,
+		seed:   state.Seed,
+	}, nil
+}
+
+// LoadSnapshot loads a snapshot from the file at path.
+func LoadSnapshot(path string) (*Snapshot, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	s, err := ReadSnapshot(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err.Error())
+	}
+	return s, nil
+}
+
+// entityState is the JSON form of an Entity, without its random number
+// generator.
+type entityState struct {
+	Patient       *Patient                  `json:"patient"`
+	Record        *records.Record           `json:"record"`
+	Attributes    map[string]attributeState `json:"attributes,omitempty"`
+	Modules       map[string]*ModuleContext `json:"modules,omitempty"`
+	Symptoms      map[string]map[string]int `json:"symptoms,omitempty"`
+	LastWellVisit time.Time                 `json:"last_well_visit"`
+}
+
+// attributeState is the JSON form of an attribute. Attributes hold
+// either codes or values parsed from module JSON, so numbers are
+// restored as float64.
+type attributeState struct {
+	Type  string          `json:"type,omitempty"`
+	Value json.RawMessage `json:"value"`
+}
+
+// MarshalJSON returns the entity's patient, record, attributes, module
+// contexts and symptoms. See Snapshot.
+func (e *Entity) MarshalJSON() ([]byte, error) {
+	state := entityState{
+		Patient:       &e.Patient,
+		Record:        &e.Record,
+		Attributes:    make(map[string]attributeState),
+		Modules:       e.contexts,
+		Symptoms:      e.symptoms,
+		LastWellVisit: e.lastWellVisit,
+	}
+	for name, value := range e.Attributes {
+		var attribute attributeState
+		if _, ok := value.([]records.Code); ok {
+			attribute.Type = "codes"
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("Attribute '%s': %s", name, err.Error())
+		}
+		attribute.Value = data
+		state.Attributes[name] = attribute
+	}
+	return json.Marshal(state)
+}
+
+// UnmarshalJSON restores an entity written by MarshalJSON. Its random
+// number generator is not restored.
+func (e *Entity) UnmarshalJSON(data []byte) error {
+	state := entityState{Patient: new(Patient), Record: new(records.Record)}
+	err := json.Unmarshal(data, &state)
+	if err != nil {
+		return err
+	}
+	restored := NewEntity(state.Patient, nil)
+	restored.Record = *state.Record
+	restored.lastWellVisit = state.LastWellVisit
+	for name, ctx := range state.Modules {
+		restored.contexts[name] = ctx
+	}
+	for symptom, causes := range state.Symptoms {
+		restored.symptoms[symptom] = causes
+	}
+	for name, attribute := range state.Attributes {
+		var value interface{}
+		if attribute.Type == "codes" {
+			var codes []records.Code
+			err = json.Unmarshal(attribute.Value, &codes)
+			value = codes
+		} else {
+			err = json.Unmarshal(attribute.Value, &value)
+		}
+		if err != nil {
+			return fmt.Errorf("Attribute '%s': %s", name, err.Error())
+		}
+		restored.Attributes[name] = value
+	}
+	*e = *restored
+	return nil
+}
+
+// patientState is the JSON form of a Patient.
+type patientState struct {
+	ID            string            `json:"id"`
+	Gender        string            `json:"gender"`
+	FirstName     string            `json:"first_name"`
+	LastName      string            `json:"last_name"`
+	Prefix        string            `json:"prefix,omitempty"`
+	Suffix        string            `json:"suffix,omitempty"`
+	MaidenName    string            `json:"maiden_name,omitempty"`
+	BirthDate     time.Time         `json:"birth_date"`
+	Race          string            `json:"race"`
+	Ethnicity     string            `json:"ethnicity"`
+	BloodType     string            `json:"blood_type"`
+	Height        float64           `json:"height"`
+	Weight        float64           `json:"weight"`
+	Address       addressState      `json:"address"`
+	PlaceOfBirth  placeOfBirthState `json:"place_of_birth"`
+	Income        int               `json:"income"`
+	Education     string            `json:"education"`
+	MultipleBirth int               `json:"multiple_birth,omitempty"`
+	Relations     []relationState   `json:"relations,omitempty"`
+	Identifiers   []identifierState `json:"identifiers,omitempty"`
+}
+
+type identifierState struct {
+	Type     string `json:"type"`
+	System   string `json:"system"`
+	Value    string `json:"value"`
+	Assigner string `json:"assigner,omitempty"`
+}
+
+type relationState struct {
+	Relationship string    `json:"relationship"`
+	ID           string    `json:"id"`
+	FirstName    string    `json:"first_name"`
+	LastName     string    `json:"last_name"`
+	Gender       string    `json:"gender"`
+	BirthDate    time.Time `json:"birth_date"`
+}
+
+type addressState struct {
+	Line       []string `json:"line"`
+	City       string   `json:"city"`
+	State      string   `json:"state"`
+	PostalCode string   `json:"postal_code"`
+	Latitude   float64  `json:"latitude,omitempty"`
+	Longitude  float64  `json:"longitude,omitempty"`
+}
+
+type placeOfBirthState struct {
+	City    string `json:"city"`
+	State   string `json:"state"`
+	Country string `json:"country"`
+}
+
+// MarshalJSON returns every detail of the patient, so that it can be
+// saved and restored with UnmarshalJSON.
+func (p *Patient) MarshalJSON() ([]byte, error) {
+	var relations []relationState
+	for _, r := range p.relations {
+		relations = append(relations, relationState{
+			Relationship: r.relationship,
+			ID:           r.id,
+			FirstName:    r.firstName,
+			LastName:     r.lastName,
+			Gender:       r.gender,
+			BirthDate:    r.birthDate,
+		})
+	}
+	var identifiers []identifierState
+	for _, id := range p.identifiers {
+		identifiers = append(identifiers, identifierState{
+			Type:     id.typ,
+			System:   id.system,
+			Value:    id.value,
+			Assigner: id.assigner,
+		})
+	}
+	return json.Marshal(patientState{
+		ID:         p.id,
+		Gender:     p.gender,
+		FirstName:  p.firstName,
+		LastName:   p.lastName,
+		Prefix:     p.prefix,
+		Suffix:     p.suffix,
+		MaidenName: p.maidenName,
+		BirthDate:  p.birthDate,
+		Race:       p.race,
+		Ethnicity:  p.ethnicity,
+		BloodType:  p.bloodType,
+		Height:     p.height,
+		Weight:     p.weight,
+		Address: addressState{
+			Line:       p.address.line,
+			City:       p.address.city,
+			State:      p.address.state,
+			PostalCode: p.address.postalCode,
+			Latitude:   p.address.latitude,
+			Longitude:  p.address.longitude,
+		},
+		PlaceOfBirth: placeOfBirthState{
+			City:    p.placeOfBirth.city,
+			State:   p.placeOfBirth.state,
+			Country: p.placeOfBirth.country,
+		},
+		Income:        p.income,
+		Education:     p.education,
+		MultipleBirth: p.multipleBirth,
+		Relations:     relations,
+		Identifiers:   identifiers,
+	})
+}
+
+// UnmarshalJSON restores a patient written by MarshalJSON.
+func (p *Patient) UnmarshalJSON(data []byte) error {
+	var state patientState
+	err := json.Unmarshal(data, &state)
+	if err != nil {
+		return err
+	}
+	*p = Patient{
+		id:         state.ID,
+		gender:     state.Gender,
+		firstName:  state.FirstName,
+		lastName:   state.LastName,
+		prefix:     state.Prefix,
+		suffix:     state.Suffix,
+		maidenName: state.MaidenName,
+		birthDate:  state.BirthDate,
+		race:       state.Race,
+		ethnicity:  state.Ethnicity,
+		bloodType:  state.BloodType,
+		height:     state.Height,
+		weight:     state.Weight,
+		address: Address{
+			line:       state.Address.Line,
+			city:       state.Address.City,
+			state:      state.Address.State,
+			postalCode: state.Address.PostalCode,
+			latitude:   state.Address.Latitude,
+			longitude:  state.Address.Longitude,
+		},
+		placeOfBirth: PlaceOfBirth{
+			city:    state.PlaceOfBirth.City,
+			state:   state.PlaceOfBirth.State,
+			country: state.PlaceOfBirth.Country,
+		},
+		income:        state.Income,
+		education:     state.Education,
+		multipleBirth: state.MultipleBirth,
+	}
+	for _, r := range state.Relations {
+		p.relations = append(p.relations, Relation{
+			relationship: r.Relationship,
+			id:           r.ID,
+			firstName:    r.FirstName,
+			lastName:     r.LastName,
+			gender:       r.Gender,
+			birthDate:    r.BirthDate,
+		})
+	}
+	for _, id := range state.Identifiers {
+		p.identifiers = append(p.identifiers, Identifier{
+			typ:      id.Type,
+			system:   id.System,
+			value:    id.Value,
+			assigner: id.Assigner,
+		})
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package entity
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"sort"
+
+	"github.com/cjduffett/synthea/utils"
+)
+
+// Town holds the demographics of a single town, used to generate patients
+// that live there. Towns are loaded from a JSON file keyed by town name:
+//
+//	{
+//	  "Boston": {
+//	    "state": "MA",
+//	    "population": 667137,
+//	    "postal_codes": ["02108", "02109"],
+//	    "race": {"White": 0.53, "Hispanic": 0.19, "Black": 0.25, "Asian": 0.03},
+//	    "gender": {"Male": 0.48, "Female": 0.52},
+//	    "ages": {"0..17": 0.17, "18..64": 0.72, "65..99": 0.11},
+//	    "income": {"0..24999": 0.3, "25000..74999": 0.4, "75000..200000": 0.3},
+//	    "education": {"less_than_hs": 0.15, "hs_degree": 0.3, "some_college": 0.2, "bs_degree": 0.35}
+//	  }
+//	}
+//
+// Age and income brackets are inclusive ranges of the form "low..high".
+// "ethnicity" may optionally map each race to a distribution of ethnicities;
+// otherwise the statewide ethnicities of the data pack are used.
+type Town struct {
+	Name        string                        `json:"-"`
+	State       string                        `json:"state"`
+	Population  int                           `json:"population"`
+	PostalCodes []string                      `json:"postal_codes"`
+	Race        map[string]float64            `json:"race"`
+	Ethnicity   map[string]map[string]float64 `json:"ethnicity"`
+	Gender      map[string]float64            `json:"gender"`
+	Ages        map[string]float64            `json:"ages"`
+	Income      map[string]float64            `json:"income"`
+	Education   map[string]float64            `json:"education"`
+
+	// Parsed distributions, built by LoadTowns.
+	race      []utils.Choice
+	ethnicity map[string][]utils.Choice
+	gender    []utils.Choice
+	ages      []utils.Choice
+	income    []utils.Choice
+	education []utils.Choice
+}
+
+// LoadTowns loads and validates the towns in a demographics file. Towns
+// are returned sorted by name.
+func LoadTowns(path string) ([]*Town, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	townMap := make(map[string]*Town)
+	err = json.Unmarshal(data, &townMap)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid demographics %s: %s", path, err.Error())
+	}
+	if len(townMap) == 0 {
+		return nil, fmt.Errorf("Invalid demographics %s: no towns found", path)
+	}
+
+	var towns []*Town
+	for name, town := range townMap {
+		town.Name = name
+		err = town.parse()
+		if err != nil {
+			return nil, fmt.Errorf("Invalid demographics %s: town '%s': %s", path, name, err.Error())
+		}
+		towns = append(towns, town)
+	}
+	sort.Slice(towns, func(i, j int) bool {
+		return towns[i].Name < towns[j].Name
+	})
+	return towns, nil
+}
+
+// parse validates the town and builds its distributions.
+func (t *Town) parse() error {
+	var err error
+	if t.Population <= 0 {
+		return errors.New("'population' must be greater than 0")
+	}
+	if len(t.State) != 2 {
+		return errors.New("'state' must be a 2 character abbreviation")
+	}
+	if len(t.PostalCodes) == 0 {
+		return errors.New("no 'postal_codes' found")
+	}
+	if t.race, err = parseWeights("race", t.Race, nil); err != nil {
+		return err
+	}
+	if t.gender, err = parseWeights("gender", t.Gender, nil); err != nil {
+		return err
+	}
+	for gender := range t.Gender {
+		if gender != "Male" && gender != "Female" {
+			return fmt.Errorf("'gender' '%s' must be Male or Female", gender)
+		}
+	}
+	if t.ages, err = parseWeights("ages", t.Ages, parseBracket); err != nil {
+		return err
+	}
+	if t.income, err = parseWeights("income", t.Income, parseBracket); err != nil {
+		return err
+	}
+	if t.education, err = parseWeights("education", t.Education, nil); err != nil {
+		return err
+	}
+	t.ethnicity = make(map[string][]utils.Choice)
+	for race, weights := range t.Ethnicity {
+		if t.ethnicity[race], err = parseWeights("ethnicity."+race, weights, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AllocatePopulation divides n patients among the towns in proportion to
+// their populations. Counts are rounded using the largest remainder method
+// so that they always sum to n.
+func AllocatePopulation(towns []*Town, n int) []int {
+	total := 0
+	for _, town := range towns {
+		total += town.Population
+	}
+
+	counts := make([]int, len(towns))
+	remainders := make([]float64, len(towns))
+	allocated := 0
+	for i, town := range towns {
+		share := float64(n) * float64(town.Population) / float64(total)
+		counts[i] = int(share)
+		remainders[i] = share - float64(counts[i])
+		allocated += counts[i]
+	}
+
+	order := make([]int, len(towns))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return remainders[order[a]] > remainders[order[b]]
+	})
+	for i := 0; allocated < n; i++ {
+		counts[order[i%len(order)]]++
+		allocated++
+	}
+	return counts
+}
+
+func (t *Town) pickGender(rng *rand.Rand) string {
+	gender, _ := utils.WeightedChoice(rng, t.gender).Item.(string)
+	return gender
+}
+
+func (t *Town) pickAge(rng *rand.Rand) int {
+	ages, _ := utils.WeightedChoice(rng, t.ages).Item.(bracket)
+	return ages.pick(rng)
+}
+
+func (t *Town) pickRace(rng *rand.Rand) string {
+	race, _ := utils.WeightedChoice(rng, t.race).Item.(string)
+	return race
+}
+
+// pickEthnicity picks an ethnicity for race from the town's ethnicities,
+// falling back to the statewide ethnicities of pack.
+func (t *Town) pickEthnicity(rng *rand.Rand, pack *DataPack, race string) string {
+	choices, ok := t.ethnicity[race]
+	if !ok {
+		return pack.pickEthnicity(rng, race)
+	}
+	eth, _ := utils.WeightedChoice(rng, choices).Item.(string)
+	return eth
+}
+
+func (t *Town) pickIncome(rng *rand.Rand) int {
+	income, _ := utils.WeightedChoice(rng, t.income).Item.(bracket)
+	return income.pick(rng)
+}
+
+func (t *Town) pickEducation(rng *rand.Rand) string {
+	education, _ := utils.WeightedChoice(rng, t.education).Item.(string)
+	return education
+}
+
+func (t *Town) pickPostalCode(rng *rand.Rand) string {
+	return t.PostalCodes[rng.Intn(len(t.PostalCodes))]
+}
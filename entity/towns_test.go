@@ -0,0 +1,81 @@
+package entity
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cjduffett/synthea/utils"
+	"github.com/stretchr/testify/suite"
+)
+
+type TownsTestSuite struct {
+	suite.Suite
+	towns []*Town
+}
+
+func TestTownsTestSuite(t *testing.T) {
+	suite.Run(t, new(TownsTestSuite))
+}
+
+func (t *TownsTestSuite) SetupTest() {
+	var err error
+	t.towns, err = LoadTowns("../fixtures/demographics/towns.json")
+	t.Require().Nil(err)
+}
+
+func (t *TownsTestSuite) TestLoadTowns() {
+	t.Equal(3, len(t.towns))
+	t.Equal("Boston", t.towns[0].Name, "Towns should be sorted by name")
+	t.Equal("Nantucket", t.towns[1].Name)
+	t.Equal("Worcester", t.towns[2].Name)
+	t.Equal(667137, t.towns[0].Population)
+}
+
+func (t *TownsTestSuite) TestLoadInvalidTowns() {
+	_, err := LoadTowns("../fixtures/demographics/invalid_towns.json")
+	t.NotNil(err)
+	t.Contains(err.Error(), "65..18")
+
+	_, err = LoadTowns("../fixtures/demographics/invalid_gender_towns.json")
+	t.NotNil(err)
+	t.Contains(err.Error(), "must be Male or Female")
+}
+
+func (t *TownsTestSuite) TestAllocatePopulation() {
+	counts := AllocatePopulation(t.towns, 1000)
+	t.Equal([]int{774, 12, 214}, counts)
+
+	counts = AllocatePopulation(t.towns, 1)
+	t.Equal([]int{1, 0, 0}, counts)
+
+	total := 0
+	for _, count := range AllocatePopulation(t.towns, 12345) {
+		total += count
+	}
+	t.Equal(12345, total)
+}
+
+func (t *TownsTestSuite) TestNewPatientInTown() {
+	endTime := time.Date(2016, time.December, 1, 0, 0, 0, 0, time.UTC)
+	nantucket := t.towns[1]
+
+	patient := NewPatient(utils.NewRand(1), endTime.AddDate(-100, 0, 0), endTime, nantucket)
+	t.Equal("Nantucket", patient.address.city)
+	t.Equal("MA", patient.address.state)
+	t.Equal("02554", patient.address.postalCode)
+	t.True(patient.birthDate.After(endTime.AddDate(-66, 0, 0)), "Patient is older than 65")
+	t.False(patient.birthDate.After(endTime.AddDate(-65, 0, 0)), "Patient is younger than 65")
+	t.Equal(50000, patient.income)
+	t.Equal("bs_degree", patient.education)
+	t.True(contains([]string{"White", "Hispanic", "Black", "Other"}, patient.race), "Invalid race")
+}
+
+func (t *TownsTestSuite) TestTownEthnicity() {
+	boston := t.towns[0]
+	rng := utils.NewRand(1)
+	eths := []string{"Puerto Rican", "Dominican", "Central American"}
+	t.True(contains(eths, boston.pickEthnicity(rng, Demographics, "Hispanic")), "Invalid town ethnicity")
+
+	// Races without town-level ethnicities fall back to the statewide distribution
+	t.Equal("American Indian", boston.pickEthnicity(rng, Demographics, "Native"))
+}
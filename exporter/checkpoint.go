@@ -0,0 +1,121 @@
+package exporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Checkpointer is implemented by exporters that keep state between
+// patients, such as exporters that append every patient to the same
+// files, so that an interrupted run can be resumed from a checkpoint.
+// Exporters that write each patient on their own need not implement it:
+// a resumed run exports the patients after the checkpoint again, which
+// overwrites anything written for them before the interruption.
+type Checkpointer interface {
+	// Checkpoint flushes every patient exported so far and returns the
+	// state needed to resume exporting after them.
+	Checkpoint() (json.RawMessage, error)
+	// Resume readies the exporter to continue writing to outputDir
+	// from state, discarding anything written after the checkpoint. It
+	// is called instead of Init.
+	Resume(outputDir string, state json.RawMessage) error
+}
+
+// Checkpoint flushes every exporter in the set and returns their states,
+// keyed by exporter name, to resume with ResumeSet. Exporters that are
+// not Checkpointers have a null state.
+func (s *Set) Checkpoint() (map[string]json.RawMessage, error) {
+	states := make(map[string]json.RawMessage)
+	for i, exp := range s.exporters {
+		state := json.RawMessage("null")
+		if c, ok := exp.(Checkpointer); ok {
+			var err error
+			state, err = c.Checkpoint()
+			if err != nil {
+				return nil, fmt.Errorf("Exporter '%s' failed to checkpoint: %s", s.names[i], err.Error())
+			}
+		}
+		states[s.names[i]] = state
+	}
+	return states, nil
+}
+
+// ResumeSet creates the named exporters, like NewSet, and resumes each
+// from its state in states, as returned by Set.Checkpoint. The exporters
+// must be the ones that were checkpointed.
+func ResumeSet(names []string, outputDir string, options Options, states map[string]json.RawMessage) (*Set, error) {
+	for _, name := range names {
+		if _, found := states[name]; !found {
+			return nil, fmt.Errorf("Exporter '%s' was not in the checkpoint", name)
+		}
+	}
+	if len(states) != len(names) {
+		return nil, fmt.Errorf("Exporters %v do not match the %d in the checkpoint", names, len(states))
+	}
+	return newSet(names, outputDir, options, states)
+}
+
+// fileOffsets returns the current offset of each file, which must have
+// been flushed.
+func fileOffsets(files []*os.File) ([]int64, error) {
+	offsets := make([]int64, len(files))
+	for i, file := range files {
+		offset, err := file.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		offsets[i] = offset
+	}
+	return offsets, nil
+}
+
+// reopenFile opens the file at path for writing at offset, truncating
+// anything written after it.
+func reopenFile(path string, offset int64) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err == nil && info.Size() < offset {
+		err = fmt.Errorf("Invalid checkpoint: %s is shorter than its checkpoint offset %d", path, offset)
+	}
+	if err == nil {
+		err = file.Truncate(offset)
+	}
+	if err == nil {
+		_, err = file.Seek(offset, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// reopenTables reopens the CSV tables named by tables in outputDir at
+// their offsets, in order.
+func reopenTables(outputDir string, tables []string, offsets map[string]int64) ([]*os.File, error) {
+	var files []*os.File
+	for _, table := range tables {
+		offset, found := offsets[table]
+		var file *os.File
+		var err error
+		if found {
+			file, err = reopenFile(filepath.Join(outputDir, table+".csv"), offset)
+		} else {
+			err = fmt.Errorf("Invalid checkpoint: table %s is missing", table)
+		}
+		if err != nil {
+			for _, f := range files {
+				f.Close()
+			}
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
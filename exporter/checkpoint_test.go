@@ -0,0 +1,116 @@
+package exporter
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cjduffett/synthea/entity"
+	"github.com/cjduffett/synthea/records"
+	"github.com/stretchr/testify/suite"
+)
+
+type CheckpointTestSuite struct {
+	suite.Suite
+	names   []string
+	options Options
+}
+
+func TestCheckpointTestSuite(t *testing.T) {
+	suite.Run(t, new(CheckpointTestSuite))
+}
+
+func (suite *CheckpointTestSuite) SetupTest() {
+	suite.names = []string{"csv", "omop", "hl7", "html"}
+	suite.options = Options{"hl7.mllp": "true"}
+}
+
+// testRecords returns patients to export, each with the same record.
+func (suite *CheckpointTestSuite) testRecords() ([]*entity.Patient, *records.Record) {
+	_, record := testPatientRecord()
+	return testPatients(4), record
+}
+
+func (suite *CheckpointTestSuite) TestResumeProducesIdenticalOutput() {
+	patients, record := suite.testRecords()
+
+	expectedDir := suite.T().TempDir()
+	set, err := NewSet(suite.names, expectedDir, suite.options)
+	suite.Require().Nil(err)
+	for _, patient := range patients {
+		suite.Require().Nil(set.Export(patient, record))
+	}
+	suite.Require().Nil(set.Close())
+
+	// Checkpoint after two patients, and export a third that is lost in
+	// the interruption.
+	dir := suite.T().TempDir()
+	set, err = NewSet(suite.names, dir, suite.options)
+	suite.Require().Nil(err)
+	suite.Require().Nil(set.Export(patients[0], record))
+	suite.Require().Nil(set.Export(patients[1], record))
+	states, err := set.Checkpoint()
+	suite.Require().Nil(err)
+	suite.Require().Nil(set.Export(patients[2], record))
+	suite.Require().Nil(set.Close())
+
+	suite.Equal("null", string(states["html"]), "The HTML exporter has no state")
+	set, err = ResumeSet(suite.names, dir, suite.options, states)
+	suite.Require().Nil(err)
+	suite.Require().Nil(set.Export(patients[2], record))
+	suite.Require().Nil(set.Export(patients[3], record))
+	suite.Require().Nil(set.Close())
+
+	suite.Equal(readFiles(suite.T(), expectedDir), readFiles(suite.T(), dir))
+}
+
+func (suite *CheckpointTestSuite) TestResumeDifferentExporters() {
+	dir := suite.T().TempDir()
+	set, err := NewSet(suite.names, dir, suite.options)
+	suite.Require().Nil(err)
+	states, err := set.Checkpoint()
+	suite.Require().Nil(err)
+	suite.Require().Nil(set.Close())
+
+	_, err = ResumeSet([]string{"csv", "omop", "hl7", "html", "fhir"}, dir, suite.options, states)
+	suite.NotNil(err)
+	_, err = ResumeSet([]string{"csv"}, dir, suite.options, states)
+	suite.NotNil(err)
+}
+
+func (suite *CheckpointTestSuite) TestResumeTruncatedTable() {
+	dir := suite.T().TempDir()
+	set, err := NewSet([]string{"csv"}, dir, Options{})
+	suite.Require().Nil(err)
+	suite.Require().Nil(set.Export(testPatientRecord()))
+	states, err := set.Checkpoint()
+	suite.Require().Nil(err)
+	suite.Require().Nil(set.Close())
+
+	suite.Require().Nil(os.Truncate(filepath.Join(dir, "csv", "patients.csv"), 10))
+	_, err = ResumeSet([]string{"csv"}, dir, Options{}, states)
+	suite.NotNil(err)
+}
+
+// readFiles returns the contents of every file under dir, keyed by path
+// relative to dir.
+func readFiles(t *testing.T, dir string) map[string]string {
+	files := make(map[string]string)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		files[rel] = string(data)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
@@ -0,0 +1,314 @@
+package exporter
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cjduffett/synthea/entity"
+	"github.com/cjduffett/synthea/records"
+)
+
+// csvTables lists each table written by the CSV exporter and its
+// columns, in the order they are written. Codes are written as their
+// first code; every row references its patient by ID.
+var csvTables = []struct {
+	name    string
+	columns []string
+}{
+	{"patients", []string{"id", "birth_date", "death_date", "first", "last", "gender", "race", "ethnicity", "birth_place", "address", "city", "state", "postal_code", "multiple_birth", "prefix", "suffix", "maiden", "lat", "lon"}},
+	{"encounters", []string{"start", "stop", "patient", "class", "code", "description", "reason_code", "reason_description"}},
+	{"conditions", []string{"start", "stop", "patient", "code", "description"}},
+	{"observations", []string{"date", "patient", "code", "description", "value", "units"}},
+	{"procedures", []string{"date", "patient", "code", "description", "reason_code", "reason_description"}},
+	{"immunizations", []string{"date", "patient", "code", "description"}},
+	{"medications", []string{"start", "stop", "patient", "code", "description", "reason_code", "reason_description"}},
+	{"careplans", []string{"start", "stop", "patient", "code", "description", "reason_code", "reason_description"}},
+	{"relationships", []string{"patient", "relative", "relationship"}},
+	{"identifiers", []string{"patient", "type", "system", "value", "assigner"}},
+}
+
+// CSVTables returns the names of the tables the CSV exporter writes.
+func CSVTables() []string {
+	names := make([]string, len(csvTables))
+	for i, table := range csvTables {
+		names[i] = table.name
+	}
+	return names
+}
+
+// CSVExporter writes patients as one CSV file per table, with one row
+// per patient in patients.csv and one row per record entry in the
+// others. See CSVTables.
+type CSVExporter struct {
+	files   []*os.File
+	writers []*CSVWriter
+}
+
+func init() {
+	Register("csv", func(options Options) (Exporter, error) {
+		return NewCSVExporter(), nil
+	})
+}
+
+// NewCSVExporter returns a new CSVExporter.
+func NewCSVExporter() *CSVExporter {
+	return &CSVExporter{}
+}
+
+// Init creates each table in outputDir and writes its header.
+func (c *CSVExporter) Init(outputDir string) error {
+	err := os.MkdirAll(outputDir, 0755)
+	if err != nil {
+		return err
+	}
+
+	for _, table := range csvTables {
+		file, err := os.Create(filepath.Join(outputDir, table.name+".csv"))
+		if err != nil {
+			c.Close()
+			return err
+		}
+		c.files = append(c.files, file)
+		w, err := NewCSVWriter(file, table.name)
+		if err != nil {
+			c.Close()
+			return err
+		}
+		c.writers = append(c.writers, w)
+	}
+	return nil
+}
+
+// Export appends the patient and their record to every table.
+func (c *CSVExporter) Export(patient *entity.Patient, record *records.Record) error {
+	for _, w := range c.writers {
+		err := w.Write(patient, record)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close flushes and closes every table.
+func (c *CSVExporter) Close() error {
+	var err error
+	for _, w := range c.writers {
+		if ferr := w.Flush(); ferr != nil && err == nil {
+			err = ferr
+		}
+	}
+	for _, file := range c.files {
+		if cerr := file.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// csvState is the checkpoint state of a CSVExporter.
+type csvState struct {
+	// Offsets are the sizes of the tables, by name.
+	Offsets map[string]int64 `json:"offsets"`
+}
+
+// Checkpoint flushes every table and returns their sizes.
+func (c *CSVExporter) Checkpoint() (json.RawMessage, error) {
+	for _, w := range c.writers {
+		if err := w.Flush(); err != nil {
+			return nil, err
+		}
+	}
+	offsets, err := fileOffsets(c.files)
+	if err != nil {
+		return nil, err
+	}
+	state := csvState{Offsets: make(map[string]int64)}
+	for i, table := range csvTables {
+		state.Offsets[table.name] = offsets[i]
+	}
+	return json.Marshal(state)
+}
+
+// Resume reopens each table in outputDir, truncated to its size at the
+// checkpoint.
+func (c *CSVExporter) Resume(outputDir string, data json.RawMessage) error {
+	var state csvState
+	err := json.Unmarshal(data, &state)
+	if err != nil {
+		return fmt.Errorf("Invalid checkpoint: %s", err.Error())
+	}
+	c.files, err = reopenTables(outputDir, CSVTables(), state.Offsets)
+	if err != nil {
+		return err
+	}
+	for i, table := range csvTables {
+		c.writers = append(c.writers, &CSVWriter{table: table.name, writer: csv.NewWriter(c.files[i])})
+	}
+	return nil
+}
+
+// CSVWriter writes a single CSV table.
+type CSVWriter struct {
+	table  string
+	writer *csv.Writer
+}
+
+// NewCSVWriter returns a CSVWriter that writes the named table to w,
+// and writes the table's header.
+func NewCSVWriter(w io.Writer, table string) (*CSVWriter, error) {
+	for _, t := range csvTables {
+		if t.name == table {
+			c := &CSVWriter{table: table, writer: csv.NewWriter(w)}
+			return c, c.writer.Write(t.columns)
+		}
+	}
+	return nil, fmt.Errorf("Unknown CSV table '%s' (available: %s)", table, strings.Join(CSVTables(), ", "))
+}
+
+// Write writes the patient's rows of the table. Rows are buffered until
+// Flush is called.
+func (c *CSVWriter) Write(patient *entity.Patient, record *records.Record) error {
+	id := patient.ID()
+	var rows [][]string
+
+	switch c.table {
+	case "patients":
+		address := patient.Address()
+		birthPlace := patient.PlaceOfBirth()
+		var death, multipleBirth, lat, lon string
+		if record.Expired() {
+			death = csvDate(record.DeathTime())
+		}
+		if patient.MultipleBirth() > 0 {
+			multipleBirth = strconv.Itoa(patient.MultipleBirth())
+		}
+		if address.HasLocation() {
+			lat = strconv.FormatFloat(address.Latitude(), 'f', 6, 64)
+			lon = strconv.FormatFloat(address.Longitude(), 'f', 6, 64)
+		}
+		rows = append(rows, []string{
+			id,
+			csvDate(patient.BirthDate()),
+			death,
+			patient.FirstName(),
+			patient.LastName(),
+			patient.Gender(),
+			patient.Race(),
+			patient.Ethnicity(),
+			strings.Join(nonEmpty(birthPlace.City(), birthPlace.State(), birthPlace.Country()), ", "),
+			strings.Join(address.Line(), " "),
+			address.City(),
+			address.State(),
+			address.PostalCode(),
+			multipleBirth,
+			patient.Prefix(),
+			patient.Suffix(),
+			patient.MaidenName(),
+			lat,
+			lon,
+		})
+	case "encounters":
+		for _, e := range record.Encounters() {
+			code, description := csvCode(e.Codes)
+			reason, reasonDescription := csvCode(e.Reason)
+			rows = append(rows, []string{csvTime(e.Start), csvTime(e.Stop), id, e.Class, code, description, reason, reasonDescription})
+		}
+	case "conditions":
+		for _, condition := range record.Conditions() {
+			code, description := csvCode(condition.Codes)
+			rows = append(rows, []string{csvDate(condition.Start), csvDate(condition.Stop), id, code, description})
+		}
+	case "observations":
+		for _, o := range record.Observations() {
+			code, description := csvCode(o.Codes)
+			rows = append(rows, []string{csvTime(o.Time), id, code, description, strconv.FormatFloat(o.Value, 'f', -1, 64), o.Unit})
+		}
+	case "procedures":
+		for _, p := range record.Procedures() {
+			code, description := csvCode(p.Codes)
+			reason, reasonDescription := csvCode(p.Reason)
+			rows = append(rows, []string{csvTime(p.Time), id, code, description, reason, reasonDescription})
+		}
+	case "immunizations":
+		for _, i := range record.Immunizations() {
+			code, description := csvCode(i.Codes)
+			rows = append(rows, []string{csvTime(i.Time), id, code, description})
+		}
+	case "medications":
+		for _, m := range record.Medications() {
+			code, description := csvCode(m.Codes)
+			reason, reasonDescription := csvCode(m.Reason)
+			rows = append(rows, []string{csvDate(m.Start), csvDate(m.Stop), id, code, description, reason, reasonDescription})
+		}
+	case "careplans":
+		for _, c := range record.CarePlans() {
+			code, description := csvCode(c.Codes)
+			reason, reasonDescription := csvCode(c.Reason)
+			rows = append(rows, []string{csvDate(c.Start), csvDate(c.Stop), id, code, description, reason, reasonDescription})
+		}
+	case "relationships":
+		for _, r := range patient.Relations() {
+			rows = append(rows, []string{id, r.ID(), r.Relationship()})
+		}
+	case "identifiers":
+		for _, i := range patient.Identifiers() {
+			rows = append(rows, []string{id, i.Type(), i.System(), i.Value(), i.Assigner()})
+		}
+	}
+	for _, row := range rows {
+		err := c.writer.Write(row)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush writes any buffered rows.
+func (c *CSVWriter) Flush() error {
+	c.writer.Flush()
+	return c.writer.Error()
+}
+
+// csvCode returns the first code and its display, or empty strings if
+// there are no codes.
+func csvCode(codes []records.Code) (code, display string) {
+	if len(codes) == 0 {
+		return "", ""
+	}
+	return codes[0].Code, codes[0].Display
+}
+
+// csvDate formats t as a date, or an empty string if t is zero.
+func csvDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format("2006-01-02")
+}
+
+// csvTime formats t as an RFC 3339 time, or an empty string if t is zero.
+func csvTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+func nonEmpty(values ...string) []string {
+	var result []string
+	for _, v := range values {
+		if v != "" {
+			result = append(result, v)
+		}
+	}
+	return result
+}
@@ -0,0 +1,74 @@
+package exporter
+
+import (
+	"bytes"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type CSVTestSuite struct {
+	suite.Suite
+	outputDir string
+}
+
+func TestCSVTestSuite(t *testing.T) {
+	suite.Run(t, new(CSVTestSuite))
+}
+
+func (suite *CSVTestSuite) SetupTest() {
+	suite.outputDir = suite.T().TempDir()
+}
+
+func (suite *CSVTestSuite) TestExport() {
+	exp := NewCSVExporter()
+	suite.Nil(exp.Init(suite.outputDir))
+	patient, record := testPatientRecord()
+	suite.Nil(exp.Export(patient, record))
+	suite.Nil(exp.Close())
+
+	rows := make(map[string]int)
+	for _, table := range CSVTables() {
+		f, err := os.Open(filepath.Join(suite.outputDir, table+".csv"))
+		suite.Require().Nil(err)
+		lines, err := csv.NewReader(f).ReadAll()
+		f.Close()
+		suite.Nil(err)
+		rows[table] = len(lines) - 1
+	}
+	suite.Equal(map[string]int{
+		"patients":      1,
+		"encounters":    1,
+		"conditions":    2,
+		"observations":  3,
+		"procedures":    0,
+		"immunizations": 0,
+		"medications":   1,
+		"careplans":     0,
+		"relationships": 0,
+		"identifiers":   len(patient.Identifiers()),
+	}, rows)
+}
+
+func (suite *CSVTestSuite) TestCSVWriter() {
+	var buf bytes.Buffer
+	w, err := NewCSVWriter(&buf, "conditions")
+	suite.Nil(err)
+	patient, record := testPatientRecord()
+	suite.Nil(w.Write(patient, record))
+	suite.Nil(w.Flush())
+
+	lines, err := csv.NewReader(&buf).ReadAll()
+	suite.Nil(err)
+	suite.Equal([]string{"start", "stop", "patient", "code", "description"}, lines[0])
+	suite.Equal([]string{"2010-03-04", "", patient.ID(), "44054006", "Diabetes mellitus"}, lines[1])
+	suite.Equal([]string{"2010-03-04", "2010-03-18", patient.ID(), "444814009", "Viral sinusitis <disorder>"}, lines[2])
+}
+
+func (suite *CSVTestSuite) TestUnknownTable() {
+	_, err := NewCSVWriter(&bytes.Buffer{}, "claims")
+	suite.NotNil(err)
+}
@@ -0,0 +1,129 @@
+package exporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/cjduffett/synthea/entity"
+	"github.com/cjduffett/synthea/records"
+)
+
+// Exporter writes generated patients to a single output format.
+type Exporter interface {
+	// Init readies the exporter to write to outputDir. It is called
+	// once, before the first patient is exported.
+	Init(outputDir string) error
+	// Export writes one patient and their record.
+	Export(patient *entity.Patient, record *records.Record) error
+	// Close flushes any buffered output. It is called once, after the
+	// last patient is exported.
+	Close() error
+}
+
+// Options are exporter-specific settings, keyed by "<exporter>.<setting>",
+// for example "omop.concepts".
+type Options map[string]string
+
+// Factory creates a new, uninitialized Exporter.
+type Factory func(options Options) (Exporter, error)
+
+var registry = make(map[string]Factory)
+
+// Register makes an exporter available by name. It panics if an exporter
+// with the same name is already registered.
+func Register(name string, factory Factory) {
+	if _, found := registry[name]; found {
+		panic(fmt.Sprintf("Exporter '%s' is already registered", name))
+	}
+	registry[name] = factory
+}
+
+// New returns a new, uninitialized Exporter by name.
+func New(name string, options Options) (Exporter, error) {
+	factory, found := registry[name]
+	if !found {
+		return nil, fmt.Errorf("Unknown exporter '%s' (available: %s)", name, strings.Join(Names(), ", "))
+	}
+	return factory(options)
+}
+
+// Names returns the names of all registered exporters, sorted.
+func Names() []string {
+	var names []string
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Set is a group of exporters that are all fed the same patients. Each
+// exporter writes to its own subdirectory of the output directory.
+type Set struct {
+	names     []string
+	exporters []Exporter
+}
+
+// NewSet creates and initializes the named exporters. Exporter <name>
+// writes to <outputDir>/<name>.
+func NewSet(names []string, outputDir string, options Options) (*Set, error) {
+	return newSet(names, outputDir, options, nil)
+}
+
+// newSet creates the named exporters and initializes them, or resumes
+// those that are Checkpointers from states if states is not nil.
+func newSet(names []string, outputDir string, options Options, states map[string]json.RawMessage) (*Set, error) {
+	set := &Set{}
+	for _, name := range names {
+		exp, err := New(name, options)
+		if err != nil {
+			set.Close()
+			return nil, err
+		}
+		dir := filepath.Join(outputDir, name)
+		if err = os.MkdirAll(dir, 0755); err == nil {
+			if c, ok := exp.(Checkpointer); ok && states != nil {
+				err = c.Resume(dir, states[name])
+			} else {
+				err = exp.Init(dir)
+			}
+		}
+		if err != nil {
+			set.Close()
+			return nil, fmt.Errorf("Failed to initialize exporter '%s': %s", name, err.Error())
+		}
+		set.names = append(set.names, name)
+		set.exporters = append(set.exporters, exp)
+	}
+	return set, nil
+}
+
+// Names returns the names of the exporters in the set.
+func (s *Set) Names() []string {
+	return s.names
+}
+
+// Export writes the patient with every exporter in the set.
+func (s *Set) Export(patient *entity.Patient, record *records.Record) error {
+	for i, exp := range s.exporters {
+		if err := exp.Export(patient, record); err != nil {
+			return fmt.Errorf("Exporter '%s' failed on patient %s: %s", s.names[i], patient.ID(), err.Error())
+		}
+	}
+	return nil
+}
+
+// Close closes every exporter in the set, returning the first error.
+func (s *Set) Close() error {
+	var err error
+	for i, exp := range s.exporters {
+		if cerr := exp.Close(); cerr != nil && err == nil {
+			err = fmt.Errorf("Exporter '%s' failed to close: %s", s.names[i], cerr.Error())
+		}
+	}
+	return err
+}
@@ -0,0 +1,60 @@
+package exporter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ExporterTestSuite struct {
+	suite.Suite
+	outputDir string
+}
+
+func TestExporterTestSuite(t *testing.T) {
+	suite.Run(t, new(ExporterTestSuite))
+}
+
+func (suite *ExporterTestSuite) SetupTest() {
+	suite.outputDir = suite.T().TempDir()
+}
+
+func (suite *ExporterTestSuite) TestRegisteredExporters() {
+	suite.Equal([]string{"csv", "fhir", "fhirupload", "hl7", "html", "omop"}, Names())
+}
+
+func (suite *ExporterTestSuite) TestRegisterDuplicate() {
+	suite.Panics(func() { Register("html", nil) })
+}
+
+func (suite *ExporterTestSuite) TestNewUnknownExporter() {
+	_, err := New("ccda", nil)
+	suite.NotNil(err)
+}
+
+func (suite *ExporterTestSuite) TestNewExporterBadOptions() {
+	_, err := New("omop", Options{"omop.concepts": "../fixtures/exporter/invalid_omop_concepts.csv"})
+	suite.NotNil(err)
+}
+
+func (suite *ExporterTestSuite) TestSetExportsToSubdirectories() {
+	set, err := NewSet([]string{"html", "hl7"}, suite.outputDir, Options{})
+	suite.Nil(err)
+	suite.Equal([]string{"html", "hl7"}, set.Names())
+
+	patient, record := testPatientRecord()
+	suite.Nil(set.Export(patient, record))
+	suite.Nil(set.Close())
+
+	_, err = os.Stat(filepath.Join(suite.outputDir, "html", patient.ID()+".html"))
+	suite.Nil(err)
+	_, err = os.Stat(filepath.Join(suite.outputDir, "hl7", patient.ID()+".hl7"))
+	suite.Nil(err)
+}
+
+func (suite *ExporterTestSuite) TestSetUnknownExporter() {
+	_, err := NewSet([]string{"html", "ccda"}, suite.outputDir, Options{})
+	suite.NotNil(err)
+}
@@ -0,0 +1,528 @@
+package exporter
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cjduffett/synthea/entity"
+	"github.com/cjduffett/synthea/records"
+)
+
+// FHIR code system URIs for the coding systems used by the modules.
+// Codes from other systems are exported with the system as written.
+var fhirCodingSystems = map[string]string{
+	"SNOMED-CT": "http://snomed.info/sct",
+	"LOINC":     "http://loinc.org",
+	"RxNorm":    "http://www.nlm.nih.gov/research/umls/rxnorm",
+	"CVX":       "http://hl7.org/fhir/sid/cvx",
+}
+
+var fhirEncounterClasses = map[string]string{
+	"ambulatory": "AMB",
+	"outpatient": "AMB",
+	"wellness":   "AMB",
+	"emergency":  "EMER",
+	"inpatient":  "IMP",
+}
+
+// US Core race and ethnicity extensions, coded with the CDC Race and
+// Ethnicity code set.
+const (
+	fhirRaceExtension      = "http://hl7.org/fhir/us/core/StructureDefinition/us-core-race"
+	fhirEthnicityExtension = "http://hl7.org/fhir/us/core/StructureDefinition/us-core-ethnicity"
+	fhirRaceSystem         = "urn:oid:2.16.840.1.113883.6.238"
+)
+
+var fhirRaceCodes = map[string]records.Code{
+	"White":  {Code: "2106-3", Display: "White"},
+	"Black":  {Code: "2054-5", Display: "Black or African American"},
+	"Asian":  {Code: "2028-9", Display: "Asian"},
+	"Native": {Code: "1002-5", Display: "American Indian or Alaska Native"},
+}
+
+// FHIRBundle is a FHIR STU3 transaction Bundle holding one patient and
+// every resource in their record. Each entry PUTs its resource at its
+// own ID and resources reference the patient by type and ID, so the
+// bundle can be posted to a FHIR server as is, posting it again updates
+// the same resources, and later bundles for the patient (see
+// NewFHIRDelta) apply on top of it.
+type FHIRBundle struct {
+	ResourceType string            `json:"resourceType"`
+	ID           string            `json:"id"`
+	Identifier   *FHIRIdentifier   `json:"identifier,omitempty"`
+	Type         string            `json:"type"`
+	Entry        []FHIRBundleEntry `json:"entry"`
+}
+
+// FHIRIdentifier is a FHIR Identifier.
+type FHIRIdentifier struct {
+	System string `json:"system"`
+	Value  string `json:"value"`
+}
+
+// FHIRBundleEntry is a single resource in a FHIRBundle, with the
+// request that creates or updates it.
+type FHIRBundleEntry struct {
+	FullURL  string            `json:"fullUrl"`
+	Resource FHIRResource      `json:"resource"`
+	Request  FHIRBundleRequest `json:"request"`
+}
+
+// FHIRBundleRequest is the request a transaction performs for an entry.
+type FHIRBundleRequest struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+}
+
+// FHIRResource is a FHIR resource as JSON, keyed by element name.
+type FHIRResource map[string]interface{}
+
+// FHIRExporter writes each patient as a FHIR STU3 transaction Bundle
+// to <outputDir>/<patient id>.json.
+type FHIRExporter struct {
+	outputDir string
+}
+
+func init() {
+	Register("fhir", func(options Options) (Exporter, error) {
+		return NewFHIRExporter(), nil
+	})
+}
+
+// NewFHIRExporter returns a new FHIRExporter.
+func NewFHIRExporter() *FHIRExporter {
+	return &FHIRExporter{}
+}
+
+// Init sets the directory the bundles are written to.
+func (f *FHIRExporter) Init(outputDir string) error {
+	f.outputDir = outputDir
+	return os.MkdirAll(outputDir, 0755)
+}
+
+// Export writes the patient's bundle to <outputDir>/<patient id>.json.
+func (f *FHIRExporter) Export(patient *entity.Patient, record *records.Record) error {
+	file, err := os.Create(filepath.Join(f.outputDir, patient.ID()+".json"))
+	if err != nil {
+		return err
+	}
+	err = WriteFHIR(file, patient, record)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Close is a no-op; each bundle is written in full by Export.
+func (f *FHIRExporter) Close() error {
+	return nil
+}
+
+// WriteFHIR writes the patient's bundle to w as indented JSON.
+func WriteFHIR(w io.Writer, patient *entity.Patient, record *records.Record) error {
+	return WriteFHIRBundle(w, NewFHIRBundle(patient, record))
+}
+
+// WriteFHIRBundle writes the bundle to w as indented JSON.
+func WriteFHIRBundle(w io.Writer, bundle *FHIRBundle) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(bundle)
+}
+
+// NewFHIRBundle returns the patient and their record as a transaction
+// Bundle. Every resource gets an ID derived from the patient's ID, so
+// exporting the same patient twice gives identical bundles.
+func NewFHIRBundle(patient *entity.Patient, record *records.Record) *FHIRBundle {
+	return newFHIRBundle(fhirID(patient.ID(), "Bundle", 0), patient, record, time.Time{})
+}
+
+// NewFHIRDelta returns the changes to the patient's record after since as
+// a transaction Bundle, to apply on top of a bundle of the record as it
+// was at since: the resources that started or happened after since, the
+// conditions, medications and care plans that ended after since, and the
+// patient if they died after since. Resources have the same IDs as in
+// NewFHIRBundle, so changed resources replace their earlier versions.
+func NewFHIRDelta(patient *entity.Patient, record *records.Record, since time.Time) *FHIRBundle {
+	return newFHIRBundle(fhirID(patient.ID(), "Bundle/"+fhirDateTime(since), 0), patient, record, since)
+}
+
+// newFHIRBundle returns the resources of the record that changed after
+// since, which is zero for every resource.
+func newFHIRBundle(id string, patient *entity.Patient, record *records.Record, since time.Time) *FHIRBundle {
+	b := &FHIRBundle{
+		ResourceType: "Bundle",
+		ID:           id,
+		Type:         "transaction",
+	}
+	// changed returns true if something that started at start, and
+	// stopped at stop unless stop is zero, changed after since.
+	changed := func(start, stop time.Time) bool {
+		return start.After(since) || stop.After(since)
+	}
+
+	subject := FHIRResource{"reference": "Patient/" + patient.ID()}
+	if since.IsZero() || (record.Expired() && record.DeathTime().After(since)) {
+		b.add(patient.ID(), fhirPatient(patient, record))
+	}
+
+	// Relatives do not change after the patient is generated.
+	if since.IsZero() {
+		for i, relation := range patient.Relations() {
+			b.add(fhirID(patient.ID(), "RelatedPerson", i), fhirRelatedPerson(subject, relation))
+		}
+	}
+
+	for i, encounter := range record.Encounters() {
+		if !encounter.Start.After(since) {
+			continue
+		}
+		b.add(fhirID(patient.ID(), "Encounter", i), FHIRResource{
+			"resourceType": "Encounter",
+			"status":       "finished",
+			"class":        fhirCoding("http://hl7.org/fhir/v3/ActCode", fhirEncounterClasses[encounter.Class], encounter.Class),
+			"type":         []FHIRResource{fhirConcept(encounter.Codes)},
+			"subject":      subject,
+			"period":       fhirPeriod(encounter.Start, encounter.Stop),
+			"reason":       fhirConcepts(encounter.Reason),
+		})
+	}
+
+	for i, condition := range record.Conditions() {
+		if !changed(condition.Start, condition.Stop) {
+			continue
+		}
+		resource := FHIRResource{
+			"resourceType":       "Condition",
+			"clinicalStatus":     "active",
+			"verificationStatus": "confirmed",
+			"code":               fhirConcept(condition.Codes),
+			"subject":            subject,
+			"onsetDateTime":      fhirDateTime(condition.Start),
+		}
+		if !condition.Active() {
+			resource["clinicalStatus"] = "resolved"
+			resource["abatementDateTime"] = fhirDateTime(condition.Stop)
+		}
+		b.add(fhirID(patient.ID(), "Condition", i), resource)
+	}
+
+	for i, observation := range record.Observations() {
+		if !observation.Time.After(since) {
+			continue
+		}
+		b.add(fhirID(patient.ID(), "Observation", i), FHIRResource{
+			"resourceType":      "Observation",
+			"status":            "final",
+			"code":              fhirConcept(observation.Codes),
+			"subject":           subject,
+			"effectiveDateTime": fhirDateTime(observation.Time),
+			"valueQuantity": FHIRResource{
+				"value":  observation.Value,
+				"unit":   observation.Unit,
+				"system": "http://unitsofmeasure.org",
+				"code":   observation.Unit,
+			},
+		})
+	}
+
+	for i, procedure := range record.Procedures() {
+		if !procedure.Time.After(since) {
+			continue
+		}
+		b.add(fhirID(patient.ID(), "Procedure", i), FHIRResource{
+			"resourceType":      "Procedure",
+			"status":            "completed",
+			"code":              fhirConcept(procedure.Codes),
+			"subject":           subject,
+			"performedDateTime": fhirDateTime(procedure.Time),
+			"reasonCode":        fhirConcepts(procedure.Reason),
+		})
+	}
+
+	for i, immunization := range record.Immunizations() {
+		if !immunization.Time.After(since) {
+			continue
+		}
+		b.add(fhirID(patient.ID(), "Immunization", i), FHIRResource{
+			"resourceType":  "Immunization",
+			"status":        "completed",
+			"notGiven":      false,
+			"primarySource": true,
+			"vaccineCode":   fhirConcept(immunization.Codes),
+			"patient":       subject,
+			"date":          fhirDateTime(immunization.Time),
+		})
+	}
+
+	for i, medication := range record.Medications() {
+		if !changed(medication.Start, medication.Stop) {
+			continue
+		}
+		resource := FHIRResource{
+			"resourceType":              "MedicationRequest",
+			"status":                    "active",
+			"intent":                    "order",
+			"medicationCodeableConcept": fhirConcept(medication.Codes),
+			"subject":                   subject,
+			"authoredOn":                fhirDateTime(medication.Start),
+			"reasonCode":                fhirConcepts(medication.Reason),
+		}
+		if !medication.Active() {
+			resource["status"] = "stopped"
+		}
+		b.add(fhirID(patient.ID(), "MedicationRequest", i), resource)
+	}
+
+	for i, careplan := range record.CarePlans() {
+		if !changed(careplan.Start, careplan.Stop) {
+			continue
+		}
+		var activities []FHIRResource
+		for _, activity := range careplan.Activities {
+			activities = append(activities, FHIRResource{
+				"detail": FHIRResource{
+					"code":   fhirConcept([]records.Code{activity}),
+					"status": "in-progress",
+				},
+			})
+		}
+		resource := FHIRResource{
+			"resourceType": "CarePlan",
+			"status":       "active",
+			"intent":       "plan",
+			"category":     []FHIRResource{fhirConcept(careplan.Codes)},
+			"subject":      subject,
+			"period":       fhirPeriod(careplan.Start, careplan.Stop),
+			"activity":     activities,
+		}
+		if !careplan.Active() {
+			resource["status"] = "completed"
+		}
+		b.add(fhirID(patient.ID(), "CarePlan", i), resource)
+	}
+	return b
+}
+
+// add adds resource to the bundle with the given ID.
+func (b *FHIRBundle) add(id string, resource FHIRResource) {
+	resource["id"] = id
+	for key, value := range resource {
+		// Leave out optional elements that have no value.
+		if v, ok := value.([]FHIRResource); ok && len(v) == 0 {
+			delete(resource, key)
+		}
+	}
+	resourceType := resource["resourceType"].(string)
+	b.Entry = append(b.Entry, FHIRBundleEntry{
+		FullURL:  "urn:uuid:" + id,
+		Resource: resource,
+		Request:  FHIRBundleRequest{Method: "PUT", URL: resourceType + "/" + id},
+	})
+}
+
+func fhirPatient(patient *entity.Patient, record *records.Record) FHIRResource {
+	name := FHIRResource{
+		"use":    "official",
+		"family": patient.LastName(),
+		"given":  []string{patient.FirstName()},
+	}
+	if patient.Prefix() != "" {
+		name["prefix"] = []string{patient.Prefix()}
+	}
+	if patient.Suffix() != "" {
+		name["suffix"] = []string{patient.Suffix()}
+	}
+	names := []FHIRResource{name}
+	if patient.MaidenName() != "" {
+		names = append(names, FHIRResource{
+			"use":    "maiden",
+			"family": patient.MaidenName(),
+			"given":  []string{patient.FirstName()},
+		})
+	}
+	resource := FHIRResource{
+		"resourceType": "Patient",
+		"name":         names,
+		"gender":       strings.ToLower(patient.Gender()),
+		"birthDate":    patient.BirthDate().Format("2006-01-02"),
+		"address":      []FHIRResource{fhirAddress(patient.Address())},
+		"extension": []FHIRResource{
+			fhirRace(patient.Race()),
+			fhirEthnicity(patient.Race(), patient.Ethnicity()),
+		},
+	}
+	if record.Expired() {
+		resource["deceasedDateTime"] = fhirDateTime(record.DeathTime())
+	}
+	if patient.MultipleBirth() > 0 {
+		resource["multipleBirthInteger"] = patient.MultipleBirth()
+	}
+	if ids := patient.Identifiers(); len(ids) > 0 {
+		identifiers := make([]FHIRResource, len(ids))
+		for i, id := range ids {
+			identifiers[i] = fhirIdentifier(id)
+		}
+		resource["identifier"] = identifiers
+	}
+	return resource
+}
+
+// fhirAddress returns an Address, with its location as a geolocation
+// extension if it is known.
+func fhirAddress(address entity.Address) FHIRResource {
+	resource := FHIRResource{
+		"line":       address.Line(),
+		"city":       address.City(),
+		"state":      address.State(),
+		"postalCode": address.PostalCode(),
+	}
+	if address.HasLocation() {
+		resource["extension"] = []FHIRResource{{
+			"url": "http://hl7.org/fhir/StructureDefinition/geolocation",
+			"extension": []FHIRResource{
+				{"url": "latitude", "valueDecimal": address.Latitude()},
+				{"url": "longitude", "valueDecimal": address.Longitude()},
+			},
+		}}
+	}
+	return resource
+}
+
+// fhirIdentifierTypes maps identifier types to the display names of their
+// HL7 v2 table 0203 codes.
+var fhirIdentifierTypes = map[string]string{
+	entity.IdentifierMRN:            "Medical record number",
+	entity.IdentifierSSN:            "Social Security number",
+	entity.IdentifierDriversLicense: "Driver's license number",
+	entity.IdentifierPassport:       "Passport number",
+}
+
+func fhirIdentifier(id entity.Identifier) FHIRResource {
+	identifier := FHIRResource{
+		"type": FHIRResource{
+			"coding": []FHIRResource{fhirCoding("http://hl7.org/fhir/v2/0203", id.Type(), fhirIdentifierTypes[id.Type()])},
+		},
+		"system": id.System(),
+		"value":  id.Value(),
+	}
+	if id.Assigner() != "" {
+		identifier["assigner"] = FHIRResource{"display": id.Assigner()}
+	}
+	return identifier
+}
+
+// fhirRelationships maps relationships to HL7 v3 RoleCodes.
+var fhirRelationships = map[string]records.Code{
+	entity.RelationSpouse:  {Code: "SPS", Display: "spouse"},
+	entity.RelationMother:  {Code: "MTH", Display: "mother"},
+	entity.RelationFather:  {Code: "FTH", Display: "father"},
+	entity.RelationChild:   {Code: "CHILD", Display: "child"},
+	entity.RelationSibling: {Code: "SIB", Display: "sibling"},
+}
+
+// fhirRelatedPerson returns a relative of the patient referenced by
+// subject. The relative's own Patient resource is identified by its ID
+// as a URN.
+func fhirRelatedPerson(subject FHIRResource, relation entity.Relation) FHIRResource {
+	code := fhirRelationships[relation.Relationship()]
+	return FHIRResource{
+		"resourceType": "RelatedPerson",
+		"identifier": []FHIRResource{{
+			"system": "urn:ietf:rfc:3986",
+			"value":  "urn:uuid:" + relation.ID(),
+		}},
+		"patient": subject,
+		"relationship": FHIRResource{
+			"coding": []FHIRResource{fhirCoding("http://hl7.org/fhir/v3/RoleCode", code.Code, code.Display)},
+		},
+		"name": []FHIRResource{{
+			"family": relation.LastName(),
+			"given":  []string{relation.FirstName()},
+		}},
+		"gender":    strings.ToLower(relation.Gender()),
+		"birthDate": relation.BirthDate().Format("2006-01-02"),
+	}
+}
+
+func fhirRace(race string) FHIRResource {
+	extensions := []FHIRResource{{"url": "text", "valueString": race}}
+	if code, ok := fhirRaceCodes[race]; ok {
+		extensions = append([]FHIRResource{{
+			"url":         "ombCategory",
+			"valueCoding": fhirCoding(fhirRaceSystem, code.Code, code.Display),
+		}}, extensions...)
+	}
+	return FHIRResource{"url": fhirRaceExtension, "extension": extensions}
+}
+
+// fhirEthnicity returns the ethnicity extension. The patient's ethnicity
+// is their ancestry, for example "Irish"; Hispanic is one of their races.
+func fhirEthnicity(race, ethnicity string) FHIRResource {
+	code := fhirCoding(fhirRaceSystem, "2186-5", "Not Hispanic or Latino")
+	if race == "Hispanic" {
+		code = fhirCoding(fhirRaceSystem, "2135-2", "Hispanic or Latino")
+	}
+	return FHIRResource{"url": fhirEthnicityExtension, "extension": []FHIRResource{
+		{"url": "ombCategory", "valueCoding": code},
+		{"url": "text", "valueString": ethnicity},
+	}}
+}
+
+// fhirConcept returns codes as a CodeableConcept.
+func fhirConcept(codes []records.Code) FHIRResource {
+	var codings []FHIRResource
+	for _, code := range codes {
+		system, ok := fhirCodingSystems[code.System]
+		if !ok {
+			system = code.System
+		}
+		codings = append(codings, fhirCoding(system, code.Code, code.Display))
+	}
+	concept := FHIRResource{"coding": codings}
+	if len(codes) > 0 {
+		concept["text"] = codes[0].Display
+	}
+	return concept
+}
+
+// fhirConcepts returns codes as a list of one CodeableConcept, or no
+// CodeableConcepts if there are no codes.
+func fhirConcepts(codes []records.Code) []FHIRResource {
+	if len(codes) == 0 {
+		return nil
+	}
+	return []FHIRResource{fhirConcept(codes)}
+}
+
+func fhirCoding(system, code, display string) FHIRResource {
+	return FHIRResource{"system": system, "code": code, "display": display}
+}
+
+// fhirPeriod returns a Period, leaving out the end if stop is zero.
+func fhirPeriod(start, stop time.Time) FHIRResource {
+	period := FHIRResource{"start": fhirDateTime(start)}
+	if !stop.IsZero() {
+		period["end"] = fhirDateTime(stop)
+	}
+	return period
+}
+
+func fhirDateTime(t time.Time) string {
+	return t.Format(time.RFC3339)
+}
+
+// fhirID returns the ID of the i-th resource of a type in a patient's
+// record, as a version 5 style UUID derived from the patient's ID.
+func fhirID(patientID, resourceType string, i int) string {
+	b := sha1.Sum([]byte(fmt.Sprintf("%s/%s/%d", patientID, resourceType, i)))
+	b[6] = (b[6] & 0x0f) | 0x50
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
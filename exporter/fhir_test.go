@@ -0,0 +1,205 @@
+package exporter
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cjduffett/synthea/entity"
+	"github.com/cjduffett/synthea/records"
+	"github.com/cjduffett/synthea/utils"
+	"github.com/stretchr/testify/suite"
+)
+
+type FHIRTestSuite struct {
+	suite.Suite
+	patient *entity.Patient
+	record  *records.Record
+}
+
+func TestFHIRTestSuite(t *testing.T) {
+	suite.Run(t, new(FHIRTestSuite))
+}
+
+func (suite *FHIRTestSuite) SetupTest() {
+	suite.patient, suite.record = testPatientRecord()
+}
+
+func (suite *FHIRTestSuite) TestNewFHIRBundle() {
+	bundle := NewFHIRBundle(suite.patient, suite.record)
+	suite.Equal("transaction", bundle.Type)
+
+	counts := make(map[string]int)
+	for _, entry := range bundle.Entry {
+		resourceType := entry.Resource["resourceType"].(string)
+		counts[resourceType]++
+		suite.Equal("urn:uuid:"+entry.Resource["id"].(string), entry.FullURL)
+		suite.Equal(FHIRBundleRequest{Method: "PUT", URL: resourceType + "/" + entry.Resource["id"].(string)}, entry.Request)
+		if resourceType != "Patient" && resourceType != "Immunization" {
+			suite.Equal(FHIRResource{"reference": "Patient/" + suite.patient.ID()}, entry.Resource["subject"])
+		}
+	}
+	suite.Equal(map[string]int{
+		"Patient":           1,
+		"Encounter":         1,
+		"Condition":         2,
+		"MedicationRequest": 1,
+		"Observation":       3,
+	}, counts)
+
+	patient := bundle.Entry[0].Resource
+	suite.Equal(suite.patient.ID(), patient["id"])
+	suite.Equal(suite.patient.BirthDate().Format("2006-01-02"), patient["birthDate"])
+	suite.NotContains(patient, "deceasedDateTime")
+
+	condition := bundle.Entry[3].Resource
+	suite.Equal("resolved", condition["clinicalStatus"])
+	suite.Equal("2010-03-18T09:00:00Z", condition["abatementDateTime"])
+	suite.Equal("http://snomed.info/sct", condition["code"].(FHIRResource)["coding"].([]FHIRResource)[0]["system"])
+}
+
+func (suite *FHIRTestSuite) TestNewFHIRBundleIsDeterministic() {
+	var first, second bytes.Buffer
+	suite.Nil(WriteFHIR(&first, suite.patient, suite.record))
+	suite.Nil(WriteFHIR(&second, suite.patient, suite.record))
+	suite.Equal(first.String(), second.String())
+
+	bundle := NewFHIRBundle(suite.patient, suite.record)
+	ids := make(map[string]bool)
+	for _, entry := range bundle.Entry {
+		ids[entry.FullURL] = true
+	}
+	suite.Len(ids, len(bundle.Entry), "Every resource has its own ID")
+}
+
+func (suite *FHIRTestSuite) TestNewFHIRDelta() {
+	// The record has an encounter and conditions on 2010-03-04, the
+	// sinusitis resolving two weeks later, and observations a year apart.
+	full := NewFHIRBundle(suite.patient, suite.record)
+	ids := make(map[string]FHIRBundleEntry)
+	for _, entry := range full.Entry {
+		ids[entry.FullURL] = entry
+	}
+
+	since := time.Date(2010, time.March, 10, 0, 0, 0, 0, time.UTC)
+	delta := NewFHIRDelta(suite.patient, suite.record, since)
+	suite.Equal("transaction", delta.Type)
+	suite.NotEqual(full.ID, delta.ID)
+	var types []string
+	for _, entry := range delta.Entry {
+		types = append(types, entry.Resource["resourceType"].(string))
+		suite.Equal(ids[entry.FullURL], entry, "Delta resources replace the same resources")
+	}
+	suite.Equal([]string{"Condition", "Observation", "Observation"}, types)
+	suite.Equal("resolved", delta.Entry[0].Resource["clinicalStatus"])
+
+	suite.Empty(NewFHIRDelta(suite.patient, suite.record, since.AddDate(5, 0, 0)).Entry)
+
+	suite.record.Death(since.AddDate(6, 0, 0), nil)
+	delta = NewFHIRDelta(suite.patient, suite.record, since.AddDate(5, 0, 0))
+	suite.Require().Len(delta.Entry, 1)
+	suite.Equal(suite.patient.ID(), delta.Entry[0].Resource["id"])
+	suite.Equal("2016-03-10T00:00:00Z", delta.Entry[0].Resource["deceasedDateTime"])
+}
+
+func (suite *FHIRTestSuite) TestRelatedPersons() {
+	members := testHousehold()
+	householder := members[0]
+	bundle := NewFHIRBundle(householder, new(records.Record))
+
+	var related []FHIRResource
+	for _, entry := range bundle.Entry {
+		if entry.Resource["resourceType"] == "RelatedPerson" {
+			related = append(related, entry.Resource)
+		}
+	}
+	suite.Require().Len(related, len(members)-1)
+	for i, resource := range related {
+		relative := members[i+1]
+		suite.Equal(FHIRResource{"reference": "Patient/" + householder.ID()}, resource["patient"])
+		suite.Equal("urn:uuid:"+relative.ID(), resource["identifier"].([]FHIRResource)[0]["value"])
+		suite.Equal(relative.BirthDate().Format("2006-01-02"), resource["birthDate"])
+	}
+	suite.Equal("SPS", related[0]["relationship"].(FHIRResource)["coding"].([]FHIRResource)[0]["code"])
+
+	delta := NewFHIRDelta(householder, new(records.Record), time.Now())
+	suite.Empty(delta.Entry, "Relatives are only in the full bundle")
+}
+
+func (suite *FHIRTestSuite) TestNames() {
+	patient := testMarried()
+	names := NewFHIRBundle(patient, new(records.Record)).Entry[0].Resource["name"].([]FHIRResource)
+	suite.Require().Len(names, 2)
+	suite.Equal(patient.LastName(), names[0]["family"])
+	suite.Equal([]string{patient.Prefix()}, names[0]["prefix"])
+	suite.Equal("maiden", names[1]["use"])
+	suite.Equal(patient.MaidenName(), names[1]["family"])
+}
+
+func (suite *FHIRTestSuite) TestAddress() {
+	address := suite.patient.Address()
+	suite.Require().True(address.HasLocation())
+	resource := NewFHIRBundle(suite.patient, suite.record).Entry[0].Resource["address"].([]FHIRResource)[0]
+	suite.Equal(address.PostalCode(), resource["postalCode"])
+	geolocation := resource["extension"].([]FHIRResource)[0]
+	suite.Equal("http://hl7.org/fhir/StructureDefinition/geolocation", geolocation["url"])
+	suite.Equal([]FHIRResource{
+		{"url": "latitude", "valueDecimal": address.Latitude()},
+		{"url": "longitude", "valueDecimal": address.Longitude()},
+	}, geolocation["extension"])
+}
+
+func (suite *FHIRTestSuite) TestIdentifiers() {
+	bundle := NewFHIRBundle(suite.patient, suite.record)
+	identifiers := bundle.Entry[0].Resource["identifier"].([]FHIRResource)
+	suite.Require().Len(identifiers, len(suite.patient.Identifiers()))
+	for i, id := range suite.patient.Identifiers() {
+		suite.Equal(id.System(), identifiers[i]["system"])
+		suite.Equal(id.Value(), identifiers[i]["value"])
+		suite.Equal(id.Type(), identifiers[i]["type"].(FHIRResource)["coding"].([]FHIRResource)[0]["code"])
+	}
+	suite.Equal(entity.IdentifierMRN, suite.patient.Identifiers()[0].Type())
+	suite.Equal(FHIRResource{"display": suite.patient.Identifiers()[0].Assigner()}, identifiers[0]["assigner"])
+}
+
+func (suite *FHIRTestSuite) TestExport() {
+	dir := suite.T().TempDir()
+	fhir := NewFHIRExporter()
+	suite.Nil(fhir.Init(dir))
+	suite.Nil(fhir.Export(suite.patient, suite.record))
+	suite.Nil(fhir.Close())
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, suite.patient.ID()+".json"))
+	suite.Nil(err)
+	var bundle map[string]interface{}
+	suite.Nil(json.Unmarshal(data, &bundle))
+	suite.Equal("Bundle", bundle["resourceType"])
+	suite.Len(bundle["entry"], 8)
+}
+
+// testHousehold returns the members of a household with a spouse and at
+// least one child.
+// testMarried returns a patient who took their spouse's family name.
+func testMarried() *entity.Patient {
+	endTime := time.Date(2016, time.December, 1, 0, 0, 0, 0, time.UTC)
+	for seed := int64(1); ; seed++ {
+		for _, member := range entity.Demographics.NewHousehold(utils.NewRand(seed), endTime.AddDate(-100, 0, 0), endTime, nil) {
+			if member.MaidenName() != "" {
+				return member
+			}
+		}
+	}
+}
+
+func testHousehold() []*entity.Patient {
+	endTime := time.Date(2016, time.December, 1, 0, 0, 0, 0, time.UTC)
+	for seed := int64(1); ; seed++ {
+		members := entity.Demographics.NewHousehold(utils.NewRand(seed), endTime.AddDate(-100, 0, 0), endTime, nil)
+		if len(members) > 2 && members[1].Relations()[0].Relationship() == entity.RelationSpouse {
+			return members
+		}
+	}
+}
@@ -0,0 +1,354 @@
+package exporter
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cjduffett/synthea/entity"
+	"github.com/cjduffett/synthea/records"
+)
+
+// Default settings of the FHIR upload exporter.
+const (
+	DefaultUploadConcurrency = 4
+	DefaultUploadBatchSize   = 1
+	DefaultUploadRetries     = 5
+	DefaultUploadBackoff     = time.Second
+	DefaultUploadTimeout     = time.Minute
+)
+
+// FHIRUploadExporter posts patients as FHIR transaction bundles to the
+// base URL of a FHIR server, for example a HAPI FHIR server. Up to
+// fhirupload.concurrency bundles are posted at once, each holding up to
+// fhirupload.batch_size patients. Requests that fail with a network
+// error, a 429 or a 5xx response are retried up to fhirupload.retries
+// times, waiting fhirupload.backoff before the first retry and twice as
+// long before each one after, or as long as the server asks with a
+// Retry-After header. Each request times out after fhirupload.timeout.
+//
+// Bundles that still fail are written to the failed subdirectory of the
+// output directory, with the reason appended to failed/errors.log. The
+// IDs of uploaded patients are appended to uploaded.log; patients listed
+// there are skipped, so a rerun with the same seed uploads only the
+// patients that did not succeed before.
+type FHIRUploadExporter struct {
+	url         string
+	concurrency int
+	batchSize   int
+	retries     int
+	backoff     time.Duration
+	client      *http.Client
+
+	failedDir string
+	uploaded  map[string]bool
+	resumeLog *os.File
+
+	batch   []*FHIRBundle
+	uploads chan fhirUpload
+	workers sync.WaitGroup
+
+	mutex    sync.Mutex // guards resumeLog, errorLog and the counts below
+	errorLog *os.File
+	posted   int
+	failed   int
+	skipped  int
+}
+
+// fhirUpload is a bundle to post and the patients in it.
+type fhirUpload struct {
+	bundle   *FHIRBundle
+	patients []string
+}
+
+func init() {
+	Register("fhirupload", func(options Options) (Exporter, error) {
+		u := NewFHIRUploadExporter(options["fhirupload.url"])
+		if u.url == "" {
+			return nil, errors.New("Invalid fhirupload.url: a FHIR server base URL is required")
+		}
+		for key, value := range options {
+			var err error
+			switch key {
+			case "fhirupload.concurrency":
+				u.concurrency, err = positiveInt(value)
+			case "fhirupload.batch_size":
+				u.batchSize, err = positiveInt(value)
+			case "fhirupload.retries":
+				u.retries, err = strconv.Atoi(value)
+				if err == nil && u.retries < 0 {
+					err = errors.New("must not be negative")
+				}
+			case "fhirupload.backoff":
+				u.backoff, err = time.ParseDuration(value)
+			case "fhirupload.timeout":
+				u.client.Timeout, err = time.ParseDuration(value)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("Invalid %s: %s", key, err.Error())
+			}
+		}
+		return u, nil
+	})
+}
+
+func positiveInt(value string) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err == nil && n <= 0 {
+		err = errors.New("must be greater than 0")
+	}
+	return n, err
+}
+
+// NewFHIRUploadExporter returns a new FHIRUploadExporter that posts to
+// the FHIR server at url, with the default settings.
+func NewFHIRUploadExporter(url string) *FHIRUploadExporter {
+	return &FHIRUploadExporter{
+		url:         strings.TrimRight(url, "/"),
+		concurrency: DefaultUploadConcurrency,
+		batchSize:   DefaultUploadBatchSize,
+		retries:     DefaultUploadRetries,
+		backoff:     DefaultUploadBackoff,
+		client:      &http.Client{Timeout: DefaultUploadTimeout},
+	}
+}
+
+// Init reads the patients already uploaded from <outputDir>/uploaded.log
+// and starts posting bundles.
+func (u *FHIRUploadExporter) Init(outputDir string) error {
+	u.failedDir = filepath.Join(outputDir, "failed")
+	err := os.MkdirAll(u.failedDir, 0755)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(outputDir, "uploaded.log")
+	u.uploaded, err = readResumeLog(path)
+	if err != nil {
+		return err
+	}
+	u.resumeLog, err = os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	u.errorLog, err = os.OpenFile(filepath.Join(u.failedDir, "errors.log"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		u.resumeLog.Close()
+		return err
+	}
+
+	u.start()
+	return nil
+}
+
+// start starts fhirupload.concurrency workers posting bundles.
+func (u *FHIRUploadExporter) start() {
+	u.uploads = make(chan fhirUpload)
+	for i := 0; i < u.concurrency; i++ {
+		u.workers.Add(1)
+		go func() {
+			defer u.workers.Done()
+			for upload := range u.uploads {
+				u.upload(upload)
+			}
+		}()
+	}
+}
+
+// wait posts the last batch and waits for every bundle to be posted.
+func (u *FHIRUploadExporter) wait() {
+	u.send()
+	close(u.uploads)
+	u.workers.Wait()
+	u.uploads = nil
+}
+
+// Checkpoint posts the current batch and waits for every bundle to be
+// posted, so that every patient exported so far is either in the resume
+// log or in the failed directory. The exporter has no other state.
+func (u *FHIRUploadExporter) Checkpoint() (json.RawMessage, error) {
+	u.wait()
+	u.start()
+	return json.RawMessage("null"), nil
+}
+
+// Resume is the same as Init: patients uploaded after the checkpoint are
+// in the resume log, and are skipped.
+func (u *FHIRUploadExporter) Resume(outputDir string, state json.RawMessage) error {
+	return u.Init(outputDir)
+}
+
+// readResumeLog returns the patient IDs listed in the resume log at
+// path, one per line. A missing log lists no patients.
+func readResumeLog(path string) (map[string]bool, error) {
+	uploaded := make(map[string]bool)
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return uploaded, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if id := strings.TrimSpace(scanner.Text()); id != "" {
+			uploaded[id] = true
+		}
+	}
+	return uploaded, scanner.Err()
+}
+
+// Export adds the patient to the current batch, and posts the batch once
+// it is full. Export blocks while fhirupload.concurrency bundles are
+// being posted. Patients listed in the resume log are skipped.
+func (u *FHIRUploadExporter) Export(patient *entity.Patient, record *records.Record) error {
+	if u.uploaded[patient.ID()] {
+		u.mutex.Lock()
+		u.skipped++
+		u.mutex.Unlock()
+		return nil
+	}
+	u.batch = append(u.batch, NewFHIRBundle(patient, record))
+	if len(u.batch) >= u.batchSize {
+		u.send()
+	}
+	return nil
+}
+
+// send merges the current batch into one transaction bundle and queues
+// it for posting.
+func (u *FHIRUploadExporter) send() {
+	if len(u.batch) == 0 {
+		return
+	}
+	bundle := &FHIRBundle{
+		ResourceType: "Bundle",
+		ID:           u.batch[0].ID,
+		Type:         "transaction",
+	}
+	var patients []string
+	for _, b := range u.batch {
+		bundle.Entry = append(bundle.Entry, b.Entry...)
+		patients = append(patients, b.Entry[0].Resource["id"].(string))
+	}
+	u.batch = nil
+	u.uploads <- fhirUpload{bundle: bundle, patients: patients}
+}
+
+// Close posts the last batch and waits for every bundle to be posted. It
+// returns an error if any bundle failed.
+func (u *FHIRUploadExporter) Close() error {
+	if u.uploads != nil {
+		u.wait()
+	}
+
+	var err error
+	if u.resumeLog != nil {
+		err = u.resumeLog.Close()
+		u.resumeLog = nil
+	}
+	if u.errorLog != nil {
+		if cerr := u.errorLog.Close(); err == nil {
+			err = cerr
+		}
+		u.errorLog = nil
+	}
+	if err == nil && u.failed > 0 {
+		err = fmt.Errorf("%d of %d bundles failed to upload to %s, see %s", u.failed, u.posted+u.failed, u.url, u.failedDir)
+	}
+	return err
+}
+
+// Counts returns the number of bundles posted and failed, and the number
+// of patients skipped because they were uploaded before.
+func (u *FHIRUploadExporter) Counts() (posted, failed, skipped int) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	return u.posted, u.failed, u.skipped
+}
+
+// upload posts a bundle and records the outcome.
+func (u *FHIRUploadExporter) upload(upload fhirUpload) {
+	data, err := json.Marshal(upload.bundle)
+	if err == nil {
+		err = u.post(data)
+	}
+
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	if err == nil {
+		u.posted++
+		for _, id := range upload.patients {
+			// The resume log is best effort: a patient missing from it
+			// is uploaded again by the next run.
+			fmt.Fprintln(u.resumeLog, id)
+		}
+		return
+	}
+
+	u.failed++
+	path := filepath.Join(u.failedDir, upload.bundle.ID+".json")
+	ioutil.WriteFile(path, data, 0644)
+	fmt.Fprintf(u.errorLog, "%s\t%s\t%s\n", upload.bundle.ID, strings.Join(upload.patients, ","), err.Error())
+}
+
+// post posts a bundle, retrying failures that may be temporary.
+func (u *FHIRUploadExporter) post(data []byte) error {
+	for attempt := 0; ; attempt++ {
+		wait, err := u.postOnce(data)
+		if err == nil {
+			return nil
+		}
+		if wait < 0 || attempt >= u.retries {
+			return err
+		}
+		if wait == 0 {
+			wait = u.backoff << uint(attempt)
+		}
+		time.Sleep(wait)
+	}
+}
+
+// postOnce posts a bundle once. If it fails, postOnce returns how long
+// the server asked to wait before retrying, 0 if it did not say, or a
+// negative duration if the request should not be retried.
+func (u *FHIRUploadExporter) postOnce(data []byte) (time.Duration, error) {
+	req, err := http.NewRequest(http.MethodPost, u.url, bytes.NewReader(data))
+	if err != nil {
+		return -1, err
+	}
+	req.Header.Set("Content-Type", "application/fhir+json")
+	req.Header.Set("Accept", "application/fhir+json")
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return 0, nil
+	}
+
+	err = fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+		return -1, err
+	}
+	if seconds, perr := strconv.Atoi(resp.Header.Get("Retry-After")); perr == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, err
+	}
+	return 0, err
+}
@@ -0,0 +1,203 @@
+package exporter
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cjduffett/synthea/entity"
+	"github.com/cjduffett/synthea/records"
+	"github.com/cjduffett/synthea/utils"
+	"github.com/stretchr/testify/suite"
+)
+
+type FHIRUploadTestSuite struct {
+	suite.Suite
+	outputDir string
+	server    *httptest.Server
+
+	mutex sync.Mutex
+	// respond returns the status of the response to the n-th request,
+	// counting from 0, which posted bundle.
+	respond func(n int, bundle FHIRBundle) int
+	bundles []FHIRBundle
+}
+
+func TestFHIRUploadTestSuite(t *testing.T) {
+	suite.Run(t, new(FHIRUploadTestSuite))
+}
+
+func (suite *FHIRUploadTestSuite) SetupTest() {
+	suite.outputDir = suite.T().TempDir()
+	suite.bundles = nil
+	suite.respond = func(int, FHIRBundle) int { return http.StatusOK }
+	suite.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var bundle FHIRBundle
+		err := json.NewDecoder(r.Body).Decode(&bundle)
+		if err != nil || r.Header.Get("Content-Type") != "application/fhir+json" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		suite.mutex.Lock()
+		n := len(suite.bundles)
+		suite.bundles = append(suite.bundles, bundle)
+		status := suite.respond(n, bundle)
+		suite.mutex.Unlock()
+		if status == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", "0")
+		}
+		w.WriteHeader(status)
+	}))
+}
+
+func (suite *FHIRUploadTestSuite) TearDownTest() {
+	suite.server.Close()
+}
+
+// upload uploads the patients with the given options, and returns the
+// error Close returned.
+func (suite *FHIRUploadTestSuite) upload(patients []*entity.Patient, options Options) error {
+	options["fhirupload.url"] = suite.server.URL
+	options["fhirupload.backoff"] = "1ms"
+	exp, err := New("fhirupload", options)
+	suite.Require().Nil(err)
+	suite.Require().Nil(exp.Init(suite.outputDir))
+	for _, patient := range patients {
+		suite.Require().Nil(exp.Export(patient, new(records.Record)))
+	}
+	return exp.Close()
+}
+
+// posted returns the bundles posted to the server so far.
+func (suite *FHIRUploadTestSuite) posted() []FHIRBundle {
+	suite.mutex.Lock()
+	defer suite.mutex.Unlock()
+	return append([]FHIRBundle(nil), suite.bundles...)
+}
+
+// uploadedLog returns the patient IDs in the resume log.
+func (suite *FHIRUploadTestSuite) uploadedLog() []string {
+	data, err := ioutil.ReadFile(filepath.Join(suite.outputDir, "uploaded.log"))
+	suite.Require().Nil(err)
+	return strings.Fields(string(data))
+}
+
+func testPatients(n int) []*entity.Patient {
+	endTime := time.Date(2016, time.December, 1, 0, 0, 0, 0, time.UTC)
+	var patients []*entity.Patient
+	for i := 0; i < n; i++ {
+		patients = append(patients, entity.NewPatient(utils.NewRand(int64(i+1)), endTime.AddDate(-100, 0, 0), endTime, nil))
+	}
+	return patients
+}
+
+func (suite *FHIRUploadTestSuite) TestUpload() {
+	patients := testPatients(3)
+	suite.Nil(suite.upload(patients, Options{"fhirupload.concurrency": "2"}))
+
+	suite.Len(suite.posted(), 3)
+	for _, bundle := range suite.posted() {
+		suite.Equal("transaction", bundle.Type)
+		suite.Len(bundle.Entry, 1)
+	}
+	suite.ElementsMatch([]string{patients[0].ID(), patients[1].ID(), patients[2].ID()}, suite.uploadedLog())
+}
+
+func (suite *FHIRUploadTestSuite) TestBatches() {
+	suite.Nil(suite.upload(testPatients(5), Options{"fhirupload.batch_size": "2"}))
+
+	var sizes []int
+	for _, bundle := range suite.posted() {
+		sizes = append(sizes, len(bundle.Entry))
+	}
+	suite.ElementsMatch([]int{2, 2, 1}, sizes)
+	suite.Len(suite.uploadedLog(), 5)
+}
+
+func (suite *FHIRUploadTestSuite) TestRetries() {
+	suite.respond = func(n int, bundle FHIRBundle) int {
+		switch n {
+		case 0:
+			return http.StatusServiceUnavailable
+		case 1:
+			return http.StatusTooManyRequests
+		}
+		return http.StatusOK
+	}
+	suite.Nil(suite.upload(testPatients(1), Options{}))
+	suite.Len(suite.posted(), 3)
+	suite.Len(suite.uploadedLog(), 1)
+}
+
+func (suite *FHIRUploadTestSuite) TestDeadLetter() {
+	patients := testPatients(2)
+	suite.respond = func(n int, bundle FHIRBundle) int {
+		if bundle.Entry[0].Resource["id"] == patients[0].ID() {
+			return http.StatusInternalServerError
+		}
+		return http.StatusOK
+	}
+	err := suite.upload(patients, Options{"fhirupload.retries": "2"})
+	suite.NotNil(err)
+	suite.Contains(err.Error(), "1 of 2 bundles failed")
+	suite.Len(suite.posted(), 4, "The failing bundle is posted 3 times")
+	suite.Equal([]string{patients[1].ID()}, suite.uploadedLog())
+
+	bundleID := fhirID(patients[0].ID(), "Bundle", 0)
+	data, err := ioutil.ReadFile(filepath.Join(suite.outputDir, "failed", bundleID+".json"))
+	suite.Nil(err)
+	var bundle FHIRBundle
+	suite.Nil(json.Unmarshal(data, &bundle))
+	suite.Equal(patients[0].ID(), bundle.Entry[0].Resource["id"])
+
+	errors, err := ioutil.ReadFile(filepath.Join(suite.outputDir, "failed", "errors.log"))
+	suite.Nil(err)
+	suite.Contains(string(errors), bundleID+"\t"+patients[0].ID()+"\t500 Internal Server Error")
+}
+
+func (suite *FHIRUploadTestSuite) TestClientErrorsAreNotRetried() {
+	suite.respond = func(int, FHIRBundle) int { return http.StatusUnprocessableEntity }
+	suite.NotNil(suite.upload(testPatients(1), Options{}))
+	suite.Len(suite.posted(), 1)
+}
+
+func (suite *FHIRUploadTestSuite) TestResume() {
+	patients := testPatients(3)
+	suite.respond = func(n int, bundle FHIRBundle) int {
+		if bundle.Entry[0].Resource["id"] == patients[1].ID() {
+			return http.StatusBadRequest
+		}
+		return http.StatusOK
+	}
+	suite.NotNil(suite.upload(patients, Options{}))
+
+	// The rerun uploads only the patient who failed.
+	suite.mutex.Lock()
+	suite.bundles = nil
+	suite.respond = func(int, FHIRBundle) int { return http.StatusOK }
+	suite.mutex.Unlock()
+	suite.Nil(suite.upload(patients, Options{}))
+	bundles := suite.posted()
+	suite.Require().Len(bundles, 1)
+	suite.Equal(patients[1].ID(), bundles[0].Entry[0].Resource["id"])
+	suite.ElementsMatch([]string{patients[0].ID(), patients[1].ID(), patients[2].ID()}, suite.uploadedLog())
+}
+
+func (suite *FHIRUploadTestSuite) TestInvalidOptions() {
+	for _, options := range []Options{
+		{},
+		{"fhirupload.url": "http://localhost", "fhirupload.concurrency": "0"},
+		{"fhirupload.url": "http://localhost", "fhirupload.batch_size": "x"},
+		{"fhirupload.url": "http://localhost", "fhirupload.retries": "-1"},
+		{"fhirupload.url": "http://localhost", "fhirupload.backoff": "soon"},
+	} {
+		_, err := New("fhirupload", options)
+		suite.NotNil(err, "%v", options)
+	}
+}
@@ -0,0 +1,410 @@
+package exporter
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cjduffett/synthea/entity"
+	"github.com/cjduffett/synthea/records"
+)
+
+// HL7 v2 encoding characters and MLLP framing bytes.
+const (
+	hl7FieldSeparator     = "|"
+	hl7EncodingCharacters = `^~\&`
+	hl7SegmentTerminator  = "\r"
+	hl7Version            = "2.5.1"
+
+	mllpStartBlock = '\x0b'
+	mllpEndBlock   = '\x1c'
+)
+
+var hl7Escaper = strings.NewReplacer(
+	`\`, `\E\`,
+	`|`, `\F\`,
+	`^`, `\S\`,
+	`&`, `\T\`,
+	`~`, `\R\`,
+	"\r", `\X0D\`,
+	"\n", `\X0A\`,
+)
+
+var hl7CodingSystems = map[string]string{
+	"LOINC":     "LN",
+	"SNOMED-CT": "SCT",
+	"RxNorm":    "RXNORM",
+	"CVX":       "CVX",
+}
+
+var hl7PatientClasses = map[string]string{
+	"ambulatory": "O",
+	"outpatient": "O",
+	"wellness":   "O",
+	"emergency":  "E",
+	"inpatient":  "I",
+}
+
+// HL7Exporter writes each patient's encounters as HL7 v2 messages. Every
+// encounter produces an ADT^A01 (admit) and ADT^A03 (discharge) message,
+// and every encounter with observations also produces an ORU^R01 result
+// message. Messages are either written to one file per patient or, in
+// MLLP mode, framed and appended to a single stream.
+type HL7Exporter struct {
+	outputDir string
+	mllp      bool
+	stream    *os.File
+	writer    *bufio.Writer
+	messageID int64
+}
+
+func init() {
+	Register("hl7", func(options Options) (Exporter, error) {
+		return NewHL7Exporter(options["hl7.mllp"] == "true"), nil
+	})
+}
+
+// NewHL7Exporter returns a new HL7Exporter. If mllp is true all messages
+// are MLLP framed and written to a single messages.mllp stream.
+func NewHL7Exporter(mllp bool) *HL7Exporter {
+	return &HL7Exporter{
+		mllp: mllp,
+	}
+}
+
+// Init sets the directory messages are written to and, in MLLP mode,
+// opens the message stream.
+func (h *HL7Exporter) Init(outputDir string) error {
+	h.outputDir = outputDir
+	err := os.MkdirAll(outputDir, 0755)
+	if err != nil {
+		return err
+	}
+
+	if h.mllp {
+		h.stream, err = os.Create(filepath.Join(outputDir, "messages.mllp"))
+		if err != nil {
+			return err
+		}
+		h.writer = bufio.NewWriter(h.stream)
+	}
+	return nil
+}
+
+// Export writes all of the patient's HL7 messages.
+func (h *HL7Exporter) Export(patient *entity.Patient, record *records.Record) error {
+	if h.mllp {
+		err := h.writeMessages(h.writer, patient, record)
+		if err != nil {
+			return err
+		}
+		return h.writer.Flush()
+	}
+
+	file, err := os.Create(filepath.Join(h.outputDir, patient.ID()+".hl7"))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	err = h.writeMessages(w, patient, record)
+	if err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// Close closes the MLLP stream, if there is one.
+func (h *HL7Exporter) Close() error {
+	if h.stream == nil {
+		return nil
+	}
+	err := h.writer.Flush()
+	if cerr := h.stream.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// hl7State is the checkpoint state of an HL7Exporter.
+type hl7State struct {
+	// MessageID is the last message control ID used.
+	MessageID int64 `json:"message_id"`
+	// Offset is the size of the MLLP stream, in MLLP mode.
+	Offset int64 `json:"offset,omitempty"`
+}
+
+// Checkpoint flushes the MLLP stream, if there is one, and returns its
+// size and the last message control ID used.
+func (h *HL7Exporter) Checkpoint() (json.RawMessage, error) {
+	state := hl7State{MessageID: h.messageID}
+	if h.stream != nil {
+		err := h.writer.Flush()
+		if err != nil {
+			return nil, err
+		}
+		offsets, err := fileOffsets([]*os.File{h.stream})
+		if err != nil {
+			return nil, err
+		}
+		state.Offset = offsets[0]
+	}
+	return json.Marshal(state)
+}
+
+// Resume continues numbering messages after the last message control ID
+// used and, in MLLP mode, reopens the message stream truncated to its
+// size at the checkpoint.
+func (h *HL7Exporter) Resume(outputDir string, data json.RawMessage) error {
+	var state hl7State
+	err := json.Unmarshal(data, &state)
+	if err != nil {
+		return fmt.Errorf("Invalid checkpoint: %s", err.Error())
+	}
+	h.outputDir = outputDir
+	h.messageID = state.MessageID
+	if h.mllp {
+		h.stream, err = reopenFile(filepath.Join(outputDir, "messages.mllp"), state.Offset)
+		if err != nil {
+			return err
+		}
+		h.writer = bufio.NewWriter(h.stream)
+	}
+	return nil
+}
+
+func (h *HL7Exporter) writeMessages(w io.Writer, patient *entity.Patient, record *records.Record) error {
+	for _, encounter := range record.Encounters() {
+		stop := encounter.Stop
+		if stop.IsZero() {
+			stop = encounter.Start
+		}
+
+		messages := []string{
+			h.adtMessage("A01", encounter.Start, patient, record, encounter),
+			h.adtMessage("A03", stop, patient, record, encounter),
+		}
+		if observations := record.EncounterObservations(encounter); len(observations) > 0 {
+			messages = append(messages, h.oruMessage(stop, patient, record, encounter, observations))
+		}
+
+		for _, message := range messages {
+			var err error
+			if h.mllp {
+				_, err = fmt.Fprintf(w, "%c%s%c\r", mllpStartBlock, message, mllpEndBlock)
+			} else {
+				_, err = io.WriteString(w, message)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// adtMessage builds an ADT message for the given trigger event.
+func (h *HL7Exporter) adtMessage(event string, time time.Time, patient *entity.Patient, record *records.Record, encounter records.Encounter) string {
+	evn := newHL7Segment("EVN", 2)
+	evn.set(1, event)
+	evn.set(2, hl7Time(time))
+
+	return hl7Message(
+		h.msh("ADT", event, time),
+		evn,
+		pidSegment(patient, record),
+		pv1Segment(patient, encounter),
+	)
+}
+
+// oruMessage builds an ORU^R01 message reporting the observations made
+// during an encounter.
+func (h *HL7Exporter) oruMessage(time time.Time, patient *entity.Patient, record *records.Record, encounter records.Encounter, observations []records.Observation) string {
+	segments := []hl7Segment{
+		h.msh("ORU", "R01", time),
+		pidSegment(patient, record),
+		pv1Segment(patient, encounter),
+	}
+
+	obr := newHL7Segment("OBR", 7)
+	obr.set(1, "1")
+	obr.set(4, hl7CodedElement(encounter.Codes))
+	obr.set(7, hl7Time(encounter.Start))
+	segments = append(segments, obr)
+
+	for i, observation := range observations {
+		obx := newHL7Segment("OBX", 14)
+		obx.set(1, fmt.Sprintf("%d", i+1))
+		obx.set(2, "NM")
+		obx.set(3, hl7CodedElement(observation.Codes))
+		obx.set(5, hl7Escape(fmt.Sprintf("%g", observation.Value)))
+		obx.set(6, hl7Escape(observation.Unit))
+		obx.set(11, "F")
+		obx.set(14, hl7Time(observation.Time))
+		segments = append(segments, obx)
+	}
+	return hl7Message(segments...)
+}
+
+// msh builds a message header. MSH-1 is the field separator itself, so
+// the fields of the returned segment are offset by one.
+func (h *HL7Exporter) msh(messageCode, triggerEvent string, time time.Time) hl7Segment {
+	h.messageID++
+	msh := newHL7Segment("MSH", 11)
+	msh.set(1, hl7EncodingCharacters)
+	msh.set(2, "SYNTHEA")
+	msh.set(3, "SYNTHEA")
+	msh.set(6, hl7Time(time))
+	msh.set(8, hl7Components(messageCode, triggerEvent, messageCode+"_"+triggerEvent))
+	msh.set(9, fmt.Sprintf("%d", h.messageID))
+	msh.set(10, "P")
+	msh.set(11, hl7Version)
+	return msh
+}
+
+func pidSegment(patient *entity.Patient, record *records.Record) hl7Segment {
+	pid := newHL7Segment("PID", 30)
+	pid.set(1, "1")
+	ids := []string{hl7Components(patient.ID(), "", "", "SYNTHEA", "MR")}
+	for _, id := range patient.Identifiers() {
+		ids = append(ids, hl7Components(id.Value(), "", "", id.Assigner(), id.Type()))
+		switch id.Type() {
+		case entity.IdentifierSSN:
+			pid.set(19, hl7Escape(id.Value()))
+		case entity.IdentifierDriversLicense:
+			pid.set(20, hl7Components(id.Value(), id.Assigner()))
+		}
+	}
+	pid.set(3, strings.Join(ids, "~"))
+	names := []string{hl7Components(patient.LastName(), patient.FirstName(), "", patient.Suffix(), patient.Prefix(), "", "L")}
+	if patient.MaidenName() != "" {
+		names = append(names, hl7Components(patient.MaidenName(), patient.FirstName(), "", "", "", "", "M"))
+	}
+	pid.set(5, strings.Join(names, "~"))
+	pid.set(7, patient.BirthDate().Format("20060102"))
+	pid.set(8, hl7Sex(patient.Gender()))
+	pid.set(10, hl7Escape(patient.Race()))
+
+	address := patient.Address()
+	lines := address.Line()
+	street, other := "", ""
+	if len(lines) > 0 {
+		street = lines[0]
+	}
+	if len(lines) > 1 {
+		other = lines[1]
+	}
+	pid.set(11, hl7Components(street, other, address.City(), address.State(), address.PostalCode()))
+	pid.set(22, hl7Escape(patient.Ethnicity()))
+
+	if record.Expired() {
+		pid.set(29, hl7Time(record.DeathTime()))
+		pid.set(30, "Y")
+	} else {
+		pid.set(30, "N")
+	}
+	return pid
+}
+
+func pv1Segment(patient *entity.Patient, encounter records.Encounter) hl7Segment {
+	class, ok := hl7PatientClasses[encounter.Class]
+	if !ok {
+		class = "U"
+	}
+	pv1 := newHL7Segment("PV1", 45)
+	pv1.set(1, "1")
+	pv1.set(2, class)
+	pv1.set(19, hl7Escape(patient.ID()+"-"+hl7Time(encounter.Start)))
+	pv1.set(44, hl7Time(encounter.Start))
+	if !encounter.Stop.IsZero() {
+		pv1.set(45, hl7Time(encounter.Stop))
+	}
+	return pv1
+}
+
+// hl7Segment is a single HL7 v2 segment. Index 0 holds the segment ID
+// and index i holds field i. Field values must already be escaped.
+type hl7Segment []string
+
+func newHL7Segment(id string, fields int) hl7Segment {
+	segment := make(hl7Segment, fields+1)
+	segment[0] = id
+	return segment
+}
+
+func (s hl7Segment) set(field int, value string) {
+	s[field] = value
+}
+
+// String encodes the segment, dropping any trailing empty fields.
+func (s hl7Segment) String() string {
+	last := len(s) - 1
+	for last > 0 && s[last] == "" {
+		last--
+	}
+	if s[0] == "MSH" {
+		// MSH-1 is the field separator, so MSH-2 follows the segment ID directly.
+		return "MSH" + hl7FieldSeparator + strings.Join(s[1:last+1], hl7FieldSeparator)
+	}
+	return strings.Join(s[:last+1], hl7FieldSeparator)
+}
+
+func hl7Message(segments ...hl7Segment) string {
+	var b strings.Builder
+	for _, segment := range segments {
+		b.WriteString(segment.String())
+		b.WriteString(hl7SegmentTerminator)
+	}
+	return b.String()
+}
+
+// hl7Escape escapes the HL7 delimiters and line breaks in a value.
+func hl7Escape(value string) string {
+	return hl7Escaper.Replace(value)
+}
+
+// hl7Components escapes each component and joins them into a single field.
+func hl7Components(components ...string) string {
+	last := len(components) - 1
+	for last > 0 && components[last] == "" {
+		last--
+	}
+	escaped := make([]string, last+1)
+	for i := range escaped {
+		escaped[i] = hl7Escape(components[i])
+	}
+	return strings.Join(escaped, "^")
+}
+
+// hl7CodedElement encodes the first code as a CE (code^text^system).
+func hl7CodedElement(codes []records.Code) string {
+	if len(codes) == 0 {
+		return ""
+	}
+	system, ok := hl7CodingSystems[codes[0].System]
+	if !ok {
+		system = codes[0].System
+	}
+	return hl7Components(codes[0].Code, codes[0].Display, system)
+}
+
+func hl7Sex(gender string) string {
+	switch gender {
+	case "Male":
+		return "M"
+	case "Female":
+		return "F"
+	default:
+		return "U"
+	}
+}
+
+func hl7Time(t time.Time) string {
+	return t.Format("20060102150405")
+}
@@ -0,0 +1,95 @@
+package exporter
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cjduffett/synthea/entity"
+	"github.com/cjduffett/synthea/records"
+	"github.com/stretchr/testify/suite"
+)
+
+type HL7TestSuite struct {
+	suite.Suite
+	outputDir string
+}
+
+func TestHL7TestSuite(t *testing.T) {
+	suite.Run(t, new(HL7TestSuite))
+}
+
+func (suite *HL7TestSuite) SetupTest() {
+	suite.outputDir = suite.T().TempDir()
+}
+
+func (suite *HL7TestSuite) TestEscape() {
+	suite.Equal(`a\F\b\S\c\T\d\R\e\E\f`, hl7Escape(`a|b^c&d~e\f`))
+	suite.Equal(`line 1\X0D\line 2`, hl7Escape("line 1\rline 2"))
+}
+
+func (suite *HL7TestSuite) TestSegmentDropsTrailingFields() {
+	pid := newHL7Segment("PID", 30)
+	pid.set(1, "1")
+	pid.set(5, hl7Components("Smith", "Jo^hn", "", ""))
+	suite.Equal(`PID|1||||Smith^Jo\S\hn`, pid.String())
+}
+
+func (suite *HL7TestSuite) TestPatientNames() {
+	patient := testMarried()
+	pid := pidSegment(patient, new(records.Record)).String()
+	suite.Contains(pid, "|"+patient.LastName()+"^"+patient.FirstName()+"^^")
+	suite.Contains(pid, "~"+patient.MaidenName()+"^"+patient.FirstName()+"^^^^^M|")
+}
+
+func (suite *HL7TestSuite) TestMessageHeader() {
+	h := &HL7Exporter{}
+	patient, _ := testPatientRecord()
+	msh := h.msh("ADT", "A01", patient.BirthDate())
+	suite.True(strings.HasPrefix(msh.String(), `MSH|^~\&|SYNTHEA|SYNTHEA|||`))
+	suite.True(strings.HasSuffix(msh.String(), "||ADT^A01^ADT_A01|1|P|2.5.1"))
+}
+
+func (suite *HL7TestSuite) TestExportPerPatientFiles() {
+	h := NewHL7Exporter(false)
+	suite.Nil(h.Init(suite.outputDir))
+
+	patient, record := testPatientRecord()
+	suite.Nil(h.Export(patient, record))
+	suite.Nil(h.Close())
+
+	data, err := ioutil.ReadFile(filepath.Join(suite.outputDir, patient.ID()+".hl7"))
+	suite.Nil(err)
+
+	messages := strings.Split(string(data), "\rMSH")
+	suite.Equal(3, len(messages), "Expected an A01, A03, and ORU^R01")
+	suite.Contains(messages[0], "ADT^A01")
+	suite.Contains(messages[1], "ADT^A03")
+	suite.Contains(messages[2], "ORU^R01")
+	suite.Contains(messages[2], "\rOBX|1|NM|4548-4^Hemoglobin A1c^LN||6.5|%|||||F")
+	for _, id := range patient.Identifiers() {
+		suite.Contains(messages[0], "~"+id.Value()+"^^^")
+		if id.Type() == entity.IdentifierSSN {
+			suite.Contains(messages[0], "|"+id.Value()+"|")
+		}
+	}
+	suite.NotContains(string(data), "\n")
+}
+
+func (suite *HL7TestSuite) TestExportMLLPStream() {
+	h := NewHL7Exporter(true)
+	suite.Nil(h.Init(suite.outputDir))
+
+	patient, record := testPatientRecord()
+	suite.Nil(h.Export(patient, record))
+	suite.Nil(h.Export(patient, record))
+	suite.Nil(h.Close())
+
+	data, err := ioutil.ReadFile(filepath.Join(suite.outputDir, "messages.mllp"))
+	suite.Nil(err)
+	suite.Equal(6, bytes.Count(data, []byte{mllpStartBlock}))
+	suite.Equal(6, bytes.Count(data, []byte{mllpEndBlock, '\r'}))
+	suite.Equal(byte(mllpStartBlock), data[0])
+}
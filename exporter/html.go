@@ -0,0 +1,285 @@
+package exporter
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cjduffett/synthea/entity"
+	"github.com/cjduffett/synthea/records"
+)
+
+// HTMLExporter writes a self-contained HTML summary page for each patient.
+// Pages have no external assets: styles are inlined and observation charts
+// are drawn as inline SVG.
+type HTMLExporter struct {
+	outputDir string
+}
+
+func init() {
+	Register("html", func(options Options) (Exporter, error) {
+		return NewHTMLExporter(), nil
+	})
+}
+
+// NewHTMLExporter returns a new HTMLExporter.
+func NewHTMLExporter() *HTMLExporter {
+	return &HTMLExporter{}
+}
+
+// Init sets the directory the summary pages are written to.
+func (h *HTMLExporter) Init(outputDir string) error {
+	h.outputDir = outputDir
+	return os.MkdirAll(outputDir, 0755)
+}
+
+// Export writes the patient's summary page to <outputDir>/<patient id>.html.
+func (h *HTMLExporter) Export(patient *entity.Patient, record *records.Record) error {
+	file, err := os.Create(filepath.Join(h.outputDir, patient.ID()+".html"))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return WriteHTML(file, patient, record)
+}
+
+// Close is a no-op; each page is written in full by Export.
+func (h *HTMLExporter) Close() error {
+	return nil
+}
+
+// WriteHTML renders the patient's summary page to w.
+func WriteHTML(w io.Writer, patient *entity.Patient, record *records.Record) error {
+	summary := htmlSummary{
+		Patient:     patient,
+		Record:      record,
+		Charts:      buildCharts(record.Observations()),
+		Encounters:  record.Encounters(),
+		Medications: record.Medications(),
+	}
+	for _, condition := range record.Conditions() {
+		if condition.Active() {
+			summary.ActiveConditions = append(summary.ActiveConditions, condition)
+		} else {
+			summary.ResolvedConditions = append(summary.ResolvedConditions, condition)
+		}
+	}
+	return htmlTemplate.Execute(w, summary)
+}
+
+// htmlSummary is the data rendered by htmlTemplate.
+type htmlSummary struct {
+	Patient            *entity.Patient
+	Record             *records.Record
+	Encounters         []records.Encounter
+	ActiveConditions   []records.Condition
+	ResolvedConditions []records.Condition
+	Medications        []records.Medication
+	Charts             []htmlChart
+}
+
+// htmlChart is a line chart of all observations that share the same code.
+type htmlChart struct {
+	Title  string
+	Unit   string
+	Min    float64
+	Max    float64
+	First  time.Time
+	Last   time.Time
+	Points string
+	Dots   []htmlPoint
+}
+
+type htmlPoint struct {
+	X, Y  float64
+	Value float64
+	Time  time.Time
+}
+
+// Chart dimensions, in SVG user units.
+const (
+	chartWidth   = 320.0
+	chartHeight  = 80.0
+	chartPadding = 6.0
+)
+
+// buildCharts groups observations by their primary code and lays each
+// group out as a chart, ordered by title.
+func buildCharts(observations []records.Observation) []htmlChart {
+	groups := make(map[string][]records.Observation)
+	for _, obs := range observations {
+		key := displayCodes(obs.Codes)
+		groups[key] = append(groups[key], obs)
+	}
+
+	var charts []htmlChart
+	for title, group := range groups {
+		sort.SliceStable(group, func(i, j int) bool {
+			return group[i].Time.Before(group[j].Time)
+		})
+		charts = append(charts, layoutChart(title, group))
+	}
+	sort.Slice(charts, func(i, j int) bool {
+		return charts[i].Title < charts[j].Title
+	})
+	return charts
+}
+
+func layoutChart(title string, observations []records.Observation) htmlChart {
+	first := observations[0]
+	last := observations[len(observations)-1]
+	chart := htmlChart{
+		Title: title,
+		Unit:  first.Unit,
+		Min:   first.Value,
+		Max:   first.Value,
+		First: first.Time,
+		Last:  last.Time,
+	}
+	for _, obs := range observations {
+		if obs.Value < chart.Min {
+			chart.Min = obs.Value
+		}
+		if obs.Value > chart.Max {
+			chart.Max = obs.Value
+		}
+	}
+
+	span := last.Time.Sub(first.Time).Seconds()
+	valueRange := chart.Max - chart.Min
+	points := make([]string, len(observations))
+
+	for i, obs := range observations {
+		// A single observation (or several at one instant) is centered.
+		x := chartWidth / 2
+		if span > 0 {
+			x = chartPadding + (chartWidth-2*chartPadding)*obs.Time.Sub(first.Time).Seconds()/span
+		}
+		y := chartHeight / 2
+		if valueRange > 0 {
+			y = chartHeight - chartPadding - (chartHeight-2*chartPadding)*(obs.Value-chart.Min)/valueRange
+		}
+		points[i] = fmt.Sprintf("%.1f,%.1f", x, y)
+		chart.Dots = append(chart.Dots, htmlPoint{X: x, Y: y, Value: obs.Value, Time: obs.Time})
+	}
+	chart.Points = strings.Join(points, " ")
+	return chart
+}
+
+// displayCodes returns a human readable description of a set of codes.
+func displayCodes(codes []records.Code) string {
+	if len(codes) == 0 {
+		return "Unknown"
+	}
+	if codes[0].Display != "" {
+		return codes[0].Display
+	}
+	return codes[0].System + " " + codes[0].Code
+}
+
+var htmlFuncs = template.FuncMap{
+	"date": func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format("2006-01-02")
+	},
+	"display": displayCodes,
+	"identifierType": func(typ string) string {
+		return fhirIdentifierTypes[typ]
+	},
+	"join": strings.Join,
+	"number": func(f float64) string {
+		return strings.TrimRight(strings.TrimRight(fmt.Sprintf("%.2f", f), "0"), ".")
+	},
+	"chartWidth":  func() float64 { return chartWidth },
+	"chartHeight": func() float64 { return chartHeight },
+}
+
+var htmlTemplate = template.Must(template.New("patient").Funcs(htmlFuncs).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Patient.FirstName}} {{.Patient.LastName}}</title>
+<style>
+body { font-family: Helvetica, Arial, sans-serif; margin: 2em auto; max-width: 960px; color: #222; }
+h1 { margin-bottom: 0.2em; }
+h2 { border-bottom: 1px solid #ccc; padding-bottom: 0.2em; margin-top: 1.5em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; padding: 0.3em 0.6em; border-bottom: 1px solid #eee; vertical-align: top; }
+th { background: #f5f5f5; }
+.muted { color: #777; }
+.deceased { color: #a00; font-weight: bold; }
+.charts { display: flex; flex-wrap: wrap; gap: 1em; }
+.chart { border: 1px solid #ddd; padding: 0.5em; }
+.chart h3 { font-size: 0.9em; margin: 0 0 0.3em 0; }
+.chart svg { display: block; background: #fafafa; }
+.chart .axis { font-size: 0.75em; color: #777; display: flex; justify-content: space-between; }
+</style>
+</head>
+<body>
+<h1>{{.Patient.FirstName}} {{.Patient.LastName}}</h1>
+<p class="muted">Patient {{.Patient.ID}}</p>
+{{if .Record.Expired}}<p class="deceased">Deceased {{date .Record.DeathTime}}{{with .Record.CauseOfDeath}} ({{display .}}){{end}}</p>{{end}}
+
+<h2>Demographics</h2>
+<table>
+<tr><th>Gender</th><td>{{.Patient.Gender}}</td></tr>
+<tr><th>Birth date</th><td>{{date .Patient.BirthDate}}</td></tr>
+<tr><th>Race</th><td>{{.Patient.Race}}</td></tr>
+<tr><th>Ethnicity</th><td>{{.Patient.Ethnicity}}</td></tr>
+<tr><th>Blood type</th><td>{{.Patient.BloodType}}</td></tr>
+{{with .Patient.Address}}<tr><th>Address</th><td>{{join .Line ", "}}<br>{{.City}}, {{.State}} {{.PostalCode}}</td></tr>{{end}}
+{{with .Patient.PlaceOfBirth}}<tr><th>Place of birth</th><td>{{.City}}{{if .State}}, {{.State}}{{end}}, {{.Country}}</td></tr>{{end}}
+</table>
+
+<h2>Identifiers</h2>
+{{if .Patient.Identifiers}}<table>
+<tr><th>Type</th><th>Number</th><th>Assigned by</th></tr>
+{{range .Patient.Identifiers}}<tr><td>{{identifierType .Type}}</td><td>{{.Value}}</td><td>{{.Assigner}}</td></tr>
+{{end}}</table>{{else}}<p class="muted">No identifiers.</p>{{end}}
+
+<h2>Encounters</h2>
+{{if .Encounters}}<table>
+<tr><th>Date</th><th>Class</th><th>Type</th><th>Reason</th></tr>
+{{range .Encounters}}<tr><td>{{date .Start}}</td><td>{{.Class}}</td><td>{{display .Codes}}</td><td>{{with .Reason}}{{display .}}{{end}}</td></tr>
+{{end}}</table>{{else}}<p class="muted">No encounters.</p>{{end}}
+
+<h2>Active Conditions</h2>
+{{if .ActiveConditions}}<table>
+<tr><th>Condition</th><th>Onset</th></tr>
+{{range .ActiveConditions}}<tr><td>{{display .Codes}}</td><td>{{date .Start}}</td></tr>
+{{end}}</table>{{else}}<p class="muted">No active conditions.</p>{{end}}
+
+<h2>Resolved Conditions</h2>
+{{if .ResolvedConditions}}<table>
+<tr><th>Condition</th><th>Onset</th><th>Resolved</th></tr>
+{{range .ResolvedConditions}}<tr><td>{{display .Codes}}</td><td>{{date .Start}}</td><td>{{date .Stop}}</td></tr>
+{{end}}</table>{{else}}<p class="muted">No resolved conditions.</p>{{end}}
+
+<h2>Medications</h2>
+{{if .Medications}}<table>
+<tr><th>Medication</th><th>Start</th><th>Stop</th><th>Reason</th></tr>
+{{range .Medications}}<tr><td>{{display .Codes}}</td><td>{{date .Start}}</td><td>{{if .Active}}<em>active</em>{{else}}{{date .Stop}}{{end}}</td><td>{{with .Reason}}{{display .}}{{end}}</td></tr>
+{{end}}</table>{{else}}<p class="muted">No medications.</p>{{end}}
+
+<h2>Observations</h2>
+{{if .Charts}}<div class="charts">
+{{range .Charts}}<div class="chart">
+<h3>{{.Title}}{{if .Unit}} ({{.Unit}}){{end}}</h3>
+<svg xmlns="http://www.w3.org/2000/svg" width="{{chartWidth}}" height="{{chartHeight}}" viewBox="0 0 {{chartWidth}} {{chartHeight}}">
+<polyline fill="none" stroke="#3366cc" stroke-width="1.5" points="{{.Points}}"/>
+{{range .Dots}}<circle cx="{{.X}}" cy="{{.Y}}" r="2.5" fill="#3366cc"><title>{{date .Time}}: {{number .Value}}</title></circle>
+{{end}}</svg>
+<div class="axis"><span>{{date .First}}</span><span>{{number .Min}} &ndash; {{number .Max}}</span><span>{{date .Last}}</span></div>
+</div>
+{{end}}</div>{{else}}<p class="muted">No observations.</p>{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,115 @@
+package exporter
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/cjduffett/synthea/entity"
+	"github.com/cjduffett/synthea/records"
+	"github.com/cjduffett/synthea/utils"
+	"github.com/stretchr/testify/suite"
+)
+
+type HTMLTestSuite struct {
+	suite.Suite
+	patient *entity.Patient
+	record  *records.Record
+}
+
+func TestHTMLTestSuite(t *testing.T) {
+	suite.Run(t, new(HTMLTestSuite))
+}
+
+func (suite *HTMLTestSuite) SetupTest() {
+	suite.patient, suite.record = testPatientRecord()
+}
+
+func (suite *HTMLTestSuite) TestWriteHTML() {
+	var buf bytes.Buffer
+	suite.Nil(WriteHTML(&buf, suite.patient, suite.record))
+
+	html := buf.String()
+	suite.Contains(html, suite.patient.FirstName())
+	suite.Contains(html, "Encounter for symptom")
+	suite.Contains(html, "Diabetes mellitus")
+	suite.Contains(html, "Metformin")
+	suite.Contains(html, "<svg")
+	suite.Contains(html, "Hemoglobin A1c (%)")
+}
+
+func (suite *HTMLTestSuite) TestWriteHTMLIdentifiers() {
+	var buf bytes.Buffer
+	suite.Nil(WriteHTML(&buf, suite.patient, suite.record))
+
+	html := buf.String()
+	suite.NotEmpty(suite.patient.Identifiers())
+	for _, id := range suite.patient.Identifiers() {
+		suite.Contains(html, "<td>"+id.Value()+"</td>")
+	}
+	suite.Contains(html, "Medical record number")
+	suite.Contains(html, "Social Security number")
+}
+
+func (suite *HTMLTestSuite) TestWriteHTMLEscapesText() {
+	var buf bytes.Buffer
+	suite.Nil(WriteHTML(&buf, suite.patient, suite.record))
+	suite.Contains(buf.String(), "Viral sinusitis &lt;disorder&gt;")
+}
+
+func (suite *HTMLTestSuite) TestWriteHTMLHasNoExternalAssets() {
+	var buf bytes.Buffer
+	suite.Nil(WriteHTML(&buf, suite.patient, suite.record))
+
+	html := buf.String()
+	suite.NotContains(html, "<link")
+	suite.NotContains(html, "<script")
+	suite.NotContains(html, "src=")
+}
+
+func (suite *HTMLTestSuite) TestLayoutChart() {
+	chart := buildCharts(suite.record.Observations())[0]
+	suite.Equal(3, len(chart.Dots))
+	suite.Equal(6.5, chart.Min)
+	suite.Equal(8.5, chart.Max)
+	suite.Equal(chartPadding, chart.Dots[0].X)
+	suite.Equal(chartHeight-chartPadding, chart.Dots[0].Y)
+	suite.Equal(chartPadding, chart.Dots[2].Y)
+}
+
+// testPatientRecord returns a patient with a small record containing one
+// of each of the entries the exporters render.
+func testPatientRecord() (*entity.Patient, *records.Record) {
+	endTime := time.Date(2016, time.December, 1, 0, 0, 0, 0, time.UTC)
+	patient := entity.NewPatient(utils.NewRand(1), endTime.AddDate(-100, 0, 0), endTime, nil)
+	record := new(records.Record)
+
+	visit := time.Date(2010, time.March, 4, 9, 0, 0, 0, time.UTC)
+	diabetes := records.Code{System: "SNOMED-CT", Code: "44054006", Display: "Diabetes mellitus"}
+	record.AddEncounter(records.Encounter{
+		Class: "ambulatory",
+		Codes: []records.Code{{System: "SNOMED-CT", Code: "185345009", Display: "Encounter for symptom"}},
+		Start: visit,
+		Stop:  visit.Add(time.Hour),
+	})
+	record.AddCondition(records.Condition{Codes: []records.Code{diabetes}, Start: visit})
+	record.AddCondition(records.Condition{
+		Codes: []records.Code{{System: "SNOMED-CT", Code: "444814009", Display: "Viral sinusitis <disorder>"}},
+		Start: visit,
+		Stop:  visit.AddDate(0, 0, 14),
+	})
+	record.StartMedication(records.Medication{
+		Codes:  []records.Code{{System: "RxNorm", Code: "860975", Display: "Metformin"}},
+		Reason: []records.Code{diabetes},
+		Start:  visit,
+	})
+	for i := 0; i < 3; i++ {
+		record.AddObservation(records.Observation{
+			Codes: []records.Code{{System: "LOINC", Code: "4548-4", Display: "Hemoglobin A1c"}},
+			Value: 6.5 + float64(i),
+			Unit:  "%",
+			Time:  visit.AddDate(i, 0, 0),
+		})
+	}
+	return patient, record
+}
@@ -0,0 +1,83 @@
+package exporter
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// FHIRNDJSONWriter writes the resources of FHIR bundles as newline
+// delimited JSON, one file per resource type named <type>.ndjson, as in
+// a FHIR bulk data export. Each resource is written on its own, so the
+// files can be loaded by any bulk import that updates resources by ID.
+type FHIRNDJSONWriter struct {
+	outputDir string
+	files     map[string]*os.File
+	writers   map[string]*bufio.Writer
+}
+
+// NewFHIRNDJSONWriter returns a writer of NDJSON files in outputDir. Files
+// are created, replacing any written before, when the first resource of
+// their type is written.
+func NewFHIRNDJSONWriter(outputDir string) *FHIRNDJSONWriter {
+	return &FHIRNDJSONWriter{
+		outputDir: outputDir,
+		files:     make(map[string]*os.File),
+		writers:   make(map[string]*bufio.Writer),
+	}
+}
+
+// Write appends every resource in the bundle to the file of its type.
+func (n *FHIRNDJSONWriter) Write(bundle *FHIRBundle) error {
+	for _, entry := range bundle.Entry {
+		w, err := n.writer(entry.Resource["resourceType"].(string))
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(entry.Resource)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(append(data, '\n'))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writer returns the writer of the file of a resource type, creating
+// the file on first use.
+func (n *FHIRNDJSONWriter) writer(resourceType string) (*bufio.Writer, error) {
+	if w, ok := n.writers[resourceType]; ok {
+		return w, nil
+	}
+	err := os.MkdirAll(n.outputDir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Create(filepath.Join(n.outputDir, resourceType+".ndjson"))
+	if err != nil {
+		return nil, err
+	}
+	n.files[resourceType] = file
+	n.writers[resourceType] = bufio.NewWriter(file)
+	return n.writers[resourceType], nil
+}
+
+// Close flushes and closes every file, returning the first error.
+func (n *FHIRNDJSONWriter) Close() error {
+	var err error
+	for resourceType, file := range n.files {
+		if ferr := n.writers[resourceType].Flush(); err == nil {
+			err = ferr
+		}
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+	}
+	n.files = make(map[string]*os.File)
+	n.writers = make(map[string]*bufio.Writer)
+	return err
+}
@@ -0,0 +1,455 @@
+package exporter
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/cjduffett/synthea/entity"
+	"github.com/cjduffett/synthea/records"
+)
+
+// OMOP concept IDs for the fixed vocabularies used by the exporter.
+const (
+	omopEHRTypeConcept       = 32817 // "EHR"
+	omopMaleConcept          = 8507
+	omopFemaleConcept        = 8532
+	omopHispanicConcept      = 38003563
+	omopNotHispanicConcept   = 38003564
+	omopInpatientConcept     = 9201
+	omopOutpatientConcept    = 9202
+	omopEmergencyRoomConcept = 9203
+)
+
+var omopRaceConcepts = map[string]int64{
+	"White":  8527,
+	"Black":  8516,
+	"Asian":  8515,
+	"Native": 8657,
+}
+
+var omopVisitConcepts = map[string]int64{
+	"ambulatory": omopOutpatientConcept,
+	"outpatient": omopOutpatientConcept,
+	"wellness":   omopOutpatientConcept,
+	"emergency":  omopEmergencyRoomConcept,
+	"inpatient":  omopInpatientConcept,
+}
+
+// omopTables lists each OMOP CDM table written by the exporter and its
+// columns, in the order they are written.
+var omopTables = []struct {
+	name    string
+	columns []string
+}{
+	{"person", []string{"person_id", "gender_concept_id", "year_of_birth", "month_of_birth", "day_of_birth", "birth_datetime", "race_concept_id", "ethnicity_concept_id", "person_source_value", "gender_source_value", "race_source_value", "ethnicity_source_value"}},
+	{"observation_period", []string{"observation_period_id", "person_id", "observation_period_start_date", "observation_period_end_date", "period_type_concept_id"}},
+	{"visit_occurrence", []string{"visit_occurrence_id", "person_id", "visit_concept_id", "visit_start_date", "visit_start_datetime", "visit_end_date", "visit_end_datetime", "visit_type_concept_id", "visit_source_value"}},
+	{"condition_occurrence", []string{"condition_occurrence_id", "person_id", "condition_concept_id", "condition_start_date", "condition_start_datetime", "condition_end_date", "condition_end_datetime", "condition_type_concept_id", "visit_occurrence_id", "condition_source_value"}},
+	{"drug_exposure", []string{"drug_exposure_id", "person_id", "drug_concept_id", "drug_exposure_start_date", "drug_exposure_start_datetime", "drug_exposure_end_date", "drug_exposure_end_datetime", "drug_type_concept_id", "visit_occurrence_id", "drug_source_value"}},
+	{"measurement", []string{"measurement_id", "person_id", "measurement_concept_id", "measurement_date", "measurement_datetime", "measurement_type_concept_id", "value_as_number", "unit_source_value", "visit_occurrence_id", "measurement_source_value"}},
+	{"procedure_occurrence", []string{"procedure_occurrence_id", "person_id", "procedure_concept_id", "procedure_date", "procedure_datetime", "procedure_type_concept_id", "visit_occurrence_id", "procedure_source_value"}},
+	{"death", []string{"person_id", "death_date", "death_datetime", "death_type_concept_id", "cause_concept_id", "cause_source_value"}},
+}
+
+// OMOPExporter writes patients to the OMOP Common Data Model as one CSV
+// file per table. Source codes are mapped to OMOP concept IDs using a
+// ConceptMap; unmapped codes are exported with a concept ID of 0 and the
+// source code preserved in the *_source_value column.
+type OMOPExporter struct {
+	outputDir string
+	concepts  *ConceptMap
+	files     []*os.File
+	tables    map[string]*csv.Writer
+	ids       map[string]int64
+}
+
+func init() {
+	Register("omop", func(options Options) (Exporter, error) {
+		concepts := NewConceptMap()
+		if path := options["omop.concepts"]; path != "" {
+			var err error
+			concepts, err = LoadConceptMap(path)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return NewOMOPExporter(concepts), nil
+	})
+}
+
+// NewOMOPExporter returns a new OMOPExporter. If concepts is nil all
+// codes are left unmapped.
+func NewOMOPExporter(concepts *ConceptMap) *OMOPExporter {
+	if concepts == nil {
+		concepts = NewConceptMap()
+	}
+	return &OMOPExporter{
+		concepts: concepts,
+		tables:   make(map[string]*csv.Writer),
+		ids:      make(map[string]int64),
+	}
+}
+
+// Init creates each OMOP table in outputDir and writes its header.
+func (o *OMOPExporter) Init(outputDir string) error {
+	o.outputDir = outputDir
+	err := os.MkdirAll(outputDir, 0755)
+	if err != nil {
+		return err
+	}
+
+	for _, table := range omopTables {
+		file, err := os.Create(filepath.Join(outputDir, table.name+".csv"))
+		if err != nil {
+			o.Close()
+			return err
+		}
+		o.files = append(o.files, file)
+		o.tables[table.name] = csv.NewWriter(file)
+		o.tables[table.name].Write(table.columns)
+	}
+	return nil
+}
+
+// Export appends the patient and their record to the OMOP tables.
+func (o *OMOPExporter) Export(patient *entity.Patient, record *records.Record) error {
+	personID := o.nextID("person")
+	person := strconv.FormatInt(personID, 10)
+	birth := patient.BirthDate()
+
+	ethnicity := omopNotHispanicConcept
+	if patient.Race() == "Hispanic" {
+		ethnicity = omopHispanicConcept
+	}
+	o.write("person",
+		person,
+		strconv.Itoa(omopGenderConcept(patient.Gender())),
+		strconv.Itoa(birth.Year()),
+		strconv.Itoa(int(birth.Month())),
+		strconv.Itoa(birth.Day()),
+		omopDatetime(birth),
+		strconv.FormatInt(omopRaceConcepts[patient.Race()], 10),
+		strconv.Itoa(ethnicity),
+		omopPersonSourceValue(patient),
+		patient.Gender(),
+		patient.Race(),
+		patient.Ethnicity(),
+	)
+
+	end := observationPeriodEnd(patient, record)
+	o.write("observation_period",
+		o.nextIDString("observation_period"),
+		person,
+		omopDate(birth),
+		omopDate(end),
+		strconv.Itoa(omopEHRTypeConcept),
+	)
+
+	encounters := record.Encounters()
+	visits := make([]string, len(encounters))
+	for i, encounter := range encounters {
+		visits[i] = o.nextIDString("visit_occurrence")
+		stop := encounter.Stop
+		if stop.IsZero() {
+			stop = encounter.Start
+		}
+		o.write("visit_occurrence",
+			visits[i],
+			person,
+			strconv.FormatInt(omopVisitConcepts[encounter.Class], 10),
+			omopDate(encounter.Start),
+			omopDatetime(encounter.Start),
+			omopDate(stop),
+			omopDatetime(stop),
+			strconv.Itoa(omopEHRTypeConcept),
+			encounter.Class,
+		)
+	}
+	visitAt := func(t time.Time) string {
+		if i := encounterAt(encounters, t); i >= 0 {
+			return visits[i]
+		}
+		return ""
+	}
+
+	for _, condition := range record.Conditions() {
+		o.write("condition_occurrence",
+			o.nextIDString("condition_occurrence"),
+			person,
+			o.concept(condition.Codes),
+			omopDate(condition.Start),
+			omopDatetime(condition.Start),
+			omopDate(condition.Stop),
+			omopDatetime(condition.Stop),
+			strconv.Itoa(omopEHRTypeConcept),
+			visitAt(condition.Start),
+			sourceValue(condition.Codes),
+		)
+	}
+
+	for _, medication := range record.Medications() {
+		// drug_exposure_end_date is required, so active prescriptions
+		// end with the observation period.
+		stop := medication.Stop
+		if stop.IsZero() {
+			stop = end
+		}
+		o.writeDrugExposure(person, medication.Codes, medication.Start, stop, visitAt(medication.Start))
+	}
+	for _, immunization := range record.Immunizations() {
+		o.writeDrugExposure(person, immunization.Codes, immunization.Time, immunization.Time, visitAt(immunization.Time))
+	}
+
+	for _, observation := range record.Observations() {
+		o.write("measurement",
+			o.nextIDString("measurement"),
+			person,
+			o.concept(observation.Codes),
+			omopDate(observation.Time),
+			omopDatetime(observation.Time),
+			strconv.Itoa(omopEHRTypeConcept),
+			strconv.FormatFloat(observation.Value, 'f', -1, 64),
+			observation.Unit,
+			visitAt(observation.Time),
+			sourceValue(observation.Codes),
+		)
+	}
+
+	for _, procedure := range record.Procedures() {
+		o.write("procedure_occurrence",
+			o.nextIDString("procedure_occurrence"),
+			person,
+			o.concept(procedure.Codes),
+			omopDate(procedure.Time),
+			omopDatetime(procedure.Time),
+			strconv.Itoa(omopEHRTypeConcept),
+			visitAt(procedure.Time),
+			sourceValue(procedure.Codes),
+		)
+	}
+
+	if record.Expired() {
+		o.write("death",
+			person,
+			omopDate(record.DeathTime()),
+			omopDatetime(record.DeathTime()),
+			strconv.Itoa(omopEHRTypeConcept),
+			o.concept(record.CauseOfDeath()),
+			sourceValue(record.CauseOfDeath()),
+		)
+	}
+
+	for _, table := range omopTables {
+		if err := o.flush(table.name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close flushes and closes all of the OMOP tables.
+func (o *OMOPExporter) Close() error {
+	var err error
+	for _, table := range omopTables {
+		if _, ok := o.tables[table.name]; ok {
+			if ferr := o.flush(table.name); ferr != nil && err == nil {
+				err = ferr
+			}
+		}
+	}
+	for _, file := range o.files {
+		if cerr := file.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// omopState is the checkpoint state of an OMOPExporter.
+type omopState struct {
+	// Offsets are the sizes of the tables, by name.
+	Offsets map[string]int64 `json:"offsets"`
+	// IDs are the last IDs used in each table, by name.
+	IDs map[string]int64 `json:"ids"`
+}
+
+// Checkpoint flushes every table and returns their sizes and last IDs.
+func (o *OMOPExporter) Checkpoint() (json.RawMessage, error) {
+	state := omopState{Offsets: make(map[string]int64), IDs: o.ids}
+	for _, table := range omopTables {
+		if err := o.flush(table.name); err != nil {
+			return nil, err
+		}
+	}
+	offsets, err := fileOffsets(o.files)
+	if err != nil {
+		return nil, err
+	}
+	for i, table := range omopTables {
+		state.Offsets[table.name] = offsets[i]
+	}
+	return json.Marshal(state)
+}
+
+// Resume reopens each table in outputDir, truncated to its size at the
+// checkpoint, and continues numbering rows after the last IDs used.
+func (o *OMOPExporter) Resume(outputDir string, data json.RawMessage) error {
+	var state omopState
+	err := json.Unmarshal(data, &state)
+	if err != nil {
+		return fmt.Errorf("Invalid checkpoint: %s", err.Error())
+	}
+	o.outputDir = outputDir
+	var tables []string
+	for _, table := range omopTables {
+		tables = append(tables, table.name)
+	}
+	o.files, err = reopenTables(outputDir, tables, state.Offsets)
+	if err != nil {
+		return err
+	}
+	for i, table := range omopTables {
+		o.tables[table.name] = csv.NewWriter(o.files[i])
+	}
+	for table, id := range state.IDs {
+		o.ids[table] = id
+	}
+	return nil
+}
+
+func (o *OMOPExporter) writeDrugExposure(person string, codes []records.Code, start, stop time.Time, visit string) {
+	o.write("drug_exposure",
+		o.nextIDString("drug_exposure"),
+		person,
+		o.concept(codes),
+		omopDate(start),
+		omopDatetime(start),
+		omopDate(stop),
+		omopDatetime(stop),
+		strconv.Itoa(omopEHRTypeConcept),
+		visit,
+		sourceValue(codes),
+	)
+}
+
+func (o *OMOPExporter) write(table string, row ...string) {
+	o.tables[table].Write(row)
+}
+
+func (o *OMOPExporter) flush(table string) error {
+	o.tables[table].Flush()
+	return o.tables[table].Error()
+}
+
+func (o *OMOPExporter) concept(codes []records.Code) string {
+	return strconv.FormatInt(o.concepts.Lookup(codes), 10)
+}
+
+// nextID returns the next sequential ID for a table, starting at 1.
+func (o *OMOPExporter) nextID(table string) int64 {
+	o.ids[table]++
+	return o.ids[table]
+}
+
+func (o *OMOPExporter) nextIDString(table string) string {
+	return strconv.FormatInt(o.nextID(table), 10)
+}
+
+// omopPersonSourceValue returns the first of the patient's medical record
+// numbers, the one their hospital assigned, which links the person to the
+// patient's records in the other formats. Patients without one, such as
+// those of snapshots taken before identifiers were assigned, are
+// identified by their patient ID.
+func omopPersonSourceValue(patient *entity.Patient) string {
+	for _, id := range patient.Identifiers() {
+		if id.Type() == entity.IdentifierMRN {
+			return id.Value()
+		}
+	}
+	return patient.ID()
+}
+
+func omopGenderConcept(gender string) int {
+	switch gender {
+	case "Male":
+		return omopMaleConcept
+	case "Female":
+		return omopFemaleConcept
+	default:
+		return 0
+	}
+}
+
+func omopDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format("2006-01-02")
+}
+
+func omopDatetime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
+
+func sourceValue(codes []records.Code) string {
+	if len(codes) == 0 {
+		return ""
+	}
+	return codes[0].Code
+}
+
+// observationPeriodEnd returns the patient's time of death or, if they
+// are still alive, the time of the latest entry in their record.
+func observationPeriodEnd(patient *entity.Patient, record *records.Record) time.Time {
+	if record.Expired() {
+		return record.DeathTime()
+	}
+	end := patient.BirthDate()
+	latest := func(t time.Time) {
+		if t.After(end) {
+			end = t
+		}
+	}
+	for _, e := range record.Encounters() {
+		latest(e.Start)
+		latest(e.Stop)
+	}
+	for _, c := range record.Conditions() {
+		latest(c.Start)
+		latest(c.Stop)
+	}
+	for _, m := range record.Medications() {
+		latest(m.Start)
+		latest(m.Stop)
+	}
+	for _, o := range record.Observations() {
+		latest(o.Time)
+	}
+	for _, p := range record.Procedures() {
+		latest(p.Time)
+	}
+	for _, i := range record.Immunizations() {
+		latest(i.Time)
+	}
+	return end
+}
+
+// encounterAt returns the index of the latest encounter in progress at
+// time t, or -1 if there is none.
+func encounterAt(encounters []records.Encounter, t time.Time) int {
+	for i := len(encounters) - 1; i >= 0; i-- {
+		e := encounters[i]
+		if t.Equal(e.Start) || (t.After(e.Start) && !t.After(e.Stop)) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,91 @@
+package exporter
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/cjduffett/synthea/records"
+)
+
+// ConceptMap maps source codes (SNOMED-CT, LOINC, RxNorm, etc.) used in
+// GMF modules to OMOP standard concept IDs.
+type ConceptMap struct {
+	concepts map[string]int64
+}
+
+// NewConceptMap returns a new, empty ConceptMap.
+func NewConceptMap() *ConceptMap {
+	return &ConceptMap{
+		concepts: make(map[string]int64),
+	}
+}
+
+// LoadConceptMap loads a ConceptMap from a local CSV file. The file
+// must have a header row followed by rows of the form:
+//
+//	system,code,concept_id
+//	SNOMED-CT,44054006,201826
+//
+// Systems are matched case-insensitively.
+func LoadConceptMap(path string) (*ConceptMap, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = 3
+	reader.TrimLeadingSpace = true
+
+	// Skip the header
+	if _, err = reader.Read(); err != nil {
+		return nil, fmt.Errorf("Invalid concept map %s: %s", path, err.Error())
+	}
+
+	concepts := NewConceptMap()
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Invalid concept map %s: %s", path, err.Error())
+		}
+		conceptID, err := strconv.ParseInt(row[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid concept map %s: concept_id '%s' for %s %s is not an integer", path, row[2], row[0], row[1])
+		}
+		concepts.Add(row[0], row[1], conceptID)
+	}
+	return concepts, nil
+}
+
+// Add maps a source code to an OMOP concept ID.
+func (c *ConceptMap) Add(system, code string, conceptID int64) {
+	c.concepts[conceptKey(system, code)] = conceptID
+}
+
+// Lookup returns the OMOP concept ID of the first code that is mapped.
+// Following OMOP convention, unmapped codes have a concept ID of 0.
+func (c *ConceptMap) Lookup(codes []records.Code) int64 {
+	for _, code := range codes {
+		if id, ok := c.concepts[conceptKey(code.System, code.Code)]; ok {
+			return id
+		}
+	}
+	return 0
+}
+
+// Len returns the number of mapped codes.
+func (c *ConceptMap) Len() int {
+	return len(c.concepts)
+}
+
+func conceptKey(system, code string) string {
+	return strings.ToUpper(system) + "|" + code
+}
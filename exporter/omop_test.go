@@ -0,0 +1,95 @@
+package exporter
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cjduffett/synthea/entity"
+	"github.com/cjduffett/synthea/records"
+	"github.com/stretchr/testify/suite"
+)
+
+type OMOPTestSuite struct {
+	suite.Suite
+	outputDir string
+}
+
+func TestOMOPTestSuite(t *testing.T) {
+	suite.Run(t, new(OMOPTestSuite))
+}
+
+func (suite *OMOPTestSuite) SetupTest() {
+	suite.outputDir = suite.T().TempDir()
+}
+
+func (suite *OMOPTestSuite) TestLoadConceptMap() {
+	concepts, err := LoadConceptMap("../fixtures/exporter/omop_concepts.csv")
+	suite.Nil(err)
+	suite.Equal(3, concepts.Len())
+
+	codes := []records.Code{
+		{System: "SNOMED-CT", Code: "00000000"},
+		{System: "snomed-ct", Code: "44054006"},
+	}
+	suite.Equal(int64(201826), concepts.Lookup(codes))
+	suite.Equal(int64(0), concepts.Lookup(codes[:1]))
+}
+
+func (suite *OMOPTestSuite) TestLoadInvalidConceptMap() {
+	_, err := LoadConceptMap("../fixtures/exporter/invalid_omop_concepts.csv")
+	suite.NotNil(err)
+}
+
+func (suite *OMOPTestSuite) TestExport() {
+	concepts, err := LoadConceptMap("../fixtures/exporter/omop_concepts.csv")
+	suite.Nil(err)
+
+	omop := NewOMOPExporter(concepts)
+	suite.Nil(omop.Init(suite.outputDir))
+
+	patient, record := testPatientRecord()
+	suite.Nil(omop.Export(patient, record))
+	suite.Nil(omop.Close())
+
+	person := suite.readTable("person")
+	suite.Equal(2, len(person))
+	suite.Equal("1", person[1][0])
+	suite.Equal(patient.Identifiers()[0].Value(), person[1][8], "The person is identified by their hospital's MRN")
+	suite.Equal(entity.IdentifierMRN, patient.Identifiers()[0].Type())
+
+	conditions := suite.readTable("condition_occurrence")
+	suite.Equal(3, len(conditions))
+	suite.Equal("201826", conditions[1][2])
+	suite.Equal("1", conditions[1][8], "Condition should reference the visit it was diagnosed in")
+	suite.Equal("", conditions[1][5], "Active condition should not have an end date")
+	suite.Equal("0", conditions[2][2], "Unmapped code should have concept 0")
+	suite.Equal("444814009", conditions[2][9])
+
+	drugs := suite.readTable("drug_exposure")
+	suite.Equal(2, len(drugs))
+	suite.Equal("1503297", drugs[1][2])
+	suite.NotEmpty(drugs[1][5], "Drug exposure must have an end date")
+
+	measurements := suite.readTable("measurement")
+	suite.Equal(4, len(measurements))
+	suite.Equal("3004410", measurements[1][2])
+	suite.Equal("6.5", measurements[1][6])
+	suite.Equal("1", measurements[1][8])
+	suite.Equal("", measurements[2][8], "Measurement outside of an encounter should not reference a visit")
+
+	suite.Equal(2, len(suite.readTable("visit_occurrence")))
+	suite.Equal(2, len(suite.readTable("observation_period")))
+	suite.Equal(1, len(suite.readTable("death")))
+}
+
+func (suite *OMOPTestSuite) readTable(name string) [][]string {
+	file, err := os.Open(filepath.Join(suite.outputDir, name+".csv"))
+	suite.Require().Nil(err)
+	defer file.Close()
+
+	rows, err := csv.NewReader(file).ReadAll()
+	suite.Require().Nil(err)
+	return rows
+}
@@ -0,0 +1,367 @@
+package generator
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sort"
+	"time"
+
+	"github.com/cjduffett/synthea/config"
+	"github.com/cjduffett/synthea/entity"
+	"github.com/cjduffett/synthea/gmf"
+	"github.com/cjduffett/synthea/utils"
+)
+
+// MaxUnmatched is the number of patients an Iterator generates without
+// keeping any before it gives up. Keep filters that have not matched by
+// then are likely impossible for the loaded modules.
+const MaxUnmatched = 10000
+
+// Generator generates synthetic patients, as configured by a
+// config.Config. A Generator is immutable once created and is safe for
+// concurrent use; generated patients share nothing with each other.
+//
+// Every patient has an index. The same seed and index always generate
+// the same patient, so any patient can be regenerated exactly without
+// generating the patients before it. If the generator generates
+// households, each index generates a household of patients instead.
+type Generator struct {
+	startDate   time.Time
+	endDate     time.Time
+	step        time.Duration
+	seed        int64
+	population  int
+	birthCohort bool
+	households  bool
+	threads     int
+	pack        *entity.DataPack
+	modules     *gmf.GMF
+	keep        *gmf.Filter
+	towns       []*entity.Town
+	townTotals  []int // cumulative number of patients allocated to each town
+}
+
+// Patient is a generated patient.
+type Patient struct {
+	// Index is the patient's index in the run. Members of a household
+	// share the household's index.
+	Index int
+	// Member is the patient's position in their household, counting
+	// from 0 for the householder, or 0 if the generator does not generate
+	// households.
+	Member int
+	// Entity is the simulated patient: their demographics are in
+	// Entity.Patient and their medical record in Entity.Record.
+	Entity *entity.Entity
+	// Kept is false if the generator has a keep filter and the patient
+	// does not match it. Patients who are not kept may not have been
+	// simulated at all.
+	Kept bool
+}
+
+// New returns a new Generator configured by cfg. It loads the modules,
+// data pack, name dictionary, town demographics and keep filter that cfg
+// refers to, and returns an error if any module is invalid; see
+// gmf.Module.Validate.
+func New(cfg *config.Config) (*Generator, error) {
+	err := cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+	startDate, endDate, _ := cfg.Window()
+
+	g := &Generator{
+		startDate:   startDate,
+		endDate:     endDate,
+		step:        time.Duration(cfg.TimeStep) * 24 * time.Hour,
+		seed:        cfg.Seed,
+		population:  cfg.Population,
+		birthCohort: cfg.BirthCohort,
+		households:  cfg.Households,
+		threads:     cfg.Threads,
+		modules:     new(gmf.GMF),
+	}
+	if g.seed == 0 {
+		g.seed = time.Now().UnixNano()
+	}
+	if g.threads == 0 {
+		g.threads = runtime.NumCPU()
+	}
+
+	for _, dir := range cfg.ModuleDirs {
+		err = g.modules.Load(dir)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// Modules whose states do not fit together would otherwise fail
+	// every patient they are processed for.
+	err = g.modules.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	g.pack, err = entity.LoadDataPack(cfg.DataPack)
+	if err != nil {
+		return nil, err
+	}
+	names, err := entity.LoadNames(cfg.Names)
+	if err != nil {
+		return nil, err
+	}
+	g.pack = g.pack.WithNames(names, cfg.NameDigits)
+
+	if cfg.Demographics != "" {
+		g.towns, err = entity.LoadTowns(cfg.Demographics)
+		if err != nil {
+			return nil, err
+		}
+		err = g.pack.CheckTowns(g.towns)
+		if err != nil {
+			return nil, err
+		}
+		g.allocateTowns()
+	}
+
+	if cfg.Keep != "" {
+		g.keep, err = gmf.LoadFilter(cfg.Keep)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return g, nil
+}
+
+// allocateTowns allocates the generator's population to its towns.
+func (g *Generator) allocateTowns() {
+	g.townTotals = nil
+	total := 0
+	for _, count := range entity.AllocatePopulation(g.towns, g.population) {
+		total += count
+		g.townTotals = append(g.townTotals, total)
+	}
+}
+
+// WithSeed returns a copy of the generator that uses a different master
+// seed. The modules, data pack and towns are shared with g.
+func (g *Generator) WithSeed(seed int64) *Generator {
+	c := *g
+	c.seed = seed
+	return &c
+}
+
+// WithPopulation returns a copy of the generator that generates a
+// different number of living patients.
+func (g *Generator) WithPopulation(population int) *Generator {
+	c := *g
+	c.population = population
+	if len(c.towns) > 0 {
+		c.allocateTowns()
+	}
+	return &c
+}
+
+// WithFilter returns a copy of the generator that keeps only the
+// patients matching keep, or every patient if keep is nil.
+func (g *Generator) WithFilter(keep *gmf.Filter) *Generator {
+	c := *g
+	c.keep = keep
+	return &c
+}
+
+// Seed returns the master seed the generator derives every patient's
+// random numbers from. If no seed was configured it is picked from the
+// clock.
+func (g *Generator) Seed() int64 {
+	return g.seed
+}
+
+// Population returns the number of living patients an Iterator yields.
+func (g *Generator) Population() int {
+	return g.population
+}
+
+// Threads returns the number of patients an Iterator generates
+// concurrently.
+func (g *Generator) Threads() int {
+	return g.threads
+}
+
+// EndDate returns the end of the simulation.
+func (g *Generator) EndDate() time.Time {
+	return g.endDate
+}
+
+// Modules returns the names of the loaded modules, in the order they
+// are processed.
+func (g *Generator) Modules() []string {
+	return g.modules.Modules()
+}
+
+// NewProfile returns an empty profile of the loaded modules, which counts
+// the states generated patients reach. See gmf.GMF.NewProfile.
+func (g *Generator) NewProfile() *gmf.Profile {
+	return g.modules.NewProfile()
+}
+
+// WriteGraphviz writes a graph of each loaded module to dir, weighted by
+// profile if it is not nil. See gmf.GMF.WriteGraphviz.
+func (g *Generator) WriteGraphviz(dir string, profile *gmf.Profile) error {
+	return g.modules.WriteGraphviz(dir, profile)
+}
+
+// Generate creates and simulates the index-th patient. If the generator
+// has a keep filter, patients who cannot match it, for example because
+// they are the wrong gender, are not simulated, and simulation stops as
+// soon as a patient can no longer match it, for example because they
+// entered a state the filter excludes; see gmf.Filter.Possible. Either
+// way the patient is not kept. If the
+// generator generates households, Generate returns the householder of
+// the index-th household.
+//
+// Generate returns an error if ctx is done, or if the modules fail to
+// simulate the patient.
+func (g *Generator) Generate(ctx context.Context, index int) (*Patient, error) {
+	members, err := g.generate(ctx, index, 1)
+	if err != nil {
+		return nil, err
+	}
+	return members[0], nil
+}
+
+// GenerateHousehold creates and simulates every member of the index-th
+// household, householder first, as Generate does for a single patient.
+// See entity.DataPack.NewHousehold. The parents are simulated first, and
+// in full even if they cannot match the keep filter, so that children
+// who would have been born after their mother died are left out of the
+// household, and children born after their father died have no father;
+// see entity.RemoveUnborn. If the generator does not generate households,
+// the household is just the index-th patient.
+func (g *Generator) GenerateHousehold(ctx context.Context, index int) ([]*Patient, error) {
+	return g.generate(ctx, index, -1)
+}
+
+// generate creates the index-th patient, or household, and returns at
+// most limit of its members, or all of them if limit is negative. The
+// parents of a household are simulated whether or not they are returned.
+func (g *Generator) generate(ctx context.Context, index, limit int) (patients []*Patient, err error) {
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// The modules panic on errors they find while processing, for
+	// example a transition to a state that does not exist.
+	defer func() {
+		if r := recover(); r != nil {
+			patients = nil
+			err = fmt.Errorf("Failed to generate patient %d: %v", index, r)
+		}
+	}()
+
+	seed := utils.DeriveSeed(g.seed, index)
+	rng := utils.NewRand(seed)
+	town := g.townFor(index)
+	if !g.households {
+		var p *entity.Patient
+		if g.birthCohort {
+			p = g.pack.NewBirthCohortPatient(rng, g.startDate, g.endDate, town)
+		} else {
+			p = g.pack.NewPatient(rng, g.startDate, g.endDate, town)
+		}
+		return []*Patient{g.simulate(index, 0, entity.NewEntity(p, rng), false)}, nil
+	}
+
+	// Each member is simulated with their own random numbers, so that
+	// a member is simulated the same whether or not the others are.
+	// Children are only born to parents who are alive, so the parents
+	// are simulated first, in full even if they cannot match the keep
+	// filter, and then the children born after a parent died are
+	// removed.
+	var entities []*entity.Entity
+	parents := make(map[*entity.Entity]*Patient)
+	for m, p := range g.pack.NewHousehold(rng, g.startDate, g.endDate, town) {
+		e := entity.NewEntity(p, utils.NewRand(utils.DeriveSeed(seed, m)))
+		entities = append(entities, e)
+		if !isChild(p) {
+			parents[e] = g.simulate(index, m, e, true)
+		}
+	}
+	for m, e := range entity.RemoveUnborn(entities) {
+		if limit >= 0 && m >= limit {
+			break
+		}
+		p, ok := parents[e]
+		if !ok {
+			p = g.simulate(index, m, e, false)
+		}
+		patients = append(patients, p)
+	}
+	return patients, nil
+}
+
+// isChild returns true if the patient is a child in their household.
+func isChild(p *entity.Patient) bool {
+	for _, r := range p.Relations() {
+		if r.Relationship() == entity.RelationMother {
+			return true
+		}
+	}
+	return false
+}
+
+// simulate simulates a generated patient until the end date, unless the
+// generator has a keep filter, full is false, and the patient cannot
+// match the filter or stops being able to match it.
+func (g *Generator) simulate(index, member int, e *entity.Entity, full bool) *Patient {
+	var filter *gmf.Filter
+	if !full {
+		filter = g.keep
+	}
+	if filter != nil && !filter.Possible(e, g.endDate) {
+		return &Patient{Index: index, Member: member, Entity: e}
+	}
+	if !g.modules.SimulateMatching(e, e.Patient.BirthDate(), g.endDate, g.step, filter) {
+		return &Patient{Index: index, Member: member, Entity: e}
+	}
+	kept := g.keep == nil || g.keep.Keep(e, g.endDate)
+	return &Patient{Index: index, Member: member, Entity: e, Kept: kept}
+}
+
+// Snapshot returns a snapshot of a patient the generator generated, who
+// has been simulated until the end date. See entity.Snapshot.
+func (g *Generator) Snapshot(p *Patient) *entity.Snapshot {
+	return p.Entity.Snapshot(p.Entity.Patient.BirthDate(), g.endDate, g.step)
+}
+
+// Continue continues simulating the patient of a snapshot until the end
+// date, and advances the snapshot to it. See gmf.GMF.Continue. Continue
+// returns an error if the modules fail to simulate the patient, or if the
+// snapshot has no time step.
+func (g *Generator) Continue(s *entity.Snapshot) (err error) {
+	// The modules panic on errors they find while processing, as in
+	// generate.
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Failed to continue patient %s: %v", s.Entity.Patient.ID(), r)
+		}
+	}()
+	g.modules.Continue(s, g.endDate)
+	return nil
+}
+
+// townFor returns the town the i-th patient lives in, or nil if no town
+// demographics were provided. Patients are allocated to towns in
+// proportion to each town's population. Patients generated beyond the
+// population, to replace those who died, cycle through the towns again.
+func (g *Generator) townFor(i int) *entity.Town {
+	if len(g.towns) == 0 {
+		return nil
+	}
+	t := sort.SearchInts(g.townTotals, i%g.population+1)
+	if t == len(g.towns) {
+		// Past the allocated population, pick any town.
+		t = len(g.towns) - 1
+	}
+	return g.towns[t]
+}
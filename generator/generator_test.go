@@ -0,0 +1,321 @@
+package generator
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cjduffett/synthea/config"
+	"github.com/cjduffett/synthea/entity"
+	"github.com/cjduffett/synthea/gmf"
+	"github.com/stretchr/testify/suite"
+)
+
+type GeneratorTestSuite struct {
+	suite.Suite
+	moduleDirs []string
+}
+
+func TestGeneratorTestSuite(t *testing.T) {
+	suite.Run(t, new(GeneratorTestSuite))
+}
+
+func (suite *GeneratorTestSuite) SetupTest() {
+	suite.moduleDirs = []string{"../fixtures/modules/lifecycle", "../fixtures/modules/random"}
+}
+
+// generator returns a generator of a small population on the given
+// number of threads.
+func (suite *GeneratorTestSuite) generator(threads int) *Generator {
+	return suite.newGenerator(threads, false)
+}
+
+// householdGenerator returns a generator of a small population of
+// households on the given number of threads.
+func (suite *GeneratorTestSuite) householdGenerator(threads int) *Generator {
+	return suite.newGenerator(threads, true)
+}
+
+func (suite *GeneratorTestSuite) newGenerator(threads int, households bool) *Generator {
+	cfg := config.Default()
+	cfg.Population = 10
+	cfg.Seed = 42
+	cfg.EndDate = "2016-12-01"
+	cfg.Threads = threads
+	cfg.ModuleDirs = suite.moduleDirs
+	cfg.Households = households
+	g, err := New(cfg)
+	suite.Require().Nil(err)
+	return g
+}
+
+// collect returns the indices and names of every patient the iterator
+// yields.
+func (suite *GeneratorTestSuite) collect(it *Iterator) ([]int, []string) {
+	defer it.Close()
+	var indices []int
+	var names []string
+	for it.Next() {
+		p := it.Patient()
+		indices = append(indices, p.Index)
+		names = append(names, p.Entity.Patient.FirstName()+" "+p.Entity.Patient.LastName())
+	}
+	suite.Nil(it.Err())
+	return indices, names
+}
+
+func (suite *GeneratorTestSuite) TestNew() {
+	g := suite.generator(0)
+	suite.Equal(int64(42), g.Seed())
+	suite.Equal(10, g.Population())
+	suite.True(g.Threads() > 0, "0 threads means one per CPU")
+	suite.Equal([]string{"Lifecycle", "Random"}, g.Modules())
+}
+
+func (suite *GeneratorTestSuite) TestNewInvalidConfig() {
+	cfg := config.Default()
+	cfg.Population = -1
+	_, err := New(cfg)
+	suite.NotNil(err)
+}
+
+func (suite *GeneratorTestSuite) TestNewInvalidModule() {
+	cfg := config.Default()
+	cfg.ModuleDirs = []string{"../fixtures/modules/random", "../fixtures/modules/broken"}
+	_, err := New(cfg)
+	suite.Require().NotNil(err)
+	suite.Contains(err.Error(), "Invalid Module Broken")
+}
+
+func (suite *GeneratorTestSuite) TestGenerateIsDeterministic() {
+	first, err := suite.generator(1).Generate(context.Background(), 7)
+	suite.Require().Nil(err)
+	second, err := suite.generator(1).Generate(context.Background(), 7)
+	suite.Require().Nil(err)
+
+	suite.Equal(7, first.Index)
+	suite.True(first.Kept)
+	suite.Equal(first.Entity.Patient.ID(), second.Entity.Patient.ID())
+	suite.Equal(first.Entity.Patient.LastName(), second.Entity.Patient.LastName())
+	suite.Equal(first.Entity.Patient.BirthDate(), second.Entity.Patient.BirthDate())
+	suite.Equal(len(first.Entity.Record.Encounters()), len(second.Entity.Record.Encounters()))
+}
+
+func (suite *GeneratorTestSuite) TestIteratorIsIndependentOfThreads() {
+	it := suite.generator(1).Iterator(context.Background())
+	indices, names := suite.collect(it)
+	living, dead, rejected := it.Counts()
+	suite.Equal(10, living)
+	suite.Equal(0, rejected)
+	suite.Len(indices, living+dead)
+	for i, index := range indices {
+		suite.Equal(i, index, "Patients are yielded in index order")
+	}
+
+	threadedIndices, threadedNames := suite.collect(suite.generator(3).Iterator(context.Background()))
+	suite.Equal(indices, threadedIndices)
+	suite.Equal(names, threadedNames)
+}
+
+func (suite *GeneratorTestSuite) TestIteratorAt() {
+	indices, names := suite.collect(suite.generator(1).Iterator(context.Background()))
+
+	it := suite.generator(2).Iterator(context.Background())
+	for i := 0; i < 4; i++ {
+		suite.Require().True(it.Next())
+	}
+	pos := it.Position()
+	it.Close()
+	suite.Equal(indices[3]+1, pos.Next)
+	suite.Equal(4, pos.Living+pos.Dead)
+
+	resumed := suite.generator(3).IteratorAt(context.Background(), pos)
+	resumedIndices, resumedNames := suite.collect(resumed)
+	suite.Equal(indices[4:], resumedIndices)
+	suite.Equal(names[4:], resumedNames)
+	living, _, _ := resumed.Counts()
+	suite.Equal(10, living, "Counts continue from the position")
+}
+
+func (suite *GeneratorTestSuite) TestIteratorCanceled() {
+	ctx, cancel := context.WithCancel(context.Background())
+	it := suite.generator(2).Iterator(ctx)
+	defer it.Close()
+	suite.True(it.Next())
+	cancel()
+	suite.False(it.Next())
+	suite.Equal(context.Canceled, it.Err())
+
+	_, err := suite.generator(1).Generate(ctx, 0)
+	suite.Equal(context.Canceled, err)
+}
+
+func (suite *GeneratorTestSuite) TestIteratorClosed() {
+	it := suite.generator(2).Iterator(context.Background())
+	suite.True(it.Next())
+	it.Close()
+	it.Close()
+	suite.False(it.Next())
+	suite.Nil(it.Err())
+}
+
+func (suite *GeneratorTestSuite) TestModuleErrorIsReturned() {
+	// The loop module transitions between states that never block, which
+	// the modules report by panicking.
+	suite.moduleDirs = []string{"../fixtures/modules/invalid"}
+	g := suite.generator(2)
+
+	_, err := g.Generate(context.Background(), 0)
+	suite.NotNil(err)
+
+	it := g.Iterator(context.Background())
+	defer it.Close()
+	suite.False(it.Next())
+	suite.NotNil(it.Err())
+}
+
+func (suite *GeneratorTestSuite) TestContinue() {
+	g := suite.generator(1)
+	it := g.Iterator(context.Background())
+	suite.Require().True(it.Next())
+	for it.Patient().Entity.Record.Expired() {
+		suite.Require().True(it.Next())
+	}
+	p := it.Patient()
+	it.Close()
+	s := g.Snapshot(p)
+
+	cfg := config.Default()
+	cfg.EndDate = "2017-12-01"
+	cfg.ModuleDirs = []string{"../fixtures/modules/invalid"}
+	later, err := New(cfg)
+	suite.Require().Nil(err)
+	err = later.Continue(s)
+	suite.Require().NotNil(err)
+	suite.Contains(err.Error(), p.Entity.Patient.ID())
+
+	s.Step = 0
+	suite.NotNil(later.Continue(s), "A snapshot without a time step cannot be continued")
+}
+
+func (suite *GeneratorTestSuite) TestStream() {
+	g := suite.generator(2)
+	expected, _ := suite.collect(g.Iterator(context.Background()))
+
+	patients, errc := g.Stream(context.Background())
+	var indices []int
+	for p := range patients {
+		indices = append(indices, p.Index)
+	}
+	suite.Equal(expected, indices)
+	suite.Nil(<-errc)
+}
+
+func (suite *GeneratorTestSuite) TestHouseholds() {
+	suite.moduleDirs = []string{"../fixtures/modules/random"}
+	it := suite.householdGenerator(1).Iterator(context.Background())
+	var patients []*Patient
+	for it.Next() {
+		patients = append(patients, it.Patient())
+	}
+	suite.Nil(it.Err())
+	living, _, _ := it.Counts()
+	suite.True(living >= 10, "The last household is yielded in full")
+
+	households := 0
+	for i, p := range patients {
+		if p.Member == 0 {
+			households++
+			continue
+		}
+		householder := patients[i-p.Member]
+		suite.Equal(householder.Index, p.Index, "Members share their household's index")
+		suite.Equal(householder.Entity.Patient.Address(), p.Entity.Patient.Address())
+	}
+	suite.True(households < len(patients), "Some householders live with others")
+
+	members, err := suite.householdGenerator(1).GenerateHousehold(context.Background(), patients[0].Index)
+	suite.Require().Nil(err)
+	suite.Equal(patients[0].Entity.Patient.ID(), members[0].Entity.Patient.ID())
+	householder, err := suite.householdGenerator(1).Generate(context.Background(), patients[0].Index)
+	suite.Require().Nil(err)
+	suite.Equal(patients[0].Entity.Patient.ID(), householder.Entity.Patient.ID())
+
+	_, threadedNames := suite.collect(suite.householdGenerator(3).Iterator(context.Background()))
+	_, names := suite.collect(suite.householdGenerator(1).Iterator(context.Background()))
+	suite.Equal(names, threadedNames)
+}
+
+func (suite *GeneratorTestSuite) TestChildrenAreBornToLivingParents() {
+	// Everyone dies at 23 in the lifecycle module, long before most
+	// householders' children would have been born.
+	g := suite.householdGenerator(2).WithPopulation(100)
+	linked, dead := 0, 0
+	for index := 0; index < 100; index++ {
+		members, err := g.GenerateHousehold(context.Background(), index)
+		suite.Require().Nil(err)
+		byID := make(map[string]*Patient)
+		for _, p := range members {
+			byID[p.Entity.Patient.ID()] = p
+		}
+		for m, p := range members {
+			suite.Equal(m, p.Member)
+			for _, r := range p.Entity.Patient.Relations() {
+				relative, ok := byID[r.ID()]
+				suite.Require().True(ok, "Relations refer to members of the household")
+				if r.Relationship() != entity.RelationMother && r.Relationship() != entity.RelationFather {
+					continue
+				}
+				linked++
+				birth := p.Entity.Patient.BirthDate()
+				suite.True(relative.Entity.Alive(birth), "%s was born after their %s died", p.Entity.Patient.ID(), r.Relationship())
+				if relative.Entity.Record.Expired() {
+					dead++
+				}
+			}
+		}
+	}
+	suite.True(linked > 0, "Some children live with their parents")
+	suite.True(dead > 0, "Some children's parents have since died")
+}
+
+func (suite *GeneratorTestSuite) TestKeptHouseholdRelations() {
+	suite.moduleDirs = []string{"../fixtures/modules/random"}
+	keep, err := gmf.LoadFilter("../fixtures/keep/sick_women_over_50.json")
+	suite.Require().Nil(err)
+	it := suite.householdGenerator(2).WithFilter(keep).Iterator(context.Background())
+	yielded := make(map[string]bool)
+	var patients []*Patient
+	for it.Next() {
+		patients = append(patients, it.Patient())
+		yielded[it.Patient().Entity.Patient.ID()] = true
+	}
+	suite.Require().Nil(it.Err())
+	_, _, rejected := it.Counts()
+	suite.True(rejected > 0)
+
+	for _, p := range patients {
+		for _, r := range p.Entity.Patient.Relations() {
+			suite.True(yielded[r.ID()], "%s is related to %s, who was not kept", p.Entity.Patient.ID(), r.ID())
+		}
+	}
+}
+
+func (suite *GeneratorTestSuite) TestIteratorAtMidHousehold() {
+	suite.moduleDirs = []string{"../fixtures/modules/random"}
+	_, names := suite.collect(suite.householdGenerator(1).Iterator(context.Background()))
+
+	it := suite.householdGenerator(2).Iterator(context.Background())
+	yielded := 0
+	for it.Next() {
+		yielded++
+		if it.Position().Member > 0 {
+			break
+		}
+	}
+	pos := it.Position()
+	it.Close()
+	suite.Require().True(pos.Member > 0, "A household has more than one member")
+
+	_, resumedNames := suite.collect(suite.householdGenerator(3).IteratorAt(context.Background(), pos))
+	suite.Equal(names[yielded:], resumedNames)
+}
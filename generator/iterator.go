@@ -0,0 +1,259 @@
+package generator
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/cjduffett/synthea/entity"
+)
+
+// Iterator generates a population of patients one at a time, in index
+// order, until the generator's population of living patients has been
+// reached. If the generator generates households, the members of each
+// household are yielded in order, and the population is only checked
+// between households, so it may be exceeded by the size of the last
+// household. Patients who die before the end of the simulation are yielded
+// too, unless the generator has a keep filter; then only the patients it
+// keeps are yielded, all of whom are alive, and their relations only
+// refer to the members of their household who are yielded too.
+//
+// Patients are generated ahead of time on the generator's threads, but
+// are always yielded in the same order, so an Iterator yields the same
+// patients for any number of threads. Use it like a bufio.Scanner:
+//
+//	it := g.Iterator(ctx)
+//	defer it.Close()
+//	for it.Next() {
+//		patient := it.Patient()
+//		...
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type Iterator struct {
+	g       *Generator
+	ctx     context.Context
+	cancel  context.CancelFunc
+	tokens  chan struct{}
+	results chan result
+	workers sync.WaitGroup
+
+	pending  map[int]result
+	next     int
+	members  []*Patient // the rest of the household being yielded
+	member   int        // the number of its members already yielded
+	skip     int        // the members of the first household to skip
+	living   int
+	dead     int
+	rejected int
+	patient  *Patient
+	err      error
+	done     bool
+}
+
+// Position is how far an Iterator has got: the index of the next patient
+// to generate, and the counts of the patients generated before it. See
+// IteratorAt. If the Iterator is part way through a household, Next is
+// the household's index and Member is the number of its members already
+// yielded.
+type Position struct {
+	Next     int `json:"next"`
+	Member   int `json:"member,omitempty"`
+	Living   int `json:"living"`
+	Dead     int `json:"dead"`
+	Rejected int `json:"rejected"`
+}
+
+// result is a patient, or household, generated by a worker, waiting to
+// be yielded.
+type result struct {
+	index    int
+	patients []*Patient
+	err      error
+}
+
+// Iterator starts generating the generator's population. Generation
+// stops when the population is reached, when ctx is done, or when the
+// Iterator is closed.
+func (g *Generator) Iterator(ctx context.Context) *Iterator {
+	return g.IteratorAt(ctx, Position{})
+}
+
+// IteratorAt continues generating the generator's population from pos,
+// as returned by Iterator.Position, yielding the same patients the
+// Iterator that returned it would have yielded after it.
+func (g *Generator) IteratorAt(ctx context.Context, pos Position) *Iterator {
+	ctx, cancel := context.WithCancel(ctx)
+
+	// Workers may run at most window patients ahead of the consumer,
+	// which bounds the number of patients held in memory.
+	window := 4 * g.threads
+	it := &Iterator{
+		g:        g,
+		ctx:      ctx,
+		cancel:   cancel,
+		tokens:   make(chan struct{}, window),
+		results:  make(chan result, window),
+		pending:  make(map[int]result),
+		next:     pos.Next,
+		skip:     pos.Member,
+		living:   pos.Living,
+		dead:     pos.Dead,
+		rejected: pos.Rejected,
+	}
+
+	indices := make(chan int)
+	go func() {
+		defer close(indices)
+		for index := pos.Next; ; index++ {
+			select {
+			case it.tokens <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case indices <- index:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < g.threads; i++ {
+		it.workers.Add(1)
+		go func() {
+			defer it.workers.Done()
+			for index := range indices {
+				patients, err := g.GenerateHousehold(ctx, index)
+				// results has room for every patient in the window, so
+				// this never blocks.
+				it.results <- result{index: index, patients: patients, err: err}
+			}
+		}()
+	}
+	return it
+}
+
+// Next generates the next patient, which is then available through
+// Patient. It returns false when the population has been reached or
+// generation stopped; Err returns the error that stopped it, if any.
+func (it *Iterator) Next() bool {
+	it.patient = nil
+	for !it.done {
+		if len(it.members) == 0 && it.living >= it.g.population {
+			it.stop(nil)
+			break
+		}
+		if err := it.ctx.Err(); err != nil {
+			// Patients generated ahead are discarded.
+			it.stop(err)
+			break
+		}
+
+		if len(it.members) == 0 {
+			r, ok := it.pending[it.next]
+			for !ok {
+				select {
+				case r = <-it.results:
+					it.pending[r.index] = r
+					r, ok = it.pending[it.next]
+				case <-it.ctx.Done():
+					it.stop(it.ctx.Err())
+					return false
+				}
+			}
+			delete(it.pending, it.next)
+			<-it.tokens
+			it.next++
+
+			if r.err != nil {
+				it.stop(r.err)
+				break
+			}
+			if it.g.keep != nil {
+				unrelateRejected(r.patients)
+			}
+			it.members, it.member = r.patients[it.skip:], it.skip
+			it.skip = 0
+			continue
+		}
+
+		patient := it.members[0]
+		it.members = it.members[1:]
+		it.member++
+		if !patient.Kept {
+			it.rejected++
+			if it.rejected == MaxUnmatched && it.living+it.dead == 0 {
+				it.stop(fmt.Errorf("No patients matched the keep filter after %d patients were generated", MaxUnmatched))
+			}
+			continue
+		}
+
+		if patient.Entity.Alive(it.g.endDate) {
+			it.living++
+		} else {
+			it.dead++
+		}
+		it.patient = patient
+		return true
+	}
+	return false
+}
+
+// unrelateRejected removes the relations of the members of a household
+// to the members the keep filter rejected, who are not yielded.
+func unrelateRejected(members []*Patient) {
+	rejected := make(map[string]bool)
+	entities := make([]*entity.Entity, len(members))
+	for i, p := range members {
+		entities[i] = p.Entity
+		if !p.Kept {
+			rejected[p.Entity.Patient.ID()] = true
+		}
+	}
+	entity.RemoveRelations(entities, func(id string) bool { return rejected[id] })
+}
+
+// Patient returns the patient generated by the last call to Next.
+func (it *Iterator) Patient() *Patient {
+	return it.patient
+}
+
+// Err returns the error that stopped generation, or nil if the
+// population was reached or the Iterator was closed.
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// Counts returns the number of living and dead patients yielded so far,
+// and the number of patients rejected by the keep filter.
+func (it *Iterator) Counts() (living, dead, rejected int) {
+	return it.living, it.dead, it.rejected
+}
+
+// Position returns the position after the patient generated by the last
+// call to Next, from which IteratorAt continues.
+func (it *Iterator) Position() Position {
+	pos := Position{Next: it.next, Living: it.living, Dead: it.dead, Rejected: it.rejected}
+	if len(it.members) > 0 {
+		pos.Next, pos.Member = it.next-1, it.member
+	}
+	return pos
+}
+
+// Close stops generation and waits for the generator's threads to
+// finish. It is safe to call Close more than once.
+func (it *Iterator) Close() {
+	it.stop(nil)
+}
+
+func (it *Iterator) stop(err error) {
+	if it.done {
+		return
+	}
+	it.done = true
+	it.err = err
+	it.cancel()
+	it.workers.Wait()
+}
@@ -0,0 +1,28 @@
+package generator
+
+import "context"
+
+// Stream generates the generator's population like an Iterator, sending
+// each patient on the returned channel in index order. The channel is
+// closed when generation stops, after which exactly one value is sent on
+// the error channel: nil if the population was reached, otherwise the
+// error that stopped generation. Cancel ctx to stop generation early.
+func (g *Generator) Stream(ctx context.Context) (<-chan *Patient, <-chan error) {
+	patients := make(chan *Patient)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(patients)
+		it := g.Iterator(ctx)
+		defer it.Close()
+		for it.Next() {
+			select {
+			case patients <- it.Patient():
+			case <-ctx.Done():
+				errc <- ctx.Err()
+				return
+			}
+		}
+		errc <- it.Err()
+	}()
+	return patients, errc
+}
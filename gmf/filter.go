@@ -0,0 +1,217 @@
+package gmf
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/cjduffett/synthea/entity"
+)
+
+// Filter selects which generated patients to keep: those alive at the
+// end of the simulation who match a condition written in the GMF
+// condition language, for example
+//
+//	{
+//	  "condition_type": "And",
+//	  "conditions": [
+//	    {"condition_type": "Gender", "gender": "F"},
+//	    {"condition_type": "Age", "operator": ">", "quantity": 50, "unit": "years"},
+//	    {"condition_type": "Active Condition", "codes": [{"system": "SNOMED-CT", "code": "44054006"}]}
+//	  ]
+//	}
+type Filter struct {
+	condition Condition
+}
+
+// LoadFilter loads a filter from a JSON file holding a single condition.
+func LoadFilter(path string) (*Filter, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := ParseFilter(data)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid filter %s: %s", path, err.Error())
+	}
+	return filter, nil
+}
+
+// ParseFilter parses a filter from the JSON representation of a single
+// condition. It returns an error if the condition is invalid, including
+// the genders, units and operators that conditions in modules only
+// report when they are tested.
+func ParseFilter(data []byte) (filter *Filter, err error) {
+	var jsonCondition JSONCondition
+	err = json.Unmarshal(data, &jsonCondition)
+	if err != nil {
+		return nil, err
+	}
+
+	// parseCondition panics on invalid conditions, as it does when
+	// parsing a module.
+	defer func() {
+		if r := recover(); r != nil {
+			switch t := r.(type) {
+			case string:
+				err = errors.New(t)
+			case error:
+				err = t
+			default:
+				err = errors.New("Unknown panic")
+			}
+			filter = nil
+		}
+	}()
+	condition := parseCondition(jsonCondition)
+	err = validateCondition(condition)
+	if err != nil {
+		return nil, err
+	}
+	return &Filter{condition: condition}, nil
+}
+
+// comparisons are the operators that Age and Date conditions accept.
+var comparisons = map[string]bool{"==": true, "!=": true, "<": true, ">": true, "<=": true, ">=": true}
+
+// validateCondition returns an error if condition, or any condition it
+// combines, would panic when tested.
+func validateCondition(condition Condition) error {
+	var children []Condition
+	switch c := condition.(type) {
+	case *AndCondition:
+		children = c.conditions
+	case *OrCondition:
+		children = c.conditions
+	case *AtLeastCondition:
+		children = c.conditions
+	case *AtMostCondition:
+		children = c.conditions
+	case *NotCondition:
+		children = []Condition{c.condition}
+	case *GenderCondition:
+		if c.gender != "M" && c.gender != "F" {
+			return fmt.Errorf("'%s' is not a valid gender", c.gender)
+		}
+	case *AgeCondition:
+		if !comparisons[c.operator] {
+			return fmt.Errorf("'%s' is not a valid operator", c.operator)
+		}
+		if !isValidUnitOfTime(c.unit) {
+			return fmt.Errorf("'%s' is not a valid unit of time", c.unit)
+		}
+	case *DateCondition:
+		if !comparisons[c.operator] {
+			return fmt.Errorf("'%s' is not a valid operator", c.operator)
+		}
+	}
+	for _, child := range children {
+		err := validateCondition(child)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Keep returns true if the entity is alive at end and matches the
+// filter's condition then.
+func (f *Filter) Keep(e *entity.Entity, end time.Time) bool {
+	return e.Alive(end) && f.condition.test(e, end)
+}
+
+// Possible returns false if the entity cannot match the filter at end
+// however the rest of their simulation turns out. It is checked before
+// simulating an entity, so that entities who cannot be kept are not
+// simulated, and during the simulation; see GMF.SimulateMatching. Only
+// the parts of the condition that are fixed when the patient is created
+// are checked: gender, race, socioeconomic status, and age or date at
+// end, and the states the entity has already been in, which they will
+// still have been in at end. Anything else is assumed to be possible.
+func (f *Filter) Possible(e *entity.Entity, end time.Time) bool {
+	return possible(f.condition, e, end)
+}
+
+// possible returns false if condition cannot be true for the entity at
+// end.
+func possible(condition Condition, e *entity.Entity, end time.Time) bool {
+	switch c := condition.(type) {
+	case *AndCondition:
+		for _, child := range c.conditions {
+			if !possible(child, e, end) {
+				return false
+			}
+		}
+		return true
+	case *OrCondition:
+		for _, child := range c.conditions {
+			if possible(child, e, end) {
+				return true
+			}
+		}
+		return false
+	case *AtLeastCondition:
+		count := 0
+		for _, child := range c.conditions {
+			if possible(child, e, end) {
+				count++
+			}
+		}
+		return count >= c.minimum
+	case *AtMostCondition:
+		count := 0
+		for _, child := range c.conditions {
+			if certain(child, e, end) {
+				count++
+			}
+		}
+		return count <= c.maximum
+	case *NotCondition:
+		return !certain(c.condition, e, end)
+	}
+	if fixed(condition) {
+		return condition.test(e, end)
+	}
+	return true
+}
+
+// certain returns true if condition is certain to be true for the
+// entity at end.
+func certain(condition Condition, e *entity.Entity, end time.Time) bool {
+	if _, ok := condition.(*PriorStateCondition); ok {
+		// A state the entity has been in stays in its history.
+		return condition.test(e, end)
+	}
+	return fixed(condition) && condition.test(e, end)
+}
+
+// fixed returns true if the result of condition at the end of the
+// simulation is known when the patient is created.
+func fixed(condition Condition) bool {
+	switch c := condition.(type) {
+	case *GenderCondition, *RaceCondition, *SocioStatusCondition, *AgeCondition, *DateCondition, *TrueCondition, *FalseCondition:
+		return true
+	case *AndCondition:
+		return allFixed(c.conditions)
+	case *OrCondition:
+		return allFixed(c.conditions)
+	case *AtLeastCondition:
+		return allFixed(c.conditions)
+	case *AtMostCondition:
+		return allFixed(c.conditions)
+	case *NotCondition:
+		return fixed(c.condition)
+	}
+	return false
+}
+
+func allFixed(conditions []Condition) bool {
+	for _, c := range conditions {
+		if !fixed(c) {
+			return false
+		}
+	}
+	return true
+}
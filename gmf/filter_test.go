@@ -0,0 +1,97 @@
+package gmf
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cjduffett/synthea/entity"
+	"github.com/cjduffett/synthea/utils"
+	"github.com/stretchr/testify/suite"
+)
+
+type FilterTestSuite struct {
+	suite.Suite
+	entity *entity.Entity
+	end    time.Time
+}
+
+func TestFilterTestSuite(t *testing.T) {
+	suite.Run(t, new(FilterTestSuite))
+}
+
+func (suite *FilterTestSuite) SetupTest() {
+	suite.end = time.Date(2016, time.December, 1, 0, 0, 0, 0, time.UTC)
+	rng := utils.NewRand(1)
+	suite.entity = entity.NewEntity(entity.NewPatient(rng, suite.end.AddDate(-100, 0, 0), suite.end, nil), rng)
+}
+
+// parse parses a filter, replacing GENDER with the entity's gender and
+// OTHER with the other gender.
+func (suite *FilterTestSuite) parse(json string) *Filter {
+	gender, other := "M", "F"
+	if suite.entity.Patient.Gender() == "Female" {
+		gender, other = "F", "M"
+	}
+	json = strings.NewReplacer("OTHER", other, "GENDER", gender).Replace(json)
+	filter, err := ParseFilter([]byte(json))
+	suite.Require().Nil(err)
+	return filter
+}
+
+func (suite *FilterTestSuite) TestLoadFilter() {
+	filter, err := LoadFilter("../fixtures/keep/sick_women_over_50.json")
+	suite.Nil(err)
+	suite.NotNil(filter)
+
+	_, err = LoadFilter("../fixtures/keep/invalid.json")
+	suite.EqualError(err, "Invalid filter ../fixtures/keep/invalid.json: Unknown condition type 'Favorite Color'")
+
+	_, err = ParseFilter([]byte(`{"condition_type": "Not"}`))
+	suite.NotNil(err, "Not requires a condition")
+}
+
+func (suite *FilterTestSuite) TestLoadFilterInvalidValues() {
+	_, err := LoadFilter("../fixtures/keep/invalid_gender.json")
+	suite.EqualError(err, "Invalid filter ../fixtures/keep/invalid_gender.json: 'X' is not a valid gender")
+
+	_, err = ParseFilter([]byte(`{"condition_type": "Not", "condition": {"condition_type": "Age", "operator": "about", "quantity": 50, "unit": "years"}}`))
+	suite.EqualError(err, "'about' is not a valid operator")
+	_, err = ParseFilter([]byte(`{"condition_type": "Age", "operator": ">", "quantity": 50, "unit": "decades"}`))
+	suite.EqualError(err, "'decades' is not a valid unit of time")
+	_, err = ParseFilter([]byte(`{"condition_type": "Or", "conditions": [{"condition_type": "True"}, {"condition_type": "Date", "operator": "is nil", "year": 2000}]}`))
+	suite.EqualError(err, "'is nil' is not a valid operator")
+}
+
+func (suite *FilterTestSuite) TestKeep() {
+	suite.True(suite.parse(`{"condition_type": "Gender", "gender": "GENDER"}`).Keep(suite.entity, suite.end))
+	suite.False(suite.parse(`{"condition_type": "Gender", "gender": "OTHER"}`).Keep(suite.entity, suite.end))
+
+	suite.entity.Record.Death(suite.end.AddDate(0, 0, -1), nil)
+	suite.False(suite.parse(`{"condition_type": "True"}`).Keep(suite.entity, suite.end), "Dead patients are never kept")
+}
+
+func (suite *FilterTestSuite) TestPossible() {
+	possible := func(json string) bool {
+		return suite.parse(json).Possible(suite.entity, suite.end)
+	}
+	active := `{"condition_type": "Active Condition", "codes": [{"system": "SNOMED-CT", "code": "44054006"}]}`
+
+	suite.True(possible(`{"condition_type": "Gender", "gender": "GENDER"}`))
+	suite.False(possible(`{"condition_type": "Gender", "gender": "OTHER"}`))
+	suite.True(possible(active), "Conditions that depend on the simulation are always possible")
+	suite.False(possible(`{"condition_type": "And", "conditions": [` + active + `, {"condition_type": "Gender", "gender": "OTHER"}]}`))
+	suite.True(possible(`{"condition_type": "Or", "conditions": [` + active + `, {"condition_type": "Gender", "gender": "OTHER"}]}`))
+	suite.False(possible(`{"condition_type": "Not", "condition": {"condition_type": "Gender", "gender": "GENDER"}}`))
+	suite.True(possible(`{"condition_type": "Not", "condition": ` + active + `}`))
+	suite.False(possible(`{"condition_type": "At Least", "minimum": 2, "conditions": [` + active + `, {"condition_type": "False"}]}`))
+	suite.False(possible(`{"condition_type": "At Most", "maximum": 1, "conditions": [` + active + `, {"condition_type": "True"}, {"condition_type": "Gender", "gender": "GENDER"}]}`))
+	suite.False(possible(`{"condition_type": "Age", "operator": ">", "quantity": 200, "unit": "years"}`))
+
+	healthy := `{"condition_type": "Not", "condition": {"condition_type": "PriorState", "name": "Sick"}}`
+	suite.True(possible(healthy))
+	ctx := suite.entity.Context("Random")
+	ctx.Transition("Sick", suite.end.AddDate(-1, 0, 0))
+	ctx.Transition("Visit", suite.end.AddDate(-1, 0, 0))
+	suite.False(possible(healthy), "States the entity has been in stay in its history")
+}
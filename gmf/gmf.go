@@ -41,7 +41,6 @@ func (gmf *GMF) Load(moduleDir string) error {
 }
 
 func (gmf *GMF) loadModule(filePath string) error {
-	var err error
 	if !strings.HasSuffix(filePath, ".json") {
 		return errors.New("Not a valid JSON module file")
 	}
@@ -51,16 +50,28 @@ func (gmf *GMF) loadModule(filePath string) error {
 		return err
 	}
 
+	module, err := ParseModule(data)
+	if err != nil {
+		return err
+	}
+	gmf.modules = append(gmf.modules, *module)
+	return nil
+}
+
+// ParseModule parses a module from its JSON representation. It checks
+// that every state is valid, but not how the states fit together; see
+// Module.Validate.
+func ParseModule(data []byte) (*Module, error) {
 	// First load the module in its JSON representation
 	var jmodule JSONModule
-	err = json.Unmarshal(data, &jmodule)
+	err := json.Unmarshal(data, &jmodule)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// If the module doesn't have a name and states it's not valid
 	if jmodule.Name == "" && len(jmodule.JSONStates) == 0 {
-		return errors.New("Invalid Module: Missing 'name' or 'states'")
+		return nil, errors.New("Invalid Module: Missing 'name' or 'states'")
 	}
 
 	// Then parse the JSON representation into a concrete Module and States
@@ -69,18 +80,47 @@ func (gmf *GMF) loadModule(filePath string) error {
 	for name, jsonState := range jmodule.JSONStates {
 		state, err := parseState(name, jsonState)
 		if err != nil {
-			return fmt.Errorf("Invalid Module: %s", err.Error())
+			return nil, fmt.Errorf("Invalid Module: %s", err.Error())
 		}
 		module.states[name] = state
+		module.types[name] = jsonState.Type
+		module.edges[name] = parseEdges(jsonState)
 	}
-	gmf.modules = append(gmf.modules, *module)
+	return module, nil
+}
+
+// Run runs an entity through all of the modules at a given time,
+// in the order they were loaded. Modules stop processing as soon as
+// the entity dies.
+func (gmf *GMF) Run(entity *entity.Entity, time time.Time) {
+	for i := range gmf.modules {
+		if !entity.Alive(time) {
+			return
+		}
+		gmf.modules[i].Process(entity, time)
+	}
+}
 
-	fmt.Printf("Loaded module '%s'\n", module.name)
+// Validate checks how the states of each loaded module fit together, and
+// returns the error of the first module that fails. See Module.Validate.
+func (gmf *GMF) Validate() error {
+	for i := range gmf.modules {
+		err := gmf.modules[i].Validate()
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-// Run runs an entity through all of the modules at a given time.
-func (gmf *GMF) Run(entity *entity.Entity, time time.Time) {}
+// Modules returns the names of the loaded modules.
+func (gmf *GMF) Modules() []string {
+	names := make([]string, len(gmf.modules))
+	for i, module := range gmf.modules {
+		names[i] = module.name
+	}
+	return names
+}
 
 func getStateNames(stateMap map[string]JSONState) []string {
 	var keys []string
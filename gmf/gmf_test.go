@@ -2,6 +2,7 @@ package gmf
 
 import (
 	"errors"
+	"io/ioutil"
 	"testing"
 
 	"github.com/stretchr/testify/suite"
@@ -67,6 +68,35 @@ func (suite *GMFTestSuite) TestLoadModules() {
 	suite.Equal(0, len(keys))
 }
 
+func (suite *GMFTestSuite) TestParseModule() {
+	data, err := ioutil.ReadFile("../fixtures/gmf/basic_module.json")
+	suite.Require().Nil(err)
+	module, err := ParseModule(data)
+	suite.Nil(err)
+	suite.Equal("Basic Module", module.Name())
+	suite.Nil(module.Validate())
+
+	_, err = ParseModule([]byte("{"))
+	suite.NotNil(err)
+}
+
+func (suite *GMFTestSuite) TestValidateModule() {
+	module, err := ParseModule([]byte(`{
+		"name": "Broken",
+		"states": {
+			"Start": {"type": "Simple", "direct_transition": "Middle"},
+			"Middle": {"type": "Simple", "distributed_transition": [
+				{"distribution": 0.5, "transition": "End"},
+				{"distribution": 0.5, "transition": "Nowhere"}
+			]},
+			"End": {"type": "Terminal"}
+		}
+	}`))
+	suite.Require().Nil(err)
+	suite.Equal(errors.New("Invalid Module Broken: no Initial state; "+
+		"state 'Middle' transitions to state 'Nowhere', which does not exist"), module.Validate())
+}
+
 func getKeys(stateMap map[string]State) []string {
 	var keys []string
 	for key := range stateMap {
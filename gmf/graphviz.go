@@ -0,0 +1,198 @@
+package gmf
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// edge is a transition a state can take to another state, as written in
+// the module. Its label describes when the transition is taken.
+type edge struct {
+	to    string
+	label string
+}
+
+// parseEdges returns the transitions a state can take. Transitions to
+// the same state are merged into one edge.
+func parseEdges(jsonState JSONState) []edge {
+	var edges []edge
+	add := func(to, label string) {
+		for i := range edges {
+			if edges[i].to == to {
+				if label != "" {
+					edges[i].label = strings.TrimPrefix(edges[i].label+"\n"+label, "\n")
+				}
+				return
+			}
+		}
+		edges = append(edges, edge{to: to, label: label})
+	}
+
+	switch {
+	case jsonState.DirectTransition != "":
+		add(jsonState.DirectTransition, "")
+	case len(jsonState.DistributedTransition) > 0:
+		for _, d := range jsonState.DistributedTransition {
+			add(d.Transition, percent(d.Distribution))
+		}
+	case len(jsonState.ConditionalTransition) > 0:
+		for _, c := range jsonState.ConditionalTransition {
+			add(c.Transition, conditionLabel(c.Condition))
+		}
+	case len(jsonState.ComplexTransition) > 0:
+		for _, c := range jsonState.ComplexTransition {
+			for _, d := range c.Distributions {
+				add(d.Transition, conditionLabel(c.Condition)+": "+percent(d.Distribution))
+			}
+		}
+	}
+	return edges
+}
+
+// conditionLabel returns the type of a transition's condition, or
+// "else" if it has none.
+func conditionLabel(condition JSONCondition) string {
+	if condition.ConditionType == "" {
+		return "else"
+	}
+	return condition.ConditionType
+}
+
+// percent formats a probability as a percentage.
+func percent(p float64) string {
+	return fmt.Sprintf("%.3g%%", 100*p)
+}
+
+// WriteGraphviz writes a Graphviz DOT file for each loaded module to dir,
+// named after the module. If profile is not nil, each state is labeled
+// with how often it was entered, and each transition is labeled and
+// weighted by how often it was followed. States and transitions that
+// were never reached are dashed.
+func (gmf *GMF) WriteGraphviz(dir string, profile *Profile) error {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	for i := range gmf.modules {
+		m := &gmf.modules[i]
+		var mp *ModuleProfile
+		if profile != nil {
+			mp = profile.Modules[m.name]
+		}
+		path := filepath.Join(dir, dotFileName(m.name))
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		err = m.WriteGraphviz(f, mp)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to write graph %s: %s", path, err.Error())
+		}
+	}
+	return nil
+}
+
+// unsafeFileChars are the characters replaced in module file names.
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// dotFileName returns the name of the DOT file for a module.
+func dotFileName(module string) string {
+	return unsafeFileChars.ReplaceAllString(module, "_") + ".dot"
+}
+
+// WriteGraphviz writes the module as a Graphviz DOT digraph. If profile
+// is not nil, the graph is labeled and weighted by the profile's counts.
+func (m *Module) WriteGraphviz(w io.Writer, profile *ModuleProfile) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph %s {\n", dotQuote(m.name))
+	b.WriteString("  node [shape=box, style=rounded];\n")
+
+	names := make([]string, 0, len(m.states))
+	for name := range m.states {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		label := name + "\n" + m.types[name]
+		attrs := ""
+		if profile != nil {
+			entered := profile.States[name]
+			label += fmt.Sprintf("\nentered %d", entered)
+			if entered == 0 {
+				attrs = ", style=\"rounded,dashed\", color=gray"
+			}
+		}
+		fmt.Fprintf(&b, "  %s [label=%s%s];\n", dotQuote(name), dotQuote(label), attrs)
+	}
+
+	for _, name := range names {
+		var observed map[string]int
+		total := 0
+		if profile != nil {
+			observed = profile.Transitions[name]
+			for _, count := range observed {
+				total += count
+			}
+		}
+
+		drawn := make(map[string]bool)
+		for _, e := range m.edges[name] {
+			drawn[e.to] = true
+			writeEdge(&b, name, e.to, e.label, profile != nil, observed[e.to], total, "")
+		}
+
+		// Transitions that are not written in the module, for example
+		// to "Terminal" when no condition of a conditional transition
+		// matched.
+		var implicit []string
+		for to, count := range observed {
+			if !drawn[to] && count > 0 {
+				implicit = append(implicit, to)
+			}
+		}
+		sort.Strings(implicit)
+		for _, to := range implicit {
+			writeEdge(&b, name, to, "implicit", true, observed[to], total, ", style=dotted")
+		}
+	}
+
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeEdge writes a transition. If profiled, the edge is labeled with
+// the number of times it was followed and its share of the total
+// transitions out of the state, and its width is scaled by that share.
+func writeEdge(b *strings.Builder, from, to, label string, profiled bool, count, total int, attrs string) {
+	if profiled {
+		share := 0.0
+		if total > 0 {
+			share = float64(count) / float64(total)
+		}
+		observed := fmt.Sprintf("%d (%.1f%% observed)", count, 100*share)
+		label = strings.TrimPrefix(label+"\n"+observed, "\n")
+		if count == 0 && attrs == "" {
+			attrs = ", style=dashed, color=gray"
+		}
+		attrs = fmt.Sprintf(", penwidth=%.2f, weight=%d", 1+4*share, count+1) + attrs
+	}
+	fmt.Fprintf(b, "  %s -> %s [label=%s%s];\n", dotQuote(from), dotQuote(to), dotQuote(label), attrs)
+}
+
+// dotEscaper escapes a string for a quoted DOT ID.
+var dotEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// dotQuote returns s as a quoted DOT ID.
+func dotQuote(s string) string {
+	return `"` + dotEscaper.Replace(s) + `"`
+}
@@ -1,9 +1,12 @@
 package gmf
 
 import (
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/cjduffett/synthea/entity"
+	"github.com/cjduffett/synthea/records"
 )
 
 // Condition is an interface for all condition classes
@@ -91,17 +94,17 @@ func (n *NotCondition) test(entity *entity.Entity, time time.Time) bool {
 	return !n.condition.test(entity, time)
 }
 
-// GenderCondition tests if the patient is a given gender.
+// GenderCondition tests if the patient is a given gender, "M" or "F".
 type GenderCondition struct {
 	gender string
 }
 
 func (g *GenderCondition) test(entity *entity.Entity, time time.Time) bool {
-	// TODO: Test against the patient entity
 	if g.gender != "M" && g.gender != "F" {
-		panic("'%s' is not a valid gender")
+		panic(fmt.Sprintf("'%s' is not a valid gender", g.gender))
 	}
-	return true
+	// Patient genders are "Male" or "Female"
+	return strings.HasPrefix(entity.Patient.Gender(), g.gender)
 }
 
 // AgeCondition tests if the patient is a certain age.
@@ -112,19 +115,18 @@ type AgeCondition struct {
 }
 
 func (a *AgeCondition) test(entity *entity.Entity, time time.Time) bool {
-	age := normalizeUnitOfTime(a.quantity, a.unit)
-	// TODO: Compare against the patient entity
-	return compare(age, 100, a.operator)
+	age := ageInUnit(entity.Patient.BirthDate(), time, a.unit)
+	return compare(age, a.quantity, a.operator)
 }
 
-// SocioStatusCondition tests the socioeconomic status of the patient.
+// SocioStatusCondition tests the socioeconomic status of the patient,
+// "Low", "Middle", or "High".
 type SocioStatusCondition struct {
 	category string
 }
 
 func (s *SocioStatusCondition) test(entity *entity.Entity, time time.Time) bool {
-	// TODO: Test agains the patient entity
-	return s.category == "Middle"
+	return s.category == socioeconomicCategory(entity.Patient.Income())
 }
 
 // RaceCondition tests if the patient is a given race.
@@ -133,7 +135,7 @@ type RaceCondition struct {
 }
 
 func (r *RaceCondition) test(entity *entity.Entity, time time.Time) bool {
-	return r.race == "Hispanic"
+	return r.race == entity.Patient.Race()
 }
 
 // DateCondition compares the current world time to the specified date.
@@ -143,8 +145,7 @@ type DateCondition struct {
 }
 
 func (d *DateCondition) test(entity *entity.Entity, time time.Time) bool {
-	// TODO: compare against Synthea world
-	return compare(d.year, 1990, d.operator)
+	return compare(float64(time.Year()), float64(d.year), d.operator)
 }
 
 // AttributeCondition compares the specified value against an Attribute
@@ -156,8 +157,30 @@ type AttributeCondition struct {
 }
 
 func (a *AttributeCondition) test(entity *entity.Entity, time time.Time) bool {
-	// TODO: compare against patient entity
-	return false
+	value, ok := entity.Attributes[a.attribute]
+	switch a.operator {
+	case "is nil":
+		return !ok || value == nil
+	case "is not nil":
+		return ok && value != nil
+	}
+	if !ok {
+		return false
+	}
+
+	lhs, lok := toFloat(value)
+	rhs, rok := toFloat(a.value)
+	if lok && rok {
+		return compare(lhs, rhs, a.operator)
+	}
+	switch a.operator {
+	case "==":
+		return value == a.value
+	case "!=":
+		return value != a.value
+	default:
+		return false
+	}
 }
 
 // SymptomCondition tests the severity of a patient's symptom.
@@ -168,8 +191,7 @@ type SymptomCondition struct {
 }
 
 func (s *SymptomCondition) test(entity *entity.Entity, time time.Time) bool {
-	// TODO: compare against the patient entity
-	return false
+	return compare(float64(entity.Symptom(s.symptom)), s.value, s.operator)
 }
 
 // ObservationCondition tests if an observation has been performed
@@ -182,8 +204,24 @@ type ObservationCondition struct {
 }
 
 func (o *ObservationCondition) test(entity *entity.Entity, time time.Time) bool {
-	// TODO: compare against patient entity
-	return false
+	codes := referencedCodes(entity, o.referencedByAttribute, o.codes)
+
+	// Find the most recent observation with any of the codes
+	var latest *records.Observation
+	observations := entity.Record.Observations()
+	for i := len(observations) - 1; i >= 0 && latest == nil; i-- {
+		if anyCode(observations[i].Codes, codes) {
+			latest = &observations[i]
+		}
+	}
+
+	switch o.operator {
+	case "is nil":
+		return latest == nil
+	case "is not nil":
+		return latest != nil
+	}
+	return latest != nil && compare(latest.Value, o.value, o.operator)
 }
 
 // PriorStateCondition tests if a state has already been processed.
@@ -192,8 +230,7 @@ type PriorStateCondition struct {
 }
 
 func (p *PriorStateCondition) test(entity *entity.Entity, time time.Time) bool {
-	// TODO: compare against patient entity
-	return false
+	return entity.VisitedState(p.name)
 }
 
 // ActiveCondition tests if a condition previously diagnosed
@@ -204,7 +241,11 @@ type ActiveCondition struct {
 }
 
 func (a *ActiveCondition) test(entity *entity.Entity, time time.Time) bool {
-	// TODO: compare against patient entity
+	for _, code := range referencedCodes(entity, a.referencedByAttribute, a.codes) {
+		if entity.Record.ConditionIsActive(code) {
+			return true
+		}
+	}
 	return false
 }
 
@@ -216,7 +257,11 @@ type ActiveCarePlan struct {
 }
 
 func (a *ActiveCarePlan) test(entity *entity.Entity, time time.Time) bool {
-	// TODO: compare against patient entity
+	for _, code := range referencedCodes(entity, a.referencedByAttribute, a.codes) {
+		if entity.Record.CarePlanIsActive(code) {
+			return true
+		}
+	}
 	return false
 }
 
@@ -228,7 +273,11 @@ type ActiveMedication struct {
 }
 
 func (a *ActiveMedication) test(entity *entity.Entity, time time.Time) bool {
-	// TODO: compare against patient entity
+	for _, code := range referencedCodes(entity, a.referencedByAttribute, a.codes) {
+		if entity.Record.MedicationIsActive(code) {
+			return true
+		}
+	}
 	return false
 }
 
@@ -248,7 +297,7 @@ func (t *FalseCondition) test(entity *entity.Entity, time time.Time) bool {
 	return false
 }
 
-func compare(lhs, rhs int, operator string) bool {
+func compare(lhs, rhs float64, operator string) bool {
 	switch operator {
 	case "==":
 		return lhs == rhs
@@ -267,7 +316,28 @@ func compare(lhs, rhs int, operator string) bool {
 	case "is not nil":
 		return false
 	default:
-		panic("'%s' is not a valid operator")
+		panic(fmt.Sprintf("'%s' is not a valid operator", operator))
+	}
+}
+
+// ageInUnit returns the age of someone born at birth at the given time,
+// in whole years or months, or in fractional smaller units of time.
+func ageInUnit(birth, time time.Time, unit string) float64 {
+	switch unit {
+	case "years":
+		years := time.Year() - birth.Year()
+		if time.Before(birth.AddDate(years, 0, 0)) {
+			years--
+		}
+		return float64(years)
+	case "months":
+		months := (time.Year()-birth.Year())*12 + int(time.Month()-birth.Month())
+		if time.Before(birth.AddDate(0, months, 0)) {
+			months--
+		}
+		return float64(months)
+	default:
+		return time.Sub(birth).Seconds() / float64(normalizeUnitOfTime(1, unit))
 	}
 }
 
@@ -278,6 +348,8 @@ func normalizeUnitOfTime(quantity float64, unit string) int {
 		return int(quantity * 3600 * 24 * 30 * 12)
 	case "months":
 		return int(quantity * 3600 * 24 * 30)
+	case "weeks":
+		return int(quantity * 3600 * 24 * 7)
 	case "days":
 		return int(quantity * 3600 * 24)
 	case "hours":
@@ -287,6 +359,54 @@ func normalizeUnitOfTime(quantity float64, unit string) int {
 	case "seconds":
 		return int(quantity)
 	default:
-		panic("'%s' is not a valid unit of time")
+		panic(fmt.Sprintf("'%s' is not a valid unit of time", unit))
+	}
+}
+
+// socioeconomicCategory returns the socioeconomic status of a household
+// with the given annual income.
+func socioeconomicCategory(income int) string {
+	switch {
+	case income < 35000:
+		return "Low"
+	case income < 100000:
+		return "Middle"
+	default:
+		return "High"
+	}
+}
+
+// toFloat returns a numeric attribute value as a float64.
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+// referencedCodes returns the codes assigned to attribute if it is
+// given, otherwise codes.
+func referencedCodes(entity *entity.Entity, attribute string, codes []Code) []records.Code {
+	if attribute != "" {
+		referenced, _ := entity.Attributes[attribute].([]records.Code)
+		return referenced
 	}
+	return convertCodes(codes)
+}
+
+// anyCode returns true if any of want is in codes.
+func anyCode(codes, want []records.Code) bool {
+	for _, code := range codes {
+		for _, w := range want {
+			if code.System == w.System && code.Code == w.Code {
+				return true
+			}
+		}
+	}
+	return false
 }
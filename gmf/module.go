@@ -1,84 +1,154 @@
 package gmf
 
-import "fmt"
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cjduffett/synthea/entity"
+	"github.com/cjduffett/synthea/records"
+)
+
+// maxTransitions is the most transitions a module may make in a single
+// time step. Modules that loop without a blocking state (a Delay, Guard,
+// or Terminal) would otherwise never finish processing.
+const maxTransitions = 10000
 
 // Module is a GMF module, for example "Diabetes". Each JSON module
-// is loaded into this struct for processing by the GMF.
+// is loaded into this struct for processing by the GMF. Modules are
+// never modified while processing an entity; each entity's progress
+// through a module is kept in its own entity.ModuleContext.
 type Module struct {
-	name    string
-	states  map[string]State
-	context *Context
+	name   string
+	states map[string]State
+	// types and edges are the JSON type of each state and the
+	// transitions it can take, kept for drawing the module.
+	types map[string]string
+	edges map[string][]edge
 }
 
 // NewModule returns a new initialized GMF module.
 func NewModule(name string) *Module {
 	return &Module{
-		name:    name,
-		states:  make(map[string]State),
-		context: NewContext(),
+		name:   name,
+		states: make(map[string]State),
+		types:  make(map[string]string),
+		edges:  make(map[string][]edge),
 	}
 }
 
-// Context is the current context of a GMF module, including the current
-// state being processed and the module's history of processed states.
-type Context struct {
-	history      []State
-	currentState State
+// Name returns the name of the module.
+func (m *Module) Name() string {
+	return m.name
 }
 
-// NewContext returns a new initialized module context.
-func NewContext() *Context {
-	return &Context{
-		history:      []State{},
-		currentState: nil,
+// Validate checks how the module's states fit together: that it has an
+// Initial state and that every transition is to a state in the module.
+// Modules that fail these checks panic when they are processed. All the
+// problems found are returned in one error, sorted by state.
+func (m *Module) Validate() error {
+	var problems []string
+	if _, ok := m.states["Initial"]; !ok {
+		problems = append(problems, "no Initial state")
 	}
-}
-
-// Init initializes (or re-initializes) the module, readying it to
-// process a patient's record.
-func (m *Module) Init() {
-	m.context.history = make([]State, len(m.states))
-	initial, ok := m.states["Initial"]
-	if !ok {
-		panic(fmt.Sprintf("No Initial state found in module %s", m.name))
+	for from, edges := range m.edges {
+		for _, e := range edges {
+			if _, ok := m.states[e.to]; !ok {
+				problems = append(problems, fmt.Sprintf("state '%s' transitions to state '%s', which does not exist", from, e.to))
+			}
+		}
+	}
+	if len(problems) == 0 {
+		return nil
 	}
-	m.context.currentState = initial
+	sort.Strings(problems)
+	return fmt.Errorf("Invalid Module %s: %s", m.name, strings.Join(problems, "; "))
 }
 
-/*
-
-// Process processes the next state(s) in the module until
-// a blocking state or the "Terminal" state is reached.
-func (m *Module) Process(entity *entity.Entity, time time.Time) {
-
-	// Time is passed as a pointer so Delay states may modify (rewind)
-	// the time within this module without modifying the global
-	// simulation time.
-		for m.context.currentState.process(entity, &time) {
-			nextStateName := m.context.currentState.transition()
-			nextState, ok := m.states[nextStateName]
-
-			if currentState.name == nextState.name {
+// Context is the context a module processes an entity in: the module
+// itself and the entity's progress through it.
+type Context struct {
+	*entity.ModuleContext
+	module *Module
+}
 
-			}
+// Process processes the entity's next state(s) in the module at the
+// given time, until a blocking state or a "Terminal" state is reached
+// or the entity dies. It returns the time the module next needs to be
+// processed: when a Delay ends, when a wellness Encounter is scheduled,
+// or the given time if a Guard should be re-checked at the next
+// opportunity. It returns the zero time if the
+// module never needs to be processed again.
+//
+// States entered during this call are processed at the time they were
+// entered, which may be before time if the entity just left a Delay. A
+// blocking state entered during an earlier call is processed at time.
+func (m *Module) Process(e *entity.Entity, time time.Time) (wake time.Time) {
+	ctx := &Context{ModuleContext: e.Context(m.name), module: m}
+	if ctx.CurrentState == "" {
+		if _, ok := m.states["Initial"]; !ok {
+			panic(fmt.Sprintf("No Initial state found in module %s", m.name))
+		}
+		ctx.Transition("Initial", time)
+	}
 
-			if !ok {
-				panic(fmt.Sprintf("Attempted to transition to state '%s': state not found", nextStateName))
-			}
-			if nextState != m.context.currentState {
-				m.context.history = append(m.context.history, m.context.currentState)
-			} else {
-				// This state loops back to itself. Just upate the timestamps.
+	at := time
+	for i := 0; i < maxTransitions; i++ {
+		state, ok := m.states[ctx.CurrentState]
+		if !ok {
+			panic(fmt.Sprintf("Attempted to transition to state '%s' in module %s: state not found", ctx.CurrentState, m.name))
+		}
+		if !state.process(e, ctx, at) {
+			switch state.(type) {
+			case *TerminalState:
+				return wake
+			case *DelayState, *EncounterState:
+				return ctx.DelayUntil
+			default:
+				return at
 			}
+		}
 
-			m.context.currentState = nextState
+		exited := at
+		if !ctx.DelayUntil.IsZero() {
+			// Rewind to the time the delay ended, or the wellness
+			// encounter was scheduled, so that the states that follow
+			// it happen at the right time.
+			exited = ctx.DelayUntil
+		}
+		ctx.Transition(state.next(e, exited), exited)
+		at = exited
+		if !e.Alive(at) {
+			return wake
 		}
+	}
+	panic(fmt.Sprintf("Module %s made more than %d transitions in one time step, stuck at state '%s'", m.name, maxTransitions, ctx.CurrentState))
 }
 
-// Processed returns true if the module has been fully processed and
-// the module has reached a Terminal state.
-func (m *Module) Processed() bool {
-	return m.currentState.(type) == Terminal
+// codesOf returns the codes of the named state in the context's module,
+// or nil if there is no state with that name or it has no codes.
+func (c *Context) codesOf(name string) []records.Code {
+	switch state := c.module.states[name].(type) {
+	case *ConditionOnsetState:
+		return convertCodes(state.codes)
+	case *MedicationOrderState:
+		return convertCodes(state.codes)
+	case *CarePlanStartState:
+		return convertCodes(state.codes)
+	case *EncounterState:
+		return convertCodes(state.codes)
+	case *ProcedureState:
+		return convertCodes(state.codes)
+	}
+	return nil
 }
 
-*/
+// convertCodes converts GMF codes into record codes.
+func convertCodes(codes []Code) []records.Code {
+	converted := make([]records.Code, len(codes))
+	for i, code := range codes {
+		converted[i] = records.Code{System: code.System, Code: code.Code, Display: code.Display}
+	}
+	return converted
+}
@@ -0,0 +1,86 @@
+package gmf
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cjduffett/synthea/entity"
+	"github.com/cjduffett/synthea/records"
+	"github.com/cjduffett/synthea/utils"
+	"github.com/stretchr/testify/suite"
+)
+
+type ModuleTestSuite struct {
+	suite.Suite
+	gmf    *GMF
+	entity *entity.Entity
+	birth  time.Time
+}
+
+func TestModuleTestSuite(t *testing.T) {
+	suite.Run(t, new(ModuleTestSuite))
+}
+
+func (suite *ModuleTestSuite) SetupTest() {
+	suite.gmf = new(GMF)
+	suite.Require().Nil(suite.gmf.loadModule("../fixtures/modules/lifecycle/lifecycle.json"))
+
+	endTime := time.Date(2016, time.December, 1, 0, 0, 0, 0, time.UTC)
+	rng := utils.NewRand(1)
+	suite.entity = entity.NewEntity(entity.NewPatient(rng, endTime.AddDate(-100, 0, 0), endTime, nil), rng)
+	suite.birth = suite.entity.Patient.BirthDate()
+}
+
+func (suite *ModuleTestSuite) TestProcessBlocksOnGuard() {
+	suite.gmf.Run(suite.entity, suite.birth)
+	ctx := suite.entity.Context("Lifecycle")
+	suite.Equal("Adult", ctx.CurrentState)
+	suite.Equal(1, len(ctx.History))
+	suite.Equal("Initial", ctx.History[0].Name)
+
+	suite.gmf.Run(suite.entity, suite.birth.AddDate(17, 0, 0))
+	suite.Equal("Adult", ctx.CurrentState, "Patient is not 18 yet")
+}
+
+func (suite *ModuleTestSuite) TestProcessThroughLifetime() {
+	diagnosed := suite.birth.AddDate(18, 0, 1)
+	suite.gmf.Run(suite.entity, suite.birth)
+	suite.gmf.Run(suite.entity, diagnosed)
+
+	ctx := suite.entity.Context("Lifecycle")
+	record := &suite.entity.Record
+	diabetes := records.Code{System: "SNOMED-CT", Code: "44054006", Display: "Diabetes"}
+	suite.Equal("Wait", ctx.CurrentState)
+	suite.True(record.ConditionIsActive(diabetes))
+	suite.Equal([]records.Code{diabetes}, suite.entity.Attributes["diabetes"])
+	suite.Require().Equal(1, len(record.Encounters()))
+	suite.Equal(diagnosed, record.Encounters()[0].Start)
+	suite.Equal([]records.Code{diabetes}, record.Encounters()[0].Reason)
+	suite.Require().Equal(1, len(record.Observations()))
+	suite.Equal(7.0, record.Observations()[0].Value)
+
+	// The delay ends part way through the next time step; the states that
+	// follow it happen when it ended, not at the time step.
+	delayEnd := diagnosed.Add(5 * 365 * 24 * time.Hour)
+	suite.gmf.Run(suite.entity, delayEnd.AddDate(0, 0, 3))
+	suite.Equal("Terminal", ctx.CurrentState)
+	suite.False(record.ConditionIsActive(diabetes))
+	suite.Equal(delayEnd, record.Conditions()[0].Stop)
+	suite.Equal(1.0, suite.entity.Attributes["visits"])
+	suite.True(record.Expired())
+	suite.Equal(delayEnd, record.DeathTime())
+	suite.Equal([]records.Code{diabetes}, record.CauseOfDeath())
+	suite.False(suite.entity.Alive(delayEnd))
+	suite.True(suite.entity.Alive(delayEnd.Add(-time.Second)))
+}
+
+func (suite *ModuleTestSuite) TestRunStopsOnDeath() {
+	suite.entity.Record.Death(suite.birth, nil)
+	suite.gmf.Run(suite.entity, suite.birth)
+	suite.Equal("", suite.entity.Context("Lifecycle").CurrentState, "Dead patients should not be processed")
+}
+
+func (suite *ModuleTestSuite) TestProcessInfiniteLoop() {
+	suite.Require().Nil(suite.gmf.loadModule("../fixtures/modules/invalid/loop.json"))
+	suite.Panics(func() { suite.gmf.Run(suite.entity, suite.birth) })
+}
@@ -489,8 +489,11 @@ func parseCounterState(jsonState JSONState, transition Transition) *CounterState
 
 func parseDeathState(jsonState JSONState, transition Transition) *DeathState {
 	return &DeathState{
-		exact:      jsonState.Exact,
-		rng:        jsonState.Range,
-		transition: transition,
+		exact:                 jsonState.Exact,
+		rng:                   jsonState.Range,
+		conditionOnset:        jsonState.ConditionOnset,
+		referencedByAttribute: jsonState.ReferencedByAttribute,
+		codes:                 jsonState.Codes,
+		transition:            transition,
 	}
 }
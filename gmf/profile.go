@@ -0,0 +1,122 @@
+package gmf
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/cjduffett/synthea/entity"
+)
+
+// Profile counts how often patients entered each state of each module,
+// and how often they followed each transition between states, across a
+// run. It is used to find the paths patients actually take through a
+// module, and the states and transitions they never reach.
+type Profile struct {
+	Modules map[string]*ModuleProfile `json:"modules"`
+}
+
+// ModuleProfile counts the state entries and transitions of a module.
+type ModuleProfile struct {
+	// States counts how often each state was entered, keyed by state.
+	States map[string]int `json:"states"`
+	// Transitions counts how often each transition was followed, keyed
+	// by the state it leaves and then the state it enters.
+	Transitions map[string]map[string]int `json:"transitions"`
+}
+
+// NewProfile returns an empty profile of the loaded modules. Every state
+// and transition in the modules starts with a count of 0, so those that
+// are never reached are listed in the profile too.
+func (gmf *GMF) NewProfile() *Profile {
+	profile := &Profile{Modules: make(map[string]*ModuleProfile)}
+	for i := range gmf.modules {
+		m := &gmf.modules[i]
+		mp := profile.module(m.name)
+		for name := range m.states {
+			mp.States[name] = 0
+			for _, e := range m.edges[name] {
+				mp.transition(name)[e.to] = 0
+			}
+		}
+	}
+	return profile
+}
+
+// LoadProfile loads a profile written by Profile.WriteJSON.
+func LoadProfile(path string) (*Profile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	profile := new(Profile)
+	err = json.Unmarshal(data, profile)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid profile %s: %s", path, err.Error())
+	}
+	if profile.Modules == nil {
+		profile.Modules = make(map[string]*ModuleProfile)
+	}
+	return profile, nil
+}
+
+// Add counts the states the entity entered and the transitions it
+// followed in every module. It is not safe to call Add concurrently.
+func (p *Profile) Add(e *entity.Entity) {
+	for _, module := range e.Modules() {
+		ctx := e.Context(module)
+		if ctx.CurrentState == "" {
+			continue
+		}
+		mp := p.module(module)
+		previous := ""
+		for _, visit := range ctx.History {
+			mp.enter(previous, visit.Name)
+			previous = visit.Name
+		}
+		mp.enter(previous, ctx.CurrentState)
+	}
+}
+
+// WriteJSON writes the profile as indented JSON.
+func (p *Profile) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(p)
+}
+
+// module returns the profile of the named module, adding it if needed.
+func (p *Profile) module(name string) *ModuleProfile {
+	mp, ok := p.Modules[name]
+	if !ok {
+		mp = new(ModuleProfile)
+		p.Modules[name] = mp
+	}
+	if mp.States == nil {
+		mp.States = make(map[string]int)
+	}
+	if mp.Transitions == nil {
+		mp.Transitions = make(map[string]map[string]int)
+	}
+	return mp
+}
+
+// enter counts entering state from the previous state, or from no
+// state if previous is empty.
+func (mp *ModuleProfile) enter(previous, state string) {
+	mp.States[state]++
+	if previous != "" {
+		mp.transition(previous)[state]++
+	}
+}
+
+// transition returns the counts of the transitions out of state.
+func (mp *ModuleProfile) transition(state string) map[string]int {
+	counts, ok := mp.Transitions[state]
+	if !ok {
+		counts = make(map[string]int)
+		mp.Transitions[state] = counts
+	}
+	return counts
+}
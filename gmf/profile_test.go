@@ -0,0 +1,121 @@
+package gmf
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cjduffett/synthea/entity"
+	"github.com/cjduffett/synthea/utils"
+	"github.com/stretchr/testify/suite"
+)
+
+type ProfileTestSuite struct {
+	suite.Suite
+	gmf *GMF
+	now time.Time
+}
+
+func TestProfileTestSuite(t *testing.T) {
+	suite.Run(t, new(ProfileTestSuite))
+}
+
+func (suite *ProfileTestSuite) SetupTest() {
+	suite.gmf = new(GMF)
+	suite.Require().Nil(suite.gmf.loadModule("../fixtures/modules/random/random.json"))
+	suite.now = time.Date(2016, time.December, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// newEntity returns an entity that has visited the given states of the
+// Random module, in order.
+func (suite *ProfileTestSuite) newEntity(states ...string) *entity.Entity {
+	rng := utils.NewRand(1)
+	e := entity.NewEntity(entity.NewPatient(rng, suite.now.AddDate(-100, 0, 0), suite.now, nil), rng)
+	ctx := e.Context("Random")
+	for _, state := range states {
+		ctx.Transition(state, suite.now)
+	}
+	return e
+}
+
+func (suite *ProfileTestSuite) TestNewProfile() {
+	profile := suite.gmf.NewProfile()
+	random := profile.Modules["Random"]
+	suite.Require().NotNil(random)
+	suite.Len(random.States, 9)
+	suite.Equal(0, random.States["Terminal"])
+	suite.Equal(map[string]int{"Wait": 0, "Sick": 0, "Death": 0}, random.Transitions["Wait"])
+}
+
+func (suite *ProfileTestSuite) TestAdd() {
+	profile := suite.gmf.NewProfile()
+	profile.Add(suite.newEntity("Initial", "Wait", "Wait", "Sick"))
+	profile.Add(suite.newEntity("Initial", "Wait", "Death", "Terminal"))
+
+	random := profile.Modules["Random"]
+	suite.Equal(2, random.States["Initial"])
+	suite.Equal(3, random.States["Wait"])
+	suite.Equal(0, random.States["Recovery"], "States never reached are counted as 0")
+	suite.Equal(map[string]int{"Wait": 1, "Sick": 1, "Death": 1}, random.Transitions["Wait"])
+	suite.Equal(map[string]int{"Terminal": 1}, random.Transitions["Death"])
+}
+
+func (suite *ProfileTestSuite) TestWriteAndLoadProfile() {
+	profile := suite.gmf.NewProfile()
+	profile.Add(suite.newEntity("Initial", "Wait"))
+
+	var buf bytes.Buffer
+	suite.Nil(profile.WriteJSON(&buf))
+	path := filepath.Join(suite.T().TempDir(), "profile.json")
+	suite.Nil(ioutil.WriteFile(path, buf.Bytes(), 0644))
+
+	loaded, err := LoadProfile(path)
+	suite.Nil(err)
+	suite.Equal(profile, loaded)
+}
+
+func (suite *ProfileTestSuite) TestWriteGraphviz() {
+	profile := suite.gmf.NewProfile()
+	profile.Add(suite.newEntity("Initial", "Wait", "Wait", "Sick"))
+	profile.Add(suite.newEntity("Initial", "Wait", "Death", "Terminal"))
+
+	var buf bytes.Buffer
+	suite.Nil(suite.gmf.modules[0].WriteGraphviz(&buf, nil))
+	graph := buf.String()
+	suite.Contains(graph, `digraph "Random" {`)
+	suite.Contains(graph, `"Wait" [label="Wait\nDelay"];`)
+	suite.Contains(graph, `"Wait" -> "Sick" [label="35%"];`)
+	suite.Contains(graph, `"Initial" -> "Wait" [label=""];`)
+
+	buf.Reset()
+	suite.Nil(suite.gmf.modules[0].WriteGraphviz(&buf, profile.Modules["Random"]))
+	graph = buf.String()
+	suite.Contains(graph, `"Wait" [label="Wait\nDelay\nentered 3"];`)
+	suite.Contains(graph, `"Recovery" [label="Recovery\nDelay\nentered 0", style="rounded,dashed", color=gray];`)
+	suite.Contains(graph, `"Wait" -> "Sick" [label="35%\n1 (33.3% observed)", penwidth=2.33, weight=2];`)
+	suite.Contains(graph, `"Recovery" -> "Recovered" [label="0 (0.0% observed)", penwidth=1.00, weight=1, style=dashed, color=gray];`)
+
+	dir := suite.T().TempDir()
+	suite.Nil(suite.gmf.WriteGraphviz(dir, profile))
+	written, err := ioutil.ReadFile(filepath.Join(dir, "Random.dot"))
+	suite.Nil(err)
+	suite.Equal(graph, string(written))
+}
+
+func (suite *ProfileTestSuite) TestParseEdges() {
+	suite.Equal([]edge{{to: "Terminal", label: "Age\nelse"}}, parseEdges(JSONState{
+		ConditionalTransition: []JSONConditional{
+			{Condition: JSONCondition{ConditionType: "Age"}, Transition: "Terminal"},
+			{Transition: "Terminal"},
+		},
+	}))
+	suite.Equal([]edge{{to: "Sick", label: "Gender: 50%"}, {to: "Terminal", label: "Gender: 50%\nelse: 100%"}}, parseEdges(JSONState{
+		ComplexTransition: []JSONComplex{
+			{Condition: JSONCondition{ConditionType: "Gender"}, Distributions: []Distribution{{0.5, "Sick"}, {0.5, "Terminal"}}},
+			{Distributions: []Distribution{{1, "Terminal"}}},
+		},
+	}))
+	suite.Equal("Heart_Disease.dot", dotFileName("Heart Disease"))
+}
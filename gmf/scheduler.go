@@ -0,0 +1,129 @@
+package gmf
+
+import (
+	"container/heap"
+	"time"
+
+	"github.com/cjduffett/synthea/entity"
+)
+
+// Simulate runs an entity through all of the modules from start until
+// end, or until the entity dies. Modules are processed on a grid of time
+// steps from start, as if Run were called at every step, but each module
+// is only processed at the steps where it has something to do: when it
+// starts, when a Delay ends, when a wellness Encounter is scheduled, and
+// at every step while it waits on a Guard.
+// Modules in a Terminal state are never processed again.
+//
+// Simulate and stepping through Run produce the same records for the
+// same random seed; modules that are skipped would not have changed the
+// entity or drawn any random numbers.
+func (gmf *GMF) Simulate(entity *entity.Entity, start, end time.Time, step time.Duration) {
+	gmf.SimulateMatching(entity, start, end, step, nil)
+}
+
+// SimulateMatching simulates the entity as Simulate does, but stops as
+// soon as the entity can no longer match filter at end: before each time
+// step the modules are processed at, Filter.Possible is checked again.
+// It returns false if the simulation stopped for that reason. If filter
+// is nil the entity is simulated in full, as Simulate does.
+func (gmf *GMF) SimulateMatching(entity *entity.Entity, start, end time.Time, step time.Duration, filter *Filter) bool {
+	if step <= 0 {
+		panic("Simulation time step must be greater than 0")
+	}
+
+	events := make(eventQueue, 0, len(gmf.modules))
+	for i := range gmf.modules {
+		events = append(events, event{time: start, module: i})
+	}
+	heap.Init(&events)
+
+	for len(events) > 0 {
+		next := events[0].time
+		if next.After(end) || !entity.Alive(next) {
+			return true
+		}
+		if filter != nil && !filter.Possible(entity, end) {
+			return false
+		}
+
+		// Process every module due at this time step in the order the
+		// modules were loaded, as Run does.
+		var due []int
+		for len(events) > 0 && events[0].time.Equal(next) {
+			due = append(due, heap.Pop(&events).(event).module)
+		}
+		for _, i := range due {
+			if !entity.Alive(next) {
+				return true
+			}
+			wake := gmf.modules[i].Process(entity, next)
+			if !wake.IsZero() {
+				heap.Push(&events, event{time: nextStep(start, next, wake, step), module: i})
+			}
+		}
+	}
+	return true
+}
+
+// Continue continues simulating a snapshot's entity from the end of the
+// snapshot until end, on the same time steps, and advances the
+// snapshot's Until to end. The modules are processed at the same time
+// steps as if Simulate had simulated the entity until end in the first
+// place, except for modules the entity had not entered, which start at
+// the first time step after the snapshot.
+func (gmf *GMF) Continue(s *entity.Snapshot, end time.Time) {
+	if s.Step <= 0 {
+		panic("Simulation time step must be greater than 0")
+	}
+	if !end.After(s.Until) {
+		return
+	}
+	// Every module is processed at the first time step after the
+	// snapshot. A module that is waiting for a Delay to end returns the
+	// same wake up time it returned before the snapshot, and processing a
+	// Guard or Terminal state has no effect.
+	steps := s.Until.Sub(s.Start)/s.Step + 1
+	gmf.Simulate(s.Entity, s.Start.Add(steps*s.Step), end, s.Step)
+	s.Until = end
+}
+
+// nextStep returns the first time step on the grid from start that is
+// at or after wake, and after the current time step now.
+func nextStep(start, now, wake time.Time, step time.Duration) time.Time {
+	if !wake.After(now) {
+		return now.Add(step)
+	}
+	steps := (wake.Sub(start) + step - 1) / step
+	return start.Add(steps * step)
+}
+
+// event is a module that needs to be processed at a time step.
+type event struct {
+	time   time.Time
+	module int
+}
+
+// eventQueue is a priority queue of events, ordered by time and then by
+// module so that modules due at the same time are processed in order.
+type eventQueue []event
+
+func (q eventQueue) Len() int { return len(q) }
+
+func (q eventQueue) Less(i, j int) bool {
+	if q[i].time.Equal(q[j].time) {
+		return q[i].module < q[j].module
+	}
+	return q[i].time.Before(q[j].time)
+}
+
+func (q eventQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *eventQueue) Push(x interface{}) { *q = append(*q, x.(event)) }
+
+func (q *eventQueue) Pop() interface{} {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
@@ -0,0 +1,148 @@
+package gmf
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/cjduffett/synthea/entity"
+	"github.com/cjduffett/synthea/utils"
+	"github.com/stretchr/testify/suite"
+)
+
+type SchedulerTestSuite struct {
+	suite.Suite
+	gmf     *GMF
+	patient *entity.Patient
+	end     time.Time
+	step    time.Duration
+}
+
+func TestSchedulerTestSuite(t *testing.T) {
+	suite.Run(t, new(SchedulerTestSuite))
+}
+
+func (suite *SchedulerTestSuite) SetupTest() {
+	suite.gmf = new(GMF)
+	suite.Require().Nil(suite.gmf.loadModule("../fixtures/modules/lifecycle/lifecycle.json"))
+	suite.Require().Nil(suite.gmf.loadModule("../fixtures/modules/random/random.json"))
+
+	suite.end = time.Date(2016, time.December, 1, 0, 0, 0, 0, time.UTC)
+	suite.patient = entity.NewPatient(utils.NewRand(1), suite.end.AddDate(-100, 0, 0), suite.end, nil)
+	suite.step = 7 * 24 * time.Hour
+}
+
+func (suite *SchedulerTestSuite) TestSimulateMatchesStepping() {
+	suite.assertSimulateMatchesStepping()
+}
+
+func (suite *SchedulerTestSuite) TestSimulateMatchesSteppingWithWellness() {
+	suite.Require().Nil(suite.gmf.loadModule("../fixtures/modules/wellness/wellness.json"))
+	suite.assertSimulateMatchesStepping()
+
+	// Wellness encounters wait for the patient's schedule rather than
+	// happening at every step.
+	e := entity.NewEntity(suite.patient, utils.NewRand(1))
+	birth := e.Patient.BirthDate()
+	suite.gmf.Simulate(e, birth, birth.AddDate(10, 0, 0), suite.step)
+	var visits []time.Time
+	for _, encounter := range e.Record.Encounters() {
+		if encounter.Class == "wellness" {
+			visits = append(visits, encounter.Start)
+		}
+	}
+	suite.Require().True(len(visits) > 10)
+	suite.Equal(birth.AddDate(0, 6, 0), visits[0], "The first visit is at 6 months")
+	for i := 1; i < len(visits); i++ {
+		suite.True(visits[i].After(visits[i-1].AddDate(0, 5, 0)), "Visits %s and %s are too close", visits[i-1], visits[i])
+	}
+}
+
+// assertSimulateMatchesStepping asserts that Simulate produces the same
+// entities as calling Run at every time step, for a range of seeds.
+func (suite *SchedulerTestSuite) assertSimulateMatchesStepping() {
+	for seed := int64(1); seed <= 20; seed++ {
+		stepped := entity.NewEntity(suite.patient, utils.NewRand(seed))
+		birth := stepped.Patient.BirthDate()
+		for t := birth; !t.After(suite.end) && stepped.Alive(t); t = t.Add(suite.step) {
+			suite.gmf.Run(stepped, t)
+		}
+
+		simulated := entity.NewEntity(suite.patient, utils.NewRand(seed))
+		suite.gmf.Simulate(simulated, birth, suite.end, suite.step)
+
+		suite.Equal(stepped.Record, simulated.Record, "Records differ for seed %d", seed)
+		suite.Equal(stepped.Attributes, simulated.Attributes, "Attributes differ for seed %d", seed)
+		for _, module := range suite.gmf.Modules() {
+			suite.Equal(stepped.Context(module), simulated.Context(module), "Module %s differs for seed %d", module, seed)
+		}
+	}
+}
+
+func (suite *SchedulerTestSuite) TestSimulateMatchingStops() {
+	filter, err := ParseFilter([]byte(`{"condition_type": "Not", "condition": {"condition_type": "PriorState", "name": "Sick"}}`))
+	suite.Require().Nil(err)
+	stopped := 0
+	for seed := int64(1); seed <= 20; seed++ {
+		full := entity.NewEntity(suite.patient, utils.NewRand(seed))
+		birth := full.Patient.BirthDate()
+		suite.gmf.Simulate(full, birth, suite.end, suite.step)
+
+		matching := entity.NewEntity(suite.patient, utils.NewRand(seed))
+		if suite.gmf.SimulateMatching(matching, birth, suite.end, suite.step, filter) {
+			suite.False(full.VisitedState("Sick"), "Seed %d", seed)
+			suite.Equal(full.Record, matching.Record, "Records differ for seed %d", seed)
+			continue
+		}
+		// The simulation stops at the first time step after the entity
+		// first got sick.
+		stopped++
+		suite.True(full.VisitedState("Sick"), "Seed %d", seed)
+		suite.Require().Len(matching.Record.Conditions(), 1, "Seed %d", seed)
+		suite.Equal(full.Record.Conditions()[0].Start, matching.Record.Conditions()[0].Start, "Seed %d", seed)
+	}
+	suite.True(stopped > 0)
+}
+
+func (suite *SchedulerTestSuite) TestContinueMatchesStepping() {
+	middle := suite.end.AddDate(-40, 0, 0)
+	for seed := int64(1); seed <= 20; seed++ {
+		stepped := entity.NewEntity(suite.patient, utils.NewRand(seed))
+		birth := stepped.Patient.BirthDate()
+		t := birth
+		for ; !t.After(middle) && stepped.Alive(t); t = t.Add(suite.step) {
+			suite.gmf.Run(stepped, t)
+		}
+		stepped.Snapshot(birth, middle, suite.step)
+		for ; !t.After(suite.end) && stepped.Alive(t); t = t.Add(suite.step) {
+			suite.gmf.Run(stepped, t)
+		}
+
+		// Simulate until the middle, then continue from a copy restored
+		// from a snapshot.
+		simulated := entity.NewEntity(suite.patient, utils.NewRand(seed))
+		suite.gmf.Simulate(simulated, birth, middle, suite.step)
+		var buf bytes.Buffer
+		suite.Require().Nil(simulated.Snapshot(birth, middle, suite.step).WriteJSON(&buf))
+		snapshot, err := entity.ReadSnapshot(&buf)
+		suite.Require().Nil(err)
+		suite.gmf.Continue(snapshot, suite.end)
+		suite.Equal(suite.end, snapshot.Until)
+
+		expected, err := json.Marshal(stepped)
+		suite.Require().Nil(err)
+		actual, err := json.Marshal(snapshot.Entity)
+		suite.Require().Nil(err)
+		suite.JSONEq(string(expected), string(actual), "Entities differ for seed %d", seed)
+	}
+}
+
+func (suite *SchedulerTestSuite) TestNextStep() {
+	start := suite.end.AddDate(-1, 0, 0)
+	now := start.Add(2 * suite.step)
+
+	suite.Equal(start.Add(3*suite.step), nextStep(start, now, now, suite.step), "Re-check at the next step")
+	suite.Equal(start.Add(3*suite.step), nextStep(start, now, now.Add(time.Hour), suite.step), "Round up to the next step")
+	suite.Equal(start.Add(5*suite.step), nextStep(start, now, start.Add(5*suite.step), suite.step), "Wake exactly on a step")
+}
@@ -1,15 +1,17 @@
 package gmf
 
 import (
+	"fmt"
 	"math/rand"
 	"time"
 
 	"github.com/cjduffett/synthea/entity"
+	"github.com/cjduffett/synthea/records"
 )
 
 // State is an interface to all GMF state types.
 type State interface {
-	process(entity *entity.Entity, time time.Time) bool
+	process(entity *entity.Entity, ctx *Context, time time.Time) bool
 	next(entity *entity.Entity, time time.Time) string
 }
 
@@ -42,12 +44,12 @@ type Range struct {
 	Unit string `json:"unit"`
 }
 
-// Converts an Exact quantity to a duration of time. The
-// Unit field must be a valid unit of time.
-func (r *Range) convertToDuration() time.Duration {
+// Converts a Range to a random duration of time within it, picked
+// with rng. The Unit field must be a valid unit of time.
+func (r *Range) convertToDuration(rng *rand.Rand) time.Duration {
 	var pick int64
 	if r.High >= r.Low {
-		pick = rand.Int63n(r.High+1-r.Low) + r.Low
+		pick = rng.Int63n(r.High+1-r.Low) + r.Low
 		if isValidUnitOfTime(r.Unit) {
 			return convertTimeToDuration(pick, r.Unit)
 		}
@@ -56,13 +58,68 @@ func (r *Range) convertToDuration() time.Duration {
 	panic("'high' cannot be less than 'low'")
 }
 
+// pickDuration returns the exact duration if one is given, otherwise a
+// random duration within the range picked with r.
+func pickDuration(r *rand.Rand, exact Exact, rng Range) time.Duration {
+	if exact.Unit != "" {
+		return exact.convertToDuration()
+	}
+	return rng.convertToDuration(r)
+}
+
+// pickQuantity returns the exact quantity if one is given, otherwise a
+// random quantity within the range picked with r.
+func pickQuantity(r *rand.Rand, exact Exact, rng Range) float64 {
+	if rng.Low == 0 && rng.High == 0 {
+		return float64(exact.Quantity)
+	}
+	if rng.High < rng.Low {
+		panic("'high' cannot be less than 'low'")
+	}
+	return float64(rng.Low + r.Int63n(rng.High+1-rng.Low))
+}
+
+// assign sets the entity's attribute to value. An empty attribute name
+// is ignored so that states may optionally assign to an attribute.
+func assign(entity *entity.Entity, attribute string, value interface{}) {
+	if attribute == "" {
+		return
+	}
+	if entity.Attributes == nil {
+		entity.Attributes = make(map[string]interface{})
+	}
+	entity.Attributes[attribute] = value
+}
+
+// reasonCodes returns the codes of a reason, which may name either an
+// attribute assigned by an earlier state or a state in the module.
+func (c *Context) reasonCodes(entity *entity.Entity, reason string) []records.Code {
+	if reason == "" {
+		return nil
+	}
+	if codes, ok := entity.Attributes[reason].([]records.Code); ok {
+		return codes
+	}
+	return c.codesOf(reason)
+}
+
+// referencedCodes returns the codes an ending state refers to: those of
+// the named state if given, those assigned to the attribute if given,
+// otherwise the state's own codes.
+func (c *Context) referencedCodes(entity *entity.Entity, state, attribute string, codes []Code) []records.Code {
+	if state != "" {
+		return c.codesOf(state)
+	}
+	return referencedCodes(entity, attribute, codes)
+}
+
 // InitialState is the initial state of each module. All modules
 // should have one and only one Initial state.
 type InitialState struct {
 	transition Transition
 }
 
-func (i *InitialState) process(entity *entity.Entity, time time.Time) bool {
+func (i *InitialState) process(entity *entity.Entity, ctx *Context, time time.Time) bool {
 	return true
 }
 
@@ -77,7 +134,7 @@ type TerminalState struct {
 	transition Transition
 }
 
-func (t *TerminalState) process(entity *entity.Entity, time time.Time) bool {
+func (t *TerminalState) process(entity *entity.Entity, ctx *Context, time time.Time) bool {
 	// By returning false, Terminal blocks the further
 	// progression of the module forever, given this entity.
 	return false
@@ -95,7 +152,7 @@ type SimpleState struct {
 	transition Transition
 }
 
-func (s *SimpleState) process(entity *entity.Entity, time time.Time) bool {
+func (s *SimpleState) process(entity *entity.Entity, ctx *Context, time time.Time) bool {
 	return true
 }
 
@@ -109,7 +166,7 @@ type GuardState struct {
 	transition Transition
 }
 
-func (g *GuardState) process(entity *entity.Entity, time time.Time) bool {
+func (g *GuardState) process(entity *entity.Entity, ctx *Context, time time.Time) bool {
 	return g.allow.test(entity, time)
 }
 
@@ -128,16 +185,20 @@ type DelayState struct {
 	transition Transition
 }
 
-func (d *DelayState) process(entity *entity.Entity, time time.Time) bool {
-	// TODO: Delay state processing logic
-	return true
+func (d *DelayState) process(entity *entity.Entity, ctx *Context, time time.Time) bool {
+	if ctx.DelayUntil.IsZero() {
+		ctx.DelayUntil = ctx.Entered.Add(pickDuration(entity.Rand(), d.exact, d.rng))
+	}
+	return !ctx.DelayUntil.After(time)
 }
 
 func (d *DelayState) next(entity *entity.Entity, time time.Time) string {
 	return d.transition.follow(entity, time)
 }
 
-// EncounterState creates an encounter in the patient's record.
+// EncounterState creates an encounter in the patient's record. Wellness
+// encounters block module progression until the patient's next scheduled
+// wellness encounter.
 type EncounterState struct {
 	wellness   bool
 	class      string
@@ -146,20 +207,26 @@ type EncounterState struct {
 	transition Transition
 }
 
-func (e *EncounterState) process(entity *entity.Entity, time time.Time) bool {
-
-	// TODO: Encounter state processing logic
-
-	// If it's not a wellness encounter, process it immediately:
-	// 1. Scan the history for undiagnosed but prior conditions
-	// 2. Diagnose them here
-	// 3. Transition
-
-	// If it is a wellness encounter:
-	// 1. Check when the next scheduled wellness encounter is
-	// 2. Delay until that time
-	// 3. Based on adherence, either process the encounter or skip it
-
+func (e *EncounterState) process(entity *entity.Entity, ctx *Context, time time.Time) bool {
+	if e.wellness {
+		// Wellness encounters wait for the patient's next scheduled
+		// wellness encounter, and happen when it is scheduled.
+		if ctx.DelayUntil.IsZero() {
+			ctx.DelayUntil = entity.NextWellnessEncounter(ctx.Entered)
+		}
+		if ctx.DelayUntil.After(time) {
+			return false
+		}
+		time = ctx.DelayUntil
+		entity.SetLastWellnessEncounter(time)
+	}
+	entity.Record.AddEncounter(records.Encounter{
+		Class:  e.class,
+		Codes:  convertCodes(e.codes),
+		Reason: ctx.reasonCodes(entity, e.reason),
+		Start:  time,
+		Stop:   time,
+	})
 	return true
 }
 
@@ -176,8 +243,13 @@ type ConditionOnsetState struct {
 	transition        Transition
 }
 
-func (c *ConditionOnsetState) process(entity *entity.Entity, time time.Time) bool {
-	// TODO: ConditionOnset state logic
+func (c *ConditionOnsetState) process(entity *entity.Entity, ctx *Context, time time.Time) bool {
+	codes := convertCodes(c.codes)
+	entity.Record.AddCondition(records.Condition{
+		Codes: codes,
+		Start: time,
+	})
+	assign(entity, c.assignToAttribute, codes)
 	return true
 }
 
@@ -193,8 +265,10 @@ type ConditionEndState struct {
 	transition            Transition
 }
 
-func (c *ConditionEndState) process(entity *entity.Entity, time time.Time) bool {
-	// TODO: ConditionEnd processing logic
+func (c *ConditionEndState) process(entity *entity.Entity, ctx *Context, time time.Time) bool {
+	for _, code := range ctx.referencedCodes(entity, c.conditionOnset, c.referencedByAttribute, c.codes) {
+		entity.Record.EndCondition(code, time)
+	}
 	return true
 }
 
@@ -211,8 +285,14 @@ type MedicationOrderState struct {
 	transition        Transition
 }
 
-func (m *MedicationOrderState) process(entity *entity.Entity, time time.Time) bool {
-	// TODO: MedicationOrder processing logic
+func (m *MedicationOrderState) process(entity *entity.Entity, ctx *Context, time time.Time) bool {
+	codes := convertCodes(m.codes)
+	entity.Record.StartMedication(records.Medication{
+		Codes:  codes,
+		Reason: ctx.reasonCodes(entity, m.reason),
+		Start:  time,
+	})
+	assign(entity, m.assignToAttribute, codes)
 	return true
 }
 
@@ -228,8 +308,10 @@ type MedicationEndState struct {
 	transition            Transition
 }
 
-func (m *MedicationEndState) process(entity *entity.Entity, time time.Time) bool {
-	// TODO: MedicationEnd processing logic
+func (m *MedicationEndState) process(entity *entity.Entity, ctx *Context, time time.Time) bool {
+	for _, code := range ctx.referencedCodes(entity, m.medicationOrder, m.referencedByAttribute, m.codes) {
+		entity.Record.StopMedication(code, time)
+	}
 	return true
 }
 
@@ -247,8 +329,15 @@ type CarePlanStartState struct {
 	transition        Transition
 }
 
-func (c *CarePlanStartState) process(entity *entity.Entity, time time.Time) bool {
-	// TODO: CarePlanStart processing logic
+func (c *CarePlanStartState) process(entity *entity.Entity, ctx *Context, time time.Time) bool {
+	codes := convertCodes(c.codes)
+	entity.Record.StartCarePlan(records.CarePlan{
+		Codes:      codes,
+		Activities: convertCodes(c.activities),
+		Reason:     ctx.reasonCodes(entity, c.reason),
+		Start:      time,
+	})
+	assign(entity, c.assignToAttribute, codes)
 	return true
 }
 
@@ -264,8 +353,10 @@ type CarePlanEndState struct {
 	transition            Transition
 }
 
-func (c *CarePlanEndState) process(entity *entity.Entity, time time.Time) bool {
-	// TODO: CarePLanEnd processing logic
+func (c *CarePlanEndState) process(entity *entity.Entity, ctx *Context, time time.Time) bool {
+	for _, code := range ctx.referencedCodes(entity, c.careplan, c.referencedByAttribute, c.codes) {
+		entity.Record.StopCarePlan(code, time)
+	}
 	return true
 }
 
@@ -281,8 +372,12 @@ type ProcedureState struct {
 	transition      Transition
 }
 
-func (p *ProcedureState) process(entity *entity.Entity, time time.Time) bool {
-	// TODO: Procedure processing logic
+func (p *ProcedureState) process(entity *entity.Entity, ctx *Context, time time.Time) bool {
+	entity.Record.AddProcedure(records.Procedure{
+		Codes:  convertCodes(p.codes),
+		Reason: ctx.reasonCodes(entity, p.reason),
+		Time:   time,
+	})
 	return true
 }
 
@@ -301,8 +396,13 @@ type ObservationState struct {
 	transition      Transition
 }
 
-func (o *ObservationState) process(entity *entity.Entity, time time.Time) bool {
-	// TODO: Observation processing logic
+func (o *ObservationState) process(entity *entity.Entity, ctx *Context, time time.Time) bool {
+	entity.Record.AddObservation(records.Observation{
+		Codes: convertCodes(o.codes),
+		Value: pickQuantity(entity.Rand(), o.exact, o.rng),
+		Unit:  o.unit,
+		Time:  time,
+	})
 	return true
 }
 
@@ -321,8 +421,8 @@ type SymptomState struct {
 	transition Transition
 }
 
-func (s *SymptomState) process(entity *entity.Entity, time time.Time) bool {
-	// TODO: Symptom processing logic
+func (s *SymptomState) process(entity *entity.Entity, ctx *Context, time time.Time) bool {
+	entity.SetSymptom(s.cause, s.symptom, int(pickQuantity(entity.Rand(), s.exact, s.rng)))
 	return true
 }
 
@@ -338,8 +438,8 @@ type SetAttributeState struct {
 	transition Transition
 }
 
-func (s *SetAttributeState) process(entity *entity.Entity, time time.Time) bool {
-	// TODO: SetAttribute processing logic
+func (s *SetAttributeState) process(entity *entity.Entity, ctx *Context, time time.Time) bool {
+	assign(entity, s.attribute, s.value)
 	return true
 }
 
@@ -377,8 +477,17 @@ type CounterState struct {
 	transition Transition
 }
 
-func (c *CounterState) process(entity *entity.Entity, time time.Time) bool {
-	// TODO: Counter processing logic
+func (c *CounterState) process(entity *entity.Entity, ctx *Context, time time.Time) bool {
+	value, _ := toFloat(entity.Attributes[c.attribute])
+	switch c.action {
+	case "increment":
+		value++
+	case "decrement":
+		value--
+	default:
+		panic(fmt.Sprintf("'%s' is not a valid counter action", c.action))
+	}
+	assign(entity, c.attribute, value)
 	return true
 }
 
@@ -387,15 +496,27 @@ func (c *CounterState) next(entity *entity.Entity, time time.Time) string {
 }
 
 // DeathState results in either an immediate or future death of the
-// patient. If exact, the exact quantity is stored in low.
+// patient. If exact, the exact quantity is stored in low. The cause of
+// death is the condition of the named ConditionOnset state, the condition
+// assigned to an attribute, or the state's own codes, if any are given.
 type DeathState struct {
-	exact      Exact
-	rng        Range
-	transition Transition
+	exact                 Exact
+	rng                   Range
+	conditionOnset        string
+	referencedByAttribute string
+	codes                 []Code
+	transition            Transition
 }
 
-func (d *DeathState) process(entity *entity.Entity, time time.Time) bool {
-	// TODO: Death processing logic
+func (d *DeathState) process(entity *entity.Entity, ctx *Context, time time.Time) bool {
+	// A death with a quantity happens that long in the future; the
+	// module keeps processing until then.
+	deathTime := time
+	if d.exact.Unit != "" || d.rng.Unit != "" {
+		deathTime = time.Add(pickDuration(entity.Rand(), d.exact, d.rng))
+	}
+	cause := ctx.referencedCodes(entity, d.conditionOnset, d.referencedByAttribute, d.codes)
+	entity.Record.Death(deathTime, cause)
 	return true
 }
 
@@ -1,9 +1,11 @@
 package gmf
 
 import (
+	"math/rand"
 	"time"
 
 	"github.com/cjduffett/synthea/entity"
+	"github.com/cjduffett/synthea/utils"
 )
 
 // Transition is an interface for all transition types.
@@ -42,7 +44,8 @@ func NewConditionalTransition(conditionals []Conditional) Transition {
 
 func (ct *ConditionalTransition) follow(entity *entity.Entity, time time.Time) string {
 	for _, conditional := range ct.conditionals {
-		if conditional.Condition.test(entity, time) {
+		// The last conditional may have no condition, which always matches.
+		if conditional.Condition == nil || conditional.Condition.test(entity, time) {
 			return conditional.NextState
 		}
 	}
@@ -69,8 +72,7 @@ func NewDistributedTransition(distributions []Distribution) Transition {
 }
 
 func (t *DistributedTransition) follow(entity *entity.Entity, time time.Time) string {
-	// TODO: Pick a distributed transition
-	return ""
+	return pickDistribution(entity.Rand(), t.distributions)
 }
 
 // Complex maps a logical condition to a series of distributions.
@@ -93,6 +95,22 @@ func NewComplexTransition(transitions []Complex) Transition {
 }
 
 func (t *ComplexTransition) follow(entity *entity.Entity, time time.Time) string {
-	// TODO: Pick a complex transition
-	return ""
+	for _, transition := range t.transitions {
+		// The last transition may have no condition, which always matches.
+		if transition.Condition == nil || transition.Condition.test(entity, time) {
+			return pickDistribution(entity.Rand(), transition.Distributions)
+		}
+	}
+	return "Terminal"
+}
+
+// pickDistribution picks the next state from a set of distributions
+// using rng.
+func pickDistribution(rng *rand.Rand, distributions []Distribution) string {
+	choices := make([]utils.Choice, len(distributions))
+	for i, d := range distributions {
+		choices[i] = utils.Choice{Weight: d.Distribution, Item: d.Transition}
+	}
+	next, _ := utils.WeightedChoice(rng, choices).Item.(string)
+	return next
 }
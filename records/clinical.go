@@ -1,22 +1,94 @@
 package records
 
+import "time"
+
+// Code is a coded clinical concept, for example a SNOMED-CT, LOINC,
+// or RxNorm code.
+type Code struct {
+	System  string `json:"system"`
+	Code    string `json:"code"`
+	Display string `json:"display,omitempty"`
+}
+
+// Encounter is a single visit between the patient and a provider.
 type Encounter struct {
+	Class  string    `json:"class"`
+	Codes  []Code    `json:"codes"`
+	Reason []Code    `json:"reason,omitempty"`
+	Start  time.Time `json:"start"`
+	Stop   time.Time `json:"stop"`
 }
 
+// Observation is a single measurement made of the patient, for
+// example a body weight or a lab result.
 type Observation struct {
+	Codes []Code    `json:"codes"`
+	Value float64   `json:"value"`
+	Unit  string    `json:"unit,omitempty"`
+	Time  time.Time `json:"time"`
 }
 
+// Procedure is a procedure performed on the patient.
 type Procedure struct {
+	Codes  []Code    `json:"codes"`
+	Reason []Code    `json:"reason,omitempty"`
+	Time   time.Time `json:"time"`
 }
 
+// Condition is a condition diagnosed in the patient. A condition
+// with a zero Stop time is still active.
 type Condition struct {
+	Codes []Code    `json:"codes"`
+	Start time.Time `json:"start"`
+	Stop  time.Time `json:"stop"`
 }
 
+// Active returns true if the condition has not been resolved.
+func (c *Condition) Active() bool {
+	return c.Stop.IsZero()
+}
+
+// Immunization is a vaccine administered to the patient.
 type Immunization struct {
+	Codes []Code    `json:"codes"`
+	Time  time.Time `json:"time"`
 }
 
+// Medication is a prescription ordered for the patient. A medication
+// with a zero Stop time is still active.
 type Medication struct {
+	Codes  []Code    `json:"codes"`
+	Reason []Code    `json:"reason,omitempty"`
+	Start  time.Time `json:"start"`
+	Stop   time.Time `json:"stop"`
 }
 
+// Active returns true if the medication has not been stopped.
+func (m *Medication) Active() bool {
+	return m.Stop.IsZero()
+}
+
+// CarePlan is a plan of care prescribed for the patient. A care plan
+// with a zero Stop time is still active.
 type CarePlan struct {
+	Codes      []Code    `json:"codes"`
+	Activities []Code    `json:"activities,omitempty"`
+	Reason     []Code    `json:"reason,omitempty"`
+	Start      time.Time `json:"start"`
+	Stop       time.Time `json:"stop"`
+}
+
+// Active returns true if the care plan has not been stopped.
+func (c *CarePlan) Active() bool {
+	return c.Stop.IsZero()
+}
+
+// hasCode returns true if any of the given codes matches code.
+func hasCode(codes []Code, code Code) bool {
+	for _, c := range codes {
+		if c.System == code.System && c.Code == code.Code {
+			return true
+		}
+	}
+	return false
 }
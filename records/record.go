@@ -1,129 +1,244 @@
 package records
 
+import (
+	"encoding/json"
+	"time"
+)
+
 // Record is a patient's synthesized medical record. This record is generated
 // primarilly by modules from the Generic Module Framework.
 type Record struct {
 	expired       bool
+	deathTime     time.Time
+	causeOfDeath  []Code
 	encounters    []Encounter
 	observations  []Observation
-	conditions    map[string]Condition
-	active        map[string]Condition
+	conditions    []Condition
 	procedures    []Procedure
 	immunizations []Immunization
 	medications   []Medication
 	careplans     []CarePlan
 }
 
-/*
-func (r *Record) death(time time.Time) {
+// Death marks the patient as deceased at the given time.
+func (r *Record) Death(time time.Time, cause []Code) {
 	r.expired = true
+	r.deathTime = time
+	r.causeOfDeath = cause
 }
 
-func (r *Record) observation(obs Observation, time time.Time) {
-	r.observations = append(r.observations, obs)
+// Expired returns true if the patient has died.
+func (r *Record) Expired() bool {
+	return r.expired
 }
 
-func (r *Record) condition(cond Condition, time time.Time) {
-	r.conditions[cond.name]
+// DeathTime returns the time of the patient's death. It is the zero
+// time if the patient is still alive.
+func (r *Record) DeathTime() time.Time {
+	return r.deathTime
 }
 
-func (r *Record) endCondition(typ string, time time.Time) {
-	r.conditions[typ].endTime = time
-	r.active[typ] = nil
+// CauseOfDeath returns the codes for the patient's cause of death, if known.
+func (r *Record) CauseOfDeath() []Code {
+	return r.causeOfDeath
 }
 
-func (r *Record) procedure(typ string, time time.Time, reason string, fhirMethod Procedure, ccdaMethod Procedure) {
-	r.active[typ] = Procedure{
-		typ:    typ,
-		time:   time,
-		reason: reason,
-		fhir:   fhirMethod,
-		ccda:   ccdaMethod,
-	}
-	r.procedures = append(r.procedures, r.active[typ])
+// AddEncounter adds an Encounter to the patient's record.
+func (r *Record) AddEncounter(encounter Encounter) {
+	r.encounters = append(r.encounters, encounter)
 }
 
-func (r *Record) diagnosticReport(typ string, time time.Time, numObs int, fhirMethod DiagnosticReport, ccdaMethod NoAction) {
-	r.observations = append(r.observations, Observation{
-		typ:    typ,
-		time:   time,
-		numObs: num_obs,
-		fhir:   fhirMethod,
-		ccda:   ccdaMethod,
-	})
+// Encounters returns all encounters in the record, in the order they occurred.
+func (r *Record) Encounters() []Encounter {
+	return r.encounters
 }
 
-// RecordEncounter adds an Encounter to the patient's record.
-func (r *Record) RecordEncounter(encounter Encounter, time time.Time) {
-	encounter.time = time.Time
-	r.encounters = append(r.encounters, encounter)
+// AddObservation adds an Observation to the patient's record.
+func (r *Record) AddObservation(observation Observation) {
+	r.observations = append(r.observations, observation)
 }
 
-func (r *Record) immunization(typ string, time time.Time, fhirMethod Immunization, ccdaMethod Immunization) {
-	r.immunizations = append(r.immunizations, Immunization{
-		typ:  imm,
-		time: time,
-		fhir: fhirMethod,
-		ccda: ccdaMethod,
-	})
+// Observations returns all observations in the record, in the order they occurred.
+func (r *Record) Observations() []Observation {
+	return r.observations
 }
 
-func (r *Record) medicationStart(typ string, time time.Time, reasons []string) {
-	r.medications = append(r.medications, MedicationOrder{
-		typ:        typ,
-		time:       time,
-		start_time: time,
-		reasons:    reasons,
-	})
+// EncounterObservations returns the observations made during the given
+// encounter. An observation belongs to an encounter if it was made at or
+// after the encounter's start and no later than the encounter's stop. An
+// encounter with a zero stop is treated as stopping when it starts, as the
+// exporters do, so only observations made at its start belong to it.
+func (r *Record) EncounterObservations(encounter Encounter) []Observation {
+	stop := encounter.Stop
+	if stop.IsZero() {
+		stop = encounter.Start
+	}
+	var observations []Observation
+	for _, obs := range r.observations {
+		if obs.Time.Before(encounter.Start) || obs.Time.After(stop) {
+			continue
+		}
+		observations = append(observations, obs)
+	}
+	return observations
+}
+
+// AddCondition adds a newly diagnosed Condition to the patient's record.
+func (r *Record) AddCondition(condition Condition) {
+	r.conditions = append(r.conditions, condition)
 }
 
-func (r *Record) medicationIsActive(med MedicationOrder) bool {
-	// See if medication is in this array, and there is no end time
-	// r.medications
+// EndCondition resolves all active conditions with the given code.
+func (r *Record) EndCondition(code Code, time time.Time) {
+	for i := range r.conditions {
+		if r.conditions[i].Active() && hasCode(r.conditions[i].Codes, code) {
+			r.conditions[i].Stop = time
+		}
+	}
+}
+
+// ConditionIsActive returns true if a condition with the given code is active.
+func (r *Record) ConditionIsActive(code Code) bool {
+	for i := range r.conditions {
+		if r.conditions[i].Active() && hasCode(r.conditions[i].Codes, code) {
+			return true
+		}
+	}
 	return false
 }
 
-func (r *Record) updateMedicationReasons(typ string, reasons []string, updateTime time.Time) {
-	// Need to find medication typ in the list of medications
-	prescription := r.medications[0]
-	if prescription {
-		// prescription[reasons] = reasons
-		// prescription[time] = update_time
+// Conditions returns all conditions in the record, active and resolved.
+func (r *Record) Conditions() []Condition {
+	return r.conditions
+}
+
+// AddProcedure adds a Procedure to the patient's record.
+func (r *Record) AddProcedure(procedure Procedure) {
+	r.procedures = append(r.procedures, procedure)
+}
+
+// Procedures returns all procedures in the record.
+func (r *Record) Procedures() []Procedure {
+	return r.procedures
+}
+
+// AddImmunization adds an Immunization to the patient's record.
+func (r *Record) AddImmunization(immunization Immunization) {
+	r.immunizations = append(r.immunizations, immunization)
+}
+
+// Immunizations returns all immunizations in the record.
+func (r *Record) Immunizations() []Immunization {
+	return r.immunizations
+}
+
+// StartMedication adds a new prescription to the patient's record.
+func (r *Record) StartMedication(medication Medication) {
+	r.medications = append(r.medications, medication)
+}
+
+// StopMedication stops all active prescriptions with the given code.
+func (r *Record) StopMedication(code Code, time time.Time) {
+	for i := range r.medications {
+		if r.medications[i].Active() && hasCode(r.medications[i].Codes, code) {
+			r.medications[i].Stop = time
+		}
 	}
 }
 
-func (r *Record) medicationStop(typ string, time time.Time, reason string) {
-	// Need to find medication typ in the list of medications
-	prescription := r.medications[0]
-	if prescription {
-		// prescription[stop] = time
-		// prescription[stop_reason] = reason
+// MedicationIsActive returns true if a prescription with the given code is active.
+func (r *Record) MedicationIsActive(code Code) bool {
+	for i := range r.medications {
+		if r.medications[i].Active() && hasCode(r.medications[i].Codes, code) {
+			return true
+		}
 	}
+	return false
 }
-func (r *Record) careplanStart(typ string, activities []Codes, time time.Time, reason string) {
-	r.careplans = append(r.careplans, CarePlanStart{
-		typ:        typ,
-		activities: activities,
-		time:       time,
-		start_time: time,
-		reasons:    reason,
-	})
+
+// Medications returns all prescriptions in the record, active and stopped.
+func (r *Record) Medications() []Medication {
+	return r.medications
 }
-func (r *Record) careplanIsActive(careplan CarePlanStart) bool {
-	// Need to find in the list of careplans
+
+// StartCarePlan adds a new care plan to the patient's record.
+func (r *Record) StartCarePlan(careplan CarePlan) {
+	r.careplans = append(r.careplans, careplan)
+}
+
+// StopCarePlan stops all active care plans with the given code.
+func (r *Record) StopCarePlan(code Code, time time.Time) {
+	for i := range r.careplans {
+		if r.careplans[i].Active() && hasCode(r.careplans[i].Codes, code) {
+			r.careplans[i].Stop = time
+		}
+	}
+}
+
+// CarePlanIsActive returns true if a care plan with the given code is active.
+func (r *Record) CarePlanIsActive(code Code) bool {
+	for i := range r.careplans {
+		if r.careplans[i].Active() && hasCode(r.careplans[i].Codes, code) {
+			return true
+		}
+	}
 	return false
 }
 
-func (r *Record) careplanStop(typ string, time time.Time) {
-	// Need to find in list of careplans
-	careplan := r.careplans[0]
+// CarePlans returns all care plans in the record, active and stopped.
+func (r *Record) CarePlans() []CarePlan {
+	return r.careplans
+}
+
+// recordState is the JSON form of a Record.
+type recordState struct {
+	Expired       bool           `json:"expired"`
+	DeathTime     time.Time      `json:"death_time"`
+	CauseOfDeath  []Code         `json:"cause_of_death,omitempty"`
+	Encounters    []Encounter    `json:"encounters,omitempty"`
+	Observations  []Observation  `json:"observations,omitempty"`
+	Conditions    []Condition    `json:"conditions,omitempty"`
+	Procedures    []Procedure    `json:"procedures,omitempty"`
+	Immunizations []Immunization `json:"immunizations,omitempty"`
+	Medications   []Medication   `json:"medications,omitempty"`
+	CarePlans     []CarePlan     `json:"careplans,omitempty"`
 }
 
-func (r *Record) updateCarePlanReasons(typ string, reasons []string, updateTime time.Time) {
-	careplan := r.careplans[0]
-	if careplan {
-		//careplan[reasons] = reasons
-		//careplan[time] = update_time
+// MarshalJSON returns every entry in the record, so that it can be saved
+// and restored with UnmarshalJSON.
+func (r *Record) MarshalJSON() ([]byte, error) {
+	return json.Marshal(recordState{
+		Expired:       r.expired,
+		DeathTime:     r.deathTime,
+		CauseOfDeath:  r.causeOfDeath,
+		Encounters:    r.encounters,
+		Observations:  r.observations,
+		Conditions:    r.conditions,
+		Procedures:    r.procedures,
+		Immunizations: r.immunizations,
+		Medications:   r.medications,
+		CarePlans:     r.careplans,
+	})
+}
+
+// UnmarshalJSON restores a record written by MarshalJSON.
+func (r *Record) UnmarshalJSON(data []byte) error {
+	var state recordState
+	err := json.Unmarshal(data, &state)
+	if err != nil {
+		return err
+	}
+	*r = Record{
+		expired:       state.Expired,
+		deathTime:     state.DeathTime,
+		causeOfDeath:  state.CauseOfDeath,
+		encounters:    state.Encounters,
+		observations:  state.Observations,
+		conditions:    state.Conditions,
+		procedures:    state.Procedures,
+		immunizations: state.Immunizations,
+		medications:   state.Medications,
+		careplans:     state.CarePlans,
 	}
+	return nil
 }
-*/
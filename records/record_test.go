@@ -0,0 +1,49 @@
+package records
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type RecordTestSuite struct {
+	suite.Suite
+	record *Record
+	start  time.Time
+}
+
+func TestRecordTestSuite(t *testing.T) {
+	suite.Run(t, new(RecordTestSuite))
+}
+
+func (r *RecordTestSuite) SetupTest() {
+	r.record = &Record{}
+	r.start = time.Date(2017, 3, 1, 9, 0, 0, 0, time.UTC)
+	for _, minutes := range []int{-30, 0, 15, 60, 90} {
+		r.record.AddObservation(Observation{Value: float64(minutes), Time: r.start.Add(time.Duration(minutes) * time.Minute)})
+	}
+}
+
+func (r *RecordTestSuite) values(observations []Observation) []float64 {
+	var values []float64
+	for _, obs := range observations {
+		values = append(values, obs.Value)
+	}
+	return values
+}
+
+func (r *RecordTestSuite) TestEncounterObservations() {
+	encounter := Encounter{Start: r.start, Stop: r.start.Add(time.Hour)}
+	r.Equal([]float64{0, 15, 60}, r.values(r.record.EncounterObservations(encounter)))
+}
+
+func (r *RecordTestSuite) TestEncounterObservationsZeroStop() {
+	encounter := Encounter{Start: r.start}
+	r.Equal([]float64{0}, r.values(r.record.EncounterObservations(encounter)))
+}
+
+func (r *RecordTestSuite) TestEncounterObservationsNone() {
+	encounter := Encounter{Start: r.start.Add(2 * time.Hour)}
+	r.Empty(r.record.EncounterObservations(encounter))
+}
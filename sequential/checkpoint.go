@@ -0,0 +1,169 @@
+package sequential
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/cjduffett/synthea/config"
+	"github.com/cjduffett/synthea/generator"
+	"github.com/cjduffett/synthea/gmf"
+	"github.com/cjduffett/synthea/summary"
+)
+
+// CheckpointFile is the name of the checkpoint in the output directory.
+const CheckpointFile = "checkpoint.json"
+
+// Checkpoint is the state of a run after the patients exported so far,
+// from which the run can be resumed. Resuming a run with the same
+// configuration produces the same output as if it had not been
+// interrupted.
+type Checkpoint struct {
+	Seed       int64 `json:"seed"`
+	Population int   `json:"population"`
+	// Fingerprint identifies the rest of the configuration that changes
+	// the output of the run. See fingerprint.
+	Fingerprint string `json:"fingerprint"`
+	// Position is the index of the next patient to generate, and the
+	// counts of the patients generated before it.
+	Position generator.Position `json:"position"`
+	// Exporters are the states of the run's exporters, by name. See
+	// exporter.Set.Checkpoint.
+	Exporters map[string]json.RawMessage `json:"exporters"`
+	Summary   *summary.Summary           `json:"summary"`
+	Profile   *gmf.Profile               `json:"profile,omitempty"`
+}
+
+// fingerprint returns a hash of the settings of cfg that change the
+// output of a run, which a run can only be resumed with if they are the
+// same. The seed and population are checked on their own, with clearer
+// errors, and the number of threads, the output directory and the
+// checkpoint interval do not change the output. The simulation window is
+// resolved first, so a run that ends today cannot be resumed on another
+// day. The contents of the modules, keep filter, town demographics, data
+// pack and name dictionary that cfg refers to are hashed too, so a run
+// cannot be resumed after one of them was edited.
+func fingerprint(cfg *config.Config) (string, error) {
+	start, end, err := cfg.Window()
+	if err != nil {
+		return "", err
+	}
+	settings := *cfg
+	settings.StartDate = start.Format(config.DateFormat)
+	settings.EndDate = end.Format(config.DateFormat)
+	settings.Seed = 0
+	settings.Population = 0
+	settings.Threads = 0
+	settings.OutputDir = ""
+	settings.CheckpointInterval = 0
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	h.Write(data)
+
+	paths := append([]string{cfg.Keep, cfg.Demographics, cfg.DataPack, cfg.Names}, cfg.ModuleDirs...)
+	for _, path := range paths {
+		err = hashFiles(h, path)
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashFiles writes the name and contents of the file at path, or of
+// every file under it if it is a directory, to h. Empty paths and the
+// names of built-in data packs and name dictionaries, which are not on
+// disk, are skipped; built-in data only changes with Synthea itself.
+func hashFiles(h hash.Hash, path string) error {
+	if path == "" {
+		return nil
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+	return filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(path, file)
+		fmt.Fprintf(h, "%s\x00%d\x00", rel, len(data))
+		h.Write(data)
+		return nil
+	})
+}
+
+// LoadCheckpoint loads the checkpoint of the run in outputDir.
+func LoadCheckpoint(outputDir string) (*Checkpoint, error) {
+	path := filepath.Join(outputDir, CheckpointFile)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cp := new(Checkpoint)
+	err = json.Unmarshal(data, cp)
+	if err == nil && cp.Summary == nil {
+		err = fmt.Errorf("missing summary")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Invalid checkpoint %s: %s", path, err.Error())
+	}
+	return cp, nil
+}
+
+// checkpoint flushes the exporters and writes a checkpoint of the run at
+// pos, the position after the last patient exported. The checkpoint is
+// replaced atomically, so an interruption while writing it leaves the
+// previous checkpoint in place.
+func (task *Task) checkpoint(pos generator.Position) error {
+	states, err := task.exporters.Checkpoint()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(Checkpoint{
+		Seed:        task.generator.Seed(),
+		Population:  task.generator.Population(),
+		Fingerprint: task.fingerprint,
+		Position:    pos,
+		Exporters:   states,
+		Summary:     task.summary,
+		Profile:     task.profile,
+	})
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(task.outputDir, CheckpointFile)
+	tmp := path + ".tmp"
+	err = os.MkdirAll(task.outputDir, 0755)
+	if err == nil {
+		err = ioutil.WriteFile(tmp, data, 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to write checkpoint %s: %s", path, err.Error())
+	}
+	return nil
+}
+
+// removeCheckpoint removes the checkpoint of a completed run.
+func (task *Task) removeCheckpoint() error {
+	err := os.Remove(filepath.Join(task.outputDir, CheckpointFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -1,63 +1,278 @@
 package sequential
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"time"
+	"io"
+	"os"
+	"path/filepath"
 
-	"github.com/cjduffett/synthea/patient"
+	"github.com/cjduffett/synthea/config"
+	"github.com/cjduffett/synthea/exporter"
+	"github.com/cjduffett/synthea/generator"
+	"github.com/cjduffett/synthea/gmf"
+	"github.com/cjduffett/synthea/summary"
 )
 
 // Task executes a sequential generation of patients.
 type Task struct {
-	startDate      time.Time
-	endDate        time.Time
-	timeStep       int
-	numToGenerate  int
+	generator      *generator.Generator
 	livingPopCount int
 	deadPopCount   int
+	rejectedCount  int
+	exporters      *exporter.Set
+	outputDir      string
+	summary        *summary.Summary
+	profile        *gmf.Profile
+	snapshots      bool
+
+	checkpointInterval int
+	fingerprint        string
+	start              generator.Position
 }
 
-// NewTask returns a new sequential run to execute.
-func NewTask(numToGenerate int) *Task {
-	now := time.Now()
+// NewTask returns a new sequential run to execute, as configured by cfg.
+// Every generated patient is exported with each exporter in exporters.
+func NewTask(cfg *config.Config, exporters *exporter.Set) (*Task, error) {
+	g, err := generator.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range g.Modules() {
+		fmt.Printf("Loaded module '%s'\n", name)
+	}
+
+	fp, err := fingerprint(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var profile *gmf.Profile
+	if cfg.Profile {
+		profile = g.NewProfile()
+	}
+
 	return &Task{
-		endDate:        now,
-		startDate:      now.AddDate(-100, 0, 0),
-		timeStep:       7,
-		numToGenerate:  numToGenerate,
+		generator:      g,
 		livingPopCount: 0,
 		deadPopCount:   0,
-	}
+		exporters:      exporters,
+		outputDir:      cfg.OutputDir,
+		summary:        summary.New(g.EndDate()),
+		profile:        profile,
+		snapshots:      cfg.Snapshots,
+
+		checkpointInterval: cfg.CheckpointInterval,
+		fingerprint:        fp,
+	}, nil
+}
 
-	// TODO: Track patient statistics
+// ResumeTask returns a run that continues from a checkpoint of a run
+// configured by cfg, as loaded by LoadCheckpoint. The exporters must have
+// been resumed from the checkpoint, see exporter.ResumeSet. ResumeTask
+// returns an error if cfg would change the output of the run; only the
+// number of threads and the checkpoint interval may differ.
+func ResumeTask(cfg *config.Config, exporters *exporter.Set, cp *Checkpoint) (*Task, error) {
+	task, err := NewTask(cfg, exporters)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Seed != 0 && cfg.Seed != cp.Seed {
+		return nil, fmt.Errorf("Invalid seed: the checkpoint was taken with seed %d, not %d", cp.Seed, cfg.Seed)
+	}
+	if cfg.Population != cp.Population {
+		return nil, fmt.Errorf("Invalid population: the checkpoint was taken with population %d, not %d", cp.Population, cfg.Population)
+	}
+	if cp.Fingerprint != task.fingerprint {
+		return nil, errors.New("Invalid configuration: the checkpoint was taken with different settings, such as the dates, modules, data pack, keep filter or exporters")
+	}
+	if task.profile != nil {
+		if cp.Profile == nil {
+			return nil, fmt.Errorf("Invalid checkpoint: it was taken without profiling")
+		}
+		task.profile = cp.Profile
+	}
+	task.generator = task.generator.WithSeed(cp.Seed)
+	task.summary = cp.Summary
+	task.start = cp.Position
+	return task, nil
 }
 
 // Run executes a sequential Synthea generation.
-func (task *Task) Run() {
-	if task.numToGenerate == 0 {
-		// The world is not initialized yet
-		panic("World not initialized")
+func (task *Task) Run() error {
+	return task.RunContext(context.Background())
+}
+
+// RunContext executes a sequential Synthea generation, which is
+// interrupted when ctx is done. An interrupted run flushes the patients
+// exported so far and writes a checkpoint to resume from, then returns
+// an error.
+func (task *Task) RunContext(ctx context.Context) error {
+	if task.generator == nil {
+		return errors.New("Task not initialized: create it with NewTask or ResumeTask")
 	}
 
-	fmt.Printf("Generating %d patients...\n", task.numToGenerate)
-	task.runRandom()
-	// TODO: support multithreading
+	g := task.generator
+	if task.start.Next > 0 {
+		fmt.Printf("Resuming generation of %d patients with seed %d at patient %d on %d thread(s)...\n", g.Population(), g.Seed(), task.start.Next, g.Threads())
+	} else {
+		fmt.Printf("Generating %d patients with seed %d on %d thread(s)...\n", g.Population(), g.Seed(), g.Threads())
+	}
+	err := task.runRandom(ctx)
+	fmt.Printf("Generated %d living and %d dead patients\n", task.livingPopCount, task.deadPopCount)
+	if task.rejectedCount > 0 {
+		kept := task.livingPopCount + task.deadPopCount
+		generated := kept + task.rejectedCount
+		fmt.Printf("Kept %d of %d patients generated (%.1f%% acceptance rate)\n", kept, generated, 100*float64(kept)/float64(generated))
+	}
 
-	// load modules
+	// Always close the exporters so that patients exported before
+	// an error or interruption are flushed to disk.
+	if cerr := task.exporters.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = task.writeSummary()
+	}
+	if err == nil && task.profile != nil {
+		err = task.writeProfile()
+	}
+	if err == nil {
+		err = task.removeCheckpoint()
+	}
+	return err
+}
 
-	// execute modules
+// writeProfile writes the module profile to profile.json in the
+// profile subdirectory of the output directory, with a graph of each
+// module weighted by the profile.
+func (task *Task) writeProfile() error {
+	dir := filepath.Join(task.outputDir, "profile")
+	err := task.generator.WriteGraphviz(dir, task.profile)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, "profile.json")
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = task.profile.WriteJSON(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to write profile %s: %s", path, err.Error())
+	}
+	fmt.Printf("Wrote module profile to %s\n", dir)
+	return nil
+}
 
-	// export
+// writeSnapshot writes a snapshot of the patient to <id>.json in the
+// snapshots subdirectory of the output directory.
+func (task *Task) writeSnapshot(p *generator.Patient) error {
+	dir := filepath.Join(task.outputDir, "snapshots")
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, p.Entity.Patient.ID()+".json")
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = task.generator.Snapshot(p).WriteJSON(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to write snapshot %s: %s", path, err.Error())
+	}
+	return nil
+}
+
+// Summary returns the statistics of the patients generated so far.
+func (task *Task) Summary() *summary.Report {
+	return task.summary.Report()
+}
+
+// writeSummary writes the run's summary report to summary.txt and
+// summary.json in the output directory.
+func (task *Task) writeSummary() error {
+	report := task.summary.Report()
+	err := os.MkdirAll(task.outputDir, 0755)
+	if err != nil {
+		return err
+	}
+	for name, write := range map[string]func(w io.Writer) error{
+		"summary.txt":  report.WriteText,
+		"summary.json": report.WriteJSON,
+	} {
+		path := filepath.Join(task.outputDir, name)
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		err = write(f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to write summary %s: %s", path, err.Error())
+		}
+	}
+	fmt.Printf("Wrote summary to %s\n", filepath.Join(task.outputDir, "summary.txt"))
+	return nil
 }
 
-func (task *Task) runRandom() {
+// Seed returns the master seed of the run. If no seed was configured it
+// is picked from the clock; rerunning with the same seed regenerates
+// exactly the same patients.
+func (task *Task) Seed() int64 {
+	return task.generator.Seed()
+}
 
-	// TODO: randomize seed?
+// runRandom generates the population and exports every patient in
+// order, writing a checkpoint every checkpointInterval patients and when
+// ctx is done. See generator.Iterator.
+func (task *Task) runRandom(ctx context.Context) error {
+	it := task.generator.IteratorAt(ctx, task.start)
+	defer it.Close()
+	for it.Next() {
+		p := it.Patient()
+		fmt.Printf("Patient... %d\n", p.Index)
+		task.livingPopCount, task.deadPopCount, task.rejectedCount = it.Counts()
+		task.summary.Add(p.Entity)
+		if task.profile != nil {
+			task.profile.Add(p.Entity)
+		}
+
+		err := task.exporters.Export(&p.Entity.Patient, &p.Entity.Record)
+		if err == nil && task.snapshots {
+			err = task.writeSnapshot(p)
+		}
+		if err != nil {
+			return err
+		}
+		exported := task.livingPopCount + task.deadPopCount
+		if task.checkpointInterval > 0 && exported%task.checkpointInterval == 0 {
+			err = task.checkpoint(it.Position())
+			if err != nil {
+				return err
+			}
+		}
+	}
+	task.livingPopCount, task.deadPopCount, task.rejectedCount = it.Counts()
+	task.summary.SetRejected(task.rejectedCount)
 
-	for task.livingPopCount < task.numToGenerate {
-		// create a new patient
-		fmt.Printf("Patient... %d\n", task.livingPopCount)
-		patient.NewPatient(task.startDate, task.endDate)
-		task.livingPopCount++
+	err := it.Err()
+	if err != nil && err == ctx.Err() {
+		err = task.checkpoint(it.Position())
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("Interrupted after %d patients, run again with -resume to continue", task.livingPopCount+task.deadPopCount)
 	}
+	return err
 }
@@ -0,0 +1,292 @@
+package sequential
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/cjduffett/synthea/config"
+	"github.com/cjduffett/synthea/entity"
+	"github.com/cjduffett/synthea/exporter"
+	"github.com/cjduffett/synthea/records"
+	"github.com/stretchr/testify/suite"
+)
+
+// interrupter is an exporter that interrupts a run by calling
+// interruptRun once interruptAfter patients have been exported.
+type interrupter struct {
+	exported int
+}
+
+var (
+	interruptAfter int
+	interruptRun   context.CancelFunc
+)
+
+func init() {
+	exporter.Register("interrupt", func(options exporter.Options) (exporter.Exporter, error) {
+		return new(interrupter), nil
+	})
+}
+
+func (i *interrupter) Init(outputDir string) error { return nil }
+func (i *interrupter) Close() error                { return nil }
+
+func (i *interrupter) Export(patient *entity.Patient, record *records.Record) error {
+	i.exported++
+	if i.exported == interruptAfter {
+		interruptRun()
+	}
+	return nil
+}
+
+type SequentialTestSuite struct {
+	suite.Suite
+}
+
+func TestSequentialTestSuite(t *testing.T) {
+	suite.Run(t, new(SequentialTestSuite))
+}
+
+// config returns the configuration of a small run with the given number
+// of threads.
+func (suite *SequentialTestSuite) config(threads int) *config.Config {
+	cfg := config.Default()
+	cfg.Population = 20
+	cfg.Seed = 42
+	cfg.EndDate = "2016-12-01"
+	cfg.Threads = threads
+	cfg.ModuleDirs = []string{"../fixtures/modules/lifecycle", "../fixtures/modules/random"}
+	cfg.Exporters = []string{"html", "omop", "hl7"}
+	cfg.OutputDir = suite.T().TempDir()
+	return cfg
+}
+
+// run runs the task configured by cfg.
+func (suite *SequentialTestSuite) run(cfg *config.Config) *Task {
+	set, err := exporter.NewSet(cfg.Exporters, cfg.OutputDir, exporter.Options(cfg.ExporterOptions))
+	suite.Require().Nil(err)
+	task, err := NewTask(cfg, set)
+	suite.Require().Nil(err)
+	suite.Require().Nil(task.Run())
+	suite.Equal(cfg.Population, task.livingPopCount)
+	return task
+}
+
+func (suite *SequentialTestSuite) TestThreadsProduceIdenticalOutput() {
+	single := suite.config(1)
+	suite.run(single)
+	expected := readTree(suite.T(), single.OutputDir)
+	suite.NotEmpty(expected)
+
+	threaded := suite.config(4)
+	suite.run(threaded)
+	suite.Equal(expected, readTree(suite.T(), threaded.OutputDir))
+}
+
+func (suite *SequentialTestSuite) TestUninitializedTask() {
+	suite.NotNil(new(Task).Run())
+}
+
+func (suite *SequentialTestSuite) TestKeepFilter() {
+	// The lifecycle module kills every patient at 23, so leave it out.
+	cfg := suite.config(3)
+	cfg.ModuleDirs = []string{"../fixtures/modules/random"}
+	cfg.Population = 5
+	cfg.Keep = "../fixtures/keep/sick_women_over_50.json"
+	report := suite.run(cfg).Summary()
+
+	suite.Equal(5, report.Patients)
+	suite.Equal(0, report.Dead, "Patients who die are rejected")
+	suite.Equal(map[string]int{"Female": 5}, report.Sexes)
+	for group := range report.Ages {
+		low, err := strconv.Atoi(strings.SplitN(group, "-", 2)[0])
+		suite.Require().Nil(err)
+		suite.True(low >= 50, "Patient in age group %s is too young", group)
+	}
+	suite.Equal(5, report.Modules["Random"]["Sick"])
+	suite.True(report.Rejected > 0)
+	suite.Equal(float64(5)/float64(5+report.Rejected), report.AcceptanceRate)
+
+	html, err := ioutil.ReadDir(filepath.Join(cfg.OutputDir, "html"))
+	suite.Nil(err)
+	suite.Len(html, 5, "Only kept patients are exported")
+}
+
+func (suite *SequentialTestSuite) TestImpossibleKeepFilter() {
+	cfg := suite.config(1)
+	cfg.Keep = "../fixtures/keep/sick_women_over_50.json"
+	set, err := exporter.NewSet(cfg.Exporters, cfg.OutputDir, exporter.Options(cfg.ExporterOptions))
+	suite.Require().Nil(err)
+	task, err := NewTask(cfg, set)
+	suite.Require().Nil(err)
+	suite.NotNil(task.Run(), "Every patient dies at 23 in the lifecycle module")
+}
+
+func (suite *SequentialTestSuite) TestInvalidKeepFilter() {
+	cfg := suite.config(1)
+	cfg.Keep = "../fixtures/keep/invalid.json"
+	_, err := NewTask(cfg, &exporter.Set{})
+	suite.NotNil(err)
+}
+
+func (suite *SequentialTestSuite) TestSnapshots() {
+	cfg := suite.config(2)
+	cfg.Snapshots = true
+	report := suite.run(cfg).Summary()
+
+	dir := filepath.Join(cfg.OutputDir, "snapshots")
+	files, err := ioutil.ReadDir(dir)
+	suite.Require().Nil(err)
+	suite.Len(files, report.Patients)
+	for _, file := range files {
+		snapshot, err := entity.LoadSnapshot(filepath.Join(dir, file.Name()))
+		suite.Require().Nil(err)
+		suite.Equal(file.Name(), snapshot.Entity.Patient.ID()+".json")
+		suite.Equal("2016-12-01", snapshot.Until.Format("2006-01-02"))
+		suite.Equal(snapshot.Entity.Patient.BirthDate(), snapshot.Start)
+	}
+}
+
+func (suite *SequentialTestSuite) TestResumeProducesIdenticalOutput() {
+	uninterrupted := suite.config(2)
+	uninterrupted.Exporters = []string{"html", "csv", "omop", "hl7"}
+	uninterrupted.ExporterOptions["hl7.mllp"] = "true"
+	uninterrupted.Profile = true
+	uninterrupted.CheckpointInterval = 3
+	expected := suite.run(uninterrupted).Summary()
+	_, err := os.Stat(filepath.Join(uninterrupted.OutputDir, CheckpointFile))
+	suite.True(os.IsNotExist(err), "A completed run removes its checkpoint")
+
+	cfg := suite.config(2)
+	cfg.Exporters = append(uninterrupted.Exporters, "interrupt")
+	cfg.ExporterOptions = uninterrupted.ExporterOptions
+	cfg.Profile = true
+	cfg.CheckpointInterval = 3
+	set, err := exporter.NewSet(cfg.Exporters, cfg.OutputDir, exporter.Options(cfg.ExporterOptions))
+	suite.Require().Nil(err)
+	task, err := NewTask(cfg, set)
+	suite.Require().Nil(err)
+	var ctx context.Context
+	ctx, interruptRun = context.WithCancel(context.Background())
+	interruptAfter = 7
+	suite.NotNil(task.RunContext(ctx))
+
+	cp, err := LoadCheckpoint(cfg.OutputDir)
+	suite.Require().Nil(err)
+	suite.Equal(int64(42), cp.Seed)
+	suite.Equal(7, cp.Position.Living+cp.Position.Dead, "Interrupting writes a checkpoint after the last patient")
+
+	// The resumed run may use a different number of threads.
+	cfg.Threads = 3
+	set, err = exporter.ResumeSet(cfg.Exporters, cfg.OutputDir, exporter.Options(cfg.ExporterOptions), cp.Exporters)
+	suite.Require().Nil(err)
+	task, err = ResumeTask(cfg, set, cp)
+	suite.Require().Nil(err)
+	suite.Require().Nil(task.Run())
+	suite.Equal(cfg.Population, task.livingPopCount)
+
+	suite.Equal(expected, task.Summary())
+	suite.Equal(readTree(suite.T(), uninterrupted.OutputDir), readTree(suite.T(), cfg.OutputDir))
+}
+
+func (suite *SequentialTestSuite) TestResumeWithDifferentConfig() {
+	cfg := suite.config(1)
+	cp := &Checkpoint{Seed: 42, Population: cfg.Population}
+
+	cfg.Seed = 7
+	_, err := ResumeTask(cfg, &exporter.Set{}, cp)
+	suite.NotNil(err)
+
+	cfg.Seed = 0
+	cfg.Population++
+	_, err = ResumeTask(cfg, &exporter.Set{}, cp)
+	suite.NotNil(err)
+
+	cfg.Population--
+	cp.Fingerprint, err = fingerprint(cfg)
+	suite.Require().Nil(err)
+	_, err = ResumeTask(cfg, &exporter.Set{}, cp)
+	suite.Nil(err, "Only the seed and population were different")
+	cfg.Threads = 4
+	cfg.CheckpointInterval = 1
+	cfg.OutputDir = suite.T().TempDir()
+	_, err = ResumeTask(cfg, &exporter.Set{}, cp)
+	suite.Nil(err, "Threads and checkpoints do not change the output")
+
+	for _, change := range []func(cfg *config.Config){
+		func(cfg *config.Config) { cfg.EndDate = "2017-01-01" },
+		func(cfg *config.Config) { cfg.TimeStep = 1 },
+		func(cfg *config.Config) { cfg.ModuleDirs = []string{"../fixtures/modules/random"} },
+		func(cfg *config.Config) { cfg.Keep = "../fixtures/keep/sick_women_over_50.json" },
+		func(cfg *config.Config) { cfg.DataPack = "texas" },
+		func(cfg *config.Config) { cfg.Exporters = []string{"csv"} },
+		func(cfg *config.Config) { cfg.ExporterOptions = map[string]string{"hl7.mllp": "true"} },
+	} {
+		changed := suite.config(1)
+		change(changed)
+		_, err = ResumeTask(changed, &exporter.Set{}, cp)
+		suite.NotNil(err)
+	}
+
+	_, err = LoadCheckpoint(cfg.OutputDir)
+	suite.NotNil(err, "There is no checkpoint")
+}
+
+func (suite *SequentialTestSuite) TestResumeWithEditedModule() {
+	dir := suite.T().TempDir()
+	for _, name := range []string{"lifecycle", "random"} {
+		src := filepath.Join("../fixtures/modules", name)
+		files, err := ioutil.ReadDir(src)
+		suite.Require().Nil(err)
+		suite.Require().Nil(os.Mkdir(filepath.Join(dir, name), 0755))
+		for _, file := range files {
+			data, err := ioutil.ReadFile(filepath.Join(src, file.Name()))
+			suite.Require().Nil(err)
+			suite.Require().Nil(ioutil.WriteFile(filepath.Join(dir, name, file.Name()), data, 0644))
+		}
+	}
+	cfg := suite.config(1)
+	cfg.ModuleDirs = []string{filepath.Join(dir, "lifecycle"), filepath.Join(dir, "random")}
+	fp, err := fingerprint(cfg)
+	suite.Require().Nil(err)
+	cp := &Checkpoint{Seed: 42, Population: cfg.Population, Fingerprint: fp}
+	_, err = ResumeTask(cfg, &exporter.Set{}, cp)
+	suite.Nil(err)
+
+	// The A1c observations are higher than when the checkpoint was taken.
+	path := filepath.Join(dir, "lifecycle", "lifecycle.json")
+	data, err := ioutil.ReadFile(path)
+	suite.Require().Nil(err)
+	edited := strings.Replace(string(data), `"quantity": 7`, `"quantity": 8`, 1)
+	suite.Require().NotEqual(string(data), edited)
+	suite.Require().Nil(ioutil.WriteFile(path, []byte(edited), 0644))
+	_, err = ResumeTask(cfg, &exporter.Set{}, cp)
+	suite.NotNil(err, "The modules were edited since the checkpoint")
+}
+
+// readTree returns the contents of every file under dir, keyed by path
+// relative to dir.
+func readTree(t *testing.T, dir string) map[string]string {
+	files := make(map[string]string)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		files[rel] = string(data)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
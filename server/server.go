@@ -0,0 +1,402 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cjduffett/synthea/exporter"
+	"github.com/cjduffett/synthea/generator"
+	"github.com/cjduffett/synthea/gmf"
+)
+
+// MaxPatients is the most patients a single request may generate.
+const MaxPatients = 10000
+
+// maxBodySize is the largest request body accepted, in bytes.
+const maxBodySize = 10 << 20
+
+// Server generates patients on demand over HTTP. Every request generates
+// its own patients with the server's modules and demographics; nothing
+// is stored between requests. It serves:
+//
+//	GET  /modules               the names of the loaded modules, as JSON
+//	POST /modules/validate      validates the module posted as JSON
+//	GET  /patients              generates patients, see generatePatients
+//	POST /patients              the same, keeping only patients who match
+//	                            the GMF condition posted as JSON
+//	GET  /patients/<seed>/<i>   regenerates the i-th patient of a seed
+type Server struct {
+	generator *generator.Generator
+	mux       *http.ServeMux
+}
+
+// New returns a Server that generates patients with g. The seed,
+// population and keep filter g was configured with are replaced by the
+// parameters of each request.
+func New(g *generator.Generator) *Server {
+	s := &Server{generator: g, mux: http.NewServeMux()}
+	s.mux.HandleFunc("/modules", s.listModules)
+	s.mux.HandleFunc("/modules/validate", s.validateModule)
+	s.mux.HandleFunc("/patients", s.generatePatients)
+	s.mux.HandleFunc("/patients/", s.fetchPatient)
+	return s
+}
+
+// ServeHTTP serves a request.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) listModules(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string][]string{"modules": s.generator.Modules()})
+}
+
+// moduleValidation is the response to a module validation request.
+type moduleValidation struct {
+	Valid bool   `json:"valid"`
+	Name  string `json:"name,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+func (s *Server) validateModule(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodPost) {
+		return
+	}
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	module, err := gmf.ParseModule(data)
+	if err == nil {
+		err = module.Validate()
+	}
+	if err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, moduleValidation{Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, moduleValidation{Valid: true, Name: module.Name()})
+}
+
+// generatePatients streams newly generated patients. The query
+// parameters are:
+//
+//	n        the number of living patients to generate (default 1)
+//	seed     the master seed (default picked from the clock)
+//	gender   keep only patients of a gender, "M" or "F"
+//	race     keep only patients of a race, for example "Hispanic"
+//	min_age  keep only patients at least this old
+//	max_age  keep only patients at most this old
+//	format   "fhir" (default) or "csv"
+//	table    the CSV table to write (default "patients")
+//
+// As in a sequential run, patients who die before the end of the
+// simulation are returned too, unless there is a keep filter. FHIR
+// patients are written as newline delimited JSON, one transaction Bundle
+// per line, each identified by the path to fetch it again. Patients are
+// written as soon as they are generated.
+func (s *Server) generatePatients(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodPost) {
+		return
+	}
+	query := r.URL.Query()
+	out, err := newWriter(w, query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	n := 1
+	if value := query.Get("n"); value != "" {
+		n, err = strconv.Atoi(value)
+		if err != nil || n <= 0 || n > MaxPatients {
+			http.Error(w, fmt.Sprintf("Invalid n: must be between 1 and %d", MaxPatients), http.StatusBadRequest)
+			return
+		}
+	}
+	seed := time.Now().UnixNano()
+	if value := query.Get("seed"); value != "" {
+		seed, err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid seed: "+value, http.StatusBadRequest)
+			return
+		}
+	}
+	var body []byte
+	if r.Method == http.MethodPost {
+		body, err = ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	keep, err := parseFilter(query, body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	g := s.generator.WithSeed(seed).WithPopulation(n).WithFilter(keep)
+	w.Header().Set("X-Synthea-Seed", strconv.FormatInt(seed, 10))
+	it := g.Iterator(r.Context())
+	defer it.Close()
+	for it.Next() {
+		p := it.Patient()
+		err = out.write(p, fmt.Sprintf("%s?n=%d", patientPath(seed, p), n))
+		if err != nil {
+			// The client has most likely gone away.
+			log.Printf("Failed to write patient %d: %s", p.Index, err.Error())
+			return
+		}
+	}
+	if err = it.Err(); err != nil {
+		if !out.started {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// The status has been sent, so the response can only be cut short.
+		log.Printf("Failed to generate patients with seed %d: %s", seed, err.Error())
+		return
+	}
+	err = out.flush()
+	if err != nil {
+		log.Printf("Failed to write patients: %s", err.Error())
+	}
+}
+
+// fetchPatient regenerates a single patient by seed and index, as
+// written by generatePatients, followed by their position in their
+// household if the server generates households and they are not the
+// householder. The n query parameter must be the n the patient was
+// generated with if the server has town demographics, which are
+// allocated by population. The format and table parameters are as for
+// generatePatients.
+func (s *Server) fetchPatient(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/patients/"), "/")
+	if len(parts) != 2 && len(parts) != 3 {
+		http.NotFound(w, r)
+		return
+	}
+	seed, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid seed: "+parts[0], http.StatusBadRequest)
+		return
+	}
+	index, err := strconv.Atoi(parts[1])
+	if err != nil || index < 0 {
+		http.Error(w, "Invalid patient index: "+parts[1], http.StatusBadRequest)
+		return
+	}
+	member := 0
+	if len(parts) == 3 {
+		member, err = strconv.Atoi(parts[2])
+		if err != nil || member < 0 {
+			http.Error(w, "Invalid household member: "+parts[2], http.StatusBadRequest)
+			return
+		}
+	}
+	query := r.URL.Query()
+	n := 1
+	if value := query.Get("n"); value != "" {
+		n, err = strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			http.Error(w, "Invalid n: "+value, http.StatusBadRequest)
+			return
+		}
+	}
+	out, err := newWriter(w, query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	g := s.generator.WithSeed(seed).WithPopulation(n).WithFilter(nil)
+	members, err := g.GenerateHousehold(r.Context(), index)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if member >= len(members) {
+		http.NotFound(w, r)
+		return
+	}
+	p := members[member]
+	w.Header().Set("X-Synthea-Seed", strconv.FormatInt(seed, 10))
+	err = out.write(p, fmt.Sprintf("%s?n=%d", patientPath(seed, p), n))
+	if err == nil {
+		err = out.flush()
+	}
+	if err != nil {
+		log.Printf("Failed to write patient %d: %s", index, err.Error())
+	}
+}
+
+// patientPath returns the path fetchPatient regenerates the patient at.
+func patientPath(seed int64, p *generator.Patient) string {
+	if p.Member > 0 {
+		return fmt.Sprintf("patients/%d/%d/%d", seed, p.Index, p.Member)
+	}
+	return fmt.Sprintf("patients/%d/%d", seed, p.Index)
+}
+
+// parseFilter returns the keep filter of a generation request: the
+// demographic query parameters and the condition posted in the body, if
+// any. It returns nil if the request has neither.
+func parseFilter(query url.Values, body []byte) (*gmf.Filter, error) {
+	var conditions []interface{}
+
+	switch gender := query.Get("gender"); gender {
+	case "":
+	case "M", "F":
+		conditions = append(conditions, map[string]interface{}{"condition_type": "Gender", "gender": gender})
+	default:
+		return nil, fmt.Errorf("Invalid gender: %s", gender)
+	}
+	if race := query.Get("race"); race != "" {
+		conditions = append(conditions, map[string]interface{}{"condition_type": "Race", "race": race})
+	}
+	for _, bound := range []struct {
+		key      string
+		operator string
+		offset   int
+	}{
+		{"min_age", ">=", 0},
+		// Ages are fractional, so a patient is at most max_age until
+		// their next birthday.
+		{"max_age", "<", 1},
+	} {
+		value := query.Get(bound.key)
+		if value == "" {
+			continue
+		}
+		age, err := strconv.Atoi(value)
+		if err != nil || age < 0 {
+			return nil, fmt.Errorf("Invalid %s: %s", bound.key, value)
+		}
+		conditions = append(conditions, map[string]interface{}{
+			"condition_type": "Age",
+			"operator":       bound.operator,
+			"quantity":       age + bound.offset,
+			"unit":           "years",
+		})
+	}
+
+	if len(bytes.TrimSpace(body)) > 0 {
+		conditions = append(conditions, json.RawMessage(body))
+	}
+
+	if len(conditions) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(map[string]interface{}{"condition_type": "And", "conditions": conditions})
+	if err != nil {
+		return nil, fmt.Errorf("Invalid filter: %s", err.Error())
+	}
+	keep, err := gmf.ParseFilter(data)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid filter: %s", err.Error())
+	}
+	return keep, nil
+}
+
+// patientWriter writes patients to a response in the requested format,
+// flushing each one to the client as soon as it is written.
+type patientWriter struct {
+	w       http.ResponseWriter
+	csv     *exporter.CSVWriter
+	started bool
+}
+
+// newWriter returns a patientWriter for the format and table query
+// parameters. Nothing is written to w until the first patient.
+func newWriter(w http.ResponseWriter, query url.Values) (*patientWriter, error) {
+	get := func(key, fallback string) string {
+		if value := query.Get(key); value != "" {
+			return value
+		}
+		return fallback
+	}
+
+	out := &patientWriter{w: w}
+	switch format := get("format", "fhir"); format {
+	case "fhir":
+		w.Header().Set("Content-Type", "application/fhir+ndjson")
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		var err error
+		out.csv, err = exporter.NewCSVWriter(w, get("table", "patients"))
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("Invalid format: %s (available: fhir, csv)", format)
+	}
+	return out, nil
+}
+
+// write writes a patient, identified by the path to fetch them again.
+func (out *patientWriter) write(p *generator.Patient, path string) error {
+	out.started = true
+	var err error
+	if out.csv != nil {
+		err = out.csv.Write(&p.Entity.Patient, &p.Entity.Record)
+	} else {
+		bundle := exporter.NewFHIRBundle(&p.Entity.Patient, &p.Entity.Record)
+		bundle.Identifier = &exporter.FHIRIdentifier{System: "urn:synthea:patient", Value: path}
+		err = json.NewEncoder(out.w).Encode(bundle)
+	}
+	if err != nil {
+		return err
+	}
+	return out.flush()
+}
+
+// flush sends everything written so far to the client.
+func (out *patientWriter) flush() error {
+	if out.csv != nil {
+		if err := out.csv.Flush(); err != nil {
+			return err
+		}
+	}
+	if f, ok := out.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+// allowMethods responds with 405 Method Not Allowed and returns false
+// if the request's method is not one of methods.
+func allowMethods(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if r.Method == method {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(value)
+	if err != nil {
+		log.Printf("Failed to write response: %s", err.Error())
+	}
+}
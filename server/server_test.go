@@ -0,0 +1,209 @@
+package server
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cjduffett/synthea/config"
+	"github.com/cjduffett/synthea/exporter"
+	"github.com/cjduffett/synthea/generator"
+	"github.com/stretchr/testify/suite"
+)
+
+type ServerTestSuite struct {
+	suite.Suite
+	server *httptest.Server
+}
+
+func TestServerTestSuite(t *testing.T) {
+	suite.Run(t, new(ServerTestSuite))
+}
+
+func (suite *ServerTestSuite) SetupTest() {
+	suite.server = httptest.NewServer(New(suite.generator(false)))
+}
+
+// generator returns the generator the server is started with.
+func (suite *ServerTestSuite) generator(households bool) *generator.Generator {
+	cfg := config.Default()
+	cfg.EndDate = "2016-12-01"
+	cfg.Threads = 2
+	// The random module on its own, in which patients live.
+	cfg.ModuleDirs = []string{"../fixtures/modules/random"}
+	cfg.Households = households
+	g, err := generator.New(cfg)
+	suite.Require().Nil(err)
+	return g
+}
+
+func (suite *ServerTestSuite) TearDownTest() {
+	suite.server.Close()
+}
+
+// do sends a request and returns the response status and body.
+func (suite *ServerTestSuite) do(method, path, body string) (int, string) {
+	req, err := http.NewRequest(method, suite.server.URL+path, strings.NewReader(body))
+	suite.Require().Nil(err)
+	resp, err := http.DefaultClient.Do(req)
+	suite.Require().Nil(err)
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	suite.Require().Nil(err)
+	return resp.StatusCode, string(data)
+}
+
+// bundles parses newline delimited FHIR bundles.
+func (suite *ServerTestSuite) bundles(body string) []exporter.FHIRBundle {
+	var bundles []exporter.FHIRBundle
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	scanner.Buffer(nil, 10<<20)
+	for scanner.Scan() {
+		var bundle exporter.FHIRBundle
+		suite.Require().Nil(json.Unmarshal(scanner.Bytes(), &bundle))
+		bundles = append(bundles, bundle)
+	}
+	return bundles
+}
+
+func (suite *ServerTestSuite) TestListModules() {
+	status, body := suite.do("GET", "/modules", "")
+	suite.Equal(http.StatusOK, status)
+	suite.JSONEq(`{"modules": ["Random"]}`, body)
+
+	status, _ = suite.do("POST", "/modules", "")
+	suite.Equal(http.StatusMethodNotAllowed, status)
+}
+
+func (suite *ServerTestSuite) TestValidateModule() {
+	data, err := ioutil.ReadFile("../fixtures/modules/lifecycle/lifecycle.json")
+	suite.Require().Nil(err)
+	status, body := suite.do("POST", "/modules/validate", string(data))
+	suite.Equal(http.StatusOK, status)
+	suite.JSONEq(`{"valid": true, "name": "Lifecycle"}`, body)
+
+	status, body = suite.do("POST", "/modules/validate", `{"name": "Broken", "states": {"Initial": {"type": "Initial", "direct_transition": "Nowhere"}}}`)
+	suite.Equal(http.StatusUnprocessableEntity, status)
+	suite.Contains(body, "'Nowhere', which does not exist")
+
+	status, _ = suite.do("POST", "/modules/validate", "not json")
+	suite.Equal(http.StatusUnprocessableEntity, status)
+}
+
+func (suite *ServerTestSuite) TestGeneratePatients() {
+	status, body := suite.do("GET", "/patients?n=3&seed=42", "")
+	suite.Equal(http.StatusOK, status)
+	bundles := suite.bundles(body)
+	living := 0
+	for _, bundle := range bundles {
+		if _, dead := bundle.Entry[0].Resource["deceasedDateTime"]; !dead {
+			living++
+		}
+	}
+	suite.Equal(3, living, "Patients who died are returned too")
+
+	status, again := suite.do("GET", "/patients?n=3&seed=42", "")
+	suite.Equal(http.StatusOK, status)
+	suite.Equal(body, again, "The same seed generates the same patients")
+
+	// Every patient can be fetched again by the path in its identifier.
+	for _, bundle := range bundles {
+		suite.Require().NotNil(bundle.Identifier)
+		status, single := suite.do("GET", "/"+bundle.Identifier.Value, "")
+		suite.Equal(http.StatusOK, status)
+		fetched := suite.bundles(single)
+		suite.Require().Len(fetched, 1)
+		suite.Equal(bundle, fetched[0])
+	}
+}
+
+func (suite *ServerTestSuite) TestGenerateHouseholds() {
+	suite.server.Close()
+	suite.server = httptest.NewServer(New(suite.generator(true)))
+
+	status, body := suite.do("GET", "/patients?n=6&seed=42", "")
+	suite.Equal(http.StatusOK, status)
+	bundles := suite.bundles(body)
+	suite.True(len(bundles) >= 6)
+
+	members := 0
+	for _, bundle := range bundles {
+		suite.Require().NotNil(bundle.Identifier)
+		if strings.Count(bundle.Identifier.Value, "/") == 3 {
+			members++
+		}
+		status, single := suite.do("GET", "/"+bundle.Identifier.Value, "")
+		suite.Equal(http.StatusOK, status)
+		fetched := suite.bundles(single)
+		suite.Require().Len(fetched, 1)
+		suite.Equal(bundle, fetched[0])
+	}
+	suite.True(members > 0, "Some householders live with others")
+
+	status, _ = suite.do("GET", "/patients/42/0/100", "")
+	suite.Equal(http.StatusNotFound, status)
+}
+
+func (suite *ServerTestSuite) TestGeneratePatientsCSV() {
+	status, body := suite.do("GET", "/patients?n=4&seed=42&format=csv&table=patients", "")
+	suite.Equal(http.StatusOK, status)
+	rows, err := csv.NewReader(strings.NewReader(body)).ReadAll()
+	suite.Nil(err)
+	suite.Equal("id", rows[0][0])
+	living := 0
+	for _, row := range rows[1:] {
+		if row[2] == "" {
+			living++
+		}
+	}
+	suite.Equal(4, living)
+
+	status, _ = suite.do("GET", "/patients?format=csv&table=claims", "")
+	suite.Equal(http.StatusBadRequest, status)
+}
+
+func (suite *ServerTestSuite) TestGeneratePatientsWithDemographics() {
+	status, body := suite.do("GET", "/patients?n=5&seed=42&gender=F&min_age=30&max_age=40&format=csv", "")
+	suite.Equal(http.StatusOK, status)
+	rows, err := csv.NewReader(strings.NewReader(body)).ReadAll()
+	suite.Nil(err)
+	suite.Len(rows, 6)
+	for _, row := range rows[1:] {
+		suite.Equal("Female", row[5])
+		suite.True(row[1] > "1975-12-01" && row[1] <= "1986-12-01", "Born %s", row[1])
+	}
+}
+
+func (suite *ServerTestSuite) TestGeneratePatientsWithKeepCondition() {
+	condition := `{"condition_type": "PriorState", "name": "Sick"}`
+	status, body := suite.do("POST", "/patients?n=2&seed=42&gender=M", condition)
+	suite.Equal(http.StatusOK, status)
+	suite.Len(suite.bundles(body), 2)
+
+	status, _ = suite.do("POST", "/patients?n=2", `{"condition_type": "Unknown"}`)
+	suite.Equal(http.StatusBadRequest, status)
+}
+
+func (suite *ServerTestSuite) TestInvalidParameters() {
+	for _, path := range []string{
+		"/patients?n=0",
+		"/patients?n=many",
+		"/patients?seed=abc",
+		"/patients?gender=X",
+		"/patients?min_age=-1",
+		"/patients?format=ccda",
+		"/patients/abc/1",
+		"/patients/42/-1",
+		"/patients/42/1/x",
+	} {
+		status, _ := suite.do("GET", path, "")
+		suite.Equal(http.StatusBadRequest, status, path)
+	}
+	status, _ := suite.do("GET", "/patients/42", "")
+	suite.Equal(http.StatusNotFound, status)
+}
@@ -0,0 +1,427 @@
+package summary
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cjduffett/synthea/entity"
+	"github.com/cjduffett/synthea/records"
+)
+
+// TopCodes is the number of medications and procedures listed in a
+// Report, the most common first.
+const TopCodes = 10
+
+// ageGroupYears is the width of the age groups in a Report.
+const ageGroupYears = 10
+
+// Summary collects statistics about a population of generated patients.
+// Patients are added one at a time as they are generated; Report returns
+// the statistics for every patient added so far.
+type Summary struct {
+	endDate     time.Time
+	living      int
+	dead        int
+	rejected    int
+	ages        map[string]int
+	sexes       map[string]int
+	races       map[string]int
+	conditions  map[records.Code]*CodeCount
+	medications map[records.Code]*CodeCount
+	procedures  map[records.Code]*CodeCount
+	encounters  map[string]int
+	deaths      map[records.Code]*CodeCount
+	modules     map[string]map[string]int
+}
+
+// Report is a statistical summary of a population of generated patients.
+type Report struct {
+	EndDate  string `json:"end_date"`
+	Patients int    `json:"patients"`
+	Living   int    `json:"living"`
+	Dead     int    `json:"dead"`
+	// Rejected is the number of patients generated but not kept, because
+	// they did not match the run's keep filter. AcceptanceRate is the
+	// share of generated patients that were kept.
+	Rejected       int     `json:"rejected"`
+	AcceptanceRate float64 `json:"acceptance_rate"`
+	// Ages counts the living patients by their age at the end of the
+	// simulation, in ten year groups, for example "30-39".
+	Ages map[string]int `json:"ages"`
+	// Sexes and Races count every patient, living or dead.
+	Sexes map[string]int `json:"sexes"`
+	Races map[string]int `json:"races"`
+	// Conditions lists every condition diagnosed in the population. The
+	// Medications and Procedures are the TopCodes most common.
+	Conditions  []CodeCount `json:"conditions"`
+	Medications []CodeCount `json:"medications"`
+	Procedures  []CodeCount `json:"procedures"`
+	// Encounters counts encounters by encounter class.
+	Encounters map[string]int `json:"encounters"`
+	// CausesOfDeath counts the dead patients by cause. Deaths without a
+	// cause are counted under a code with only the display "Unknown".
+	CausesOfDeath []CodeCount `json:"causes_of_death"`
+	// Modules counts the patients who reached each state of each module,
+	// keyed by module name and then state name.
+	Modules map[string]map[string]int `json:"modules"`
+}
+
+// CodeCount is the number of patients with a code in their record, and
+// the number of times it was recorded.
+type CodeCount struct {
+	System     string  `json:"system"`
+	Code       string  `json:"code"`
+	Display    string  `json:"display"`
+	Patients   int     `json:"patients"`
+	Count      int     `json:"count"`
+	Prevalence float64 `json:"prevalence"`
+}
+
+// unknownCause is the cause of death counted for deaths without one.
+var unknownCause = records.Code{Display: "Unknown"}
+
+// New returns an empty summary of a simulation that ends at endDate.
+func New(endDate time.Time) *Summary {
+	return &Summary{
+		endDate:     endDate,
+		ages:        make(map[string]int),
+		sexes:       make(map[string]int),
+		races:       make(map[string]int),
+		conditions:  make(map[records.Code]*CodeCount),
+		medications: make(map[records.Code]*CodeCount),
+		procedures:  make(map[records.Code]*CodeCount),
+		encounters:  make(map[string]int),
+		deaths:      make(map[records.Code]*CodeCount),
+		modules:     make(map[string]map[string]int),
+	}
+}
+
+// Add adds a generated patient to the summary. It is not safe to call
+// Add concurrently.
+func (s *Summary) Add(e *entity.Entity) {
+	if e.Alive(s.endDate) {
+		s.living++
+		s.ages[ageGroup(e.Patient.AgeAt(s.endDate))]++
+	} else {
+		s.dead++
+		cause := e.Record.CauseOfDeath()
+		if len(cause) == 0 {
+			cause = []records.Code{unknownCause}
+		}
+		count(s.deaths, [][]records.Code{cause})
+	}
+	s.sexes[e.Patient.Gender()]++
+	s.races[e.Patient.Race()]++
+
+	var conditions, medications, procedures [][]records.Code
+	for _, condition := range e.Record.Conditions() {
+		conditions = append(conditions, condition.Codes)
+	}
+	for _, medication := range e.Record.Medications() {
+		medications = append(medications, medication.Codes)
+	}
+	for _, procedure := range e.Record.Procedures() {
+		procedures = append(procedures, procedure.Codes)
+	}
+	count(s.conditions, conditions)
+	count(s.medications, medications)
+	count(s.procedures, procedures)
+
+	for _, encounter := range e.Record.Encounters() {
+		s.encounters[encounter.Class]++
+	}
+
+	for _, module := range e.Modules() {
+		states := s.modules[module]
+		if states == nil {
+			states = make(map[string]int)
+			s.modules[module] = states
+		}
+		ctx := e.Context(module)
+		reached := map[string]bool{ctx.CurrentState: true}
+		for _, visit := range ctx.History {
+			reached[visit.Name] = true
+		}
+		for state := range reached {
+			states[state]++
+		}
+	}
+}
+
+// SetRejected sets the number of generated patients who were not kept.
+// Rejected patients are not included in any other statistic.
+func (s *Summary) SetRejected(rejected int) {
+	s.rejected = rejected
+}
+
+// count counts one patient's record entries by their first code. Each
+// code is counted once per patient, however often it was recorded.
+func count(counts map[records.Code]*CodeCount, entries [][]records.Code) {
+	seen := make(map[records.Code]bool)
+	for _, codes := range entries {
+		if len(codes) == 0 {
+			continue
+		}
+		key := records.Code{System: codes[0].System, Code: codes[0].Code}
+		c := counts[key]
+		if c == nil {
+			c = &CodeCount{System: key.System, Code: key.Code, Display: codes[0].Display}
+			counts[key] = c
+		}
+		c.Count++
+		if !seen[key] {
+			seen[key] = true
+			c.Patients++
+		}
+	}
+}
+
+// ageGroup returns the ten year age group of age, for example "30-39".
+func ageGroup(age int) string {
+	low := age / ageGroupYears * ageGroupYears
+	return fmt.Sprintf("%d-%d", low, low+ageGroupYears-1)
+}
+
+// sortAgeGroups sorts age groups from the youngest, so that "100-109"
+// follows "90-99".
+func sortAgeGroups(groups []string) {
+	sort.Slice(groups, func(i, j int) bool {
+		return ageGroupLow(groups[i]) < ageGroupLow(groups[j])
+	})
+}
+
+// ageGroupLow returns the youngest age in an age group.
+func ageGroupLow(group string) int {
+	low, _ := strconv.Atoi(strings.SplitN(group, "-", 2)[0])
+	return low
+}
+
+// Report returns the statistics of every patient added so far.
+func (s *Summary) Report() *Report {
+	patients := s.living + s.dead
+	report := &Report{
+		EndDate:       s.endDate.Format("2006-01-02"),
+		Patients:      patients,
+		Living:        s.living,
+		Dead:          s.dead,
+		Rejected:      s.rejected,
+		Ages:          copyCounts(s.ages),
+		Sexes:         copyCounts(s.sexes),
+		Races:         copyCounts(s.races),
+		Conditions:    sortedCounts(s.conditions, patients, 0),
+		Medications:   sortedCounts(s.medications, patients, TopCodes),
+		Procedures:    sortedCounts(s.procedures, patients, TopCodes),
+		Encounters:    copyCounts(s.encounters),
+		CausesOfDeath: sortedCounts(s.deaths, s.dead, 0),
+		Modules:       make(map[string]map[string]int, len(s.modules)),
+	}
+	for module, states := range s.modules {
+		report.Modules[module] = copyCounts(states)
+	}
+	if generated := patients + s.rejected; generated > 0 {
+		report.AcceptanceRate = float64(patients) / float64(generated)
+	}
+	return report
+}
+
+// copyCounts returns a copy of counts, so that a Report does not change as
+// more patients are added.
+func copyCounts(counts map[string]int) map[string]int {
+	c := make(map[string]int, len(counts))
+	for key, n := range counts {
+		c[key] = n
+	}
+	return c
+}
+
+// sortedCounts returns the counts, the most patients first, with their
+// prevalence among total patients. If limit is greater than 0 only the
+// first limit counts are returned.
+func sortedCounts(counts map[records.Code]*CodeCount, total, limit int) []CodeCount {
+	sorted := make([]CodeCount, 0, len(counts))
+	for _, c := range counts {
+		count := *c
+		if total > 0 {
+			count.Prevalence = float64(count.Patients) / float64(total)
+		}
+		sorted = append(sorted, count)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Patients != sorted[j].Patients {
+			return sorted[i].Patients > sorted[j].Patients
+		}
+		if sorted[i].System != sorted[j].System {
+			return sorted[i].System < sorted[j].System
+		}
+		return sorted[i].Code < sorted[j].Code
+	})
+	if limit > 0 && len(sorted) > limit {
+		sorted = sorted[:limit]
+	}
+	return sorted
+}
+
+// summaryState is the JSON form of a Summary, from which it can be
+// restored to continue adding patients. See MarshalJSON.
+type summaryState struct {
+	EndDate     time.Time                 `json:"end_date"`
+	Living      int                       `json:"living"`
+	Dead        int                       `json:"dead"`
+	Rejected    int                       `json:"rejected"`
+	Ages        map[string]int            `json:"ages"`
+	Sexes       map[string]int            `json:"sexes"`
+	Races       map[string]int            `json:"races"`
+	Conditions  []CodeCount               `json:"conditions"`
+	Medications []CodeCount               `json:"medications"`
+	Procedures  []CodeCount               `json:"procedures"`
+	Encounters  map[string]int            `json:"encounters"`
+	Deaths      []CodeCount               `json:"deaths"`
+	Modules     map[string]map[string]int `json:"modules"`
+}
+
+// MarshalJSON returns the summary's statistics, unlike a Report including
+// every medication and procedure, so that a checkpointed run can restore
+// the summary with UnmarshalJSON and continue adding patients.
+func (s *Summary) MarshalJSON() ([]byte, error) {
+	return json.Marshal(summaryState{
+		EndDate:     s.endDate,
+		Living:      s.living,
+		Dead:        s.dead,
+		Rejected:    s.rejected,
+		Ages:        s.ages,
+		Sexes:       s.sexes,
+		Races:       s.races,
+		Conditions:  sortedCounts(s.conditions, 0, 0),
+		Medications: sortedCounts(s.medications, 0, 0),
+		Procedures:  sortedCounts(s.procedures, 0, 0),
+		Encounters:  s.encounters,
+		Deaths:      sortedCounts(s.deaths, 0, 0),
+		Modules:     s.modules,
+	})
+}
+
+// UnmarshalJSON restores a summary written by MarshalJSON.
+func (s *Summary) UnmarshalJSON(data []byte) error {
+	var state summaryState
+	err := json.Unmarshal(data, &state)
+	if err != nil {
+		return err
+	}
+	*s = *New(state.EndDate)
+	s.living, s.dead, s.rejected = state.Living, state.Dead, state.Rejected
+	for _, restored := range []struct {
+		into map[string]int
+		from map[string]int
+	}{
+		{s.ages, state.Ages},
+		{s.sexes, state.Sexes},
+		{s.races, state.Races},
+		{s.encounters, state.Encounters},
+	} {
+		for key, n := range restored.from {
+			restored.into[key] = n
+		}
+	}
+	for _, restored := range []struct {
+		into map[records.Code]*CodeCount
+		from []CodeCount
+	}{
+		{s.conditions, state.Conditions},
+		{s.medications, state.Medications},
+		{s.procedures, state.Procedures},
+		{s.deaths, state.Deaths},
+	} {
+		for _, c := range restored.from {
+			c := c
+			c.Prevalence = 0
+			restored.into[records.Code{System: c.System, Code: c.Code}] = &c
+		}
+	}
+	for module, states := range state.Modules {
+		s.modules[module] = states
+	}
+	return nil
+}
+
+// WriteJSON writes the report as indented JSON.
+func (r *Report) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// WriteText writes the report as plain text, one section per statistic.
+func (r *Report) WriteText(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Population summary at %s\n", r.EndDate)
+	fmt.Fprintf(&b, "  Patients: %d\n  Living:   %d\n  Dead:     %d\n", r.Patients, r.Living, r.Dead)
+	if r.Rejected > 0 {
+		fmt.Fprintf(&b, "  Rejected: %d (%.1f%% acceptance rate)\n", r.Rejected, 100*r.AcceptanceRate)
+	}
+
+	writeDistribution(&b, "Age (living)", r.Ages, r.Living, sortAgeGroups)
+	writeDistribution(&b, "Sex", r.Sexes, r.Patients, sort.Strings)
+	writeDistribution(&b, "Race", r.Races, r.Patients, sort.Strings)
+	writeCodeCounts(&b, "Conditions", r.Conditions)
+	writeCodeCounts(&b, fmt.Sprintf("Top %d medications", TopCodes), r.Medications)
+	writeCodeCounts(&b, fmt.Sprintf("Top %d procedures", TopCodes), r.Procedures)
+	writeDistribution(&b, "Encounters by class", r.Encounters, 0, sort.Strings)
+	writeCodeCounts(&b, "Causes of death", r.CausesOfDeath)
+
+	modules := make([]string, 0, len(r.Modules))
+	for module := range r.Modules {
+		modules = append(modules, module)
+	}
+	sort.Strings(modules)
+	for _, module := range modules {
+		writeDistribution(&b, "Module "+module+" (patients reaching each state)", r.Modules[module], 0, sort.Strings)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeDistribution writes counts in the order of their keys sorted by
+// sortKeys, and the percentage of total each count is if total is greater
+// than 0.
+func writeDistribution(b *strings.Builder, title string, counts map[string]int, total int, sortKeys func([]string)) {
+	fmt.Fprintf(b, "\n%s\n", title)
+	if len(counts) == 0 {
+		b.WriteString("  (none)\n")
+		return
+	}
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sortKeys(keys)
+	for _, key := range keys {
+		if total > 0 {
+			fmt.Fprintf(b, "  %-30s %8d %6.1f%%\n", key, counts[key], 100*float64(counts[key])/float64(total))
+		} else {
+			fmt.Fprintf(b, "  %-30s %8d\n", key, counts[key])
+		}
+	}
+}
+
+// writeCodeCounts writes codes in order with their patient counts and
+// prevalence.
+func writeCodeCounts(b *strings.Builder, title string, counts []CodeCount) {
+	fmt.Fprintf(b, "\n%s\n", title)
+	if len(counts) == 0 {
+		b.WriteString("  (none)\n")
+		return
+	}
+	for _, c := range counts {
+		code := c.Display
+		if c.Code != "" {
+			code = fmt.Sprintf("%s %s %s", c.System, c.Code, c.Display)
+		}
+		fmt.Fprintf(b, "  %-50s %8d %6.1f%%\n", code, c.Patients, 100*c.Prevalence)
+	}
+}
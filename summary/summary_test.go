@@ -0,0 +1,169 @@
+package summary
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cjduffett/synthea/entity"
+	"github.com/cjduffett/synthea/records"
+	"github.com/cjduffett/synthea/utils"
+	"github.com/stretchr/testify/suite"
+)
+
+type SummaryTestSuite struct {
+	suite.Suite
+	endDate  time.Time
+	summary  *Summary
+	diabetes records.Code
+	insulin  records.Code
+}
+
+func TestSummaryTestSuite(t *testing.T) {
+	suite.Run(t, new(SummaryTestSuite))
+}
+
+func (suite *SummaryTestSuite) SetupTest() {
+	suite.endDate = time.Date(2016, time.December, 1, 0, 0, 0, 0, time.UTC)
+	suite.summary = New(suite.endDate)
+	suite.diabetes = records.Code{System: "SNOMED-CT", Code: "44054006", Display: "Diabetes"}
+	suite.insulin = records.Code{System: "RxNorm", Code: "253182", Display: "Insulin"}
+}
+
+func (suite *SummaryTestSuite) newEntity(seed int64) *entity.Entity {
+	rng := utils.NewRand(seed)
+	return entity.NewEntity(entity.NewPatient(rng, suite.endDate.AddDate(-100, 0, 0), suite.endDate, nil), rng)
+}
+
+func (suite *SummaryTestSuite) TestReport() {
+	diabetic := suite.newEntity(1)
+	diabetic.Record.AddCondition(records.Condition{Codes: []records.Code{suite.diabetes}})
+	diabetic.Record.StartMedication(records.Medication{Codes: []records.Code{suite.insulin}})
+	diabetic.Record.StartMedication(records.Medication{Codes: []records.Code{suite.insulin}})
+	diabetic.Record.AddEncounter(records.Encounter{Class: "ambulatory"})
+	diabetic.Record.AddEncounter(records.Encounter{Class: "ambulatory"})
+	diabetic.Record.Death(suite.endDate.AddDate(-1, 0, 0), []records.Code{suite.diabetes})
+	ctx := diabetic.Context("Diabetes")
+	ctx.Transition("Initial", suite.endDate)
+	ctx.Transition("Onset", suite.endDate)
+
+	healthy := suite.newEntity(2)
+	healthy.Record.AddEncounter(records.Encounter{Class: "wellness"})
+	healthy.Context("Diabetes").Transition("Initial", suite.endDate)
+
+	suite.summary.Add(diabetic)
+	suite.summary.Add(healthy)
+	report := suite.summary.Report()
+
+	suite.Equal(2, report.Patients)
+	suite.Equal(1, report.Living)
+	suite.Equal(1, report.Dead)
+	suite.Equal(map[string]int{ageGroup(healthy.Patient.AgeAt(suite.endDate)): 1}, report.Ages)
+	sexes := map[string]int{}
+	sexes[diabetic.Patient.Gender()]++
+	sexes[healthy.Patient.Gender()]++
+	suite.Equal(sexes, report.Sexes)
+	suite.Equal([]CodeCount{{System: "SNOMED-CT", Code: "44054006", Display: "Diabetes", Patients: 1, Count: 1, Prevalence: 0.5}}, report.Conditions)
+	suite.Equal([]CodeCount{{System: "RxNorm", Code: "253182", Display: "Insulin", Patients: 1, Count: 2, Prevalence: 0.5}}, report.Medications)
+	suite.Empty(report.Procedures)
+	suite.Equal(map[string]int{"ambulatory": 2, "wellness": 1}, report.Encounters)
+	suite.Equal([]CodeCount{{System: "SNOMED-CT", Code: "44054006", Display: "Diabetes", Patients: 1, Count: 1, Prevalence: 1}}, report.CausesOfDeath)
+	suite.Equal(map[string]map[string]int{"Diabetes": {"Initial": 2, "Onset": 1}}, report.Modules)
+}
+
+func (suite *SummaryTestSuite) TestUnknownCauseOfDeath() {
+	e := suite.newEntity(1)
+	e.Record.Death(suite.endDate.AddDate(-1, 0, 0), nil)
+	suite.summary.Add(e)
+	suite.Equal([]CodeCount{{Display: "Unknown", Patients: 1, Count: 1, Prevalence: 1}}, suite.summary.Report().CausesOfDeath)
+}
+
+func (suite *SummaryTestSuite) TestTopCodes() {
+	e := suite.newEntity(1)
+	for i := 0; i < TopCodes+5; i++ {
+		code := records.Code{System: "SNOMED-CT", Code: string(rune('A' + i))}
+		e.Record.AddProcedure(records.Procedure{Codes: []records.Code{code}})
+		if i == 3 {
+			e.Record.AddProcedure(records.Procedure{Codes: []records.Code{code}})
+		}
+	}
+	suite.summary.Add(e)
+	procedures := suite.summary.Report().Procedures
+	suite.Len(procedures, TopCodes)
+	suite.Equal("A", procedures[0].Code, "Codes with the same number of patients are sorted by code")
+	suite.Equal(2, procedures[3].Count)
+}
+
+func (suite *SummaryTestSuite) TestAgeGroup() {
+	suite.Equal("0-9", ageGroup(0))
+	suite.Equal("30-39", ageGroup(39))
+	suite.Equal("100-109", ageGroup(100))
+}
+
+func (suite *SummaryTestSuite) TestWrite() {
+	e := suite.newEntity(1)
+	e.Record.AddCondition(records.Condition{Codes: []records.Code{suite.diabetes}})
+	suite.summary.Add(e)
+	report := suite.summary.Report()
+
+	var text bytes.Buffer
+	suite.Nil(report.WriteText(&text))
+	suite.Contains(text.String(), "Living:   1")
+	suite.Contains(text.String(), "SNOMED-CT 44054006 Diabetes")
+
+	var buf bytes.Buffer
+	suite.Nil(report.WriteJSON(&buf))
+	decoded := new(Report)
+	suite.Nil(json.Unmarshal(buf.Bytes(), decoded))
+	suite.Equal(report, decoded)
+}
+
+func (suite *SummaryTestSuite) TestWriteSortsAgeGroups() {
+	report := &Report{Living: 3, Ages: map[string]int{"100-109": 1, "20-29": 1, "10-19": 1}}
+	var text bytes.Buffer
+	suite.Nil(report.WriteText(&text))
+	out := text.String()
+	suite.True(strings.Index(out, "10-19") < strings.Index(out, "20-29"))
+	suite.True(strings.Index(out, "20-29") < strings.Index(out, "100-109"))
+}
+
+func (suite *SummaryTestSuite) TestReportIsNotChangedByAdd() {
+	suite.summary.Add(suite.newEntity(1))
+	report := suite.summary.Report()
+	ages := copyCounts(report.Ages)
+
+	for seed := int64(2); seed < 10; seed++ {
+		suite.summary.Add(suite.newEntity(seed))
+	}
+	suite.Equal(ages, report.Ages)
+	suite.Equal(1, report.Sexes["Male"]+report.Sexes["Female"])
+}
+
+func (suite *SummaryTestSuite) TestMarshalJSON() {
+	first := suite.newEntity(1)
+	first.Record.AddCondition(records.Condition{Codes: []records.Code{suite.diabetes}})
+	for i := 0; i < TopCodes+1; i++ {
+		code := records.Code{System: "SNOMED-CT", Code: string(rune('A' + i))}
+		first.Record.AddProcedure(records.Procedure{Codes: []records.Code{code}})
+	}
+	first.Context("Diabetes").Transition("Initial", suite.endDate)
+	second := suite.newEntity(2)
+	second.Record.AddCondition(records.Condition{Codes: []records.Code{suite.diabetes}})
+	second.Record.AddProcedure(records.Procedure{Codes: []records.Code{{System: "SNOMED-CT", Code: string(rune('A' + TopCodes))}}})
+	second.Context("Diabetes").Transition("Initial", suite.endDate)
+
+	suite.summary.Add(first)
+	data, err := json.Marshal(suite.summary)
+	suite.Require().Nil(err)
+	restored := new(Summary)
+	suite.Require().Nil(json.Unmarshal(data, restored))
+
+	// A restored summary continues exactly as the original does, including
+	// codes that were not in the top codes when it was saved.
+	suite.summary.Add(second)
+	restored.Add(second)
+	suite.Equal(suite.summary.Report(), restored.Report())
+	suite.Equal(string(rune('A'+TopCodes)), restored.Report().Procedures[0].Code)
+}
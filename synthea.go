@@ -14,7 +14,9 @@ func main() {
 		fmt.Println("usage: synthea <command> [<args>]")
 		fmt.Println("The most commonly used commands are: ")
 		fmt.Println(" sequential   Sequentially generate patients ")
+		fmt.Println(" advance      Advance saved patients to a new end date, exporting only the changes ")
 		fmt.Println(" graphviz     Create a graphical vizualization of synthea modules ")
+		fmt.Println(" serve        Generate patients on demand over HTTP ")
 		fmt.Println(" story        Create a \"story\" of a patient's life ")
 		fmt.Println(" new          Create a new generic module ")
 		return
@@ -35,8 +37,4 @@ func main() {
 
 	// export (fhir, CCDA, html)
 
-	// generate summary
-
-	// graphviz?
-
 }
@@ -19,22 +19,26 @@ func (c *Choice) WeightAsString() string {
 }
 
 // WeightedChoice selected a choice given its probability
-// of being selected.
-func WeightedChoice(choices []Choice) Choice {
-	cleaned := cleanChoices(choices)
+// of being selected, using rng. The choices passed in are not modified.
+func WeightedChoice(rng *rand.Rand, choices []Choice) Choice {
+	cleaned := cleanChoices(append([]Choice(nil), choices...))
 
-	// sort by weights
-	sort.Sort(SortChoice(cleaned))
+	// sort by weights, keeping choices with the same weight in
+	// order so that the same random number always picks the same choice
+	sort.Stable(SortChoice(cleaned))
 
-	// pick a random number that will fall in that range
-	r := rand.Float64()
-	for i := range choices {
-		if r < choices[i].Weight {
-			return choices[i]
+	// pick a random number and find the choice whose cumulative
+	// weight range it falls in
+	r := rng.Float64()
+	cumulative := 0.0
+	for i := range cleaned {
+		cumulative += cleaned[i].Weight
+		if r < cumulative {
+			return cleaned[i]
 		}
 	}
-	// If the only choice has weight of 1
-	return choices[0]
+	// Guard against floating point imprecision in the cumulative sum
+	return cleaned[len(cleaned)-1]
 }
 
 // If weights sum to >1.0, we ignore the remaining choices
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"math/rand"
 	"sort"
 	"testing"
 
@@ -11,6 +12,7 @@ type ChoiceTestSuite struct {
 	suite.Suite
 	choices   []Choice
 	tolerance float64
+	rng       *rand.Rand
 }
 
 func TestChoiceTestSuite(t *testing.T) {
@@ -19,6 +21,7 @@ func TestChoiceTestSuite(t *testing.T) {
 
 func (c *ChoiceTestSuite) SetupTest() {
 	c.tolerance = 0.00000001
+	c.rng = NewRand(1)
 	c.choices = []Choice{
 		{0.7, "a"},
 		{0.2, "b"},
@@ -27,10 +30,35 @@ func (c *ChoiceTestSuite) SetupTest() {
 }
 
 func (c *ChoiceTestSuite) TestWeightedChoice() {
-	choice := WeightedChoice(c.choices)
+	choice := WeightedChoice(c.rng, c.choices)
 	c.True(contains(c.choices, choice), "Weighted choice not found in possible choices")
 }
 
+func (c *ChoiceTestSuite) TestWeightedChoiceDistribution() {
+	counts := make(map[interface{}]int)
+	for i := 0; i < 10000; i++ {
+		counts[WeightedChoice(c.rng, c.choices).Item]++
+	}
+	c.InDelta(7000, counts["a"], 300)
+	c.InDelta(2000, counts["b"], 300)
+	c.InDelta(1000, counts["c"], 300)
+	c.Equal("a", c.choices[0].Item, "Choices should not be reordered")
+}
+
+func (c *ChoiceTestSuite) TestWeightedChoiceIsDeterministic() {
+	rng1, rng2 := NewRand(42), NewRand(42)
+	for i := 0; i < 100; i++ {
+		c.Equal(WeightedChoice(rng1, c.choices), WeightedChoice(rng2, c.choices))
+	}
+}
+
+func (c *ChoiceTestSuite) TestDeriveSeed() {
+	c.Equal(DeriveSeed(42, 7), DeriveSeed(42, 7))
+	c.NotEqual(DeriveSeed(42, 7), DeriveSeed(42, 8))
+	c.NotEqual(DeriveSeed(42, 7), DeriveSeed(43, 7))
+	c.NotEqual(DeriveSeed(0, 0), int64(0))
+}
+
 func (c *ChoiceTestSuite) TestCleanChoicesNoChoices() {
 	c.Panics(func() { cleanChoices([]Choice{}) }, "WeightedChoice: No choices provided")
 }
@@ -0,0 +1,19 @@
+package utils
+
+import "math/rand"
+
+// NewRand returns a new random number generator seeded with seed.
+func NewRand(seed int64) *rand.Rand {
+	return rand.New(rand.NewSource(seed))
+}
+
+// DeriveSeed derives an independent seed for the index-th entity of a
+// run from the run's master seed. Derived seeds are well mixed (using
+// SplitMix64), so that entities with neighbouring indices do not get
+// correlated random number sequences.
+func DeriveSeed(seed int64, index int) int64 {
+	z := uint64(seed) + uint64(index+1)*0x9e3779b97f4a7c15
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return int64(z ^ (z >> 31))
+}